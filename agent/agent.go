@@ -17,6 +17,7 @@ import (
 
 	ucli "github.com/actiontech/dtle/internal/client"
 	uconf "github.com/actiontech/dtle/internal/config"
+	"github.com/actiontech/dtle/internal/g"
 	umodel "github.com/actiontech/dtle/internal/models"
 	usrv "github.com/actiontech/dtle/internal/server"
 	"github.com/hashicorp/memberlist"
@@ -201,6 +202,15 @@ func (a *Agent) clientConfig() (*uconf.ClientConfig, error) {
 	conf.ConsulConfig = a.config.Consul
 	conf.NatsAddr = a.config.AdvertiseAddrs.Nats
 	conf.MaxPayload = a.config.Network.MaxPayload
+	conf.NatsSecurity = &uconf.NatsSecurity{
+		TLSCertFile: a.config.Network.TLSCertFile,
+		TLSKeyFile:  a.config.Network.TLSKeyFile,
+		TLSCAFile:   a.config.Network.TLSCAFile,
+		TLSVerify:   a.config.Network.TLSVerify,
+		Token:       a.config.Network.Token,
+		User:        a.config.Network.User,
+		Password:    a.config.Network.Password,
+	}
 	conf.StatsCollectionInterval = a.config.Metric.collectionInterval
 	conf.PublishNodeMetrics = a.config.Metric.PublishNodeMetrics
 	conf.PublishAllocationMetrics = a.config.Metric.PublishAllocationMetrics
@@ -244,6 +254,12 @@ func (a *Agent) setupClient() error {
 		return fmt.Errorf("client setup failed: %v", err)
 	}
 
+	g.AgentRateLimiters.SetRateLimit(&umodel.RateLimit{
+		SnapshotRowsPerSecond:  a.config.Client.SnapshotRowsPerSecond,
+		SnapshotBytesPerSecond: a.config.Client.SnapshotBytesPerSecond,
+		IncrBytesPerSecond:     a.config.Client.IncrBytesPerSecond,
+	})
+
 	// Create the client
 	client, err := ucli.NewClient(conf, a.logger)
 	if err != nil {
@@ -12,6 +12,7 @@ import (
 
 	"github.com/hashicorp/serf/serf"
 
+	"github.com/actiontech/dtle/internal/g"
 	umodel "github.com/actiontech/dtle/internal/models"
 )
 
@@ -65,6 +66,24 @@ func (s *HTTPServer) AgentSelfRequest(resp http.ResponseWriter, req *http.Reques
 	return self, nil
 }
 
+// AgentRateLimitRequest gets (GET) or changes (PUT/POST with a RateLimit body) the rate limit
+// shared by all jobs on this agent.
+func (s *HTTPServer) AgentRateLimitRequest(resp http.ResponseWriter, req *http.Request) (interface{}, error) {
+	switch req.Method {
+	case "GET":
+		return g.AgentRateLimiters.RateLimit(), nil
+	case "PUT", "POST":
+		limit := &umodel.RateLimit{}
+		if err := decodeBody(req, limit); err != nil {
+			return nil, CodedError(400, err.Error())
+		}
+		g.AgentRateLimiters.SetRateLimit(limit)
+		return limit, nil
+	default:
+		return nil, CodedError(405, ErrInvalidMethod)
+	}
+}
+
 func (s *HTTPServer) AgentJoinRequest(resp http.ResponseWriter, req *http.Request) (interface{}, error) {
 	if req.Method != "PUT" && req.Method != "POST" {
 		return nil, CodedError(405, ErrInvalidMethod)
@@ -7,6 +7,7 @@
 package agent
 
 import (
+	"bytes"
 	"fmt"
 	"net/http"
 	"strings"
@@ -97,6 +98,8 @@ func (s *HTTPServer) ClientAllocRequest(resp http.ResponseWriter, req *http.Requ
 	switch tokens[1] {
 	case "stats":
 		return s.allocStats(allocID,forward, resp, req)
+	case "ratelimit":
+		return s.allocRateLimit(allocID, forward, resp, req)
 	}
 	return nil, CodedError(404, resourceNotFoundErr)
 }
@@ -120,6 +123,93 @@ func (s *HTTPServer) allocStats(allocID string,forward bool, resp http.ResponseW
 	task := req.URL.Query().Get("task")
 	return aStats.LatestAllocStats(task)
 }
+// allocRateLimit gets (GET) or changes (PUT/POST with a RateLimit body) the rate limit of a running job.
+func (s *HTTPServer) allocRateLimit(allocID string, forward bool, resp http.ResponseWriter, req *http.Request) (interface{}, error) {
+	var limit *umodel.RateLimit
+	switch req.Method {
+	case "GET":
+	case "PUT", "POST":
+		limit = &umodel.RateLimit{}
+		if err := decodeBody(req, limit); err != nil {
+			return nil, CodedError(400, err.Error())
+		}
+	default:
+		return nil, CodedError(405, ErrInvalidMethod)
+	}
+
+	err := clientNotRunning
+	if s.agent.client != nil {
+		if limit == nil {
+			var current *umodel.RateLimit
+			if current, err = s.agent.client.GetAllocRateLimit(allocID); err == nil {
+				return current, nil
+			}
+		} else {
+			if err = s.agent.client.SetAllocRateLimit(allocID, limit); err == nil {
+				return limit, nil
+			}
+		}
+	}
+	if forward {
+		return nil, err
+	}
+	s.logger.Debugf("alloc %v is not running on %v, forward to other nodes", allocID, s.addr)
+	return s.allocRateLimitForward(allocID, req.Method, limit)
+}
+
+func (s *HTTPServer) allocRateLimitForward(allocID string, method string, limit *umodel.RateLimit) (interface{}, error) {
+	args := umodel.NodeListRequest{}
+	args.Region = "global"
+	var out umodel.NodeListResponse
+	if err := s.agent.RPC("Node.List", &args, &out); err != nil {
+		return nil, err
+	}
+
+	var body []byte
+	if limit != nil {
+		var err error
+		if body, err = json.Marshal(limit); err != nil {
+			return nil, err
+		}
+	}
+	realErr := fmt.Errorf("alloc %v is not running on any node", allocID)
+	for _, node := range out.Nodes {
+		if node.HTTPAddr == s.addr || node.Status != "ready" {
+			continue
+		}
+		url := "http://" + node.HTTPAddr + "/v1/agent/allocation/" + allocID + "/ratelimit/forward"
+		value, err := func() (interface{}, error) {
+			req, err := http.NewRequest(method, url, bytes.NewReader(body))
+			if err != nil {
+				return nil, err
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				return nil, err
+			}
+			defer resp.Body.Close()
+			respBody, err := ioutil.ReadAll(resp.Body)
+			if err != nil {
+				return nil, err
+			}
+			if resp.StatusCode != 200 {
+				return nil, fmt.Errorf("%v: %v", node.HTTPAddr, string(respBody))
+			}
+			var value interface{}
+			if err := json.Unmarshal(respBody, &value); err != nil {
+				return nil, err
+			}
+			return value, nil
+		}()
+		if err != nil {
+			realErr = err
+			continue
+		}
+		return value, nil
+	}
+	return nil, realErr
+}
+
 func (s *HTTPServer) allocStatsForward(allocID string,addr string) (interface{}, error) {
 
 	//get other node ip
@@ -157,6 +157,12 @@ type ClientConfig struct {
 	// NoHostUUID disables using the host's UUID and will force generation of a
 	// random UUID.
 	NoHostUUID bool `mapstructure:"no_host_uuid"`
+
+	// Soft traffic limits shared by all jobs on this agent. 0 for unlimited.
+	// They can be changed at runtime with the HTTP API.
+	SnapshotRowsPerSecond  int64 `mapstructure:"snapshot_rows_per_second"`
+	SnapshotBytesPerSecond int64 `mapstructure:"snapshot_bytes_per_second"`
+	IncrBytesPerSecond     int64 `mapstructure:"incr_bytes_per_second"`
 }
 
 // ServerConfig is configuration specific to the server mode
@@ -206,6 +212,20 @@ type Network struct {
 	// MAX_PAYLOAD is the maximum allowed payload size. Should be using
 	// something different if > 1MB payloads are needed.
 	MaxPayload int `mapstructure:"max_payload"`
+
+	// TLS of the nats server. The certificate is also used as the client certificate
+	// to connect to other agents, and should be valid for 127.0.0.1, which the embedded
+	// nats streaming server connects to.
+	TLSCertFile string `mapstructure:"tls_cert_file"`
+	TLSKeyFile  string `mapstructure:"tls_key_file"`
+	TLSCAFile   string `mapstructure:"tls_ca_file"`
+	// TLSVerify requires and verifies client certificates.
+	TLSVerify bool `mapstructure:"tls_verify"`
+
+	// Authentication of the nats server. Either Token or User/Password.
+	Token    string `mapstructure:"token"`
+	User     string `mapstructure:"user"`
+	Password string `mapstructure:"password"`
 }
 
 type Metric struct {
@@ -625,6 +645,15 @@ func (a *ClientConfig) Merge(b *ClientConfig) *ClientConfig {
 	if b.NoHostUUID {
 		result.NoHostUUID = b.NoHostUUID
 	}
+	if b.SnapshotRowsPerSecond != 0 {
+		result.SnapshotRowsPerSecond = b.SnapshotRowsPerSecond
+	}
+	if b.SnapshotBytesPerSecond != 0 {
+		result.SnapshotBytesPerSecond = b.SnapshotBytesPerSecond
+	}
+	if b.IncrBytesPerSecond != 0 {
+		result.IncrBytesPerSecond = b.IncrBytesPerSecond
+	}
 
 	// Add the servers
 	result.Servers = append(result.Servers, b.Servers...)
@@ -638,6 +667,27 @@ func (a *Network) Merge(b *Network) *Network {
 	if b.MaxPayload != 0 {
 		result.MaxPayload = b.MaxPayload
 	}
+	if b.TLSCertFile != "" {
+		result.TLSCertFile = b.TLSCertFile
+	}
+	if b.TLSKeyFile != "" {
+		result.TLSKeyFile = b.TLSKeyFile
+	}
+	if b.TLSCAFile != "" {
+		result.TLSCAFile = b.TLSCAFile
+	}
+	if b.TLSVerify {
+		result.TLSVerify = true
+	}
+	if b.Token != "" {
+		result.Token = b.Token
+	}
+	if b.User != "" {
+		result.User = b.User
+	}
+	if b.Password != "" {
+		result.Password = b.Password
+	}
 	return &result
 }
 
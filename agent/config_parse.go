@@ -327,6 +327,9 @@ func parseClient(result **ClientConfig, list *ast.ObjectList) error {
 		"managers",
 		"stats",
 		"no_host_uuid",
+		"snapshot_rows_per_second",
+		"snapshot_bytes_per_second",
+		"incr_bytes_per_second",
 	}
 	if err := checkHCLKeys(listVal, valid); err != nil {
 		return err
@@ -459,6 +462,13 @@ func parseNetwork(result **Network, list *ast.ObjectList) error {
 	// Check for invalid keys
 	valid := []string{
 		"max_payload",
+		"tls_cert_file",
+		"tls_key_file",
+		"tls_ca_file",
+		"tls_verify",
+		"token",
+		"user",
+		"password",
 	}
 	if err := checkHCLKeys(listVal, valid); err != nil {
 		return err
@@ -166,6 +166,7 @@ func (s *HTTPServer) registerHandlers() {
 	s.mux.HandleFunc("/v1/evaluation/", s.wrap(s.EvalSpecificRequest))
 
 	s.mux.HandleFunc("/v1/agent/allocation/", s.wrap(s.ClientAllocRequest))
+	s.mux.HandleFunc("/v1/agent/ratelimit", s.wrap(s.AgentRateLimitRequest))
 
 	s.mux.HandleFunc("/v1/self", s.wrap(s.AgentSelfRequest))
 	s.mux.HandleFunc("/v1/join", s.wrap(s.AgentJoinRequest))
@@ -7,6 +7,7 @@
 package agent
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"strconv"
@@ -255,7 +256,57 @@ func (s *HTTPServer) jobQuery(resp http.ResponseWriter, req *http.Request,
 
 	job := out.Job
 
-	return job, nil
+	return &jobInfo{Job: job, HeartbeatLag: s.jobHeartbeatLag(jobId)}, nil
+}
+
+// jobInfo is a job with the runtime info of its tasks.
+type jobInfo struct {
+	*models.Job
+	// of the running dest task. nil if not measured, see MySQLDriverConfig.HeartbeatInterval.
+	HeartbeatLag *models.HeartbeatLag
+}
+
+// jobHeartbeatLag returns the heartbeat lag from the stats of the running allocations of the job.
+// The allocations might be on other agents. It returns nil if the lag is unknown.
+func (s *HTTPServer) jobHeartbeatLag(jobId string) *models.HeartbeatLag {
+	args := models.JobSpecificRequest{
+		JobID: jobId,
+	}
+	args.Region = s.agent.config.Region
+	var out models.JobAllocationsResponse
+	if err := s.agent.RPC("Job.Allocations", &args, &out); err != nil {
+		s.logger.Debugf("cannot get allocations of job %v: %v", jobId, err)
+		return nil
+	}
+	for _, alloc := range out.Allocations {
+		if alloc.DesiredStatus != models.AllocDesiredStatusRun || alloc.ClientStatus != models.AllocClientStatusRunning {
+			continue
+		}
+		req, err := http.NewRequest("GET", "/v1/agent/allocation/"+alloc.ID+"/stats", nil)
+		if err != nil {
+			return nil
+		}
+		value, err := s.allocStats(alloc.ID, false, nil, req)
+		if err != nil {
+			s.logger.Debugf("cannot get stats of alloc %v: %v", alloc.ID, err)
+			continue
+		}
+		// A forwarded result is decoded from json.
+		bs, err := json.Marshal(value)
+		if err != nil {
+			continue
+		}
+		stats := &models.AllocStatistics{}
+		if err := json.Unmarshal(bs, stats); err != nil {
+			continue
+		}
+		for _, taskStats := range stats.Tasks {
+			if taskStats != nil && taskStats.HeartbeatLag != nil {
+				return taskStats.HeartbeatLag
+			}
+		}
+	}
+	return nil
 }
 
 func (s *HTTPServer) jobUpdate(resp http.ResponseWriter, req *http.Request,
@@ -55,6 +55,7 @@ require (
 	github.com/ikarishinjieva/golang-live-coverage-report v0.0.0-20190317040040-52af5b19c76c
 	github.com/issuj/gofaster v0.0.0-20170702192727-b08f1666d622
 	github.com/jmoiron/sqlx v1.2.0 // indirect
+	github.com/klauspost/compress v1.9.8
 	github.com/leekchan/timeutil v0.0.0-20150802142658-28917288c48d // indirect
 	github.com/lib/pq v1.2.0 // indirect
 	github.com/lytics/datemath v0.0.0-20180727225141-3ada1c10b5de // indirect
@@ -78,7 +79,7 @@ require (
 	github.com/outbrain/golib v0.0.0-20160315180704-2418949ac30d
 	github.com/pascaldekloe/goe v0.1.0 // indirect
 	github.com/pborman/uuid v1.2.0 // indirect
-	github.com/pierrec/lz4 v2.0.3+incompatible // indirect
+	github.com/pierrec/lz4 v2.0.3+incompatible
 	github.com/pingcap/dm v1.0.0-rc.1
 	github.com/pingcap/log v0.0.0-20190715063458-479153f07ebd // indirect
 	github.com/pingcap/parser v0.0.0-20190712081837-c3bdffe5d00e
@@ -109,7 +110,8 @@ require (
 	gopkg.in/vmihailenco/msgpack.v2 v2.9.1 // indirect
 )
 
-replace github.com/siddontang/go-mysql => github.com/ffffwh/go-mysql v0.0.0-20190808080613-dd15a3c6b925
+// The packages of github.com/ffffwh/go-mysql used by dtle, with the patches in third_party/go-mysql/README.md.
+replace github.com/siddontang/go-mysql => ./third_party/go-mysql
 
 replace github.com/araddon/qlbridge => github.com/ffffwh/qlbridge v0.0.0-20181026023605-fc2d5205dad3
 
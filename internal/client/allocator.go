@@ -573,6 +573,27 @@ func (r *Allocator) LatestAllocStats(taskFilter string) (*models.AllocStatistics
 	return astat, nil
 }
 
+// RateLimit returns the rate limit of the task which supports it.
+func (r *Allocator) RateLimit() (*models.RateLimit, error) {
+	for _, tr := range r.getWorkers() {
+		if h, ok := tr.rateLimitHandle(); ok {
+			return h.RateLimit(), nil
+		}
+	}
+	return nil, fmt.Errorf("allocation %q has no running task with rate limit", r.alloc.ID)
+}
+
+// SetRateLimit changes the rate limit of the task which supports it.
+func (r *Allocator) SetRateLimit(limit *models.RateLimit) error {
+	for _, tr := range r.getWorkers() {
+		if h, ok := tr.rateLimitHandle(); ok {
+			h.SetRateLimit(limit)
+			return nil
+		}
+	}
+	return fmt.Errorf("allocation %q has no running task with rate limit", r.alloc.ID)
+}
+
 // shouldUpdate takes the AllocModifyIndex of an allocation sent from the server and
 // checks if the current running allocation is behind and should be updated.
 func (r *Allocator) shouldUpdate(serverIndex uint64) bool {
@@ -32,6 +32,8 @@ import (
 
 	"github.com/actiontech/dtle/internal"
 	"github.com/actiontech/dtle/internal/client/driver"
+	"github.com/actiontech/dtle/internal/client/driver/common"
+	"github.com/actiontech/dtle/internal/client/driver/mysql/binlog"
 	"github.com/actiontech/dtle/internal/config"
 	. "github.com/actiontech/dtle/internal/g"
 	"github.com/actiontech/dtle/internal/models"
@@ -385,6 +387,28 @@ func (c *Client) GetAllocStats(allocID string) (AllocStatsReporter, error) {
 	return ar.StatsReporter(), nil
 }
 
+// GetAllocRateLimit returns the rate limit of the job of the allocation.
+func (c *Client) GetAllocRateLimit(allocID string) (*models.RateLimit, error) {
+	c.allocLock.RLock()
+	ar, ok := c.allocs[allocID]
+	c.allocLock.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown allocation ID %q", allocID)
+	}
+	return ar.RateLimit()
+}
+
+// SetAllocRateLimit changes the rate limit of the job of the allocation.
+func (c *Client) SetAllocRateLimit(allocID string, limit *models.RateLimit) error {
+	c.allocLock.RLock()
+	ar, ok := c.allocs[allocID]
+	c.allocLock.RUnlock()
+	if !ok {
+		return fmt.Errorf("unknown allocation ID %q", allocID)
+	}
+	return ar.SetRateLimit(limit)
+}
+
 // GetClientAlloc returns the allocation from the client
 func (c *Client) GetClientAlloc(allocID string) (*models.Allocation, error) {
 	all := c.allAllocs()
@@ -538,9 +562,33 @@ func (c *Client) setupNatsServer() error {
 		Trace:   true,
 		Debug:   true,
 	}
+	if security := c.config.NatsSecurity; security != nil {
+		tlsConfig, err := common.ServerTLSConfig(security)
+		if err != nil {
+			return err
+		}
+		if tlsConfig != nil {
+			nOpts.TLS = true
+			nOpts.TLSVerify = security.TLSVerify
+			nOpts.TLSConfig = tlsConfig
+		}
+		if security.Token != "" {
+			nOpts.Authorization = security.Token
+		} else if security.User != "" {
+			nOpts.Username = security.User
+			nOpts.Password = security.Password
+		}
+	}
 	c.logger.Debugf("agent: Starting nats streaming server [%v]", natsAddr)
 	sOpts := stand.GetDefaultOptions()
 	sOpts.ID = config.DefaultClusterID
+	if c.config.NatsSecurity.TLSEnabled() {
+		// The streaming server connects to the nats server as a client.
+		sOpts.Secure = true
+		sOpts.ClientCert = c.config.NatsSecurity.TLSCertFile
+		sOpts.ClientKey = c.config.NatsSecurity.TLSKeyFile
+		sOpts.ClientCA = c.config.NatsSecurity.TLSCAFile
+	}
 	//sOpts.MaxBytes = 10 * 1024
 	/*if c.config.LogLevel == "DEBUG" {
 		stand.ConfigureLogger(sOpts, &nOpts)
@@ -1399,6 +1447,18 @@ func (c *Client) removeAlloc(alloc *models.Allocation) error {
 		} else if err != nil {
 			c.logger.Errorf("error when deleting binlog file. job: %v, err: %v", alloc.JobID, err)
 		}
+		err = os.RemoveAll(path.Join(c.config.StateDir, "fullcopy", alloc.JobID))
+		if os.IsNotExist(err) {
+			// do nothing
+		} else if err != nil {
+			c.logger.Errorf("error when deleting full copy checkpoint. job: %v, err: %v", alloc.JobID, err)
+		}
+		err = os.RemoveAll(filepath.Dir(binlog.GetSchemaHistoryPath(c.config.StateDir, alloc.JobID)))
+		if os.IsNotExist(err) {
+			// do nothing
+		} else if err != nil {
+			c.logger.Errorf("error when deleting schema history. job: %v, err: %v", alloc.JobID, err)
+		}
 	}()
 
 	return nil
@@ -0,0 +1,240 @@
+package common
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4"
+
+	"github.com/actiontech/dtle/internal/models"
+)
+
+const (
+	CodecNone   = "none"
+	CodecSnappy = "snappy"
+	CodecZstd   = "zstd"
+	CodecLz4    = "lz4"
+)
+
+// The first byte of a compressed payload. The receiver decompresses by it,
+// so only the sender needs to choose the codec.
+const (
+	codecIdNone byte = iota
+	codecIdSnappy
+	codecIdZstd
+	codecIdLz4
+)
+
+// A lz4 block expands to at most 255 times of its size.
+const lz4MaxRatio = 255
+
+var codecNames = map[byte]string{
+	codecIdNone:   CodecNone,
+	codecIdSnappy: CodecSnappy,
+	codecIdZstd:   CodecZstd,
+	codecIdLz4:    CodecLz4,
+}
+
+var (
+	zstdDecodersLock sync.Mutex
+	// key: the max raw size (0 for no limit). The max payload is of the agent, so there are few decoders.
+	zstdDecoders = make(map[int]*zstd.Decoder)
+)
+
+func getZstdDecoder(maxRawSize int) (*zstd.Decoder, error) {
+	zstdDecodersLock.Lock()
+	defer zstdDecodersLock.Unlock()
+	if decoder, ok := zstdDecoders[maxRawSize]; ok {
+		return decoder, nil
+	}
+	var opts []zstd.DOption
+	if maxRawSize > 0 {
+		opts = append(opts, zstd.WithDecoderMaxMemory(uint64(maxRawSize)))
+	}
+	decoder, err := zstd.NewReader(nil, opts...)
+	if err != nil {
+		return nil, err
+	}
+	zstdDecoders[maxRawSize] = decoder
+	return decoder, nil
+}
+
+// Codec compresses the payloads of a job on the src, and decompresses them on the dest.
+// It also keeps the statistics of what it has done.
+type Codec struct {
+	id    byte
+	level int
+	// the max size of a payload before compression. 0 for no limit.
+	maxRawSize int
+
+	zstdEncoder *zstd.Encoder
+
+	// the codec to compress with, or of the last payload decompressed
+	lastId          uint32
+	rawBytes        uint64
+	compressedBytes uint64
+	timeNs          uint64
+}
+
+// NewCodec creates a codec compressing with name ("" for snappy) at level (0 for the default).
+// level is 1-22 for zstd, and the search depth of the high compression mode for lz4.
+// A dest might use any codec, as it decompresses the payloads by their headers.
+// maxRawSize (the max payload, 0 for no limit) bounds a payload both before compression and after decompression,
+// so that a bad payload could not be decompressed to an unbounded size.
+func NewCodec(name string, level int, maxRawSize int) (*Codec, error) {
+	c := &Codec{level: level, maxRawSize: maxRawSize}
+	switch name {
+	case CodecNone:
+		c.id = codecIdNone
+	case "", CodecSnappy:
+		c.id = codecIdSnappy
+	case CodecZstd:
+		c.id = codecIdZstd
+		opts := []zstd.EOption{zstd.WithEncoderConcurrency(1)}
+		if level > 0 {
+			opts = append(opts, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+		}
+		var err error
+		c.zstdEncoder, err = zstd.NewWriter(nil, opts...)
+		if err != nil {
+			return nil, err
+		}
+	case CodecLz4:
+		c.id = codecIdLz4
+	default:
+		return nil, fmt.Errorf("unknown compression codec %v", name)
+	}
+	c.lastId = uint32(c.id)
+	return c, nil
+}
+
+func (c *Codec) Compress(data []byte) (result []byte, err error) {
+	if c.maxRawSize > 0 && len(data) > c.maxRawSize {
+		return nil, fmt.Errorf("codec: payload of %v bytes exceeds the max payload %v", len(data), c.maxRawSize)
+	}
+	start := time.Now()
+	id := c.id
+	switch id {
+	case codecIdNone:
+		result = make([]byte, 1, 1+len(data))
+		result = append(result, data...)
+	case codecIdSnappy:
+		result = make([]byte, 1+snappy.MaxEncodedLen(len(data)))
+		result = result[:1+len(snappy.Encode(result[1:], data))]
+	case codecIdZstd:
+		result = c.zstdEncoder.EncodeAll(data, make([]byte, 1, 1+len(data)/2))
+	case codecIdLz4:
+		// the raw length is needed to decompress a lz4 block
+		result = make([]byte, 5+lz4.CompressBlockBound(len(data)))
+		binary.BigEndian.PutUint32(result[1:], uint32(len(data)))
+		var n int
+		if c.level > 0 {
+			n, err = lz4.CompressBlockHC(data, result[5:], c.level)
+		} else {
+			n, err = lz4.CompressBlock(data, result[5:], make([]int, 1<<16))
+		}
+		if err != nil {
+			return nil, err
+		}
+		if n == 0 {
+			// incompressible
+			id = codecIdNone
+			result = append(result[:1], data...)
+		} else {
+			result = result[:5+n]
+		}
+	}
+	result[0] = id
+
+	atomic.AddUint64(&c.rawBytes, uint64(len(data)))
+	atomic.AddUint64(&c.compressedBytes, uint64(len(result)))
+	atomic.AddUint64(&c.timeNs, uint64(time.Since(start)))
+	return result, nil
+}
+
+func (c *Codec) Decompress(data []byte) (result []byte, err error) {
+	if len(data) < 1 {
+		return nil, fmt.Errorf("codec: empty payload")
+	}
+	start := time.Now()
+	id := data[0]
+	body := data[1:]
+	switch id {
+	case codecIdNone:
+		result = body
+	case codecIdSnappy:
+		var rawLen int
+		rawLen, err = snappy.DecodedLen(body)
+		if err == nil && c.maxRawSize > 0 && rawLen > c.maxRawSize {
+			return nil, fmt.Errorf("codec: bad snappy payload. raw length %v exceeds the max payload %v", rawLen, c.maxRawSize)
+		}
+		if err == nil {
+			result, err = snappy.Decode(nil, body)
+		}
+	case codecIdZstd:
+		var decoder *zstd.Decoder
+		decoder, err = getZstdDecoder(c.maxRawSize)
+		if err == nil {
+			result, err = decoder.DecodeAll(body, nil)
+		}
+	case codecIdLz4:
+		if len(body) < 4 {
+			return nil, fmt.Errorf("codec: bad lz4 payload")
+		}
+		// The raw length in the header is not trusted. The payload itself is bounded by the max payload
+		// of the transport, and so is what it could be decompressed to.
+		rawLen := uint64(binary.BigEndian.Uint32(body))
+		if rawLen > lz4MaxRatio*uint64(len(body)-4) {
+			return nil, fmt.Errorf("codec: bad lz4 payload. raw length %v is too large for %v compressed bytes",
+				rawLen, len(body)-4)
+		}
+		if c.maxRawSize > 0 && rawLen > uint64(c.maxRawSize) {
+			return nil, fmt.Errorf("codec: bad lz4 payload. raw length %v exceeds the max payload %v", rawLen, c.maxRawSize)
+		}
+		// One more byte to tell a too small raw length, as UncompressBlock stops silently at the end of dst.
+		result = make([]byte, rawLen+1)
+		var n int
+		n, err = lz4.UncompressBlock(body[4:], result)
+		if err == nil && uint64(n) != rawLen {
+			err = fmt.Errorf("codec: lz4 payload length mismatch. expect %v, got %v", rawLen, n)
+		}
+		result = result[:rawLen]
+	default:
+		return nil, fmt.Errorf("codec: unknown codec id %v. the src might be of a newer version", id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	// The zstd decoder checks the size by blocks, and might exceed the limit by the last one.
+	if c.maxRawSize > 0 && len(result) > c.maxRawSize {
+		return nil, fmt.Errorf("codec: payload of %v bytes after decompression exceeds the max payload %v",
+			len(result), c.maxRawSize)
+	}
+
+	atomic.StoreUint32(&c.lastId, uint32(id))
+	atomic.AddUint64(&c.rawBytes, uint64(len(result)))
+	atomic.AddUint64(&c.compressedBytes, uint64(len(data)))
+	atomic.AddUint64(&c.timeNs, uint64(time.Since(start)))
+	return result, nil
+}
+
+func (c *Codec) Stat() *models.CompressionStat {
+	if c == nil {
+		return nil
+	}
+	stat := &models.CompressionStat{
+		Codec:           codecNames[byte(atomic.LoadUint32(&c.lastId))],
+		RawBytes:        atomic.LoadUint64(&c.rawBytes),
+		CompressedBytes: atomic.LoadUint64(&c.compressedBytes),
+		TimeMs:          atomic.LoadUint64(&c.timeNs) / uint64(time.Millisecond),
+	}
+	if stat.RawBytes > 0 {
+		stat.Ratio = float64(stat.CompressedBytes) / float64(stat.RawBytes)
+	}
+	return stat
+}
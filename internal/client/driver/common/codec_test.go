@@ -0,0 +1,114 @@
+package common
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestCodec(t *testing.T) {
+	data := bytes.Repeat([]byte("dtle "), 1000)
+	for _, name := range []string{CodecNone, CodecSnappy, CodecZstd, CodecLz4} {
+		for _, raw := range [][]byte{{}, []byte("x"), data} {
+			c, err := NewCodec(name, 0, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			compressed, err := c.Compress(raw)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := c.Decompress(compressed)
+			if err != nil {
+				t.Fatalf("%v: %v", name, err)
+			}
+			if !bytes.Equal(got, raw) {
+				t.Fatalf("%v: got %v bytes, want %v", name, len(got), len(raw))
+			}
+		}
+	}
+}
+
+func TestCodecBadLz4(t *testing.T) {
+	c, err := NewCodec(CodecLz4, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compressed, err := c.Compress(bytes.Repeat([]byte("a"), 1000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if compressed[0] != codecIdLz4 {
+		t.Fatalf("not compressed by lz4")
+	}
+	for _, rawLen := range []uint32{0xffffffff, 999, 1001} {
+		bad := append([]byte{}, compressed...)
+		binary.BigEndian.PutUint32(bad[1:], rawLen)
+		if _, err := c.Decompress(bad); err == nil {
+			t.Fatalf("no error for raw length %v", rawLen)
+		}
+	}
+	for _, bad := range [][]byte{{}, {codecIdLz4, 0, 0}, {0xff}} {
+		if _, err := c.Decompress(bad); err == nil {
+			t.Fatalf("no error for %v", bad)
+		}
+	}
+}
+
+func TestCodecMaxRawSize(t *testing.T) {
+	const maxRawSize = 64 * 1024
+	data := bytes.Repeat([]byte("dtle "), maxRawSize/5+1)
+
+	// payloads compressed by a src without the limit
+	var payloads [][]byte
+	for _, name := range []string{CodecNone, CodecSnappy, CodecZstd, CodecLz4} {
+		c, err := NewCodec(name, 0, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		compressed, err := c.Compress(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		payloads = append(payloads, compressed)
+	}
+	// a zstd stream, whose frame has no content size in its header, and a window within the limit
+	var stream bytes.Buffer
+	w, err := zstd.NewWriter(&stream, zstd.WithEncoderConcurrency(1), zstd.WithWindowSize(maxRawSize/2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 16; i++ {
+		if _, err := w.Write(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	payloads = append(payloads, append([]byte{codecIdZstd}, stream.Bytes()...))
+
+	c, err := NewCodec(CodecZstd, 0, maxRawSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, payload := range payloads {
+		if _, err := c.Decompress(payload); err == nil {
+			t.Fatalf("no error for an oversized payload of codec %v", codecNames[payload[0]])
+		}
+	}
+	if _, err := c.Compress(data); err == nil {
+		t.Fatalf("no error for compressing an oversized payload")
+	}
+
+	// within the limit
+	compressed, err := c.Compress(data[:maxRawSize])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := c.Decompress(compressed); err != nil || !bytes.Equal(got, data[:maxRawSize]) {
+		t.Fatalf("Decompress() = %v bytes, %v", len(got), err)
+	}
+}
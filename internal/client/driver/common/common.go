@@ -3,6 +3,9 @@ package common
 import (
 	"github.com/satori/go.uuid"
 	"github.com/siddontang/go-mysql/mysql"
+
+	"github.com/actiontech/dtle/internal/config"
+	"github.com/actiontech/dtle/internal/models"
 )
 
 type ExecContext struct {
@@ -10,6 +13,10 @@ type ExecContext struct {
 	Tp         string
 	MaxPayload int
 	StateDir   string
+	// EmitEvent appends an event to the task state. It might be nil.
+	EmitEvent func(event *models.TaskEvent)
+	// NatsSecurity is the setting of the agent. It might be nil.
+	NatsSecurity *config.NatsSecurity
 }
 
 func DtleParseMysqlGTIDSet(gtidSetStr string) (*mysql.MysqlGTIDSet, error) {
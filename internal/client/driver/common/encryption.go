@@ -0,0 +1,206 @@
+package common
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const payloadKeyCheckInterval = time.Second
+
+// PayloadCipher encrypts the payloads of a job with AES-GCM.
+//
+// The keys are read from a file on the agent, one key per line as "<id>:<base64 of 16, 24 or 32 bytes>".
+// The last key is used to encrypt, and the others are kept to decrypt. The file is read again
+// once modified, so the keys can be rotated without restarting the job: add the new key to the dest
+// before the src, and remove the old one after the in-flight payloads are applied.
+//
+// An encrypted payload is: id length (1 byte), id, nonce, sealed data. The subject is authenticated as well.
+type PayloadCipher struct {
+	keyFile string
+	logger  *logrus.Entry
+
+	lock      sync.Mutex
+	modTime   time.Time
+	lastCheck time.Time
+	keys      map[string]cipher.AEAD
+	currentId string
+}
+
+// NewPayloadCipher returns nil if keyFile is empty. A nil PayloadCipher passes the payloads as is.
+func NewPayloadCipher(keyFile string, logger *logrus.Entry) (*PayloadCipher, error) {
+	if keyFile == "" {
+		return nil, nil
+	}
+	c := &PayloadCipher{
+		keyFile: keyFile,
+		logger:  logger,
+	}
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *PayloadCipher) load() error {
+	fi, err := os.Stat(c.keyFile)
+	if err != nil {
+		return err
+	}
+	content, err := ioutil.ReadFile(c.keyFile)
+	if err != nil {
+		return err
+	}
+
+	keys := make(map[string]cipher.AEAD)
+	currentId := ""
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 || parts[0] == "" || len(parts[0]) > 255 {
+			return fmt.Errorf("bad key line in %v. expect '<id>:<base64 key>'", c.keyFile)
+		}
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(parts[1]))
+		if err != nil {
+			return fmt.Errorf("bad key %v in %v: %v", parts[0], c.keyFile, err)
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return fmt.Errorf("bad key %v in %v: %v", parts[0], c.keyFile, err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return err
+		}
+		keys[parts[0]] = aead
+		currentId = parts[0]
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if currentId == "" {
+		return fmt.Errorf("no key in %v", c.keyFile)
+	}
+
+	if c.currentId != "" && c.currentId != currentId {
+		c.logger.Infof("payload cipher: encryption key rotated from %v to %v", c.currentId, currentId)
+	}
+	c.keys = keys
+	c.currentId = currentId
+	c.modTime = fi.ModTime()
+	return nil
+}
+
+// reload reads the key file again if it is modified, or force. A bad file is logged, and the old keys are kept.
+func (c *PayloadCipher) reload(force bool) {
+	if !force && time.Since(c.lastCheck) < payloadKeyCheckInterval {
+		return
+	}
+	c.lastCheck = time.Now()
+	fi, err := os.Stat(c.keyFile)
+	if err != nil {
+		c.logger.Warnf("payload cipher: cannot stat key file. keep the old keys. err: %v", err)
+		return
+	}
+	if !force && fi.ModTime().Equal(c.modTime) {
+		return
+	}
+	if err := c.load(); err != nil {
+		c.logger.Warnf("payload cipher: cannot reload key file. keep the old keys. err: %v", err)
+	}
+}
+
+// CurrentKeyId returns the id of the key to encrypt with, or "" if c is nil.
+func (c *PayloadCipher) CurrentKeyId() string {
+	if c == nil {
+		return ""
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.reload(false)
+	return c.currentId
+}
+
+// HasKey returns true if the payloads encrypted with the key id can be decrypted.
+func (c *PayloadCipher) HasKey(id string) bool {
+	if c == nil {
+		return false
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.reload(false)
+	if _, ok := c.keys[id]; !ok {
+		c.reload(true)
+	}
+	_, ok := c.keys[id]
+	return ok
+}
+
+func (c *PayloadCipher) Encrypt(subject string, data []byte) ([]byte, error) {
+	if c == nil {
+		return data, nil
+	}
+	c.lock.Lock()
+	c.reload(false)
+	id := c.currentId
+	aead := c.keys[id]
+	c.lock.Unlock()
+
+	headerLen := 1 + len(id) + aead.NonceSize()
+	result := make([]byte, headerLen, headerLen+len(data)+aead.Overhead())
+	result[0] = byte(len(id))
+	copy(result[1:], id)
+	nonce := result[1+len(id):]
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(result, nonce, data, []byte(subject)), nil
+}
+
+func (c *PayloadCipher) Decrypt(subject string, data []byte) ([]byte, error) {
+	if c == nil {
+		return data, nil
+	}
+	if len(data) < 1 || len(data) < 1+int(data[0]) {
+		return nil, fmt.Errorf("payload cipher: bad encrypted payload")
+	}
+	id := string(data[1 : 1+data[0]])
+
+	c.lock.Lock()
+	c.reload(false)
+	aead, ok := c.keys[id]
+	if !ok {
+		// The src might have rotated the key just now.
+		c.reload(true)
+		aead, ok = c.keys[id]
+	}
+	c.lock.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("payload cipher: unknown key %v. check the key file %v", id, c.keyFile)
+	}
+
+	sealed := data[1+len(id):]
+	if len(sealed) < aead.NonceSize() {
+		return nil, fmt.Errorf("payload cipher: bad encrypted payload")
+	}
+	plain, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(subject))
+	if err != nil {
+		return nil, fmt.Errorf("payload cipher: cannot decrypt with key %v: %v", id, err)
+	}
+	return plain, nil
+}
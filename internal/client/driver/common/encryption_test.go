@@ -0,0 +1,190 @@
+package common
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func testKeyLine(id string, n int) string {
+	return fmt.Sprintf("%s:%s\n", id, base64.StdEncoding.EncodeToString(bytes.Repeat([]byte(id[:1]), n)))
+}
+
+// writeKeyFile writes the key file with a distinct modification time, so the cipher reads it again.
+func writeKeyFile(t *testing.T, path string, content string, modTime time.Time) {
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newTestCipher(t *testing.T, path string) *PayloadCipher {
+	c, err := NewPayloadCipher(path, logrus.NewEntry(logrus.New()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestPayloadCipher(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dtle-cipher")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "keys")
+	writeKeyFile(t, path, "# comment\n\n"+testKeyLine("k1", 16)+testKeyLine("a2", 32), time.Unix(1000, 0))
+	c := newTestCipher(t, path)
+	if id := c.CurrentKeyId(); id != "a2" {
+		t.Fatalf("current key %v", id)
+	}
+
+	data := []byte("payload")
+	encrypted, err := c.Encrypt("job1_full", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(encrypted, data) {
+		t.Fatalf("not encrypted")
+	}
+	got, err := c.Decrypt("job1_full", encrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatalf("got %q", got)
+	}
+
+	// decryption failures
+	if _, err := c.Decrypt("job2_full", encrypted); err == nil {
+		t.Fatalf("no error for another subject")
+	}
+	tampered := append([]byte{}, encrypted...)
+	tampered[len(tampered)-1] ^= 1
+	if _, err := c.Decrypt("job1_full", tampered); err == nil {
+		t.Fatalf("no error for a tampered payload")
+	}
+	for n := 0; n < 1+2+12; n++ {
+		if _, err := c.Decrypt("job1_full", encrypted[:n]); err == nil {
+			t.Fatalf("no error for a truncated payload of %v bytes", n)
+		}
+	}
+	unknown := append([]byte{2, 'z', '9'}, encrypted[3:]...)
+	if _, err := c.Decrypt("job1_full", unknown); err == nil {
+		t.Fatalf("no error for an unknown key")
+	}
+
+	// A nil cipher passes the payloads as is.
+	var nilCipher *PayloadCipher
+	if got, err := nilCipher.Encrypt("job1_full", data); err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("got %q, %v", got, err)
+	}
+	if got, err := nilCipher.Decrypt("job1_full", data); err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("got %q, %v", got, err)
+	}
+	if nilCipher.CurrentKeyId() != "" || nilCipher.HasKey("a2") {
+		t.Fatalf("nil cipher has a key")
+	}
+}
+
+func TestPayloadCipherRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dtle-cipher")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	srcPath := filepath.Join(dir, "src")
+	destPath := filepath.Join(dir, "dest")
+	writeKeyFile(t, srcPath, testKeyLine("k1", 16), time.Unix(1000, 0))
+	writeKeyFile(t, destPath, testKeyLine("k1", 16), time.Unix(1000, 0))
+	src := newTestCipher(t, srcPath)
+	dest := newTestCipher(t, destPath)
+
+	old, err := src.Encrypt("job1_incr_hete", []byte("old"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The src rotates to k2 before the dest has it.
+	writeKeyFile(t, srcPath, testKeyLine("k1", 16)+testKeyLine("k2", 24), time.Unix(2000, 0))
+	src.lastCheck = time.Time{}
+	if id := src.CurrentKeyId(); id != "k2" {
+		t.Fatalf("current key %v", id)
+	}
+	rotated, err := src.Encrypt("job1_incr_hete", []byte("new"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dest.HasKey("k2") {
+		t.Fatalf("dest has k2")
+	}
+	if _, err := dest.Decrypt("job1_incr_hete", rotated); err == nil {
+		t.Fatalf("no error for a key not on the dest")
+	}
+
+	// An unknown key makes the dest read the file at once, without waiting for the check interval.
+	writeKeyFile(t, destPath, testKeyLine("k1", 16)+testKeyLine("k2", 24), time.Unix(2000, 0))
+	if !dest.HasKey("k2") {
+		t.Fatalf("dest has no k2")
+	}
+	for payload, want := range map[string][]byte{"old": old, "new": rotated} {
+		got, err := dest.Decrypt("job1_incr_hete", want)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != payload {
+			t.Fatalf("got %q, want %q", got, payload)
+		}
+	}
+
+	// k1 is removed after the in-flight payloads are applied.
+	writeKeyFile(t, destPath, testKeyLine("k2", 24), time.Unix(3000, 0))
+	dest.lastCheck = time.Time{}
+	if _, err := dest.Decrypt("job1_incr_hete", old); err == nil {
+		t.Fatalf("no error for a removed key")
+	}
+
+	// A bad key file is ignored, and the old keys are kept.
+	writeKeyFile(t, destPath, "k3:not base64\n", time.Unix(4000, 0))
+	dest.lastCheck = time.Time{}
+	if got, err := dest.Decrypt("job1_incr_hete", rotated); err != nil || string(got) != "new" {
+		t.Fatalf("got %q, %v", got, err)
+	}
+}
+
+func TestNewPayloadCipher(t *testing.T) {
+	if c, err := NewPayloadCipher("", nil); c != nil || err != nil {
+		t.Fatalf("got %v, %v", c, err)
+	}
+	dir, err := ioutil.TempDir("", "dtle-cipher")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "keys")
+	for _, content := range []string{
+		"",
+		"# no key\n",
+		"k1\n",
+		":" + base64.StdEncoding.EncodeToString(make([]byte, 16)) + "\n",
+		"k1:not base64\n",
+		"k1:" + base64.StdEncoding.EncodeToString(make([]byte, 15)) + "\n",
+	} {
+		writeKeyFile(t, path, content, time.Unix(1000, 0))
+		if _, err := NewPayloadCipher(path, logrus.NewEntry(logrus.New())); err == nil {
+			t.Fatalf("no error for %q", content)
+		}
+	}
+	if _, err := NewPayloadCipher(filepath.Join(dir, "none"), logrus.NewEntry(logrus.New())); err == nil {
+		t.Fatalf("no error for a missing file")
+	}
+}
@@ -0,0 +1,251 @@
+package common
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Credit-based flow control of the incremental payloads, since WireVersionFlowControl.
+//
+// The dest grants credits in entries and bytes, up to a window, and returns them as the entries leave its queue.
+// The src takes the credits of a payload before sending it, and waits if there are not enough,
+// so it stops reading binlog when the dest falls behind, instead of resending on timeout.
+// The counters of a CreditMsg are cumulative, so a lost or repeated message does no harm.
+
+const (
+	// The default window of entries, for a dest without a queue.
+	DefaultCreditWindowEntries = 1000
+	// The default window of bytes (of the binlog entries, see BinlogEntry.OriginalSize) on the dest.
+	DefaultCreditWindowBytes = 64 * 1024 * 1024
+
+	creditCheckInterval = 100 * time.Millisecond
+	// The dest sends the credits at least this often, in case a message is lost or the src is restarted.
+	creditResendInterval = time.Second
+)
+
+// CreditMsg is published by the dest on "<subject>_credit".
+type CreditMsg struct {
+	// Changes when the dest is restarted, which resets the counters.
+	Epoch int64
+	// The window plus the credits returned.
+	GrantedEntries int64
+	GrantedBytes   int64
+	WindowEntries  int64
+	WindowBytes    int64
+}
+
+func (c *CreditMsg) Marshal() []byte {
+	w := &WireWriter{}
+	w.Svarint(1, c.Epoch)
+	w.Svarint(2, c.GrantedEntries)
+	w.Svarint(3, c.GrantedBytes)
+	w.Svarint(4, c.WindowEntries)
+	w.Svarint(5, c.WindowBytes)
+	return w.Bytes()
+}
+
+func UnmarshalCreditMsg(data []byte) (*CreditMsg, error) {
+	c := &CreditMsg{}
+	r := NewWireReader(data)
+	for r.Next() {
+		switch r.Field() {
+		case 1:
+			c.Epoch = r.Svarint()
+		case 2:
+			c.GrantedEntries = r.Svarint()
+		case 3:
+			c.GrantedBytes = r.Svarint()
+		case 4:
+			c.WindowEntries = r.Svarint()
+		case 5:
+			c.WindowBytes = r.Svarint()
+		default:
+			r.Skip()
+		}
+	}
+	return c, r.Err()
+}
+
+// CreditGranter grants the credits on the dest.
+type CreditGranter struct {
+	transport Transport
+	subject   string
+	logger    *logrus.Entry
+
+	epoch         int64
+	windowEntries int64
+	windowBytes   int64
+
+	releasedEntries int64
+	releasedBytes   int64
+}
+
+func NewCreditGranter(t Transport, subject string, windowEntries int64, windowBytes int64,
+	logger *logrus.Entry) *CreditGranter {
+
+	if windowBytes <= 0 {
+		windowBytes = DefaultCreditWindowBytes
+	}
+	return &CreditGranter{
+		transport:     t,
+		subject:       subject,
+		logger:        logger,
+		epoch:         time.Now().UnixNano(),
+		windowEntries: windowEntries,
+		windowBytes:   windowBytes,
+	}
+}
+
+// Release returns the credits of entries which have left the queue.
+func (g *CreditGranter) Release(entries int64, bytes int64) {
+	atomic.AddInt64(&g.releasedEntries, entries)
+	atomic.AddInt64(&g.releasedBytes, bytes)
+}
+
+func (g *CreditGranter) creditMsg() *CreditMsg {
+	return &CreditMsg{
+		Epoch:          g.epoch,
+		GrantedEntries: g.windowEntries + atomic.LoadInt64(&g.releasedEntries),
+		GrantedBytes:   g.windowBytes + atomic.LoadInt64(&g.releasedBytes),
+		WindowEntries:  g.windowEntries,
+		WindowBytes:    g.windowBytes,
+	}
+}
+
+// Run publishes the credits when they are released, until stopCh is closed.
+func (g *CreditGranter) Run(stopCh <-chan struct{}) {
+	ticker := time.NewTicker(creditCheckInterval)
+	defer ticker.Stop()
+	var last *CreditMsg
+	lastSent := time.Time{}
+	for {
+		msg := g.creditMsg()
+		if last == nil || *msg != *last || time.Since(lastSent) >= creditResendInterval {
+			if err := g.transport.Publish(fmt.Sprintf("%s_credit", g.subject), msg.Marshal()); err != nil {
+				g.logger.Warnf("flow control: cannot publish credits. err: %v", err)
+			} else {
+				last = msg
+				lastSent = time.Now()
+			}
+		}
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// CreditAccount takes the credits on the src.
+type CreditAccount struct {
+	logger *logrus.Entry
+
+	lock            sync.Mutex
+	granted         CreditMsg
+	consumedEntries int64
+	consumedBytes   int64
+	// closed and replaced when the credits are granted
+	changedCh chan struct{}
+
+	throttledNs int64
+}
+
+func NewCreditAccount(t Transport, subject string, logger *logrus.Entry) (*CreditAccount, error) {
+	c := &CreditAccount{
+		logger:    logger,
+		changedCh: make(chan struct{}),
+	}
+	err := t.Subscribe(fmt.Sprintf("%s_credit", subject), func(m *Msg) {
+		msg, err := UnmarshalCreditMsg(m.Data)
+		if err != nil {
+			logger.Warnf("flow control: bad credit message. err: %v", err)
+			return
+		}
+		c.grant(msg)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *CreditAccount) grant(msg *CreditMsg) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if msg.Epoch < c.granted.Epoch {
+		// from the dest before restarted
+		return
+	} else if msg.Epoch > c.granted.Epoch {
+		if c.granted.Epoch != 0 {
+			c.logger.Infof("flow control: the dest is restarted. reset the credits")
+		}
+		c.consumedEntries = 0
+		c.consumedBytes = 0
+	} else if msg.GrantedEntries < c.granted.GrantedEntries || msg.GrantedBytes < c.granted.GrantedBytes {
+		// an old message
+		return
+	}
+	c.granted = *msg
+	close(c.changedCh)
+	c.changedCh = make(chan struct{})
+}
+
+// enough must be called with the lock held.
+func (c *CreditAccount) enough(entries int64, bytes int64) bool {
+	if c.granted.Epoch == 0 {
+		return false
+	}
+	if c.granted.GrantedEntries-c.consumedEntries >= entries && c.granted.GrantedBytes-c.consumedBytes >= bytes {
+		return true
+	}
+	// Nothing in flight. Let a payload larger than the window through, or it would wait forever.
+	return c.granted.GrantedEntries-c.consumedEntries == c.granted.WindowEntries &&
+		c.granted.GrantedBytes-c.consumedBytes == c.granted.WindowBytes
+}
+
+// Acquire waits until there are enough credits, and takes them. onWait is called once if it has to wait.
+func (c *CreditAccount) Acquire(entries int64, bytes int64, stopCh <-chan struct{}, onWait func()) error {
+	var start time.Time
+	for {
+		c.lock.Lock()
+		if c.enough(entries, bytes) {
+			c.consumedEntries += entries
+			c.consumedBytes += bytes
+			c.lock.Unlock()
+			if !start.IsZero() {
+				atomic.AddInt64(&c.throttledNs, int64(time.Since(start)))
+			}
+			return nil
+		}
+		changedCh := c.changedCh
+		c.lock.Unlock()
+
+		if start.IsZero() {
+			start = time.Now()
+			if onWait != nil {
+				onWait()
+			}
+		}
+		select {
+		case <-changedCh:
+		case <-stopCh:
+			return fmt.Errorf("flow control: aborted while waiting for credits")
+		}
+	}
+}
+
+// Stat returns the credits available, and the time spent waiting for the credits in milliseconds.
+func (c *CreditAccount) Stat() (entries int64, bytes int64, throttledMs int64) {
+	if c == nil {
+		return 0, 0, 0
+	}
+	c.lock.Lock()
+	entries = c.granted.GrantedEntries - c.consumedEntries
+	bytes = c.granted.GrantedBytes - c.consumedBytes
+	c.lock.Unlock()
+	return entries, bytes, atomic.LoadInt64(&c.throttledNs) / int64(time.Millisecond)
+}
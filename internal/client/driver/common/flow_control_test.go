@@ -0,0 +1,222 @@
+package common
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	gonats "github.com/nats-io/go-nats"
+	"github.com/sirupsen/logrus"
+)
+
+// memTransport delivers the published messages to the subscribers at once.
+type memTransport struct {
+	lock     sync.Mutex
+	handlers map[string]MsgHandler
+}
+
+func (t *memTransport) Publish(subject string, data []byte) error {
+	t.lock.Lock()
+	handler := t.handlers[subject]
+	t.lock.Unlock()
+	if handler != nil {
+		handler(&Msg{Subject: subject, Data: data})
+	}
+	return nil
+}
+
+func (t *memTransport) Request(subject string, data []byte, timeout time.Duration) error {
+	return t.Publish(subject, data)
+}
+
+func (t *memTransport) Subscribe(subject string, handler MsgHandler) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.handlers == nil {
+		t.handlers = make(map[string]MsgHandler)
+	}
+	t.handlers[subject] = handler
+	return nil
+}
+
+func (t *memTransport) Statistics() gonats.Statistics {
+	return gonats.Statistics{}
+}
+
+func (t *memTransport) Close() {
+}
+
+func TestCreditMsgWire(t *testing.T) {
+	for _, msg := range []*CreditMsg{
+		{},
+		{Epoch: 1600000000000000000, GrantedEntries: 1010, GrantedBytes: 1 << 40, WindowEntries: 1000, WindowBytes: 1 << 26},
+	} {
+		got, err := UnmarshalCreditMsg(msg.Marshal())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, msg) {
+			t.Fatalf("got %+v, want %+v", got, msg)
+		}
+	}
+}
+
+// tryAcquire returns true if the credits are taken without waiting.
+func tryAcquire(t *testing.T, c *CreditAccount, entries int64, bytes int64) bool {
+	stopCh := make(chan struct{})
+	waited := false
+	err := c.Acquire(entries, bytes, stopCh, func() {
+		if waited {
+			t.Fatalf("onWait is called twice")
+		}
+		waited = true
+		close(stopCh)
+	})
+	if (err == nil) == waited {
+		t.Fatalf("err %v, waited %v", err, waited)
+	}
+	return err == nil
+}
+
+func assertCredits(t *testing.T, c *CreditAccount, wantEntries int64, wantBytes int64) {
+	entries, bytes, _ := c.Stat()
+	if entries != wantEntries || bytes != wantBytes {
+		t.Fatalf("credits: got %v/%v, want %v/%v", entries, bytes, wantEntries, wantBytes)
+	}
+}
+
+func TestCreditAccount(t *testing.T) {
+	transport := &memTransport{}
+	account, err := NewCreditAccount(transport, "job1", logrus.NewEntry(logrus.New()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	granter := NewCreditGranter(transport, "job1", 3, 100, logrus.NewEntry(logrus.New()))
+	publish := func(msg *CreditMsg) {
+		if err := transport.Publish("job1_credit", msg.Marshal()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Nothing is sent before the dest grants the credits.
+	if tryAcquire(t, account, 1, 10) {
+		t.Fatalf("acquired before granted")
+	}
+	publish(granter.creditMsg())
+	assertCredits(t, account, 3, 100)
+
+	if !tryAcquire(t, account, 1, 60) || !tryAcquire(t, account, 1, 40) {
+		t.Fatalf("cannot acquire in the window")
+	}
+	assertCredits(t, account, 1, 0)
+	if tryAcquire(t, account, 1, 1) {
+		t.Fatalf("acquired beyond the window")
+	}
+
+	// The dest returns the credits as the entries leave its queue.
+	granter.Release(1, 60)
+	publish(granter.creditMsg())
+	assertCredits(t, account, 2, 60)
+	// A repeated or an older message does no harm.
+	publish(granter.creditMsg())
+	publish(&CreditMsg{Epoch: granter.epoch, GrantedEntries: 3, GrantedBytes: 100, WindowEntries: 3, WindowBytes: 100})
+	assertCredits(t, account, 2, 60)
+
+	// A waiting Acquire goes on after the credits are granted.
+	doneCh := make(chan error)
+	go func() {
+		doneCh <- account.Acquire(2, 100, make(chan struct{}), nil)
+	}()
+	select {
+	case err := <-doneCh:
+		t.Fatalf("acquired without credits. err: %v", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+	granter.Release(1, 40)
+	publish(granter.creditMsg())
+	if err := <-doneCh; err != nil {
+		t.Fatal(err)
+	}
+	assertCredits(t, account, 1, 0)
+
+	// A payload larger than the window passes only when nothing is in flight.
+	granter.Release(2, 100)
+	publish(granter.creditMsg())
+	assertCredits(t, account, 3, 100)
+	if !tryAcquire(t, account, 1, 500) {
+		t.Fatalf("cannot acquire a large payload with nothing in flight")
+	}
+	if tryAcquire(t, account, 1, 1) {
+		t.Fatalf("acquired beyond the window")
+	}
+	granter.Release(1, 500)
+	publish(granter.creditMsg())
+	assertCredits(t, account, 3, 100)
+}
+
+func TestCreditAccountDestRestart(t *testing.T) {
+	transport := &memTransport{}
+	account, err := NewCreditAccount(transport, "job1", logrus.NewEntry(logrus.New()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	publish := func(msg *CreditMsg) {
+		if err := transport.Publish("job1_credit", msg.Marshal()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	publish(&CreditMsg{Epoch: 10, GrantedEntries: 1005, GrantedBytes: 5000, WindowEntries: 1000, WindowBytes: 1000})
+	if !tryAcquire(t, account, 1000, 5000) {
+		t.Fatalf("cannot acquire in the window")
+	}
+	assertCredits(t, account, 5, 0)
+
+	// The restarted dest has a new epoch and counts from 0. The payloads in flight are lost with the old dest.
+	publish(&CreditMsg{Epoch: 20, GrantedEntries: 1000, GrantedBytes: 1000, WindowEntries: 1000, WindowBytes: 1000})
+	assertCredits(t, account, 1000, 1000)
+	if !tryAcquire(t, account, 1, 10) {
+		t.Fatalf("cannot acquire after the dest restarts")
+	}
+
+	// A late message from the dest before restarted is ignored.
+	publish(&CreditMsg{Epoch: 10, GrantedEntries: 9999, GrantedBytes: 99999, WindowEntries: 1000, WindowBytes: 1000})
+	assertCredits(t, account, 999, 990)
+}
+
+func TestCreditGranterRun(t *testing.T) {
+	transport := &memTransport{}
+	msgCh := make(chan *CreditMsg, 100)
+	err := transport.Subscribe("job1_credit", func(m *Msg) {
+		msg, err := UnmarshalCreditMsg(m.Data)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		msgCh <- msg
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	granter := NewCreditGranter(transport, "job1", 10, 0, logrus.NewEntry(logrus.New()))
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	go granter.Run(stopCh)
+
+	msg := <-msgCh
+	if msg.GrantedEntries != 10 || msg.GrantedBytes != DefaultCreditWindowBytes || msg.WindowBytes != DefaultCreditWindowBytes {
+		t.Fatalf("got %+v", msg)
+	}
+	granter.Release(2, 20)
+	for msg.GrantedEntries != 12 {
+		select {
+		case msg = <-msgCh:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("released credits are not published")
+		}
+	}
+	if msg.GrantedBytes != DefaultCreditWindowBytes+20 || msg.Epoch != granter.epoch {
+		t.Fatalf("got %+v", msg)
+	}
+}
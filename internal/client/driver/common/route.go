@@ -0,0 +1,185 @@
+package common
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// RouteHello is the handshake before a job routes txs (OriginUuidRules) to another job.
+// The routed payloads are encoded by the src of the routing job, so the dest of the target job
+// must be able to read them. The src requests on "<target>_route_hello", and the dest of the target
+// replies on "<src>_route_hello_ack".
+type RouteHello struct {
+	// the routing job
+	Subject string
+	// the target job
+	Target string
+	// the wire version of the routing job
+	Version int
+	// the encryption key of the routing job. "" if not encrypted.
+	KeyId string
+
+	// reply. the reason if not accepted
+	Accepted bool
+	Reason   string
+}
+
+func (h *RouteHello) Marshal() []byte {
+	w := &WireWriter{}
+	w.String(1, h.Subject)
+	w.String(2, h.Target)
+	w.Uvarint(3, uint64(h.Version))
+	w.String(4, h.KeyId)
+	w.Bool(5, h.Accepted)
+	w.String(6, h.Reason)
+	return w.Bytes()
+}
+
+func UnmarshalRouteHello(data []byte) (*RouteHello, error) {
+	h := &RouteHello{}
+	r := NewWireReader(data)
+	for r.Next() {
+		switch r.Field() {
+		case 1:
+			h.Subject = r.String()
+		case 2:
+			h.Target = r.String()
+		case 3:
+			h.Version = int(r.Uvarint())
+		case 4:
+			h.KeyId = r.String()
+		case 5:
+			h.Accepted = r.Bool()
+		case 6:
+			h.Reason = r.String()
+		default:
+			r.Skip()
+		}
+	}
+	return h, r.Err()
+}
+
+// CheckRoute returns an error if the dest cannot read the payloads routed by h,
+// where version is the wire version agreed with the src of the dest and cipher might be nil.
+func CheckRoute(h *RouteHello, version int, cipher *PayloadCipher) error {
+	if h.Version != version {
+		return fmt.Errorf("wire version %v differs from %v of job %v", h.Version, version, h.Target)
+	}
+	if (h.KeyId != "") != (cipher != nil) {
+		return fmt.Errorf("EncryptionKeyFile differs from job %v", h.Target)
+	}
+	if cipher != nil && !cipher.HasKey(h.KeyId) {
+		return fmt.Errorf("job %v has no encryption key %v", h.Target, h.KeyId)
+	}
+	return nil
+}
+
+// ServeRouteHello answers the RouteHello on the dest of subject with check.
+func ServeRouteHello(t Transport, subject string, check func(h *RouteHello) error, logger *logrus.Entry) error {
+	return t.Subscribe(fmt.Sprintf("%s_route_hello", subject), func(m *Msg) {
+		h, err := UnmarshalRouteHello(m.Data)
+		if err != nil {
+			logger.Errorf("route: bad route hello. err: %v", err)
+			return
+		}
+		if err := check(h); err != nil {
+			logger.Warnf("route: reject txs routed from job %v. err: %v", h.Subject, err)
+			h.Accepted = false
+			h.Reason = err.Error()
+		} else {
+			logger.Infof("route: accept txs routed from job %v", h.Subject)
+			h.Accepted = true
+		}
+		if err := t.Publish(fmt.Sprintf("%s_route_hello_ack", h.Subject), h.Marshal()); err != nil {
+			logger.Errorf("route: cannot reply route hello. err: %v", err)
+			return
+		}
+		if err := m.Ack(); err != nil {
+			logger.Errorf("route: cannot ack route hello. err: %v", err)
+		}
+	})
+}
+
+// RouteClient does the RouteHello on the src of a routing job, once for each target.
+type RouteClient struct {
+	transport Transport
+	subject   string
+	logger    *logrus.Entry
+
+	ackCh chan *RouteHello
+	// key: target, version and key id. Checked again after the key is rotated.
+	accepted map[string]bool
+}
+
+func NewRouteClient(t Transport, subject string, logger *logrus.Entry) (*RouteClient, error) {
+	c := &RouteClient{
+		transport: t,
+		subject:   subject,
+		logger:    logger,
+		ackCh:     make(chan *RouteHello, 1),
+		accepted:  make(map[string]bool),
+	}
+	err := t.Subscribe(fmt.Sprintf("%s_route_hello_ack", subject), func(m *Msg) {
+		h, err := UnmarshalRouteHello(m.Data)
+		if err != nil {
+			logger.Warnf("route: bad route hello ack. err: %v", err)
+			return
+		}
+		select {
+		case c.ackCh <- h:
+		default:
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Check returns nil if target accepts the txs routed with version and keyId.
+// It is not safe for concurrent use.
+func (c *RouteClient) Check(target string, version int, keyId string, stopCh <-chan struct{}) error {
+	acceptedKey := fmt.Sprintf("%s/%d/%s", target, version, keyId)
+	if c.accepted[acceptedKey] {
+		return nil
+	}
+	data := (&RouteHello{
+		Subject: c.subject,
+		Target:  target,
+		Version: version,
+		KeyId:   keyId,
+	}).Marshal()
+	for attempt := 1; attempt <= helloAttempts; attempt++ {
+		err := c.transport.Request(fmt.Sprintf("%s_route_hello", target), data, helloTimeout)
+		if err == nil {
+			timer := time.NewTimer(helloTimeout)
+		wait:
+			for {
+				select {
+				case h := <-c.ackCh:
+					if h.Target != target {
+						// of an earlier attempt to another target
+						continue
+					}
+					timer.Stop()
+					if !h.Accepted {
+						return fmt.Errorf("job %v rejects the routed txs: %v", target, h.Reason)
+					}
+					c.accepted[acceptedKey] = true
+					return nil
+				case <-timer.C:
+					break wait
+				case <-stopCh:
+					timer.Stop()
+					return fmt.Errorf("route: aborted")
+				}
+			}
+		} else if err != ErrTransportTimeout {
+			return err
+		}
+		c.logger.Debugf("route: waiting for route hello from job %v. attempt %v", target, attempt)
+	}
+	return fmt.Errorf("no route hello from job %v. it should be running, on an agent of the same version", target)
+}
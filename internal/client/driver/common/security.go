@@ -0,0 +1,91 @@
+package common
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+
+	gonats "github.com/nats-io/go-nats"
+
+	"github.com/actiontech/dtle/internal/config"
+)
+
+func loadCertPool(caFile string) (*x509.CertPool, error) {
+	pem, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("failed to parse CA certificate %v", caFile)
+	}
+	return pool, nil
+}
+
+// ServerTLSConfig returns nil if TLS is not enabled.
+func ServerTLSConfig(s *config.NatsSecurity) (*tls.Config, error) {
+	if !s.TLSEnabled() {
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(s.TLSCertFile, s.TLSKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing X509 certificate/key pair: %v", err)
+	}
+	tlsConfig := &tls.Config{
+		Certificates:             []tls.Certificate{cert},
+		PreferServerCipherSuites: true,
+		MinVersion:               tls.VersionTLS12,
+	}
+	if s.TLSVerify {
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	if s.TLSCAFile != "" {
+		if tlsConfig.ClientCAs, err = loadCertPool(s.TLSCAFile); err != nil {
+			return nil, err
+		}
+	}
+	return tlsConfig, nil
+}
+
+// ClientTLSConfig returns nil if TLS is not enabled.
+func ClientTLSConfig(s *config.NatsSecurity) (*tls.Config, error) {
+	if !s.TLSEnabled() {
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(s.TLSCertFile, s.TLSKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing X509 certificate/key pair: %v", err)
+	}
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if s.TLSCAFile != "" {
+		if tlsConfig.RootCAs, err = loadCertPool(s.TLSCAFile); err != nil {
+			return nil, err
+		}
+	}
+	return tlsConfig, nil
+}
+
+// NatsOptions returns the options to connect to a nats server secured by s.
+func NatsOptions(s *config.NatsSecurity) ([]gonats.Option, error) {
+	var opts []gonats.Option
+	if s == nil {
+		return opts, nil
+	}
+	tlsConfig, err := ClientTLSConfig(s)
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig != nil {
+		opts = append(opts, gonats.Secure(tlsConfig))
+	}
+	if s.Token != "" {
+		opts = append(opts, gonats.Token(s.Token))
+	} else if s.User != "" {
+		opts = append(opts, gonats.UserInfo(s.User, s.Password))
+	}
+	return opts, nil
+}
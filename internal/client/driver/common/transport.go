@@ -0,0 +1,144 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	gonats "github.com/nats-io/go-nats"
+	"github.com/sirupsen/logrus"
+
+	"github.com/actiontech/dtle/internal/config"
+)
+
+const (
+	TransportNats = "nats"
+	TransportTcp  = "tcp"
+)
+
+// ErrTransportTimeout is returned by Transport.Request when the message is not acknowledged in time.
+// The caller should send it again.
+var ErrTransportTimeout = errors.New("transport: timeout")
+
+// Msg is a message received from a Transport.
+type Msg struct {
+	Subject string
+	Data    []byte
+
+	ack func() error
+}
+
+// Ack tells the sender (of a request) that the message has been handled.
+// A request not acked is not delivered again by the transport. The sender sends it again on timeout.
+func (m *Msg) Ack() error {
+	if m.ack == nil {
+		return nil
+	}
+	return m.ack()
+}
+
+type MsgHandler func(m *Msg)
+
+// Transport carries the messages between the extractor (src) and the applier (dest) of a job.
+// Messages of a subject are handled one after another, in the order they are sent.
+type Transport interface {
+	// Publish sends data to the subscriber of subject. It does not wait for the message to be handled.
+	Publish(subject string, data []byte) error
+	// Request sends data to the subscriber of subject, which must Ack it.
+	// ErrTransportTimeout is returned if it cannot be acked in timeout.
+	Request(subject string, data []byte, timeout time.Duration) error
+	Subscribe(subject string, handler MsgHandler) error
+	Statistics() gonats.Statistics
+	Close()
+}
+
+// NewTransport connects the src or dest task of a job to the transport selected by kind.
+// natsAddr is the address of the NATS server on the dest agent. For TransportTcp,
+// the dest listens on port at the host of natsAddr, and the src connects to it there.
+// The connections are secured by security, which might be nil. TransportTcp requires its authentication.
+func NewTransport(kind string, natsAddr string, port int, isDest bool, security *config.NatsSecurity,
+	logger *logrus.Entry) (Transport, error) {
+
+	switch kind {
+	case "", TransportNats:
+		return NewNatsTransport(natsAddr, security)
+	case TransportTcp:
+		if port <= 0 {
+			return nil, fmt.Errorf("TransportPort must be set for transport %v", kind)
+		}
+		host, _, err := net.SplitHostPort(natsAddr)
+		if err != nil {
+			return nil, err
+		}
+		addr := net.JoinHostPort(host, strconv.Itoa(port))
+		if isDest {
+			tlsConfig, err := ServerTLSConfig(security)
+			if err != nil {
+				return nil, err
+			}
+			return NewTcpTransportServer(addr, tlsConfig, security, logger)
+		}
+		tlsConfig, err := ClientTLSConfig(security)
+		if err != nil {
+			return nil, err
+		}
+		return NewTcpTransportClient(addr, tlsConfig, security, logger)
+	default:
+		return nil, fmt.Errorf("unknown transport %v", kind)
+	}
+}
+
+// NatsTransport sends requests with NATS request/reply. An unacked request times out at the sender.
+type NatsTransport struct {
+	conn *gonats.Conn
+}
+
+func NewNatsTransport(natsAddr string, security *config.NatsSecurity) (*NatsTransport, error) {
+	opts, err := NatsOptions(security)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := gonats.Connect(fmt.Sprintf("nats://%s", natsAddr), opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &NatsTransport{conn: conn}, nil
+}
+
+func (t *NatsTransport) Publish(subject string, data []byte) error {
+	return t.conn.Publish(subject, data)
+}
+
+func (t *NatsTransport) Request(subject string, data []byte, timeout time.Duration) error {
+	_, err := t.conn.Request(subject, data, timeout)
+	if err == gonats.ErrTimeout {
+		return ErrTransportTimeout
+	}
+	return err
+}
+
+func (t *NatsTransport) Subscribe(subject string, handler MsgHandler) error {
+	_, err := t.conn.Subscribe(subject, func(m *gonats.Msg) {
+		msg := &Msg{
+			Subject: m.Subject,
+			Data:    m.Data,
+		}
+		if m.Reply != "" {
+			msg.ack = func() error {
+				return t.conn.Publish(m.Reply, nil)
+			}
+		}
+		handler(msg)
+	})
+	return err
+}
+
+func (t *NatsTransport) Statistics() gonats.Statistics {
+	return t.conn.Statistics
+}
+
+func (t *NatsTransport) Close() {
+	t.conn.Close()
+}
@@ -0,0 +1,660 @@
+package common
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	gonats "github.com/nats-io/go-nats"
+	"github.com/sirupsen/logrus"
+
+	"github.com/actiontech/dtle/internal/config"
+)
+
+// Kinds of the frames.
+const (
+	tcpFrameMsg     = 0
+	tcpFrameRequest = 1
+	// The receiver has handled a request. The id is that of the request.
+	tcpFrameAck = 2
+	// The first frame from the src, with the credentials. See tcpAuth.
+	tcpFrameAuth       = 3
+	tcpFrameAuthOk     = 4
+	tcpFrameAuthFailed = 5
+	// The receiver has no subscriber of the request yet. The id is that of the request.
+	tcpFrameNack = 6
+)
+
+const (
+	tcpSubscriberQueueSize = 16
+	tcpConnectWait         = 10 * time.Second
+	// The auth frame is read before the peer is trusted, so it is limited in size.
+	tcpMaxAuthFrameSize = 64 * 1024
+	// The max size of the msgs kept for the subjects not subscribed yet.
+	tcpMaxUnsubscribedBytes = 64 * 1024 * 1024
+	// Request sends a nacked request again after it.
+	tcpNackRetryWait = 100 * time.Millisecond
+)
+
+var errTcpAuthFailed = errors.New("transport: authentication failed")
+
+// TcpTransport streams messages over a single TCP connection between the src and the dest.
+// The dest listens, and the src connects to it, with TLS if configured.
+//
+// The src authenticates itself by the first frame, with the token or user/password of the agent,
+// unless the dest verifies its client certificate.
+// A request is acked by the receiver with an ack frame, and Request waits for it, as NATS request/reply.
+// A request not acked in time is not delivered again by the receiver. The sender should send it again.
+// A request of a subject not subscribed yet is nacked, and Request sends it again until timeout.
+// A msg (by Publish) of such a subject is kept until it is subscribed, up to tcpMaxUnsubscribedBytes.
+// A lost connection cannot be recovered, and the task should be restarted.
+type TcpTransport struct {
+	logger   *logrus.Entry
+	security *config.NatsSecurity
+
+	listener net.Listener
+
+	connLock sync.Mutex
+	connCond *sync.Cond
+	conn     net.Conn
+	writer   *bufio.Writer
+	err      error
+	// closed when err is set for conn. Replaced with conn.
+	brokenCh chan struct{}
+	closed   bool
+	closeCh  chan struct{}
+
+	subLock     sync.RWMutex
+	subscribers map[string]chan *Msg
+	// msgs received before their subjects are subscribed. key: subject
+	unsubscribed      map[string][]*Msg
+	unsubscribedBytes int
+
+	nextRequestId uint64
+	pendingLock   sync.Mutex
+	// requests waiting for the ack. key: the id of the request. value: receives true on ack, false on nack
+	pending map[uint64]chan bool
+
+	inMsgs   uint64
+	outMsgs  uint64
+	inBytes  uint64
+	outBytes uint64
+}
+
+// checkTcpAuth returns an error if the transport would accept a src without authentication.
+func checkTcpAuth(s *config.NatsSecurity) error {
+	if s != nil && (s.Token != "" || s.User != "" || (s.TLSEnabled() && s.TLSVerify)) {
+		return nil
+	}
+	return fmt.Errorf("transport %v requires authentication: set token, user/password or tls_verify of the agent",
+		TransportTcp)
+}
+
+func newTcpTransport(security *config.NatsSecurity, logger *logrus.Entry) (*TcpTransport, error) {
+	if err := checkTcpAuth(security); err != nil {
+		return nil, err
+	}
+	t := &TcpTransport{
+		logger:       logger,
+		security:     security,
+		subscribers:  make(map[string]chan *Msg),
+		unsubscribed: make(map[string][]*Msg),
+		pending:      make(map[uint64]chan bool),
+		brokenCh:     make(chan struct{}),
+		closeCh:      make(chan struct{}),
+	}
+	t.connCond = sync.NewCond(&t.connLock)
+	return t, nil
+}
+
+// NewTcpTransportServer listens on addr and takes the latest authenticated connection from the src.
+// tlsConfig might be nil.
+func NewTcpTransportServer(addr string, tlsConfig *tls.Config, security *config.NatsSecurity,
+	logger *logrus.Entry) (*TcpTransport, error) {
+
+	t, err := newTcpTransport(security, logger)
+	if err != nil {
+		return nil, err
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig != nil {
+		listener = tls.NewListener(listener, tlsConfig)
+	}
+	t.listener = listener
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				t.setError(err)
+				return
+			}
+			t.logger.Debugf("transport: accept %v", conn.RemoteAddr())
+			go t.acceptConn(conn)
+		}
+	}()
+	return t, nil
+}
+
+// acceptConn takes conn if the auth frame is valid.
+func (t *TcpTransport) acceptConn(conn net.Conn) {
+	conn.SetDeadline(time.Now().Add(tcpConnectWait))
+	reader := bufio.NewReader(conn)
+	kind, _, msg, err := readTcpFrame(reader, tcpMaxAuthFrameSize)
+	if err == nil && kind != tcpFrameAuth {
+		err = fmt.Errorf("expect an auth frame, got %v", kind)
+	}
+	if err == nil {
+		err = t.authenticate(msg.Data)
+		if err != nil {
+			writeTcpFrame(conn, tcpFrameAuthFailed, 0, "", nil)
+		}
+	}
+	if err == nil {
+		err = writeTcpFrame(conn, tcpFrameAuthOk, 0, "", nil)
+	}
+	if err != nil {
+		t.logger.Warnf("transport: rejected a connection from %v. err: %v", conn.RemoteAddr(), err)
+		conn.Close()
+		return
+	}
+	conn.SetDeadline(time.Time{})
+	t.logger.Infof("transport: accepted a connection from %v", conn.RemoteAddr())
+	t.setConn(conn, reader)
+}
+
+func (t *TcpTransport) authenticate(data []byte) error {
+	auth, err := unmarshalTcpAuth(data)
+	if err != nil {
+		return err
+	}
+	equal := func(a string, b string) bool {
+		return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+	}
+	s := t.security
+	switch {
+	case s.Token != "":
+		if !equal(auth.token, s.Token) {
+			return errTcpAuthFailed
+		}
+	case s.User != "":
+		if !equal(auth.user, s.User) || !equal(auth.password, s.Password) {
+			return errTcpAuthFailed
+		}
+	default:
+		// by the client certificate, verified in the TLS handshake
+	}
+	return nil
+}
+
+// NewTcpTransportClient connects to the dest at addr. It keeps trying in background until connected.
+// tlsConfig might be nil.
+func NewTcpTransportClient(addr string, tlsConfig *tls.Config, security *config.NatsSecurity,
+	logger *logrus.Entry) (*TcpTransport, error) {
+
+	t, err := newTcpTransport(security, logger)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		for {
+			var conn net.Conn
+			var err error
+			dialer := &net.Dialer{Timeout: tcpConnectWait}
+			if tlsConfig != nil {
+				conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+			} else {
+				conn, err = dialer.Dial("tcp", addr)
+			}
+			if err == nil {
+				var reader *bufio.Reader
+				reader, err = t.login(conn)
+				if err == nil {
+					t.logger.Debugf("transport: connected to %v", addr)
+					t.setConn(conn, reader)
+					return
+				}
+				conn.Close()
+				if err == errTcpAuthFailed {
+					t.logger.Errorf("transport: rejected by %v. check the token or user/password of the agents", addr)
+					t.setError(err)
+					return
+				}
+			}
+			t.logger.Debugf("transport: connect to %v failed. will retry. err: %v", addr, err)
+			time.Sleep(1 * time.Second)
+			if t.isClosed() {
+				return
+			}
+		}
+	}()
+	return t, nil
+}
+
+// login sends the auth frame and waits for the result.
+func (t *TcpTransport) login(conn net.Conn) (*bufio.Reader, error) {
+	conn.SetDeadline(time.Now().Add(tcpConnectWait))
+	auth := &tcpAuth{
+		token:    t.security.Token,
+		user:     t.security.User,
+		password: t.security.Password,
+	}
+	if err := writeTcpFrame(conn, tcpFrameAuth, 0, "", auth.marshal()); err != nil {
+		return nil, err
+	}
+	reader := bufio.NewReader(conn)
+	kind, _, _, err := readTcpFrame(reader, tcpMaxAuthFrameSize)
+	if err != nil {
+		return nil, err
+	}
+	switch kind {
+	case tcpFrameAuthOk:
+		conn.SetDeadline(time.Time{})
+		return reader, nil
+	case tcpFrameAuthFailed:
+		return nil, errTcpAuthFailed
+	default:
+		return nil, fmt.Errorf("transport: expect an auth result, got %v", kind)
+	}
+}
+
+func (t *TcpTransport) setConn(conn net.Conn, reader *bufio.Reader) {
+	t.connLock.Lock()
+	defer t.connLock.Unlock()
+	if t.closed {
+		conn.Close()
+		return
+	}
+	if t.conn != nil {
+		t.conn.Close()
+	}
+	t.conn = conn
+	t.writer = bufio.NewWriter(conn)
+	if t.err != nil {
+		t.brokenCh = make(chan struct{})
+	}
+	t.err = nil
+	t.connCond.Broadcast()
+	go t.readLoop(conn, reader)
+}
+
+// setErrorLocked must be called with connLock held.
+func (t *TcpTransport) setErrorLocked(err error) {
+	if t.err == nil {
+		t.err = err
+		close(t.brokenCh)
+	}
+	t.connCond.Broadcast()
+}
+
+func (t *TcpTransport) setError(err error) {
+	t.connLock.Lock()
+	defer t.connLock.Unlock()
+	t.setErrorLocked(err)
+}
+
+func (t *TcpTransport) getError() error {
+	t.connLock.Lock()
+	defer t.connLock.Unlock()
+	return t.err
+}
+
+func (t *TcpTransport) isClosed() bool {
+	t.connLock.Lock()
+	defer t.connLock.Unlock()
+	return t.closed
+}
+
+// send writes a frame. If onConn is not nil, the frame is written only on that connection.
+// It returns the brokenCh of the connection written to.
+func (t *TcpTransport) send(kind byte, id uint64, subject string, data []byte, timeout time.Duration,
+	onConn net.Conn) (chan struct{}, error) {
+
+	t.connLock.Lock()
+	defer t.connLock.Unlock()
+
+	if t.conn == nil && !t.closed && t.err == nil {
+		timer := time.AfterFunc(timeout, func() {
+			t.connLock.Lock()
+			t.connCond.Broadcast()
+			t.connLock.Unlock()
+		})
+		deadline := time.Now().Add(timeout)
+		for t.conn == nil && !t.closed && t.err == nil && time.Now().Before(deadline) {
+			t.connCond.Wait()
+		}
+		timer.Stop()
+	}
+	if t.closed {
+		return nil, fmt.Errorf("transport: closed")
+	}
+	if t.err != nil {
+		return nil, t.err
+	}
+	if t.conn == nil {
+		return nil, ErrTransportTimeout
+	}
+	if onConn != nil && t.conn != onConn {
+		return nil, fmt.Errorf("transport: connection replaced")
+	}
+
+	t.conn.SetWriteDeadline(time.Now().Add(timeout))
+	if err := writeTcpFrame(t.writer, kind, id, subject, data); err != nil {
+		t.setErrorLocked(err)
+		return nil, err
+	}
+	if err := t.writer.Flush(); err != nil {
+		t.setErrorLocked(err)
+		return nil, err
+	}
+	if kind == tcpFrameMsg || kind == tcpFrameRequest {
+		atomic.AddUint64(&t.outMsgs, 1)
+		atomic.AddUint64(&t.outBytes, uint64(len(data)))
+	}
+	return t.brokenCh, nil
+}
+
+func (t *TcpTransport) Publish(subject string, data []byte) error {
+	_, err := t.send(tcpFrameMsg, 0, subject, data, tcpConnectWait, nil)
+	return err
+}
+
+// Request returns after the receiver acks the message.
+// It is sent again if nacked, i.e., the subject is not subscribed yet.
+func (t *TcpTransport) Request(subject string, data []byte, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	id := atomic.AddUint64(&t.nextRequestId, 1)
+	defer func() {
+		t.pendingLock.Lock()
+		delete(t.pending, id)
+		t.pendingLock.Unlock()
+	}()
+
+	for {
+		ackCh := make(chan bool, 1)
+		t.pendingLock.Lock()
+		t.pending[id] = ackCh
+		t.pendingLock.Unlock()
+
+		brokenCh, err := t.send(tcpFrameRequest, id, subject, data, time.Until(deadline), nil)
+		if err != nil {
+			return err
+		}
+		acked, err := t.waitAck(ackCh, brokenCh, time.Until(deadline))
+		if err != nil || acked {
+			return err
+		}
+		t.logger.Debugf("transport: no subscriber of %v yet. will send again", subject)
+		wait := tcpNackRetryWait
+		if remaining := time.Until(deadline); remaining < wait {
+			wait = remaining
+		}
+		select {
+		case <-time.After(wait):
+		case <-t.closeCh:
+			return fmt.Errorf("transport: closed")
+		}
+		if !time.Now().Before(deadline) {
+			return ErrTransportTimeout
+		}
+	}
+}
+
+// waitAck returns true if acked, or false if nacked.
+func (t *TcpTransport) waitAck(ackCh chan bool, brokenCh chan struct{}, timeout time.Duration) (bool, error) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case acked := <-ackCh:
+		return acked, nil
+	case <-timer.C:
+		return false, ErrTransportTimeout
+	case <-brokenCh:
+		return false, t.getError()
+	case <-t.closeCh:
+		return false, fmt.Errorf("transport: closed")
+	}
+}
+
+func (t *TcpTransport) onAck(id uint64, acked bool) {
+	t.pendingLock.Lock()
+	defer t.pendingLock.Unlock()
+	if ackCh, ok := t.pending[id]; ok {
+		ackCh <- acked
+		delete(t.pending, id)
+	}
+	// Otherwise the request has timed out.
+}
+
+func (t *TcpTransport) Subscribe(subject string, handler MsgHandler) error {
+	t.subLock.Lock()
+	defer t.subLock.Unlock()
+	if _, ok := t.subscribers[subject]; ok {
+		return fmt.Errorf("transport: %v has been subscribed", subject)
+	}
+	ch := make(chan *Msg, tcpSubscriberQueueSize)
+	t.subscribers[subject] = ch
+	// The msgs received before are handled first, as later ones go to ch.
+	kept := t.unsubscribed[subject]
+	delete(t.unsubscribed, subject)
+	for _, msg := range kept {
+		t.unsubscribedBytes -= len(msg.Data)
+	}
+	go func() {
+		for _, msg := range kept {
+			select {
+			case <-t.closeCh:
+				return
+			default:
+				handler(msg)
+			}
+		}
+		for {
+			select {
+			case msg := <-ch:
+				handler(msg)
+			case <-t.closeCh:
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// getSubscriber returns the subscriber of msg. If the subject is not subscribed yet, nil is returned,
+// and msg is kept for the subscriber if keep (or discarded if too many are kept).
+func (t *TcpTransport) getSubscriber(msg *Msg, keep bool) chan *Msg {
+	t.subLock.Lock()
+	defer t.subLock.Unlock()
+	if ch, ok := t.subscribers[msg.Subject]; ok {
+		return ch
+	}
+	if !keep {
+		return nil
+	}
+	if t.unsubscribedBytes+len(msg.Data) > tcpMaxUnsubscribedBytes {
+		t.logger.Warnf("transport: too many msgs without subscriber. discarding a msg of %v", msg.Subject)
+		return nil
+	}
+	t.logger.Debugf("transport: no subscriber yet. keeping a msg of %v", msg.Subject)
+	t.unsubscribed[msg.Subject] = append(t.unsubscribed[msg.Subject], msg)
+	t.unsubscribedBytes += len(msg.Data)
+	return nil
+}
+
+func (t *TcpTransport) readLoop(conn net.Conn, reader *bufio.Reader) {
+	var err error
+	for {
+		var kind byte
+		var id uint64
+		var msg *Msg
+		kind, id, msg, err = readTcpFrame(reader, 0)
+		if err != nil {
+			break
+		}
+		switch kind {
+		case tcpFrameAck, tcpFrameNack:
+			t.onAck(id, kind == tcpFrameAck)
+			continue
+		case tcpFrameMsg, tcpFrameRequest:
+		default:
+			err = fmt.Errorf("transport: unexpected frame %v", kind)
+		}
+		if err != nil {
+			break
+		}
+		atomic.AddUint64(&t.inMsgs, 1)
+		atomic.AddUint64(&t.inBytes, uint64(len(msg.Data)))
+
+		if kind == tcpFrameRequest {
+			msg.ack = func() error {
+				_, err := t.send(tcpFrameAck, id, "", nil, tcpConnectWait, conn)
+				return err
+			}
+		}
+		ch := t.getSubscriber(msg, kind == tcpFrameMsg)
+		if ch == nil {
+			if kind == tcpFrameRequest {
+				// The sender sends it again. A write error is found by the read on conn.
+				t.send(tcpFrameNack, id, "", nil, tcpConnectWait, conn)
+			}
+			continue
+		}
+		select {
+		case ch <- msg:
+		case <-t.closeCh:
+			return
+		}
+	}
+
+	t.connLock.Lock()
+	defer t.connLock.Unlock()
+	if t.conn != conn {
+		// replaced by a new connection
+		return
+	}
+	if !t.closed {
+		t.logger.Errorf("transport: connection lost. err: %v", err)
+	}
+	if err == io.EOF {
+		err = fmt.Errorf("transport: connection closed by peer")
+	}
+	t.setErrorLocked(err)
+}
+
+// A frame: kind (1 byte), id (8 bytes), subject length (2 bytes), subject, data length (4 bytes), data.
+func writeTcpFrame(w io.Writer, kind byte, id uint64, subject string, data []byte) error {
+	if len(subject) > 0xffff {
+		return fmt.Errorf("transport: subject too long")
+	}
+	header := make([]byte, 1+8+2+len(subject)+4)
+	header[0] = kind
+	binary.BigEndian.PutUint64(header[1:], id)
+	binary.BigEndian.PutUint16(header[9:], uint16(len(subject)))
+	copy(header[11:], subject)
+	binary.BigEndian.PutUint32(header[11+len(subject):], uint32(len(data)))
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	_, err := w.Write(data)
+	return err
+}
+
+// readTcpFrame reads a frame. maxSize limits the subject and the data if not 0.
+func readTcpFrame(reader *bufio.Reader, maxSize int) (kind byte, id uint64, msg *Msg, err error) {
+	var header [11]byte
+	if _, err = io.ReadFull(reader, header[:]); err != nil {
+		return 0, 0, nil, err
+	}
+	subjectLen := int(binary.BigEndian.Uint16(header[9:]))
+	if maxSize > 0 && subjectLen > maxSize {
+		return 0, 0, nil, fmt.Errorf("transport: frame too large")
+	}
+	subject := make([]byte, subjectLen)
+	if _, err = io.ReadFull(reader, subject); err != nil {
+		return 0, 0, nil, err
+	}
+	var lenBuf [4]byte
+	if _, err = io.ReadFull(reader, lenBuf[:]); err != nil {
+		return 0, 0, nil, err
+	}
+	dataLen := binary.BigEndian.Uint32(lenBuf[:])
+	if maxSize > 0 && dataLen > uint32(maxSize) {
+		return 0, 0, nil, fmt.Errorf("transport: frame too large")
+	}
+	data := make([]byte, dataLen)
+	if _, err = io.ReadFull(reader, data); err != nil {
+		return 0, 0, nil, err
+	}
+	return header[0], binary.BigEndian.Uint64(header[1:]), &Msg{Subject: string(subject), Data: data}, nil
+}
+
+// tcpAuth is the data of the auth frame.
+type tcpAuth struct {
+	token    string
+	user     string
+	password string
+}
+
+func (a *tcpAuth) marshal() []byte {
+	w := &WireWriter{}
+	w.String(1, a.token)
+	w.String(2, a.user)
+	w.String(3, a.password)
+	return w.Bytes()
+}
+
+func unmarshalTcpAuth(data []byte) (*tcpAuth, error) {
+	a := &tcpAuth{}
+	r := NewWireReader(data)
+	for r.Next() {
+		switch r.Field() {
+		case 1:
+			a.token = r.String()
+		case 2:
+			a.user = r.String()
+		case 3:
+			a.password = r.String()
+		default:
+			r.Skip()
+		}
+	}
+	return a, r.Err()
+}
+
+func (t *TcpTransport) Statistics() gonats.Statistics {
+	return gonats.Statistics{
+		InMsgs:   atomic.LoadUint64(&t.inMsgs),
+		OutMsgs:  atomic.LoadUint64(&t.outMsgs),
+		InBytes:  atomic.LoadUint64(&t.inBytes),
+		OutBytes: atomic.LoadUint64(&t.outBytes),
+	}
+}
+
+func (t *TcpTransport) Close() {
+	t.connLock.Lock()
+	if t.closed {
+		t.connLock.Unlock()
+		return
+	}
+	t.closed = true
+	close(t.closeCh)
+	if t.listener != nil {
+		t.listener.Close()
+	}
+	if t.conn != nil {
+		t.conn.Close()
+	}
+	t.connCond.Broadcast()
+	t.connLock.Unlock()
+}
@@ -0,0 +1,345 @@
+package common
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/actiontech/dtle/internal/config"
+)
+
+func newTestTcpServer(t *testing.T, security *config.NatsSecurity) *TcpTransport {
+	server, err := NewTcpTransportServer("127.0.0.1:0", nil, security, logrus.NewEntry(logrus.New()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return server
+}
+
+func newTestTcpClient(t *testing.T, server *TcpTransport, security *config.NatsSecurity) *TcpTransport {
+	client, err := NewTcpTransportClient(server.listener.Addr().String(), nil, security,
+		logrus.NewEntry(logrus.New()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+// waitInMsgs waits until the transport has received n msgs.
+func waitInMsgs(t *testing.T, transport *TcpTransport, n uint64) {
+	deadline := time.Now().Add(5 * time.Second)
+	for transport.Statistics().InMsgs < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("received %v msgs, want %v", transport.Statistics().InMsgs, n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestTcpFrame(t *testing.T) {
+	for _, c := range []struct {
+		kind    byte
+		id      uint64
+		subject string
+		data    []byte
+	}{
+		{tcpFrameMsg, 0, "job1_full", []byte("data")},
+		{tcpFrameRequest, 1<<64 - 1, "job1_incr_hete", bytes.Repeat([]byte{0xff}, 100000)},
+		{tcpFrameAck, 42, "", nil},
+	} {
+		buf := &bytes.Buffer{}
+		if err := writeTcpFrame(buf, c.kind, c.id, c.subject, c.data); err != nil {
+			t.Fatal(err)
+		}
+		kind, id, msg, err := readTcpFrame(bufio.NewReader(buf), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if kind != c.kind || id != c.id || msg.Subject != c.subject || !bytes.Equal(msg.Data, c.data) {
+			t.Fatalf("got %v %v %v %v bytes, want %v %v %v %v bytes",
+				kind, id, msg.Subject, len(msg.Data), c.kind, c.id, c.subject, len(c.data))
+		}
+		if buf.Len() != 0 {
+			t.Fatalf("%v bytes left", buf.Len())
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	if err := writeTcpFrame(buf, tcpFrameMsg, 0, "job1", make([]byte, 101)); err != nil {
+		t.Fatal(err)
+	}
+	frame := buf.Bytes()
+	if _, _, _, err := readTcpFrame(bufio.NewReader(bytes.NewReader(frame)), 100); err == nil {
+		t.Fatalf("expect an error for a frame larger than the max size")
+	}
+	if err := writeTcpFrame(&bytes.Buffer{}, tcpFrameMsg, 0, string(make([]byte, 0x10000)), nil); err == nil {
+		t.Fatalf("expect an error for a too long subject")
+	}
+	if _, _, _, err := readTcpFrame(bufio.NewReader(bytes.NewReader(frame[:20])), 0); err == nil {
+		t.Fatalf("expect an error for a truncated frame")
+	}
+}
+
+func TestTcpTransportRequest(t *testing.T) {
+	security := &config.NatsSecurity{Token: "token1"}
+	server := newTestTcpServer(t, security)
+	defer server.Close()
+	client := newTestTcpClient(t, server, security)
+	defer client.Close()
+
+	var lock sync.Mutex
+	var got []string
+	nDelivered := 0
+	err := server.Subscribe("job1_full", func(m *Msg) {
+		lock.Lock()
+		defer lock.Unlock()
+		nDelivered += 1
+		// The first delivery of "b" is not acked, to be sent again.
+		if string(m.Data) == "b" && nDelivered == 2 {
+			return
+		}
+		got = append(got, string(m.Data))
+		if err := m.Ack(); err != nil {
+			t.Error(err)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Subscribe("job1_full", func(m *Msg) {}); err == nil {
+		t.Fatalf("expect an error for subscribing a subject twice")
+	}
+
+	// ack
+	if err := client.Request("job1_full", []byte("a"), 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	// timeout
+	if err := client.Request("job1_full", []byte("b"), 200*time.Millisecond); err != ErrTransportTimeout {
+		t.Fatalf("got %v, want %v", err, ErrTransportTimeout)
+	}
+	// redelivery by the sender
+	if err := client.Request("job1_full", []byte("b"), 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	if want := []string{"a", "b"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if nDelivered != 3 {
+		t.Fatalf("delivered %v times, want 3", nDelivered)
+	}
+}
+
+func TestTcpTransportNotSubscribed(t *testing.T) {
+	security := &config.NatsSecurity{Token: "token1"}
+	server := newTestTcpServer(t, security)
+	defer server.Close()
+	client := newTestTcpClient(t, server, security)
+	defer client.Close()
+
+	// The msgs before Subscribe are kept.
+	for i := 0; i < 3; i++ {
+		if err := client.Publish("job1_full_complete", []byte(fmt.Sprint(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitInMsgs(t, server, 3)
+
+	gotCh := make(chan string, 10)
+	err := server.Subscribe("job1_full_complete", func(m *Msg) {
+		gotCh <- string(m.Data)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Publish("job1_full_complete", []byte("3")); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 4; i++ {
+		select {
+		case got := <-gotCh:
+			if want := fmt.Sprint(i); got != want {
+				t.Fatalf("got %v, want %v", got, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("msg %v not received", i)
+		}
+	}
+
+	// A request before Subscribe is nacked and sent again. It is delivered once.
+	nDelivered := 0
+	var lock sync.Mutex
+	go func() {
+		time.Sleep(300 * time.Millisecond)
+		err := server.Subscribe("job1_full", func(m *Msg) {
+			lock.Lock()
+			nDelivered += 1
+			lock.Unlock()
+			m.Ack()
+		})
+		if err != nil {
+			t.Error(err)
+		}
+	}()
+	if err := client.Request("job1_full", []byte("a"), 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	if nDelivered != 1 {
+		t.Fatalf("delivered %v times, want 1", nDelivered)
+	}
+
+	// never subscribed
+	if err := client.Request("job1_incr_hete", []byte("a"), 300*time.Millisecond); err != ErrTransportTimeout {
+		t.Fatalf("got %v, want %v", err, ErrTransportTimeout)
+	}
+}
+
+func TestTcpTransportReconnect(t *testing.T) {
+	security := &config.NatsSecurity{Token: "token1"}
+	server := newTestTcpServer(t, security)
+	defer server.Close()
+	err := server.Subscribe("job1_full", func(m *Msg) {
+		m.Ack()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client1 := newTestTcpClient(t, server, security)
+	defer client1.Close()
+	if err := client1.Request("job1_full", []byte("a"), 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	// e.g. the src task is restarted. The new connection replaces the old one.
+	client2 := newTestTcpClient(t, server, security)
+	defer client2.Close()
+	if err := client2.Request("job1_full", []byte("b"), 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-client1.brokenCh:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("the replaced connection is not closed")
+	}
+	if err := client1.Request("job1_full", []byte("c"), 5*time.Second); err == nil {
+		t.Fatalf("expect an error on the replaced connection")
+	}
+	if err := client2.Request("job1_full", []byte("d"), 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	client2.Close()
+	if err := client2.Request("job1_full", []byte("e"), 5*time.Second); err == nil {
+		t.Fatalf("expect an error after Close")
+	}
+}
+
+func TestTcpTransportAuth(t *testing.T) {
+	token := &config.NatsSecurity{Token: "token1"}
+	user := &config.NatsSecurity{User: "user1", Password: "password1"}
+	for i, c := range []struct {
+		server *config.NatsSecurity
+		client *config.NatsSecurity
+		ok     bool
+	}{
+		{token, token, true},
+		{token, &config.NatsSecurity{Token: "token2"}, false},
+		{token, &config.NatsSecurity{User: "user1", Password: "token1"}, false},
+		{user, user, true},
+		{user, &config.NatsSecurity{User: "user1", Password: "password2"}, false},
+		{user, &config.NatsSecurity{User: "user2", Password: "password1"}, false},
+		{user, &config.NatsSecurity{Token: "user1"}, false},
+	} {
+		server := newTestTcpServer(t, c.server)
+		err := server.Subscribe("job1_full", func(m *Msg) {
+			m.Ack()
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		client := newTestTcpClient(t, server, c.client)
+		err = client.Request("job1_full", []byte("a"), 5*time.Second)
+		if c.ok && err != nil {
+			t.Fatalf("case %v: %v", i, err)
+		}
+		if !c.ok && err != errTcpAuthFailed {
+			t.Fatalf("case %v: got %v, want %v", i, err, errTcpAuthFailed)
+		}
+		client.Close()
+		server.Close()
+	}
+
+	for _, security := range []*config.NatsSecurity{nil, {}, {Password: "password1"}} {
+		if _, err := NewTcpTransportServer("127.0.0.1:0", nil, security, logrus.NewEntry(logrus.New())); err == nil {
+			t.Fatalf("expect an error without authentication: %+v", security)
+		}
+	}
+}
+
+func TestTcpTransportAuthFrame(t *testing.T) {
+	security := &config.NatsSecurity{Token: "token1"}
+	server := newTestTcpServer(t, security)
+	defer server.Close()
+
+	// authFrame is a valid auth frame, padded by an unknown field to size.
+	authFrame := func(size int) []byte {
+		w := &WireWriter{}
+		w.String(1, security.Token)
+		auth := w.Bytes()
+		// The key and the length of the padding take a few bytes.
+		for pad := size - len(auth); pad > 0; pad-- {
+			w := &WireWriter{}
+			w.String(1, security.Token)
+			w.String(9, string(make([]byte, pad)))
+			if len(w.Bytes()) == size {
+				return w.Bytes()
+			}
+		}
+		if size > 0 {
+			t.Fatalf("cannot pad the auth frame to %v bytes", size)
+		}
+		return auth
+	}
+	for i, c := range []struct {
+		kind byte
+		data []byte
+		ok   bool
+	}{
+		{tcpFrameAuth, authFrame(0), true},
+		{tcpFrameAuth, authFrame(1000), true},
+		{tcpFrameAuth, authFrame(tcpMaxAuthFrameSize), true},
+		{tcpFrameAuth, authFrame(tcpMaxAuthFrameSize + 1), false},
+		{tcpFrameAuth, authFrame(16 * 1024 * 1024), false},
+		{tcpFrameMsg, authFrame(0), false},
+		{tcpFrameAuth, []byte{0xff}, false},
+	} {
+		conn, err := net.Dial("tcp", server.listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		// The frame might be rejected before it is fully written.
+		go writeTcpFrame(conn, c.kind, 0, "", c.data)
+		kind, _, _, err := readTcpFrame(bufio.NewReader(conn), tcpMaxAuthFrameSize)
+		if c.ok && (err != nil || kind != tcpFrameAuthOk) {
+			t.Fatalf("case %v: got %v %v, want auth ok", i, kind, err)
+		}
+		if !c.ok && err == nil && kind != tcpFrameAuthFailed {
+			t.Fatalf("case %v: got %v, want a rejection", i, kind)
+		}
+		conn.Close()
+	}
+}
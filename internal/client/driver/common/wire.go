@@ -0,0 +1,464 @@
+package common
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Wire format versions of the payloads between the extractor and the applier.
+// The src and the dest agree on a version with the handshake of Hello.
+const (
+	// gob (BinlogEntries, DumpStatResult) or gencode (DumpEntry), compressed by snappy.
+	// Neither encrypted nor with a codec header. Used by the agents without the handshake.
+	WireVersionLegacy = 1
+	// Protocol buffers (see mysql/wire.proto), compressed by Codec and encrypted by PayloadCipher.
+	WireVersionProto = 2
+	// WireVersionProto, with the credit-based flow control of the incremental payloads (see CreditGranter).
+	WireVersionFlowControl = 3
+
+	WireVersionMin = WireVersionLegacy
+	WireVersionMax = WireVersionFlowControl
+)
+
+// Hello is sent by the src with the versions it supports, and returned by the dest with the version chosen.
+type Hello struct {
+	MinVersion int
+	MaxVersion int
+	Version    int
+	// SnapshotParallelism of the src. The dest applies the full copy with as many workers. 0 if unknown.
+	SnapshotParallelism int
+}
+
+func NewHello() *Hello {
+	return &Hello{
+		MinVersion: WireVersionMin,
+		MaxVersion: WireVersionMax,
+	}
+}
+
+func (h *Hello) Marshal() []byte {
+	w := &WireWriter{}
+	w.Uvarint(1, uint64(h.MinVersion))
+	w.Uvarint(2, uint64(h.MaxVersion))
+	w.Uvarint(3, uint64(h.Version))
+	w.Uvarint(4, uint64(h.SnapshotParallelism))
+	return w.Bytes()
+}
+
+func UnmarshalHello(data []byte) (*Hello, error) {
+	h := &Hello{}
+	r := NewWireReader(data)
+	for r.Next() {
+		switch r.Field() {
+		case 1:
+			h.MinVersion = int(r.Uvarint())
+		case 2:
+			h.MaxVersion = int(r.Uvarint())
+		case 3:
+			h.Version = int(r.Uvarint())
+		case 4:
+			h.SnapshotParallelism = int(r.Uvarint())
+		default:
+			r.Skip()
+		}
+	}
+	return h, r.Err()
+}
+
+// NegotiateWireVersion returns the highest version supported by both this agent and the peer.
+func NegotiateWireVersion(peer *Hello) (int, error) {
+	version := WireVersionMax
+	if peer.MaxVersion < version {
+		version = peer.MaxVersion
+	}
+	if version < WireVersionMin || version < peer.MinVersion {
+		return 0, fmt.Errorf("no common wire version. local: %v-%v, peer: %v-%v",
+			WireVersionMin, WireVersionMax, peer.MinVersion, peer.MaxVersion)
+	}
+	return version, nil
+}
+
+const (
+	helloTimeout = 5 * time.Second
+	// The src falls back to WireVersionLegacy if the dest does not answer after these attempts.
+	helloAttempts = 6
+)
+
+// RequestHello does the handshake on the src, and returns the version agreed.
+// An agent of an older version does not answer. If allowLegacy, WireVersionLegacy is assumed for it
+// after some attempts. Otherwise it retries until stopCh is closed.
+func RequestHello(t Transport, subject string, snapshotParallelism int, allowLegacy bool, stopCh <-chan struct{},
+	logger *logrus.Entry) (int, error) {
+
+	ackCh := make(chan *Hello, 1)
+	err := t.Subscribe(fmt.Sprintf("%s_hello_ack", subject), func(m *Msg) {
+		h, err := UnmarshalHello(m.Data)
+		if err != nil {
+			logger.Warnf("wire: bad hello ack. err: %v", err)
+			return
+		}
+		select {
+		case ackCh <- h:
+		default:
+		}
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	hello := NewHello()
+	hello.SnapshotParallelism = snapshotParallelism
+	data := hello.Marshal()
+	for attempt := 1; ; attempt++ {
+		err := t.Request(fmt.Sprintf("%s_hello", subject), data, helloTimeout)
+		if err == nil {
+			select {
+			case h := <-ackCh:
+				if h.Version < WireVersionMin || h.Version > WireVersionMax {
+					return 0, fmt.Errorf("no common wire version. local: %v-%v, peer: %v-%v",
+						WireVersionMin, WireVersionMax, h.MinVersion, h.MaxVersion)
+				}
+				logger.Infof("wire: use wire version %v", h.Version)
+				return h.Version, nil
+			case <-time.After(helloTimeout):
+			case <-stopCh:
+				return 0, fmt.Errorf("wire: hello aborted")
+			}
+		} else if err != ErrTransportTimeout {
+			return 0, err
+		}
+
+		if allowLegacy && attempt >= helloAttempts {
+			logger.Warnf("wire: no hello from the dest. assume it is of an older version and use wire version %v",
+				WireVersionLegacy)
+			return WireVersionLegacy, nil
+		}
+		logger.Debugf("wire: waiting for hello from the dest. attempt %v", attempt)
+		select {
+		case <-stopCh:
+			return 0, fmt.Errorf("wire: hello aborted")
+		default:
+		}
+	}
+}
+
+// HelloServer answers the handshake on the dest, and keeps the version agreed.
+// Before the handshake, the payloads are taken as WireVersionLegacy, from a src of an older version.
+type HelloServer struct {
+	version             int32
+	snapshotParallelism int32
+}
+
+func ServeHello(t Transport, subject string, logger *logrus.Entry) (*HelloServer, error) {
+	s := &HelloServer{version: WireVersionLegacy}
+	err := t.Subscribe(fmt.Sprintf("%s_hello", subject), func(m *Msg) {
+		peer, err := UnmarshalHello(m.Data)
+		if err != nil {
+			logger.Errorf("wire: bad hello. err: %v", err)
+			return
+		}
+		reply := NewHello()
+		reply.Version, err = NegotiateWireVersion(peer)
+		if err != nil {
+			// The src fails with the version 0.
+			logger.Errorf("wire: %v", err)
+		} else {
+			atomic.StoreInt32(&s.version, int32(reply.Version))
+			atomic.StoreInt32(&s.snapshotParallelism, int32(peer.SnapshotParallelism))
+			logger.Infof("wire: use wire version %v", reply.Version)
+		}
+		if err := t.Publish(fmt.Sprintf("%s_hello_ack", subject), reply.Marshal()); err != nil {
+			logger.Errorf("wire: cannot reply hello. err: %v", err)
+			return
+		}
+		if err := m.Ack(); err != nil {
+			logger.Errorf("wire: cannot ack hello. err: %v", err)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *HelloServer) Version() int {
+	return int(atomic.LoadInt32(&s.version))
+}
+
+// SnapshotParallelism returns that of the src, or 0 before the handshake or if the src is of an older version.
+func (s *HelloServer) SnapshotParallelism() int {
+	return int(atomic.LoadInt32(&s.snapshotParallelism))
+}
+
+const (
+	wireTypeVarint  = 0
+	wireTypeFixed64 = 1
+	wireTypeBytes   = 2
+	wireTypeFixed32 = 5
+)
+
+// WireWriter writes fields in the protocol buffers encoding.
+// As proto3 does, a scalar field of the zero value is not written.
+type WireWriter struct {
+	buf []byte
+}
+
+func (w *WireWriter) Bytes() []byte {
+	return w.buf
+}
+
+func (w *WireWriter) key(field int, wireType int) {
+	w.raw(uint64(field)<<3 | uint64(wireType))
+}
+
+func (w *WireWriter) raw(v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(b[:], v)
+	w.buf = append(w.buf, b[:n]...)
+}
+
+func (w *WireWriter) Uvarint(field int, v uint64) {
+	if v == 0 {
+		return
+	}
+	w.key(field, wireTypeVarint)
+	w.raw(v)
+}
+
+// Svarint writes v as sint64 (zigzag).
+func (w *WireWriter) Svarint(field int, v int64) {
+	w.Uvarint(field, uint64(v<<1)^uint64(v>>63))
+}
+
+func (w *WireWriter) Bool(field int, v bool) {
+	if v {
+		w.Uvarint(field, 1)
+	}
+}
+
+func (w *WireWriter) Double(field int, v float64) {
+	bits := math.Float64bits(v)
+	if bits == 0 {
+		return
+	}
+	w.key(field, wireTypeFixed64)
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], bits)
+	w.buf = append(w.buf, b[:]...)
+}
+
+func (w *WireWriter) RawBytes(field int, v []byte) {
+	if len(v) == 0 {
+		return
+	}
+	w.RepeatedBytes(field, v)
+}
+
+func (w *WireWriter) String(field int, v string) {
+	if v == "" {
+		return
+	}
+	w.RepeatedString(field, v)
+}
+
+// RepeatedBytes writes an element of a repeated field, even if it is empty.
+func (w *WireWriter) RepeatedBytes(field int, v []byte) {
+	w.key(field, wireTypeBytes)
+	w.raw(uint64(len(v)))
+	w.buf = append(w.buf, v...)
+}
+
+// RepeatedString writes an element of a repeated field, even if it is empty.
+func (w *WireWriter) RepeatedString(field int, v string) {
+	w.key(field, wireTypeBytes)
+	w.raw(uint64(len(v)))
+	w.buf = append(w.buf, v...)
+}
+
+// Message writes an embedded message (or an element of a repeated one), even if it is empty.
+func (w *WireWriter) Message(field int, write func(w *WireWriter) error) error {
+	sub := &WireWriter{}
+	if err := write(sub); err != nil {
+		return err
+	}
+	w.RepeatedBytes(field, sub.buf)
+	return nil
+}
+
+// PackedBools writes a packed repeated bool field.
+func (w *WireWriter) PackedBools(field int, v []bool) {
+	if len(v) == 0 {
+		return
+	}
+	w.key(field, wireTypeBytes)
+	w.raw(uint64(len(v)))
+	for _, b := range v {
+		if b {
+			w.buf = append(w.buf, 1)
+		} else {
+			w.buf = append(w.buf, 0)
+		}
+	}
+}
+
+// WireReader reads fields in the protocol buffers encoding. Usage:
+//
+//	r := NewWireReader(data)
+//	for r.Next() {
+//	  switch r.Field() { case 1: x = r.Uvarint() ... default: r.Skip() }
+//	}
+//	err := r.Err()
+//
+// Errors are kept, and Next returns false after an error.
+type WireReader struct {
+	buf      []byte
+	pos      int
+	field    int
+	wireType int
+	err      error
+}
+
+func NewWireReader(data []byte) *WireReader {
+	return &WireReader{buf: data}
+}
+
+func (r *WireReader) Err() error {
+	return r.err
+}
+
+func (r *WireReader) Field() int {
+	return r.field
+}
+
+func (r *WireReader) fail(format string, args ...interface{}) {
+	if r.err == nil {
+		r.err = fmt.Errorf("wire: "+format, args...)
+	}
+}
+
+func (r *WireReader) raw() uint64 {
+	v, n := binary.Uvarint(r.buf[r.pos:])
+	if n <= 0 {
+		r.fail("bad varint at %v", r.pos)
+		return 0
+	}
+	r.pos += n
+	return v
+}
+
+func (r *WireReader) Next() bool {
+	if r.err != nil || r.pos >= len(r.buf) {
+		return false
+	}
+	key := r.raw()
+	r.field = int(key >> 3)
+	r.wireType = int(key & 7)
+	return r.err == nil
+}
+
+func (r *WireReader) expect(wireType int) bool {
+	if r.wireType != wireType {
+		r.fail("field %v: expect wire type %v, got %v", r.field, wireType, r.wireType)
+		return false
+	}
+	return r.err == nil
+}
+
+func (r *WireReader) Uvarint() uint64 {
+	if !r.expect(wireTypeVarint) {
+		return 0
+	}
+	return r.raw()
+}
+
+func (r *WireReader) Svarint() int64 {
+	v := r.Uvarint()
+	return int64(v>>1) ^ -int64(v&1)
+}
+
+func (r *WireReader) Bool() bool {
+	return r.Uvarint() != 0
+}
+
+func (r *WireReader) Double() float64 {
+	if !r.expect(wireTypeFixed64) {
+		return 0
+	}
+	if r.pos+8 > len(r.buf) {
+		r.fail("field %v: unexpected end", r.field)
+		return 0
+	}
+	v := binary.LittleEndian.Uint64(r.buf[r.pos:])
+	r.pos += 8
+	return math.Float64frombits(v)
+}
+
+// RawBytes returns a slice of the buffer. Copy it if the buffer is reused.
+func (r *WireReader) RawBytes() []byte {
+	if !r.expect(wireTypeBytes) {
+		return nil
+	}
+	n := r.raw()
+	if r.err != nil {
+		return nil
+	}
+	if n > uint64(len(r.buf)-r.pos) {
+		r.fail("field %v: unexpected end", r.field)
+		return nil
+	}
+	v := r.buf[r.pos : r.pos+int(n)]
+	r.pos += int(n)
+	return v
+}
+
+func (r *WireReader) String() string {
+	return string(r.RawBytes())
+}
+
+// Message reads an embedded message with read.
+func (r *WireReader) Message(read func(r *WireReader) error) {
+	data := r.RawBytes()
+	if r.err != nil {
+		return
+	}
+	if err := read(NewWireReader(data)); err != nil && r.err == nil {
+		r.err = err
+	}
+}
+
+// PackedBools reads a packed repeated bool field. An unpacked element is also accepted.
+func (r *WireReader) PackedBools() []bool {
+	if r.wireType == wireTypeVarint {
+		return []bool{r.Bool()}
+	}
+	data := r.RawBytes()
+	result := make([]bool, len(data))
+	for i, b := range data {
+		result[i] = b != 0
+	}
+	return result
+}
+
+// Skip skips an unknown field, e.g. one added by a newer version.
+func (r *WireReader) Skip() {
+	switch r.wireType {
+	case wireTypeVarint:
+		r.raw()
+	case wireTypeFixed64:
+		r.pos += 8
+	case wireTypeBytes:
+		r.RawBytes()
+	case wireTypeFixed32:
+		r.pos += 4
+	default:
+		r.fail("field %v: unsupported wire type %v", r.field, r.wireType)
+	}
+	if r.pos > len(r.buf) {
+		r.fail("field %v: unexpected end", r.field)
+	}
+}
@@ -0,0 +1,149 @@
+package common
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestHelloWire(t *testing.T) {
+	for _, h := range []*Hello{
+		{},
+		NewHello(),
+		{MinVersion: WireVersionLegacy, MaxVersion: WireVersionMax, Version: WireVersionProto},
+		{MinVersion: WireVersionLegacy, MaxVersion: WireVersionMax, SnapshotParallelism: 8},
+	} {
+		got, err := UnmarshalHello(h.Marshal())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, h) {
+			t.Fatalf("got %+v, want %+v", got, h)
+		}
+	}
+}
+
+func TestHelloHandshake(t *testing.T) {
+	transport := &memTransport{}
+	logger := logrus.NewEntry(logrus.New())
+	server, err := ServeHello(transport, "job1", logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if server.Version() != WireVersionLegacy || server.SnapshotParallelism() != 0 {
+		t.Fatalf("got %v %v before the handshake", server.Version(), server.SnapshotParallelism())
+	}
+	version, err := RequestHello(transport, "job1", 4, false, nil, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != WireVersionMax || server.Version() != WireVersionMax {
+		t.Fatalf("got %v and %v, want %v", version, server.Version(), WireVersionMax)
+	}
+	if server.SnapshotParallelism() != 4 {
+		t.Fatalf("got SnapshotParallelism %v, want 4", server.SnapshotParallelism())
+	}
+}
+
+func TestRouteHelloWire(t *testing.T) {
+	for _, h := range []*RouteHello{
+		{},
+		{Subject: "job1", Target: "job2", Version: WireVersionFlowControl, KeyId: "k1"},
+		{Subject: "job1", Target: "job2", Version: WireVersionProto, Accepted: false, Reason: "no key"},
+		{Subject: "job1", Target: "job2", Version: WireVersionProto, Accepted: true},
+	} {
+		got, err := UnmarshalRouteHello(h.Marshal())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, h) {
+			t.Fatalf("got %+v, want %+v", got, h)
+		}
+	}
+}
+
+func TestWireReaderWriter(t *testing.T) {
+	w := &WireWriter{}
+	w.Uvarint(1, math.MaxUint64)
+	w.Svarint(2, math.MinInt64)
+	w.Bool(3, true)
+	w.Double(4, -1.5)
+	w.RepeatedString(5, "")
+	w.RepeatedString(5, "a")
+	w.PackedBools(6, []bool{true, false, true})
+	w.Message(7, func(w *WireWriter) error {
+		w.String(1, "inner")
+		return nil
+	})
+	// unknown to the reader
+	w.String(100, "skipped")
+	w.Svarint(8, 42)
+
+	var strs []string
+	var inner string
+	r := NewWireReader(w.Bytes())
+	for r.Next() {
+		switch r.Field() {
+		case 1:
+			if v := r.Uvarint(); v != math.MaxUint64 {
+				t.Fatalf("uvarint %v", v)
+			}
+		case 2:
+			if v := r.Svarint(); v != math.MinInt64 {
+				t.Fatalf("svarint %v", v)
+			}
+		case 3:
+			if !r.Bool() {
+				t.Fatalf("bool")
+			}
+		case 4:
+			if v := r.Double(); v != -1.5 {
+				t.Fatalf("double %v", v)
+			}
+		case 5:
+			strs = append(strs, r.String())
+		case 6:
+			if v := r.PackedBools(); !reflect.DeepEqual(v, []bool{true, false, true}) {
+				t.Fatalf("bools %v", v)
+			}
+		case 7:
+			r.Message(func(r *WireReader) error {
+				for r.Next() {
+					inner = r.String()
+				}
+				return r.Err()
+			})
+		case 8:
+			if v := r.Svarint(); v != 42 {
+				t.Fatalf("after skipped field %v", v)
+			}
+		default:
+			r.Skip()
+		}
+	}
+	if err := r.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(strs, []string{"", "a"}) || inner != "inner" {
+		t.Fatalf("strs %q, inner %q", strs, inner)
+	}
+
+	// truncated. must not panic
+	data := w.Bytes()
+	for n := 1; n < len(data); n++ {
+		r := NewWireReader(data[:n])
+		for r.Next() {
+			r.Skip()
+		}
+		_ = r.Err()
+	}
+	r = NewWireReader(data[:len(data)-1])
+	for r.Next() {
+		r.Skip()
+	}
+	if r.Err() == nil {
+		t.Fatalf("no error for truncated data")
+	}
+}
@@ -105,3 +105,10 @@ type DriverHandle interface {
 	// Stats returns aggregated stats of the driver
 	Stats() (*models.TaskStatistics, error)
 }
+
+// RateLimitHandle is implemented by the DriverHandles whose rate limit can be
+// changed at runtime. The change is not persisted, and is lost when the task restarts.
+type RateLimitHandle interface {
+	RateLimit() *models.RateLimit
+	SetRateLimit(limit *models.RateLimit)
+}
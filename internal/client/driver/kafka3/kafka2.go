@@ -58,6 +58,12 @@ type KafkaConfig struct {
 	BinlogFile string
 	BinlogPos  int64
 	TimeZone   string
+
+	// See MySQLDriverConfig
+	Transport         string
+	TransportPort     int
+	EncryptionKeyFile string
+	FlowControlBytes  int64
 }
 
 type KafkaManager struct {
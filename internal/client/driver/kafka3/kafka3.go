@@ -13,6 +13,7 @@ import (
 	"fmt"
 	"github.com/pkg/errors"
 	gomysql "github.com/siddontang/go-mysql/mysql"
+	"github.com/siddontang/go-mysql/replication"
 	"strconv"
 
 	"github.com/actiontech/dtle/internal/client/driver/common"
@@ -20,8 +21,6 @@ import (
 	mysqlDriver "github.com/actiontech/dtle/internal/client/driver/mysql"
 	"github.com/actiontech/dtle/internal/config/mysql"
 
-	"github.com/golang/snappy"
-	gonats "github.com/nats-io/go-nats"
 	"github.com/satori/go.uuid"
 
 	"encoding/base64"
@@ -48,7 +47,7 @@ type KafkaRunner struct {
 	logger      *logrus.Entry
 	subject     string
 	subjectUUID uuid.UUID
-	natsConn    *gonats.Conn
+	transport   common.Transport
 	waitCh      chan *models.WaitResult
 
 	shutdown   bool
@@ -60,6 +59,17 @@ type KafkaRunner struct {
 	tables map[string](map[string]*config.Table)
 
 	gtidSet *gomysql.MysqlGTIDSet
+
+	heartbeat binlog.HeartbeatTracker
+
+	// of the agent
+	natsSecurity *config.NatsSecurity
+	maxPayload   int
+	// might be nil
+	payloadCipher *common.PayloadCipher
+	codec         *common.Codec
+	wireDecoder   *mysqlDriver.WireDecoder
+	creditGranter *common.CreditGranter
 }
 
 func NewKafkaRunner(execCtx *common.ExecContext, cfg *KafkaConfig, logger *logrus.Logger) *KafkaRunner {
@@ -67,12 +77,14 @@ func NewKafkaRunner(execCtx *common.ExecContext, cfg *KafkaConfig, logger *logru
 		"job": execCtx.Subject,
 	})
 	return &KafkaRunner{
-		subject:     execCtx.Subject,
-		kafkaConfig: cfg,
-		logger:      entry,
-		waitCh:      make(chan *models.WaitResult, 1),
-		shutdownCh:  make(chan struct{}),
-		tables:      make(map[string](map[string]*config.Table)),
+		subject:      execCtx.Subject,
+		kafkaConfig:  cfg,
+		logger:       entry,
+		waitCh:       make(chan *models.WaitResult, 1),
+		shutdownCh:   make(chan struct{}),
+		tables:       make(map[string](map[string]*config.Table)),
+		natsSecurity: execCtx.NatsSecurity,
+		maxPayload:   execCtx.MaxPayload,
 	}
 }
 
@@ -111,8 +123,8 @@ func (kr *KafkaRunner) Shutdown() error {
 	if kr.shutdown {
 		return nil
 	}
-	if kr.natsConn != nil {
-		kr.natsConn.Close()
+	if kr.transport != nil {
+		kr.transport.Close()
 	}
 	kr.shutdown = true
 	close(kr.shutdownCh)
@@ -122,23 +134,51 @@ func (kr *KafkaRunner) Shutdown() error {
 }
 
 func (kr *KafkaRunner) Stats() (*models.TaskStatistics, error) {
-	taskResUsage := &models.TaskStatistics{}
+	taskResUsage := &models.TaskStatistics{
+		HeartbeatLag:    kr.heartbeat.Stat(),
+		CompressionStat: kr.codec.Stat(),
+	}
 	return taskResUsage, nil
 }
 func (kr *KafkaRunner) initNatSubClient() (err error) {
-	natsAddr := fmt.Sprintf("nats://%s", kr.kafkaConfig.NatsAddr)
-	sc, err := gonats.Connect(natsAddr)
+	kr.transport, err = common.NewTransport(kr.kafkaConfig.Transport, kr.kafkaConfig.NatsAddr,
+		kr.kafkaConfig.TransportPort, true, kr.natsSecurity, kr.logger)
 	if err != nil {
 		kr.logger.WithFields(logrus.Fields{
 			"err":        err,
-			"natsServer": natsAddr,
-		}).Errorf("kafka: Can't connect nats server. make sure a nats streaming server is running")
+			"transport":  kr.kafkaConfig.Transport,
+			"natsServer": kr.kafkaConfig.NatsAddr,
+		}).Errorf("kafka: Can't connect transport. make sure a nats streaming server is running")
 		return err
 	}
 	kr.logger.WithFields(logrus.Fields{
-		"natsServer": natsAddr,
-	}).Debugf("kafka: Connect nats server %v", natsAddr)
-	kr.natsConn = sc
+		"transport":  kr.kafkaConfig.Transport,
+		"natsServer": kr.kafkaConfig.NatsAddr,
+	}).Debugf("kafka: Connect transport")
+	kr.payloadCipher, err = common.NewPayloadCipher(kr.kafkaConfig.EncryptionKeyFile, kr.logger)
+	if err != nil {
+		return err
+	}
+	kr.codec, err = common.NewCodec("", 0, kr.maxPayload)
+	if err != nil {
+		return err
+	}
+	handshake, err := common.ServeHello(kr.transport, kr.subject, kr.logger)
+	if err != nil {
+		return err
+	}
+	kr.wireDecoder = &mysqlDriver.WireDecoder{
+		Handshake:     handshake,
+		PayloadCipher: kr.payloadCipher,
+		Codec:         kr.codec,
+	}
+	if err := common.ServeRouteHello(kr.transport, kr.subject, kr.wireDecoder.CheckRoute, kr.logger); err != nil {
+		return err
+	}
+	// The entries are handled one payload after another, without a queue.
+	kr.creditGranter = common.NewCreditGranter(kr.transport, kr.subject, common.DefaultCreditWindowEntries,
+		kr.kafkaConfig.FlowControlBytes, kr.logger)
+	go kr.creditGranter.Run(kr.shutdownCh)
 	return nil
 }
 func (kr *KafkaRunner) Run() {
@@ -208,15 +248,15 @@ func (kr *KafkaRunner) initiateStreaming() error {
 
 	// TODO We subscribe _full anyway to receive sendSysVarAndSqlMode.
 	//  Use a better method.
-	_, err = kr.natsConn.Subscribe(fmt.Sprintf("%s_full", kr.subject), func(m *gonats.Msg) {
+	err = kr.transport.Subscribe(fmt.Sprintf("%s_full", kr.subject), func(m *common.Msg) {
 		kr.logger.Debugf("kafka: recv a full msg")
-		if err := kr.natsConn.Publish(m.Reply, nil); err != nil {
+		if err := m.Ack(); err != nil {
 			kr.onError(TaskStateDead, err)
 			return
 		}
 		kr.logger.Debugf("kafka: ack a full msg")
 
-		dumpData, err := mysqlDriver.DecodeDumpEntry(m.Data)
+		dumpData, err := kr.wireDecoder.DumpEntry(m.Subject, m.Data)
 		if err != nil {
 			kr.onError(TaskStateDead, err)
 			return
@@ -226,7 +266,7 @@ func (kr *KafkaRunner) initiateStreaming() error {
 			kr.logger.Debugf("kafka. a sql dumpEntry")
 		} else if dumpData.TableSchema == "" && dumpData.TableName == "" {
 			kr.logger.Debugf("kafka.  skip apply sqlMode and SystemVariablesStatement")
-			if err := kr.natsConn.Publish(m.Reply, nil); err != nil {
+			if err := m.Ack(); err != nil {
 				kr.onError(TaskStateDead, err)
 				return
 			}
@@ -258,17 +298,18 @@ func (kr *KafkaRunner) initiateStreaming() error {
 		return err
 	}
 
-	_, err = kr.natsConn.Subscribe(fmt.Sprintf("%s_full_complete", kr.subject), func(m *gonats.Msg) {
+	err = kr.transport.Subscribe(fmt.Sprintf("%s_full_complete", kr.subject), func(m *common.Msg) {
 		kr.logger.Debugf("kafka: recv a full_complete msg")
 
-		if err := kr.natsConn.Publish(m.Reply, nil); err != nil {
+		if err := m.Ack(); err != nil {
 			kr.onError(TaskStateDead, err)
 		}
 		kr.logger.Debugf("kafka: ack a full_complete msg")
 
-		dumpData := &mysqlDriver.DumpStatResult{}
-		if err := Decode(m.Data, dumpData); err != nil {
+		dumpData, err := kr.wireDecoder.DumpStatResult(m.Subject, m.Data)
+		if err != nil {
 			kr.onError(TaskStateDead, err)
+			return
 		}
 
 		kr.kafkaConfig.BinlogFile = dumpData.LogFile
@@ -293,19 +334,23 @@ func (kr *KafkaRunner) initiateStreaming() error {
 		return errors.Wrap(err, "DtleParseMysqlGTIDSet")
 	}
 
-	_, err = kr.natsConn.Subscribe(fmt.Sprintf("%s_incr_hete", kr.subject), func(m *gonats.Msg) {
+	err = kr.transport.Subscribe(fmt.Sprintf("%s_incr_hete", kr.subject), func(m *common.Msg) {
 		kr.logger.Debugf("kafka: recv a incr_hete msg")
 
-		if err := kr.natsConn.Publish(m.Reply, nil); err != nil {
+		if err := m.Ack(); err != nil {
 			kr.onError(TaskStateDead, errors.Wrap(err, "Publish"))
 		}
 		kr.logger.Debugf("kafka. ack a incr_hete msg")
 
 		var bigEntries binlog.BinlogEntries
 		var binlogEntries binlog.BinlogEntries
-		if err := Decode(m.Data, &binlogEntries); err != nil {
-			kr.onError(TaskStateDead, err)
+		entries, err := kr.wireDecoder.BinlogEntries(m.Subject, m.Data)
+		if err != nil {
+			kr.onError(TaskStateDead, errors.Wrap(err, "Decode"))
+			return
 		}
+		binlogEntries = *entries
+		defer kr.creditGranter.Release(entries.CreditCost())
 		if binlogEntries.BigTx {
 			if binlogEntries.TxNum == 1 {
 				bigEntries = binlogEntries
@@ -342,12 +387,7 @@ func (kr *KafkaRunner) initiateStreaming() error {
 // TODO move to one place
 func Decode(data []byte, vPtr interface{}) (err error) {
 	gob.Register(types.BinaryLiteral{})
-	msg, err := snappy.Decode(nil, data)
-	if err != nil {
-		return err
-	}
-
-	return gob.NewDecoder(bytes.NewBuffer(msg)).Decode(vPtr)
+	return gob.NewDecoder(bytes.NewBuffer(data)).Decode(vPtr)
 }
 func DecodeGob(data []byte, vPtr interface{}) (err error) {
 	gob.Register(types.BinaryLiteral{})
@@ -362,16 +402,16 @@ func (kr *KafkaRunner) onError(state int, err error) {
 	case TaskStateComplete:
 		kr.logger.Printf("kafka: Done migrating")
 	case TaskStateRestart:
-		if kr.natsConn != nil {
-			if err := kr.natsConn.Publish(fmt.Sprintf("%s_restart", kr.subject), []byte(kr.kafkaConfig.Gtid)); err != nil {
+		if kr.transport != nil {
+			if err := kr.transport.Publish(fmt.Sprintf("%s_restart", kr.subject), []byte(kr.kafkaConfig.Gtid)); err != nil {
 				kr.logger.WithFields(logrus.Fields{
 					"err": err,
 				}).Errorf("kafka: Trigger restart")
 			}
 		}
 	default:
-		if kr.natsConn != nil {
-			if err := kr.natsConn.Publish(fmt.Sprintf("%s_error", kr.subject), []byte(kr.kafkaConfig.Gtid)); err != nil {
+		if kr.transport != nil {
+			if err := kr.transport.Publish(fmt.Sprintf("%s_error", kr.subject), []byte(kr.kafkaConfig.Gtid)); err != nil {
 				kr.logger.WithFields(logrus.Fields{
 					"err": err,
 				}).Errorf("kafka: Trigger shutdown")
@@ -412,8 +452,8 @@ func (kr *KafkaRunner) kafkaTransformSnapshotData(table *config.Table, value *my
 		valuePayload.Before = nil
 		valuePayload.After = NewRow()
 
-		columnList := table.OriginalTableColumns.ColumnList()
-		valueColDef, keyColDef := kafkaColumnListToColDefs(table.OriginalTableColumns, kr.kafkaConfig.TimeZone)
+		columnList := table.GetMappedTableColumns().ColumnList()
+		valueColDef, keyColDef := kafkaColumnListToColDefs(table.GetMappedTableColumns(), kr.kafkaConfig.TimeZone)
 		keySchema := NewKeySchema(tableIdent, keyColDef)
 
 		for i, _ := range columnList {
@@ -574,8 +614,8 @@ func (kr *KafkaRunner) kafkaTransformDMLEventQuery(dmlEvent *binlog.BinlogEntry)
 		tableIdent := fmt.Sprintf("%v.%v.%v", kr.kafkaMgr.Cfg.Topic, table.TableSchema, table.TableName)
 
 		keyPayload := NewRow()
-		colList := table.OriginalTableColumns.ColumnList()
-		colDefs, keyColDefs := kafkaColumnListToColDefs(table.OriginalTableColumns, kr.kafkaConfig.TimeZone)
+		colList := table.GetMappedTableColumns().ColumnList()
+		colDefs, keyColDefs := kafkaColumnListToColDefs(table.GetMappedTableColumns(), kr.kafkaConfig.TimeZone)
 
 		for i, _ := range colList {
 			colName := colList[i].RawName
@@ -714,13 +754,16 @@ func (kr *KafkaRunner) kafkaTransformDMLEventQuery(dmlEvent *binlog.BinlogEntry)
 				}
 			}
 
-			if before != nil {
+			// A column not in the row image (binlog_row_image=MINIMAL/NOBLOB) is omitted, rather than sent as NULL.
+			if before != nil && dataEvent.WhereColumnValues.IsPresent(i) {
 				kr.logger.WithFields(logrus.Fields{
 					"beforeValue": beforeValue,
 				}).Trace("kafka. beforeValue")
 				before.AddField(colName, beforeValue)
 			}
-			if after != nil {
+			// So is a partial JSON update which the source could not resolve (without the before image).
+			_, isJsonDiffs := afterValue.(replication.JsonDiffs)
+			if after != nil && dataEvent.NewColumnValues.IsPresent(i) && !isJsonDiffs {
 				kr.logger.WithFields(logrus.Fields{
 					"afterValue": afterValue,
 				}).Trace("kafka. afterValue")
@@ -742,7 +785,11 @@ func (kr *KafkaRunner) kafkaTransformDMLEventQuery(dmlEvent *binlog.BinlogEntry)
 		valuePayload.Source.Row = 0          // TODO "the row within the event (if there is more than one)".
 		valuePayload.Source.Snapshot = false // TODO "whether this event was part of a snapshot"
 
-		valuePayload.Source.Query = nil
+		if rowsQuery := dmlEvent.RowsQueryOf(dataEvent); rowsQuery != "" {
+			valuePayload.Source.Query = rowsQuery
+		} else {
+			valuePayload.Source.Query = nil
+		}
 		// My guess: for full range, snapshot=true, else false
 		valuePayload.Source.Thread = nil // TODO
 		valuePayload.Source.Db = dataEvent.DatabaseName
@@ -800,6 +847,9 @@ func (kr *KafkaRunner) kafkaTransformDMLEventQuery(dmlEvent *binlog.BinlogEntry)
 
 	common.UpdateGtidSet(kr.gtidSet, txSid, dmlEvent.Coordinates.SID, dmlEvent.Coordinates.GNO)
 	kr.updateGtidString()
+	if dmlEvent.HeartbeatTs != 0 {
+		kr.heartbeat.OnApplied(dmlEvent.HeartbeatTs)
+	}
 
 	return nil
 }
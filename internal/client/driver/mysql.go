@@ -7,6 +7,7 @@
 package driver
 
 import (
+	gosql "database/sql"
 	"fmt"
 	"github.com/actiontech/dtle/internal/client/driver/common"
 	"strings"
@@ -125,6 +126,15 @@ func (m *MySQLDriver) Validate(task *models.Task) (*models.TaskValidateResponse,
 			reply.Binlog.Success = true
 		}
 
+		keylessTables, err := findKeylessTables(db, driverConfig.ReplicateDoDb)
+		if err != nil {
+			reply.KeylessTables.Warning = err.Error()
+		} else if len(keylessTables) > 0 {
+			reply.KeylessTables.Tables = keylessTables
+			reply.KeylessTables.Warning = fmt.Sprintf("Tables without primary key or unique key will be dumped"+
+				" by one query and cannot be resumed in the middle of full copy: %v", strings.Join(keylessTables, ", "))
+		}
+
 		query = `show grants for current_user()`
 		foundAll := false
 		foundSuper := false
@@ -223,6 +233,42 @@ func (m *MySQLDriver) Validate(task *models.Task) (*models.TaskValidateResponse,
 	return reply, nil
 }
 
+// findKeylessTables returns tables (schema.table) without primary key or unique key in replicateDoDb.
+func findKeylessTables(db *gosql.DB, replicateDoDb []*config.DataSource) ([]string, error) {
+	var result []string
+	for _, doDb := range replicateDoDb {
+		tableNames := make(map[string]bool)
+		for _, tb := range doDb.Tables {
+			tableNames[tb.TableName] = true
+		}
+		query := `select t.table_name from information_schema.tables t
+			where t.table_schema = ? and t.table_type = 'BASE TABLE' and not exists (
+				select 1 from information_schema.table_constraints c
+				where c.table_schema = t.table_schema and c.table_name = t.table_name
+					and c.constraint_type in ('PRIMARY KEY', 'UNIQUE'))`
+		rows, err := db.Query(query, doDb.TableSchema)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var tableName string
+			if err := rows.Scan(&tableName); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			// all tables in the schema are replicated if no table is assigned
+			if len(tableNames) == 0 || tableNames[tableName] {
+				result = append(result, fmt.Sprintf("%s.%s", doDb.TableSchema, tableName))
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
 func (m *MySQLDriver) Start(ctx *common.ExecContext, task *models.Task) (DriverHandle, error) {
 	var driverConfig config.MySQLDriverConfig
 	if err := mapstructure.WeakDecode(task.Config, &driverConfig); err != nil {
@@ -29,12 +29,13 @@ import (
 	"sync/atomic"
 	"time"
 
-	"github.com/golang/snappy"
 	gonats "github.com/nats-io/go-nats"
 	gomysql "github.com/siddontang/go-mysql/mysql"
+	"github.com/siddontang/go-mysql/replication"
 
 	"container/heap"
 	"context"
+	"crypto/md5"
 	"encoding/hex"
 	"os"
 
@@ -84,6 +85,9 @@ type applierTableItem struct {
 	psInsert []*gosql.Stmt
 	psDelete []*gosql.Stmt
 	psUpdate []*gosql.Stmt
+
+	// The replicated columns (after the column spec of the source table). nil for all columns.
+	columnNames []string
 }
 
 func newApplierTableItem(parallelWorkers int) *applierTableItem {
@@ -229,9 +233,21 @@ type Applier struct {
 	// only TX can be executed should be put into this chan
 	applyBinlogMtsTxQueue chan *binlog.BinlogEntry
 
-	natsConn *gonats.Conn
-	waitCh   chan *models.WaitResult
-	wg       sync.WaitGroup
+	transport common.Transport
+	waitCh    chan *models.WaitResult
+	wg        sync.WaitGroup
+	// of the agent
+	natsSecurity *config.NatsSecurity
+	maxPayload   int
+	// might be nil
+	payloadCipher *common.PayloadCipher
+	codec         *common.Codec
+	wireDecoder   *WireDecoder
+	creditGranter *common.CreditGranter
+	// bytes (BinlogEntry.OriginalSize) in applyDataEntryQueue
+	queuedBytes int64
+	// The parts of a big tx are spilled here if they exceed TxMemoryLimit.
+	spillDir string
 
 	shutdown     bool
 	shutdownCh   chan struct{}
@@ -245,6 +261,10 @@ type Applier struct {
 	stubFullApplyDelay time.Duration
 
 	gtidSet *gomysql.MysqlGTIDSet
+
+	heartbeat binlog.HeartbeatTracker
+	// nil if MaxLoad and CriticalLoad are not set
+	throttler *throttler
 }
 
 func NewApplier(ctx *common.ExecContext, cfg *config.MySQLDriverConfig, logger *logrus.Logger) (*Applier, error) {
@@ -272,11 +292,17 @@ func NewApplier(ctx *common.ExecContext, cfg *config.MySQLDriverConfig, logger *
 		waitCh:                  make(chan *models.WaitResult, 1),
 		shutdownCh:              make(chan struct{}),
 		printTps:                os.Getenv(g.ENV_PRINT_TPS) != "",
+		natsSecurity:            ctx.NatsSecurity,
+		maxPayload:              ctx.MaxPayload,
+		spillDir:                binlog.GetSpillDir(ctx.StateDir, ctx.Subject, "dest"),
 	}
 	a.gtidSet, err = common.DtleParseMysqlGTIDSet(a.mysqlContext.Gtid)
 	if err != nil {
 		return nil, err
 	}
+	if err := binlog.ResetSpillDir(a.spillDir); err != nil {
+		return nil, err
+	}
 	stubFullApplyDelayStr := os.Getenv(g.ENV_FULL_APPLY_DELAY)
 	if stubFullApplyDelayStr == "" {
 		a.stubFullApplyDelay = 0
@@ -341,6 +367,12 @@ func (a *Applier) Run() {
 		a.onError(TaskStateDead, err)
 		return
 	}
+	var err error
+	a.throttler, err = newThrottler(a.db, a.mysqlContext.MaxLoad, a.mysqlContext.CriticalLoad, a.logger)
+	if err != nil {
+		a.onError(TaskStateDead, err)
+		return
+	}
 	if err := a.initNatSubClient(); err != nil {
 		a.onError(TaskStateDead, err)
 		return
@@ -358,26 +390,77 @@ func (a *Applier) Run() {
 	go a.executeWriteFuncs()
 }
 
+// snapshotApplyParallelism is the number of workers applying the full copy. It is SnapshotParallelism of the src,
+// as many ranges are dumped at the same time. SnapshotParallelism of the dest is used only for a src of an older version.
+func (a *Applier) snapshotApplyParallelism() int {
+	n := a.wireDecoder.Handshake.SnapshotParallelism()
+	if n <= 0 {
+		n = a.mysqlContext.SnapshotParallelism
+	}
+	if n <= 0 {
+		n = 1
+	}
+	a.logger.Infof("mysql.applier: full copy is applied by %v workers", n)
+	return n
+}
+
 // executeWriteFuncs writes data via applier: both the rowcopy and the events backlog.
 // This is where the ghost table gets the data. The function fills the data single-threaded.
 // Both event backlog and rowcopy events are polled; the backlog events have precedence.
 func (a *Applier) executeWriteFuncs() {
 	if a.mysqlContext.Gtid == "" {
+		throttleStopCh := make(chan struct{})
 		go func() {
+			if err := a.throttler.run(throttleStopCh); err != nil {
+				a.onError(TaskStateDead, err)
+			}
+		}()
+		go func() {
+			defer close(throttleStopCh)
 			var stopLoop = false
+			dumpEntryDone := func() {
+				if atomic.LoadInt64(&a.nDumpEntry) < 0 {
+					a.onError(TaskStateDead, fmt.Errorf("DTLE_BUG"))
+				} else {
+					atomic.AddInt64(&a.nDumpEntry, -1)
+				}
+			}
+			// Chunks of rows are applied by concurrent workers. Entries with statements (e.g. create table)
+			// are applied alone, after all previous entries are applied.
+			// The workers are created on the first entry, after the handshake, see snapshotApplyParallelism.
+			var workerSem chan struct{}
+			workerWg := sync.WaitGroup{}
 			for !stopLoop {
 				select {
 				case copyRows := <-a.copyRowsQueue:
-					if nil != copyRows {
+					a.throttler.waitIfThrottled(a.shutdownCh)
+					if nil == copyRows {
+						dumpEntryDone()
+					} else if copyRows.hasStatements() {
+						workerWg.Wait()
 						//time.Sleep(20 * time.Second) // #348 stub
 						if err := a.ApplyEventQueries(a.db, copyRows); err != nil {
 							a.onError(TaskStateDead, err)
+						} else {
+							a.reportDumpEntryApplied(copyRows)
 						}
-					}
-					if atomic.LoadInt64(&a.nDumpEntry) < 0 {
-						a.onError(TaskStateDead, fmt.Errorf("DTLE_BUG"))
+						dumpEntryDone()
 					} else {
-						atomic.AddInt64(&a.nDumpEntry, -1)
+						if workerSem == nil {
+							workerSem = make(chan struct{}, a.snapshotApplyParallelism())
+						}
+						workerSem <- struct{}{}
+						workerWg.Add(1)
+						go func(entry *DumpEntry) {
+							defer workerWg.Done()
+							if err := a.ApplyEventQueries(a.db, entry); err != nil {
+								a.onError(TaskStateDead, err)
+							} else {
+								a.reportDumpEntryApplied(entry)
+							}
+							dumpEntryDone()
+							<-workerSem
+						}(copyRows)
 					}
 				case <-a.rowCopyComplete:
 					stopLoop = true
@@ -416,24 +499,46 @@ func (a *Applier) executeWriteFuncs() {
 }
 
 func (a *Applier) initNatSubClient() (err error) {
-	natsAddr := fmt.Sprintf("nats://%s", a.mysqlContext.NatsAddr)
-	sc, err := gonats.Connect(natsAddr)
+	a.transport, err = common.NewTransport(a.mysqlContext.Transport, a.mysqlContext.NatsAddr,
+		a.mysqlContext.TransportPort, true, a.natsSecurity, a.logger)
 	if err != nil {
-		a.logger.Errorf("mysql.applier: Can't connect nats server %v. make sure a nats streaming server is running.%v", natsAddr, err)
+		a.logger.Errorf("mysql.applier: Can't connect %v transport. nats server %v. make sure a nats streaming server is running.%v",
+			a.mysqlContext.Transport, a.mysqlContext.NatsAddr, err)
 		return err
 	}
-	a.logger.Debugf("mysql.applier: Connect nats server %v", natsAddr)
-	a.natsConn = sc
-	return nil
-}
-func DecodeDumpEntry(data []byte) (entry *DumpEntry, err error) {
-	msg, err := snappy.Decode(nil, data)
+	a.logger.Debugf("mysql.applier: Connect %v transport. nats server %v", a.mysqlContext.Transport, a.mysqlContext.NatsAddr)
+	a.payloadCipher, err = common.NewPayloadCipher(a.mysqlContext.EncryptionKeyFile, a.logger)
 	if err != nil {
-		return nil, err
+		return err
 	}
+	// The codec is read from each payload. Compression settings of the dest are not used.
+	a.codec, err = common.NewCodec("", 0, a.maxPayload)
+	if err != nil {
+		return err
+	}
+	handshake, err := common.ServeHello(a.transport, a.subject, a.logger)
+	if err != nil {
+		return err
+	}
+	a.wireDecoder = &WireDecoder{
+		Handshake:     handshake,
+		PayloadCipher: a.payloadCipher,
+		Codec:         a.codec,
+	}
+	if err := common.ServeRouteHello(a.transport, a.subject, a.wireDecoder.CheckRoute, a.logger); err != nil {
+		return err
+	}
+	// The entry credits are the queue capacity, so an entry never waits to be enqueued.
+	a.creditGranter = common.NewCreditGranter(a.transport, a.subject, int64(cap(a.applyDataEntryQueue)),
+		a.mysqlContext.FlowControlBytes, a.logger)
+	go a.creditGranter.Run(a.shutdownCh)
+	return nil
+}
 
+// DecodeDumpEntry decodes a payload of WireVersionLegacy, after snappy.
+func DecodeDumpEntry(msg []byte) (entry *DumpEntry, err error) {
 	entry = &DumpEntry{}
-	n, err := entry.Unmarshal(msg)
+	n, err := unmarshalLegacyDumpEntry(entry, msg)
 	if err != nil {
 		return nil, err
 	}
@@ -444,14 +549,9 @@ func DecodeDumpEntry(data []byte) (entry *DumpEntry, err error) {
 	return entry, nil
 }
 
-// Decode
-func Decode(data []byte, vPtr interface{}) (err error) {
+// Decode decodes a payload of WireVersionLegacy, after snappy.
+func Decode(msg []byte, vPtr interface{}) (err error) {
 	gob.Register(types.BinaryLiteral{})
-	msg, err := snappy.Decode(nil, data)
-	if err != nil {
-		return err
-	}
-
 	return gob.NewDecoder(bytes.NewBuffer(msg)).Decode(vPtr)
 }
 
@@ -464,18 +564,37 @@ func (a *Applier) setTableItemForBinlogEntry(binlogEntry *binlog.BinlogEntry) er
 			// do nothing
 		default:
 			tableItem := a.getTableItem(dmlEvent.DatabaseName, dmlEvent.TableName)
+			if dmlEvent.Table != nil {
+				// The table def is sent when the table is first seen or changed.
+				var columnNames []string
+				if dmlEvent.Table.MappedTableColumns != nil {
+					columnNames = dmlEvent.Table.MappedTableColumns.Names()
+				}
+				if strings.Join(columnNames, ",") != strings.Join(tableItem.columnNames, ",") {
+					a.logger.Debugf("mysql.applier: replicated columns of %v.%v: %v",
+						dmlEvent.DatabaseName, dmlEvent.TableName, columnNames)
+					tableItem.Reset()
+					tableItem.columnNames = columnNames
+				}
+			}
 			if tableItem.columns == nil {
 				a.logger.Debugf("mysql.applier: get tableColumns %v.%v", dmlEvent.DatabaseName, dmlEvent.TableName)
-				tableItem.columns, err = base.GetTableColumns(a.db, dmlEvent.DatabaseName, dmlEvent.TableName)
+				var columns *umconf.ColumnList
+				columns, err = base.GetTableColumns(a.db, dmlEvent.DatabaseName, dmlEvent.TableName)
 				if err != nil {
 					a.logger.Errorf("mysql.applier. GetTableColumns error. err: %v", err)
 					return err
 				}
-				err = base.ApplyColumnTypes(a.db, dmlEvent.DatabaseName, dmlEvent.TableName, tableItem.columns)
+				err = base.ApplyColumnTypes(a.db, dmlEvent.DatabaseName, dmlEvent.TableName, columns)
 				if err != nil {
 					a.logger.Errorf("mysql.applier. ApplyColumnTypes error. err: %v", err)
 					return err
 				}
+				tableItem.columns, err = mapTableColumns(columns, tableItem.columnNames)
+				if err != nil {
+					a.logger.Errorf("mysql.applier. table %v.%v. err: %v", dmlEvent.DatabaseName, dmlEvent.TableName, err)
+					return err
+				}
 			} else {
 				a.logger.Debugf("mysql.applier: reuse tableColumns %v.%v", dmlEvent.DatabaseName, dmlEvent.TableName)
 			}
@@ -485,6 +604,29 @@ func (a *Applier) setTableItemForBinlogEntry(binlogEntry *binlog.BinlogEntry) er
 	return nil
 }
 
+// mapTableColumns picks the columns in names (in that order) from the dest table columns.
+// The row values of a DML event are in that order.
+func mapTableColumns(columns *umconf.ColumnList, names []string) (*umconf.ColumnList, error) {
+	if names == nil {
+		return columns, nil
+	}
+	mapped := make([]umconf.Column, 0, len(names))
+	for _, name := range names {
+		found := false
+		for i := range columns.Columns {
+			if strings.EqualFold(columns.Columns[i].RawName, name) {
+				mapped = append(mapped, columns.Columns[i])
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("column %v is not found in dest table", name)
+		}
+	}
+	return umconf.NewColumnList(mapped), nil
+}
+
 func (a *Applier) cleanGtidExecuted(sid uuid.UUID, intervalStr string) error {
 	a.logger.Debugf("mysql.applier. incr. cleanup before WaitForExecution")
 	if !a.mtsManager.WaitForAllCommitted() {
@@ -533,6 +675,10 @@ func (a *Applier) heterogeneousReplay() {
 			if nil == binlogEntry {
 				continue
 			}
+			atomic.AddInt64(&a.queuedBytes, -int64(binlogEntry.OriginalSize))
+			if !binlogEntry.Routed {
+				a.creditGranter.Release(1, int64(binlogEntry.OriginalSize))
+			}
 			spanContext := binlogEntry.SpanContext
 			span := opentracing.GlobalTracer().StartSpan("dest use binlogEntry  ", opentracing.FollowsFrom(spanContext))
 			ctx = opentracing.ContextWithSpan(ctx, span)
@@ -542,6 +688,7 @@ func (a *Applier) heterogeneousReplay() {
 
 			if binlogEntry.Coordinates.OSID == a.mysqlContext.MySQLServerUuid {
 				a.logger.Debugf("mysql.applier: skipping a dtle tx. osid: %v", binlogEntry.Coordinates.OSID)
+				binlogEntry.DiscardSpill()
 				continue
 			}
 			// region TestIfExecuted
@@ -563,6 +710,7 @@ func (a *Applier) heterogeneousReplay() {
 			if base.IntervalSlicesContainOne(gtidSetItem.Intervals, binlogEntry.Coordinates.GNO) {
 				// entry executed
 				a.logger.Debugf("mysql.applier: skip an executed tx: %v:%v", txSid, binlogEntry.Coordinates.GNO)
+				binlogEntry.DiscardSpill()
 				continue
 			}
 			// endregion
@@ -620,6 +768,7 @@ func (a *Applier) heterogeneousReplay() {
 					a.mtsManager.lastEnqueue += 1
 					a.mtsManager.chExecuted <- a.mtsManager.lastEnqueue
 				}
+				// A spilled tx has only DMLs on the disk. Check the events in memory only.
 				hasDDL := func() bool {
 					for i := range binlogEntry.Events {
 						dmlEvent := &binlogEntry.Events[i]
@@ -675,14 +824,26 @@ func (a *Applier) heterogeneousReplay() {
 	}
 }
 
+// reportDumpEntryApplied tells the extractor that the entry is applied, so that its full copy checkpoint
+// can advance. A lost report only holds the checkpoint back.
+func (a *Applier) reportDumpEntryApplied(entry *DumpEntry) {
+	if entry.Seq == 0 {
+		return
+	}
+	err := a.transport.Publish(fmt.Sprintf("%s_full_applied", a.subject), []byte(strconv.FormatInt(entry.Seq, 10)))
+	if err != nil {
+		a.logger.Warnf("mysql.applier: cannot report applied dump entry %v. err: %v", entry.Seq, err)
+	}
+}
+
 // initiateStreaming begins treaming of binary log events and registers listeners for such events
 func (a *Applier) initiateStreaming() error {
 	a.mysqlContext.MarkRowCopyStartTime()
 	a.logger.Debugf("mysql.applier: nats subscribe")
 	tracer := opentracing.GlobalTracer()
-	_, err := a.natsConn.Subscribe(fmt.Sprintf("%s_full", a.subject), func(m *gonats.Msg) {
+	err := a.transport.Subscribe(fmt.Sprintf("%s_full", a.subject), func(m *common.Msg) {
 		a.logger.Debugf("mysql.applier: full. recv a msg. copyRowsQueue: %v", len(a.copyRowsQueue))
-		t := not.NewTraceMsg(m)
+		t := not.NewTraceMsg(&gonats.Msg{Subject: m.Subject, Data: m.Data})
 		// Extract the span context from the request message.
 		sc, err := tracer.Extract(opentracing.Binary, t)
 		if err != nil {
@@ -692,10 +853,10 @@ func (a *Applier) initiateStreaming() error {
 		replySpan := tracer.StartSpan("Service Responder", ext.SpanKindRPCServer, ext.RPCServerOption(sc))
 		ext.MessageBusDestination.Set(replySpan, m.Subject)
 		defer replySpan.Finish()
-		dumpData, err := DecodeDumpEntry(t.Bytes())
+		dumpData, err := a.wireDecoder.DumpEntry(m.Subject, t.Bytes())
 		if err != nil {
 			a.onError(TaskStateDead, err)
-			// TODO return?
+			return
 		}
 
 		timer := time.NewTimer(DefaultConnectWait / 2)
@@ -705,7 +866,7 @@ func (a *Applier) initiateStreaming() error {
 			a.logger.Debugf("mysql.applier: full. enqueue")
 			timer.Stop()
 			a.mysqlContext.Stage = models.StageSlaveWaitingForWorkersToProcessQueue
-			if err := a.natsConn.Publish(m.Reply, nil); err != nil {
+			if err := m.Ack(); err != nil {
 				a.onError(TaskStateDead, err)
 			}
 			a.logger.Debugf("mysql.applier. full. after publish nats reply")
@@ -721,9 +882,8 @@ func (a *Applier) initiateStreaming() error {
 		return err
 	}*/
 
-	_, err = a.natsConn.Subscribe(fmt.Sprintf("%s_full_complete", a.subject), func(m *gonats.Msg) {
-		dumpData := &DumpStatResult{}
-		t := not.NewTraceMsg(m)
+	err = a.transport.Subscribe(fmt.Sprintf("%s_full_complete", a.subject), func(m *common.Msg) {
+		t := not.NewTraceMsg(&gonats.Msg{Subject: m.Subject, Data: m.Data})
 		// Extract the span context from the request message.
 		sc, err := tracer.Extract(opentracing.Binary, t)
 		if err != nil {
@@ -733,8 +893,10 @@ func (a *Applier) initiateStreaming() error {
 		replySpan := tracer.StartSpan("Service Responder", ext.SpanKindRPCServer, ext.RPCServerOption(sc))
 		ext.MessageBusDestination.Set(replySpan, m.Subject)
 		defer replySpan.Finish()
-		if err := Decode(t.Bytes(), dumpData); err != nil {
+		dumpData, err := a.wireDecoder.DumpStatResult(m.Subject, t.Bytes())
+		if err != nil {
 			a.onError(TaskStateDead, err)
+			return
 		}
 		a.currentCoordinates.RetrievedGtidSet = dumpData.Gtid
 		a.currentCoordinates.File = dumpData.LogFile
@@ -751,7 +913,7 @@ func (a *Applier) initiateStreaming() error {
 		}
 
 		a.logger.Debugf("mysql.applier. ack full_complete")
-		if err := a.natsConn.Publish(m.Reply, nil); err != nil {
+		if err := m.Ack(); err != nil {
 			a.onError(TaskStateDead, err)
 		}
 		atomic.AddInt64(&a.mysqlContext.TotalRowsCopied, dumpData.TotalCount)
@@ -761,11 +923,13 @@ func (a *Applier) initiateStreaming() error {
 		return err
 	}
 	var bigEntries binlog.BinlogEntries
+	// estimated bytes of the events of bigEntries in memory
+	bigEntriesSize := 0
 
 	{
-		_, err := a.natsConn.Subscribe(fmt.Sprintf("%s_incr_hete", a.subject), func(m *gonats.Msg) {
+		err := a.transport.Subscribe(fmt.Sprintf("%s_incr_hete", a.subject), func(m *common.Msg) {
 			var binlogEntries binlog.BinlogEntries
-			t := not.NewTraceMsg(m)
+			t := not.NewTraceMsg(&gonats.Msg{Subject: m.Subject, Data: m.Data})
 			// Extract the span context from the request message.
 			spanContext, err := tracer.Extract(opentracing.Binary, t)
 			if err != nil {
@@ -775,21 +939,45 @@ func (a *Applier) initiateStreaming() error {
 			replySpan := tracer.StartSpan("nast : dest to get data  ", ext.SpanKindRPCServer, ext.RPCServerOption(spanContext))
 			ext.MessageBusDestination.Set(replySpan, m.Subject)
 			defer replySpan.Finish()
-			if err := Decode(t.Bytes(), &binlogEntries); err != nil {
+			entries, err := a.wireDecoder.BinlogEntries(m.Subject, t.Bytes())
+			if err != nil {
 				a.onError(TaskStateDead, err)
+				return
 			}
+			binlogEntries = *entries
 
 			nEntries := len(binlogEntries.Entries)
+			// With the flow control, the src sends no more than the queue can hold.
+			flowControl := a.wireDecoder.Handshake.Version() >= common.WireVersionFlowControl
 			handled := false
 			if binlogEntries.BigTx{
 				if binlogEntries.TxNum==1{
+					if bigEntries.Entries != nil {
+						// an incomplete tx, from the src before restarted
+						bigEntries.Entries[0].DiscardSpill()
+					}
 					bigEntries = binlogEntries
+					bigEntriesSize = 0
 				}else if bigEntries.Entries!=nil{
 					bigEntries.Entries[0].Events=append(bigEntries.Entries[0].Events,  binlogEntries.Entries[0].Events... )
 					bigEntries.TxNum = binlogEntries.TxNum
 					a.logger.Debugf("applier:tx get the :%v package  ", binlogEntries.TxNum)
 					binlogEntries.Entries=nil
 				}
+				if bigEntries.Entries != nil && bigEntries.TxNum < bigEntries.TxLen {
+					// The parts are of about the same size.
+					bigEntriesSize += bigEntries.Entries[0].OriginalSize / bigEntries.TxLen
+					if int64(bigEntriesSize) >= a.mysqlContext.TxMemoryLimit {
+						a.logger.Debugf("applier. incr. spill a big tx. gno: %v, part: %v/%v",
+							bigEntries.Entries[0].Coordinates.GNO, bigEntries.TxNum, bigEntries.TxLen)
+						// A chunk (about TxMemoryLimit) is read back at a time when applied.
+						if err := bigEntries.Entries[0].SpillEvents(a.spillDir, 1); err != nil {
+							a.onError(TaskStateDead, err)
+							return
+						}
+						bigEntriesSize = 0
+					}
+				}
 				if bigEntries.TxNum==bigEntries.TxLen{
 					binlogEntries = bigEntries
 					bigEntries.Entries = nil
@@ -798,7 +986,7 @@ func (a *Applier) initiateStreaming() error {
 			for i := 0; !handled && (i < DefaultConnectWaitSecond/2); i++ {
 				if binlogEntries.BigTx&&binlogEntries.TxNum<binlogEntries.TxLen{
 					handled = true
-					if err := a.natsConn.Publish(m.Reply, nil); err != nil {
+					if err := m.Ack(); err != nil {
 						a.onError(TaskStateDead, err)
 					}
 					continue
@@ -808,19 +996,20 @@ func (a *Applier) initiateStreaming() error {
 				binlogEntries.TxLen = 0
 				vacancy := cap(a.applyDataEntryQueue) - len(a.applyDataEntryQueue)
 				a.logger.Debugf("applier. incr. nEntries: %v, vacancy: %v", nEntries, vacancy)
-				if vacancy < nEntries {
+				if vacancy < nEntries && !flowControl {
 					a.logger.Debugf("applier. incr. wait 1s for applyDataEntryQueue")
 					time.Sleep(1 * time.Second) // It will wait an second at the end, but seems no hurt.
 				} else {
 					a.logger.Debugf("applier. incr. applyDataEntryQueue enqueue")
 					for _, binlogEntry := range binlogEntries.Entries {
 						binlogEntry.SpanContext = replySpan.Context()
+						atomic.AddInt64(&a.queuedBytes, int64(binlogEntry.OriginalSize))
 						a.applyDataEntryQueue <- binlogEntry
 						a.currentCoordinates.RetrievedGtidSet = binlogEntry.Coordinates.GetGtidForThisTx()
 						atomic.AddInt64(&a.mysqlContext.DeltaEstimate, 1)
 					}
 					a.mysqlContext.Stage = models.StageWaitingForMasterToSendEvent
-					if err := a.natsConn.Publish(m.Reply, nil); err != nil {
+					if err := m.Ack(); err != nil {
 						a.onError(TaskStateDead, err)
 					}
 					a.logger.Debugf("applier. incr. ack-recv. nEntries: %v", nEntries)
@@ -848,11 +1037,11 @@ func (a *Applier) publishProgress() {
 	keep := true
 	for keep {
 		a.logger.Debugf("*** applier.publishProgress. retry %v, file %v", retry, a.mysqlContext.BinlogFile)
-		_, err := a.natsConn.Request(fmt.Sprintf("%s_progress", a.subject), []byte(a.mysqlContext.BinlogFile), 10*time.Second)
+		err := a.transport.Request(fmt.Sprintf("%s_progress", a.subject), []byte(a.mysqlContext.BinlogFile), 10*time.Second)
 		if err == nil {
 			keep = false
 		} else {
-			if err == gonats.ErrTimeout {
+			if err == common.ErrTransportTimeout {
 				a.logger.WithField("retry", retry).WithField("file", a.mysqlContext.BinlogFile).Debugf(
 					"applier.publishProgress. timeout")
 				break
@@ -921,6 +1110,11 @@ func (a *Applier) initDBConnections() (err error) {
 		}
 		a.logger.Debugf("mysql.applier. after prepare stmt for gtid_executed table")
 	}
+	if a.mysqlContext.RowsQueryAudit {
+		if err := a.initRowsQueryAudit(); err != nil {
+			return err
+		}
+	}
 	a.logger.Printf("mysql.applier: Initiated on %s:%d, version %+v", a.mysqlContext.ConnectionConfig.Host, a.mysqlContext.ConnectionConfig.Port, a.mysqlContext.MySQLVersion)
 	return nil
 }
@@ -1102,6 +1296,66 @@ func (a *Applier) createTableGtidExecutedV3() error {
 	return nil
 }
 
+// initRowsQueryAudit creates the rows_query_audit table and prepares the insert on each connection.
+func (a *Applier) initRowsQueryAudit() (err error) {
+	if err := a.createTableRowsQueryAudit(); err != nil {
+		return err
+	}
+	for i := range a.dbs {
+		a.dbs[i].PsInsertRowsQuery, err = a.dbs[i].Db.PrepareContext(context.Background(), fmt.Sprintf("insert into %v.%v "+
+			"(job_uuid,source_uuid,gno,query) "+
+			"values (unhex('%s'), ?, ?, ?)",
+			g.DtleSchemaName, g.RowsQueryAuditTable,
+			hex.EncodeToString(a.subjectUUID.Bytes())))
+		if err != nil {
+			return err
+		}
+	}
+	a.logger.Debugf("mysql.applier. after prepare stmt for rows_query_audit table")
+	return nil
+}
+
+// auditRowsQueries records the statements of the tx into the rows_query_audit table, in the tx.
+func auditRowsQueries(dbApplier *sql.Conn, binlogEntry *binlog.BinlogEntry) error {
+	if dbApplier.PsInsertRowsQuery == nil {
+		return nil
+	}
+	for _, rowsQuery := range binlogEntry.RowsQueries {
+		_, err := dbApplier.PsInsertRowsQuery.Exec(binlogEntry.Coordinates.SID.Bytes(), binlogEntry.Coordinates.GNO, rowsQuery)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *Applier) createTableRowsQueryAudit() error {
+	query := fmt.Sprintf(`
+			CREATE DATABASE IF NOT EXISTS %v;
+		`, g.DtleSchemaName)
+	if _, err := a.db.Exec(query); err != nil {
+		return err
+	}
+
+	query = fmt.Sprintf(`
+			CREATE TABLE IF NOT EXISTS %v.%v (
+				id bigint unsigned NOT NULL AUTO_INCREMENT,
+				job_uuid binary(16) NOT NULL COMMENT 'unique identifier of job',
+				source_uuid binary(16) NOT NULL COMMENT 'uuid of the source where the transaction was executed.',
+				gno bigint NOT NULL COMMENT 'gno of the transaction.',
+				query longtext NOT NULL COMMENT 'the statement on the source which produced the rows.',
+				apply_time timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+				PRIMARY KEY (id)
+			);
+		`, g.DtleSchemaName, g.RowsQueryAuditTable)
+	if _, err := a.db.Exec(query); err != nil {
+		return err
+	}
+	a.logger.Debugf("mysql.applier. after create rows_query_audit table")
+
+	return nil
+}
+
 func (a *Applier) getTableItem(schema string, table string) *applierTableItem {
 	schemaItem, ok := a.tableItems[schema]
 	if !ok {
@@ -1141,10 +1395,15 @@ func (a *Applier) buildDMLEventQuery(dmlEvent binlog.DataEvent, workerIdx int, s
 	switch dmlEvent.DML {
 	case binlog.DeleteDML:
 		{
-			query, uniqueKeyArgs, hasUK, err := sql.BuildDMLDeleteQuery(dmlEvent.DatabaseName, dmlEvent.TableName, tableColumns, dmlEvent.WhereColumnValues.GetAbstractValues())
+			whereColumns, whereValues := presentColumns(tableColumns, dmlEvent.WhereColumnValues)
+			query, uniqueKeyArgs, hasUK, err := sql.BuildDMLDeleteQuery(dmlEvent.DatabaseName, dmlEvent.TableName, whereColumns, whereValues)
 			if err != nil {
 				return nil, "", nil, -1, err
 			}
+			if dmlEvent.WhereColumnValues.Present != nil {
+				// The query differs with the columns in the row image. Do not prepare it.
+				return nil, query, uniqueKeyArgs, -1, nil
+			}
 			if hasUK {
 				stmt, err := doPrepareIfNil(tableItem.psDelete, query)
 				if err != nil {
@@ -1158,6 +1417,15 @@ func (a *Applier) buildDMLEventQuery(dmlEvent binlog.DataEvent, workerIdx int, s
 	case binlog.InsertDML:
 		{
 			// TODO no need to generate query string every time
+			if dmlEvent.NewColumnValues.Present != nil {
+				// Absent columns take their default values.
+				newColumns, _ := presentColumns(tableColumns, dmlEvent.NewColumnValues)
+				query, sharedArgs, err := sql.BuildDMLInsertQuery(dmlEvent.DatabaseName, dmlEvent.TableName, tableColumns, newColumns, newColumns, dmlEvent.NewColumnValues.GetAbstractValues())
+				if err != nil {
+					return nil, "", nil, -1, err
+				}
+				return nil, query, sharedArgs, 1, nil
+			}
 			query, sharedArgs, err := sql.BuildDMLInsertQuery(dmlEvent.DatabaseName, dmlEvent.TableName, tableColumns, tableColumns, tableColumns, dmlEvent.NewColumnValues.GetAbstractValues())
 			if err != nil {
 				return nil, "", nil, -1, err
@@ -1170,13 +1438,21 @@ func (a *Applier) buildDMLEventQuery(dmlEvent binlog.DataEvent, workerIdx int, s
 		}
 	case binlog.UpdateDML:
 		{
-			query, sharedArgs, uniqueKeyArgs, hasUK, err := sql.BuildDMLUpdateQuery(dmlEvent.DatabaseName, dmlEvent.TableName, tableColumns, tableColumns, tableColumns, tableColumns, dmlEvent.NewColumnValues.GetAbstractValues(), dmlEvent.WhereColumnValues.GetAbstractValues())
+			// With binlog_row_image=MINIMAL, only the changed columns are set,
+			// and the row is identified by the columns in the before image (the primary key).
+			newColumns, _ := presentColumns(tableColumns, dmlEvent.NewColumnValues)
+			whereColumns, _ := presentColumns(tableColumns, dmlEvent.WhereColumnValues)
+			query, sharedArgs, uniqueKeyArgs, hasUK, err := sql.BuildDMLUpdateQuery(dmlEvent.DatabaseName, dmlEvent.TableName, tableColumns, newColumns, newColumns, whereColumns, dmlEvent.NewColumnValues.GetAbstractValues(), dmlEvent.WhereColumnValues.GetAbstractValues())
 			if err != nil {
 				return nil, "", nil, -1, err
 			}
 			args = append(args, sharedArgs...)
 			args = append(args, uniqueKeyArgs...)
 
+			if dmlEvent.NewColumnValues.Present != nil || dmlEvent.WhereColumnValues.Present != nil ||
+				hasJsonDiffs(dmlEvent.NewColumnValues) {
+				return nil, query, args, 0, err
+			}
 			if hasUK {
 				stmt, err := doPrepareIfNil(tableItem.psUpdate, query)
 				if err != nil {
@@ -1192,9 +1468,38 @@ func (a *Applier) buildDMLEventQuery(dmlEvent binlog.DataEvent, workerIdx int, s
 	return nil, "", args, 0, fmt.Errorf("Unknown dml event type: %+v", dmlEvent.DML)
 }
 
+// hasJsonDiffs tells whether there is a partial JSON update not resolved by the source.
+func hasJsonDiffs(values *umconf.ColumnValues) bool {
+	for _, value := range values.AbstractValues {
+		if _, ok := (*value).(replication.JsonDiffs); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// presentColumns returns the columns in the row image and their values.
+// It returns all columns and values if the row image is full.
+func presentColumns(columns *umconf.ColumnList, values *umconf.ColumnValues) (*umconf.ColumnList, []*interface{}) {
+	if values.Present == nil {
+		return columns, values.GetAbstractValues()
+	}
+	var presentCols []umconf.Column
+	var presentValues []*interface{}
+	for i, column := range columns.ColumnList() {
+		if i < len(values.Present) && !values.Present[i] {
+			continue
+		}
+		presentCols = append(presentCols, column)
+		presentValues = append(presentValues, values.AbstractValues[i])
+	}
+	return umconf.NewColumnList(presentCols), presentValues
+}
+
 // ApplyEventQueries applies multiple DML queries onto the dest table
 func (a *Applier) ApplyBinlogEvent(ctx context.Context, workerIdx int, binlogEntry *binlog.BinlogEntry) error {
 	dbApplier := a.dbs[workerIdx]
+	defer binlogEntry.DiscardSpill()
 
 	var totalDelta int64
 	var err error
@@ -1226,6 +1531,9 @@ func (a *Applier) ApplyBinlogEvent(ctx context.Context, workerIdx int, binlogEnt
 			a.onError(TaskStateDead, err)
 		} else {
 			a.mtsManager.Executed(binlogEntry)
+			if binlogEntry.HeartbeatTs != 0 {
+				a.heartbeat.OnApplied(binlogEntry.HeartbeatTs)
+			}
 		}
 		if a.printTps {
 			atomic.AddUint32(&a.txLastNSeconds, 1)
@@ -1235,18 +1543,66 @@ func (a *Applier) ApplyBinlogEvent(ctx context.Context, workerIdx int, binlogEnt
 		dbApplier.DbMutex.Unlock()
 	}()
 	span.SetTag("begin transform binlogEvent to sql time  ", time.Now().UnixNano()/1e6)
-	for i, event := range binlogEntry.Events {
-		a.logger.Debugf("mysql.applier: ApplyBinlogEvent. gno: %v, event: %v",
-			binlogEntry.Coordinates.GNO, i)
-		switch event.DML {
-		case binlog.NotDML:
-			var err error
-			a.logger.Debugf("mysql.applier: ApplyBinlogEvent: not dml: %v", event.Query)
+	// The events of a spilled tx are read back chunk by chunk, all in the tx.
+	err = binlogEntry.ForEachEvents(func(events []binlog.DataEvent) error {
+		if binlogEntry.Spill() != nil {
+			if err := a.setTableItemForBinlogEntry(&binlog.BinlogEntry{Events: events}); err != nil {
+				return err
+			}
+		}
+		for i, event := range events {
+			a.logger.Debugf("mysql.applier: ApplyBinlogEvent. gno: %v, event: %v",
+				binlogEntry.Coordinates.GNO, i)
+			switch event.DML {
+			case binlog.NotDML:
+				var err error
+				a.logger.Debugf("mysql.applier: ApplyBinlogEvent: not dml: %v", event.Query)
+
+				if event.CurrentSchema != "" {
+					query := fmt.Sprintf("USE %s", umconf.EscapeName(event.CurrentSchema))
+					a.logger.Debugf("mysql.applier: query: %v", query)
+					_, err = tx.Exec(query)
+					if err != nil {
+						if !sql.IgnoreError(err) {
+							a.logger.Errorf("mysql.applier: Exec sql error: %v", err)
+							return err
+						} else {
+							a.logger.Warnf("mysql.applier: Ignore error: %v", err)
+						}
+					}
+				}
+
+				if event.TableName != "" {
+					var schema string
+					if event.DatabaseName != "" {
+						schema = event.DatabaseName
+					} else {
+						schema = event.CurrentSchema
+					}
+					a.logger.Debugf("mysql.applier: reset tableItem %v.%v", schema, event.TableName)
+					a.getTableItem(schema, event.TableName).Reset()
+				} else { // TableName == ""
+					if event.DatabaseName != "" {
+						if schemaItem, ok := a.tableItems[event.DatabaseName]; ok {
+							for tableName, v := range schemaItem {
+								a.logger.Debugf("mysql.applier: reset tableItem %v.%v", event.DatabaseName, tableName)
+								v.Reset()
+							}
+						}
+						delete(a.tableItems, event.DatabaseName)
+					} else if strings.HasPrefix(event.Query, "rename table ") {
+						// renaming tables of several schemas
+						a.logger.Debugf("mysql.applier: reset all tableItems")
+						for _, schemaItem := range a.tableItems {
+							for _, v := range schemaItem {
+								v.Reset()
+							}
+						}
+						a.tableItems = make(mapSchemaTableItems)
+					}
+				}
 
-			if event.CurrentSchema != "" {
-				query := fmt.Sprintf("USE %s", umconf.EscapeName(event.CurrentSchema))
-				a.logger.Debugf("mysql.applier: query: %v", query)
-				_, err = tx.Exec(query)
+				_, err = tx.Exec(event.Query)
 				if err != nil {
 					if !sql.IgnoreError(err) {
 						a.logger.Errorf("mysql.applier: Exec sql error: %v", err)
@@ -1255,70 +1611,46 @@ func (a *Applier) ApplyBinlogEvent(ctx context.Context, workerIdx int, binlogEnt
 						a.logger.Warnf("mysql.applier: Ignore error: %v", err)
 					}
 				}
-			}
+				a.logger.Debugf("mysql.applier: Exec [%s]", event.Query)
+			default:
+				a.logger.Debugf("mysql.applier: ApplyBinlogEvent: a dml event")
+				stmt, query, args, rowDelta, err := a.buildDMLEventQuery(event, workerIdx, spanContext)
+				if err != nil {
+					a.logger.Errorf("mysql.applier: Build dml query error: %v", err)
+					return err
+				}
+
+				a.logger.Debugf("ApplyBinlogEvent. args: %v", args)
 
-			if event.TableName != "" {
-				var schema string
-				if event.DatabaseName != "" {
-					schema = event.DatabaseName
+				var r gosql.Result
+				if stmt != nil {
+					r, err = stmt.Exec(args...)
 				} else {
-					schema = event.CurrentSchema
+					r, err = a.dbs[workerIdx].Db.ExecContext(context.Background(), query, args...)
 				}
-				a.logger.Debugf("mysql.applier: reset tableItem %v.%v", schema, event.TableName)
-				a.getTableItem(schema, event.TableName).Reset()
-			} else { // TableName == ""
-				if event.DatabaseName != "" {
-					if schemaItem, ok := a.tableItems[event.DatabaseName]; ok {
-						for tableName, v := range schemaItem {
-							a.logger.Debugf("mysql.applier: reset tableItem %v.%v", event.DatabaseName, tableName)
-							v.Reset()
-						}
-					}
-					delete(a.tableItems, event.DatabaseName)
-				}
-			}
 
-			_, err = tx.Exec(event.Query)
-			if err != nil {
-				if !sql.IgnoreError(err) {
-					a.logger.Errorf("mysql.applier: Exec sql error: %v", err)
+				if err != nil {
+					a.logger.Errorf("mysql.applier: gtid: %s:%d, error: %v", txSid, binlogEntry.Coordinates.GNO, err)
 					return err
+				}
+				nr, err := r.RowsAffected()
+				if err != nil {
+					a.logger.Debugf("ApplyBinlogEvent executed gno %v event %v rows_affected_err %v schema", binlogEntry.Coordinates.GNO, i, err)
 				} else {
-					a.logger.Warnf("mysql.applier: Ignore error: %v", err)
+					a.logger.Debugf("ApplyBinlogEvent executed gno %v event %v rows_affected %v", binlogEntry.Coordinates.GNO, i, nr)
 				}
+				totalDelta += rowDelta
 			}
-			a.logger.Debugf("mysql.applier: Exec [%s]", event.Query)
-		default:
-			a.logger.Debugf("mysql.applier: ApplyBinlogEvent: a dml event")
-			stmt, query, args, rowDelta, err := a.buildDMLEventQuery(event, workerIdx, spanContext)
-			if err != nil {
-				a.logger.Errorf("mysql.applier: Build dml query error: %v", err)
-				return err
-			}
-
-			a.logger.Debugf("ApplyBinlogEvent. args: %v", args)
-
-			var r gosql.Result
-			if stmt != nil {
-				r, err = stmt.Exec(args...)
-			} else {
-				r, err = a.dbs[workerIdx].Db.ExecContext(context.Background(), query, args...)
-			}
-
-			if err != nil {
-				a.logger.Errorf("mysql.applier: gtid: %s:%d, error: %v", txSid, binlogEntry.Coordinates.GNO, err)
-				return err
-			}
-			nr, err := r.RowsAffected()
-			if err != nil {
-				a.logger.Debugf("ApplyBinlogEvent executed gno %v event %v rows_affected_err %v schema", binlogEntry.Coordinates.GNO, i, err)
-			} else {
-				a.logger.Debugf("ApplyBinlogEvent executed gno %v event %v rows_affected %v", binlogEntry.Coordinates.GNO, i, nr)
-			}
-			totalDelta += rowDelta
 		}
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 	span.SetTag("after  transform  binlogEvent to sql  ", time.Now().UnixNano()/1e6)
+	if err := auditRowsQueries(dbApplier, binlogEntry); err != nil {
+		return err
+	}
 	a.logger.Debugf("ApplyBinlogEvent. insert gno: %v", binlogEntry.Coordinates.GNO)
 	_, err = dbApplier.PsInsertExecutedGtid.Exec(binlogEntry.Coordinates.SID.Bytes(), binlogEntry.Coordinates.GNO)
 	if err != nil {
@@ -1331,7 +1663,7 @@ func (a *Applier) ApplyBinlogEvent(ctx context.Context, workerIdx int, binlogEnt
 	return nil
 }
 
-func (a *Applier) ApplyEventQueries(db *gosql.DB, entry *DumpEntry) error {
+func (a *Applier) ApplyEventQueries(db *gosql.DB, entry *DumpEntry) (err error) {
 	if a.stubFullApplyDelay != 0 {
 		a.logger.Debugf("mysql.applier: stubFullApplyDelay start sleep")
 		time.Sleep(a.stubFullApplyDelay)
@@ -1359,6 +1691,28 @@ func (a *Applier) ApplyEventQueries(db *gosql.DB, entry *DumpEntry) error {
 		}
 	}
 
+	// Rows of a table without unique key are inserted into a staging table, which replaces
+	// the content of the real table at the end. This keeps the table idempotent when dumped again.
+	targetSchema, targetTable := entry.TableSchema, entry.TableName
+	if entry.Staged {
+		targetSchema, targetTable = g.DtleSchemaName, stagingTableName(entry.TableSchema, entry.TableName)
+		if entry.StageBegin {
+			a.logger.Infof("mysql.applier: begin staging table %v.%v for %v.%v",
+				targetSchema, targetTable, entry.TableSchema, entry.TableName)
+			stageQueries := []string{
+				fmt.Sprintf("drop table if exists %s.%s", umconf.EscapeName(targetSchema), umconf.EscapeName(targetTable)),
+				fmt.Sprintf("create table %s.%s like %s.%s", umconf.EscapeName(targetSchema), umconf.EscapeName(targetTable),
+					umconf.EscapeName(entry.TableSchema), umconf.EscapeName(entry.TableName)),
+			}
+			for _, query := range stageQueries {
+				if _, err := db.Exec(query); err != nil {
+					a.logger.Errorf("mysql.applier: Exec [%s] error: %v", query, err)
+					return err
+				}
+			}
+		}
+	}
+
 	queries := []string{}
 	queries = append(queries, entry.SystemVariablesStatement, entry.SqlMode, entry.DbSQL)
 	queries = append(queries, entry.TbSQL...)
@@ -1367,10 +1721,20 @@ func (a *Applier) ApplyEventQueries(db *gosql.DB, entry *DumpEntry) error {
 		return err
 	}
 	defer func() {
-		if err := tx.Commit(); err != nil {
-			a.onError(TaskStateDead, err)
+		if err != nil {
+			// Keep the real table (and the staging table) as they were, for the table to be dumped again.
+			if rbErr := tx.Rollback(); rbErr != nil {
+				a.logger.Warnf("mysql.applier: rollback error: %v", rbErr)
+			}
+			return
+		}
+		if err = tx.Commit(); err != nil {
+			return
 		}
 		atomic.AddInt64(&a.mysqlContext.TotalRowsReplay, entry.RowsCount)
+		if entry.StageEnd {
+			err = a.replaceWithStagingTable(db, entry.TableSchema, entry.TableName)
+		}
 	}()
 	sessionQuery := `SET @@session.foreign_key_checks = 0`
 	if _, err := tx.Exec(sessionQuery); err != nil {
@@ -1408,7 +1772,7 @@ func (a *Applier) ApplyEventQueries(db *gosql.DB, entry *DumpEntry) error {
 	for i, _ := range entry.ValuesX {
 		if buf.Len() == 0 {
 			buf.WriteString(fmt.Sprintf(`replace into %s.%s values (`,
-				umconf.EscapeName(entry.TableSchema), umconf.EscapeName(entry.TableName)))
+				umconf.EscapeName(targetSchema), umconf.EscapeName(targetTable)))
 		} else {
 			buf.WriteString(",(")
 		}
@@ -1447,6 +1811,48 @@ func (a *Applier) ApplyEventQueries(db *gosql.DB, entry *DumpEntry) error {
 	return nil
 }
 
+// replaceWithStagingTable replaces the real table with its staging table, after all rows are inserted into the latter.
+// The two tables are swapped by one RENAME TABLE, which is atomic, then the replaced table is dropped.
+// The replaced table is kept in the schema of the real table, as a table with triggers cannot be moved to another schema.
+func (a *Applier) replaceWithStagingTable(db *gosql.DB, schema string, table string) error {
+	realTable := fmt.Sprintf("%s.%s", umconf.EscapeName(schema), umconf.EscapeName(table))
+	stagingTable := fmt.Sprintf("%s.%s", umconf.EscapeName(g.DtleSchemaName), umconf.EscapeName(stagingTableName(schema, table)))
+	replacedTable := fmt.Sprintf("%s.%s", umconf.EscapeName(schema), umconf.EscapeName(replacedTableName(table)))
+	a.logger.Infof("mysql.applier: replace %v with staging table %v", realTable, stagingTable)
+
+	queries := []string{
+		// left by an interrupted replacement
+		fmt.Sprintf("drop table if exists %s", replacedTable),
+		fmt.Sprintf("rename table %s to %s, %s to %s", realTable, replacedTable, stagingTable, realTable),
+		fmt.Sprintf("drop table if exists %s", replacedTable),
+	}
+	for _, query := range queries {
+		if _, err := db.Exec(query); err != nil {
+			a.logger.Errorf("mysql.applier: Exec [%s] error: %v", query, err)
+			return err
+		}
+	}
+	return nil
+}
+
+// stagingTableName returns the name of the staging table (in the dtle schema) for a table without unique key.
+func stagingTableName(schema string, table string) string {
+	name := fmt.Sprintf("stage_%s_%s", schema, table)
+	if len(name) > 64 { // max length of a mysql identifier
+		name = fmt.Sprintf("stage_%x", md5.Sum([]byte(fmt.Sprintf("%s.%s", schema, table))))
+	}
+	return name
+}
+
+// replacedTableName returns the name of the table replaced by the staging table, in the same schema.
+func replacedTableName(table string) string {
+	name := fmt.Sprintf("_%s_dtle_del", table)
+	if len(name) > 64 { // max length of a mysql identifier
+		name = fmt.Sprintf("_%x_dtle_del", md5.Sum([]byte(table)))
+	}
+	return name
+}
+
 func (a *Applier) Stats() (*models.TaskStatistics, error) {
 	totalRowsReplay := a.mysqlContext.GetTotalRowsReplay()
 	rowsEstimate := atomic.LoadInt64(&a.mysqlContext.RowsEstimate)
@@ -1496,17 +1902,20 @@ func (a *Applier) Stats() (*models.TaskStatistics, error) {
 		ProgressPct:        strconv.FormatFloat(progressPct, 'f', 1, 64),
 		ETA:                eta,
 		Backlog:            backlog,
-		Stage:              a.mysqlContext.Stage,
+		Stage:              throttledStage(a.mysqlContext.Stage, a.throttler),
 		CurrentCoordinates: a.currentCoordinates,
 		BufferStat: models.BufferStat{
-			ApplierTxQueueSize:      0,
+			ApplierTxQueueSize:      len(a.applyDataEntryQueue),
 			ApplierGroupTxQueueSize: 0,
+			ApplierTxQueueBytes:     atomic.LoadInt64(&a.queuedBytes),
 		},
-		Timestamp: time.Now().UTC().UnixNano(),
+		CompressionStat: a.codec.Stat(),
+		Timestamp:       time.Now().UTC().UnixNano(),
 	}
-	if a.natsConn != nil {
-		taskResUsage.MsgStat = a.natsConn.Statistics
+	if a.transport != nil {
+		taskResUsage.MsgStat = a.transport.Statistics()
 	}
+	taskResUsage.HeartbeatLag = a.heartbeat.Stat()
 
 	return &taskResUsage, nil
 }
@@ -1545,14 +1954,14 @@ func (a *Applier) onError(state int, err error) {
 	case TaskStateComplete:
 		a.logger.Printf("mysql.applier: Done migrating")
 	case TaskStateRestart:
-		if a.natsConn != nil {
-			if err := a.natsConn.Publish(fmt.Sprintf("%s_restart", a.subject), []byte(a.mysqlContext.Gtid)); err != nil {
+		if a.transport != nil {
+			if err := a.transport.Publish(fmt.Sprintf("%s_restart", a.subject), []byte(a.mysqlContext.Gtid)); err != nil {
 				a.logger.Errorf("mysql.applier: Trigger restart extractor : %v", err)
 			}
 		}
 	default:
-		if a.natsConn != nil {
-			if err := a.natsConn.Publish(fmt.Sprintf("%s_error", a.subject), []byte(a.mysqlContext.Gtid)); err != nil {
+		if a.transport != nil {
+			if err := a.transport.Publish(fmt.Sprintf("%s_error", a.subject), []byte(a.mysqlContext.Gtid)); err != nil {
 				a.logger.Errorf("mysql.applier: Trigger extractor shutdown: %v", err)
 			}
 		}
@@ -1573,8 +1982,8 @@ func (a *Applier) Shutdown() error {
 		return nil
 	}
 
-	if a.natsConn != nil {
-		a.natsConn.Close()
+	if a.transport != nil {
+		a.transport.Close()
 	}
 
 	a.shutdown = true
@@ -0,0 +1,260 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package mysql
+
+import (
+	"context"
+	gosql "database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/satori/go.uuid"
+	"github.com/sirupsen/logrus"
+
+	"github.com/actiontech/dtle/internal/client/driver/mysql/base"
+	"github.com/actiontech/dtle/internal/client/driver/mysql/binlog"
+	"github.com/actiontech/dtle/internal/client/driver/mysql/sql"
+	"github.com/actiontech/dtle/internal/config"
+	umconf "github.com/actiontech/dtle/internal/config/mysql"
+	"github.com/actiontech/dtle/internal/g"
+)
+
+func TestStagingTableNames(t *testing.T) {
+	long := strings.Repeat("t", 64)
+	tests := []struct {
+		schema       string
+		table        string
+		wantStaging  string
+		wantReplaced string
+	}{
+		{"db1", "tb1", "stage_db1_tb1", "_tb1_dtle_del"},
+		{"db1", long, "stage_c80c2046b532ee33205db03bf960ab4a", "_2560d0f5dc4fefaf2992aa052d29404c_dtle_del"},
+	}
+	for _, tt := range tests {
+		staging := stagingTableName(tt.schema, tt.table)
+		replaced := replacedTableName(tt.table)
+		if len(staging) > 64 || len(replaced) > 64 {
+			t.Fatalf("names of %v.%v are too long: %v, %v", tt.schema, tt.table, staging, replaced)
+		}
+		if staging != tt.wantStaging {
+			t.Fatalf("stagingTableName(%v, %v) = %v, want %v", tt.schema, tt.table, staging, tt.wantStaging)
+		}
+		if replaced != tt.wantReplaced {
+			t.Fatalf("replacedTableName(%v) = %v, want %v", tt.table, replaced, tt.wantReplaced)
+		}
+	}
+}
+
+func TestPresentColumns(t *testing.T) {
+	columns := umconf.NewColumnList(umconf.NewColumns([]string{"id", "a", "b"}))
+	for _, c := range []struct {
+		present     []bool
+		wantColumns []string
+		wantValues  []interface{}
+	}{
+		// full row image
+		{nil, []string{"id", "a", "b"}, []interface{}{1, "x", nil}},
+		{[]bool{true, false, true}, []string{"id", "b"}, []interface{}{1, nil}},
+		{[]bool{false, true, false}, []string{"a"}, []interface{}{"x"}},
+		// fewer bits than columns: the rest are taken as present
+		{[]bool{false}, []string{"a", "b"}, []interface{}{"x", nil}},
+	} {
+		vals := []interface{}{1, "x", nil}
+		values := &umconf.ColumnValues{AbstractValues: []*interface{}{&vals[0], &vals[1], &vals[2]}, Present: c.present}
+		gotColumns, gotValues := presentColumns(columns, values)
+		if got := gotColumns.Names(); !reflect.DeepEqual(got, c.wantColumns) {
+			t.Fatalf("%v: got columns %v, want %v", c.present, got, c.wantColumns)
+		}
+		// the ordinals are of the present columns
+		for i, name := range c.wantColumns {
+			if gotColumns.Ordinals[name] != i {
+				t.Fatalf("%v: got ordinal %v of %v, want %v", c.present, gotColumns.Ordinals[name], name, i)
+			}
+		}
+		var got []interface{}
+		for _, v := range gotValues {
+			got = append(got, *v)
+		}
+		if !reflect.DeepEqual(got, c.wantValues) {
+			t.Fatalf("%v: got values %v, want %v", c.present, got, c.wantValues)
+		}
+	}
+}
+
+// recordedExec is a statement executed on recorderDriver.
+type recordedExec struct {
+	query string
+	args  []driver.Value
+}
+
+// recorderDriver is a database/sql driver which records the executed statements,
+// and answers the queries by queryRows.
+type recorderDriver struct {
+	lock  sync.Mutex
+	execs []recordedExec
+	// returns the columns and the rows of a query. nil if no query is expected.
+	queryRows func(query string) ([]string, [][]driver.Value, error)
+}
+
+func newRecorderDB(d *recorderDriver) *gosql.DB {
+	return gosql.OpenDB(d)
+}
+
+func (d *recorderDriver) Open(name string) (driver.Conn, error) {
+	return &recorderConn{d}, nil
+}
+
+func (d *recorderDriver) Connect(ctx context.Context) (driver.Conn, error) {
+	return d.Open("")
+}
+
+func (d *recorderDriver) Driver() driver.Driver {
+	return d
+}
+
+func (d *recorderDriver) Execs() []recordedExec {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return append([]recordedExec(nil), d.execs...)
+}
+
+type recorderConn struct {
+	d *recorderDriver
+}
+
+func (c *recorderConn) Prepare(query string) (driver.Stmt, error) {
+	return &recorderStmt{c.d, query}, nil
+}
+
+func (c *recorderConn) Close() error {
+	return nil
+}
+
+func (c *recorderConn) Begin() (driver.Tx, error) {
+	return nil, driver.ErrSkip
+}
+
+type recorderStmt struct {
+	d     *recorderDriver
+	query string
+}
+
+func (s *recorderStmt) Close() error {
+	return nil
+}
+
+func (s *recorderStmt) NumInput() int {
+	return -1
+}
+
+func (s *recorderStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.d.lock.Lock()
+	defer s.d.lock.Unlock()
+	s.d.execs = append(s.d.execs, recordedExec{strings.TrimSpace(s.query), args})
+	return driver.RowsAffected(1), nil
+}
+
+func (s *recorderStmt) Query(args []driver.Value) (driver.Rows, error) {
+	if s.d.queryRows == nil {
+		return nil, fmt.Errorf("unexpected query: %v", s.query)
+	}
+	columns, rows, err := s.d.queryRows(s.query)
+	if err != nil {
+		return nil, err
+	}
+	return &recorderRows{columns: columns, rows: rows}, nil
+}
+
+type recorderRows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (r *recorderRows) Columns() []string {
+	return r.columns
+}
+
+func (r *recorderRows) Close() error {
+	return nil
+}
+
+func (r *recorderRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+func TestRowsQueryAudit(t *testing.T) {
+	d := &recorderDriver{}
+	db := newRecorderDB(d)
+	defer db.Close()
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	subjectUUID := uuid.NewV4()
+	a := &Applier{
+		logger:       logrus.NewEntry(logrus.New()),
+		subjectUUID:  subjectUUID,
+		mysqlContext: &config.MySQLDriverConfig{RowsQueryAudit: true},
+		db:           db,
+		dbs:          []*sql.Conn{{Db: conn}},
+	}
+	if err := a.initRowsQueryAudit(); err != nil {
+		t.Fatal(err)
+	}
+	execs := d.Execs()
+	if len(execs) != 2 || !strings.HasPrefix(execs[0].query, "CREATE DATABASE IF NOT EXISTS "+g.DtleSchemaName) ||
+		!strings.HasPrefix(execs[1].query, "CREATE TABLE IF NOT EXISTS "+g.DtleSchemaName+".rows_query_audit") {
+		t.Fatalf("unexpected statements to create the table: %v", execs)
+	}
+
+	sid := uuid.NewV4()
+	entry := &binlog.BinlogEntry{
+		Coordinates: base.BinlogCoordinateTx{SID: sid, GNO: 12},
+		RowsQueries: []string{"insert into tb1 values (1)", "update tb1 set a = 2"},
+	}
+	if err := auditRowsQueries(a.dbs[0], entry); err != nil {
+		t.Fatal(err)
+	}
+	// without the statements
+	if err := auditRowsQueries(a.dbs[0], &binlog.BinlogEntry{Coordinates: entry.Coordinates}); err != nil {
+		t.Fatal(err)
+	}
+	wantInsert := "insert into " + g.DtleSchemaName + ".rows_query_audit (job_uuid,source_uuid,gno,query) values (unhex('" +
+		strings.Replace(subjectUUID.String(), "-", "", -1) + "'), ?, ?, ?)"
+	execs = d.Execs()[2:]
+	if len(execs) != len(entry.RowsQueries) {
+		t.Fatalf("got %v inserts, want %v", len(execs), len(entry.RowsQueries))
+	}
+	for i, exec := range execs {
+		if exec.query != wantInsert {
+			t.Fatalf("got %v, want %v", exec.query, wantInsert)
+		}
+		if want := []driver.Value{sid.Bytes(), int64(12), entry.RowsQueries[i]}; !reflect.DeepEqual(exec.args, want) {
+			t.Fatalf("got args %v, want %v", exec.args, want)
+		}
+	}
+
+	// RowsQueryAudit=false
+	if err := auditRowsQueries(&sql.Conn{Db: conn}, entry); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(d.Execs()); n != 2+len(entry.RowsQueries) {
+		t.Fatalf("got %v statements without the audit", n)
+	}
+}
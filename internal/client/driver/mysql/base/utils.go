@@ -17,8 +17,12 @@ import (
 
 	sqle "github.com/actiontech/dtle/internal/client/driver/mysql/sqle/inspector"
 	"github.com/actiontech/dtle/internal/g"
+	"github.com/pingcap/parser"
 	"github.com/pingcap/parser/ast"
+	"github.com/pingcap/parser/format"
+	"github.com/pingcap/parser/model"
 	parsermysql "github.com/pingcap/parser/mysql"
+	_ "github.com/pingcap/tidb/types/parser_driver"
 
 	"database/sql"
 
@@ -132,6 +136,96 @@ func ShowCreateTable(db *gosql.DB, databaseName, tableName string, dropTableIfEx
 	return statement, err
 }
 
+// MapCreateTableColumns rewrites a CREATE TABLE statement to have only columns in fromNames,
+// renamed to toNames respectively and in that order. Indexes on the removed columns are dropped.
+func MapCreateTableColumns(createTableStatement string, fromNames []string, toNames []string) (string, error) {
+	stmt, err := parser.New().ParseOneStmt(createTableStatement, "", "")
+	if err != nil {
+		return "", err
+	}
+	createStmt, ok := stmt.(*ast.CreateTableStmt)
+	if !ok {
+		return "", fmt.Errorf("not a create table statement: %v", createTableStatement)
+	}
+
+	colDefs := make(map[string]*ast.ColumnDef)
+	for _, col := range createStmt.Cols {
+		colDefs[col.Name.Name.L] = col
+	}
+	// The dest table would lose its primary or unique key. Reject it rather than drop the key.
+	keyColumns := make(map[string]string)
+	for _, col := range createStmt.Cols {
+		for _, option := range col.Options {
+			switch option.Tp {
+			case ast.ColumnOptionPrimaryKey:
+				keyColumns[col.Name.Name.L] = "PRIMARY KEY"
+			case ast.ColumnOptionUniqKey:
+				keyColumns[col.Name.Name.L] = "UNIQUE KEY"
+			}
+		}
+	}
+	for _, constraint := range createStmt.Constraints {
+		var keyName string
+		switch constraint.Tp {
+		case ast.ConstraintPrimaryKey:
+			keyName = "PRIMARY KEY"
+		case ast.ConstraintUniq, ast.ConstraintUniqKey, ast.ConstraintUniqIndex:
+			keyName = fmt.Sprintf("UNIQUE KEY %v", constraint.Name)
+		default:
+			continue
+		}
+		for _, key := range constraint.Keys {
+			keyColumns[key.Column.Name.L] = keyName
+		}
+	}
+	replicated := make(map[string]bool)
+	for _, name := range fromNames {
+		replicated[strings.ToLower(name)] = true
+	}
+	for _, col := range createStmt.Cols {
+		if keyName, ok := keyColumns[col.Name.Name.L]; ok && !replicated[col.Name.Name.L] {
+			return "", fmt.Errorf("column %v of table %v is in %v and cannot be excluded",
+				col.Name.Name.O, createStmt.Table.Name.O, keyName)
+		}
+	}
+	renames := make(map[string]string)
+	cols := make([]*ast.ColumnDef, 0, len(fromNames))
+	for i, name := range fromNames {
+		col, ok := colDefs[strings.ToLower(name)]
+		if !ok {
+			return "", fmt.Errorf("column %v is not found in %v", name, createTableStatement)
+		}
+		renames[col.Name.Name.L] = toNames[i]
+		col.Name.Name = model.NewCIStr(toNames[i])
+		cols = append(cols, col)
+	}
+	createStmt.Cols = cols
+
+	// An index (other than primary or unique keys) on an excluded column is dropped.
+	constraints := make([]*ast.Constraint, 0, len(createStmt.Constraints))
+	for _, constraint := range createStmt.Constraints {
+		keep := true
+		for _, key := range constraint.Keys {
+			newName, ok := renames[key.Column.Name.L]
+			if !ok {
+				keep = false
+				break
+			}
+			key.Column.Name = model.NewCIStr(newName)
+		}
+		if keep {
+			constraints = append(constraints, constraint)
+		}
+	}
+	createStmt.Constraints = constraints
+
+	buf := bytes.NewBuffer(nil)
+	if err := createStmt.Restore(format.NewRestoreCtx(format.DefaultRestoreFlags, buf)); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
 func ShowCreateView(db *gosql.DB, databaseName, tableName string, dropTableIfExists bool) (createTableStatement string, err error) {
 	var dummy, character_set_client, collation_connection string
 	query := fmt.Sprintf(`show create table %s.%s`, umconf.EscapeName(databaseName), umconf.EscapeName(tableName))
@@ -20,6 +20,24 @@ type BinlogEntries struct {
 	TxLen int
 }
 
+// CreditCost returns the flow control credits taken by the entries, which are returned by the dest
+// as each entry leaves its queue, 1 and OriginalSize.
+// The parts of a big tx but the last are free, as the dest joins them into one entry of the whole OriginalSize.
+// So are the entries routed from another job, which has not taken the credits of this job.
+func (b *BinlogEntries) CreditCost() (entries int64, bytes int64) {
+	if b.BigTx && b.TxNum < b.TxLen {
+		return 0, 0
+	}
+	for _, entry := range b.Entries {
+		if entry.Routed {
+			continue
+		}
+		entries += 1
+		bytes += int64(entry.OriginalSize)
+	}
+	return entries, bytes
+}
+
 // BinlogEntry describes an entry in the binary log
 type BinlogEntry struct {
 	hasBeginQuery bool
@@ -27,6 +45,17 @@ type BinlogEntry struct {
 	SpanContext   opentracing.SpanContext
 	Events        []DataEvent
 	OriginalSize  int // size of binlog entry
+	// Not 0 if the tx writes the heartbeat of the job. Unix microseconds on the source.
+	HeartbeatTs int64
+	// Not empty if the tx is routed to another job (by the subject) by OriginUuidRules.
+	RouteTo string
+	// Routed from another job by OriginUuidRules. Not counted in the flow control credits of this job.
+	Routed bool
+	// Statements of the rows, from ROWS_QUERY_EVENT (binlog_rows_query_log_events=ON). See DataEvent.RowsQueryNo.
+	RowsQueries []string
+
+	// Not nil if some events are spilled to the disk. See SpillEvents.
+	spill *SpillFile
 }
 
 // NewBinlogEntry creates an empty, ready to go BinlogEntry object
@@ -39,6 +68,14 @@ func NewBinlogEntryAt(coordinates base.BinlogCoordinateTx) *BinlogEntry {
 	return binlogEntry
 }
 
+// RowsQueryOf returns the statement which produced the row event, or "" if it is unknown.
+func (b *BinlogEntry) RowsQueryOf(event *DataEvent) string {
+	if event.RowsQueryNo <= 0 || event.RowsQueryNo > len(b.RowsQueries) {
+		return ""
+	}
+	return b.RowsQueries[event.RowsQueryNo-1]
+}
+
 // Duplicate creates and returns a new binlog entry, with some of the attributes pre-assigned
 func (b *BinlogEntry) String() string {
 	return fmt.Sprintf("[BinlogEntry at %+v]", b.Coordinates)
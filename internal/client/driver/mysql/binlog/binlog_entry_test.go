@@ -0,0 +1,28 @@
+package binlog
+
+import (
+	"testing"
+)
+
+func TestCreditCost(t *testing.T) {
+	tests := []struct {
+		entries     BinlogEntries
+		wantEntries int64
+		wantBytes   int64
+	}{
+		{BinlogEntries{}, 0, 0},
+		{BinlogEntries{Entries: []*BinlogEntry{{OriginalSize: 10}, {OriginalSize: 20}}}, 2, 30},
+		// Routed from another job.
+		{BinlogEntries{Entries: []*BinlogEntry{{OriginalSize: 10}, {OriginalSize: 20, Routed: true}}}, 1, 10},
+		// The parts of a big tx. Only the last one is counted, with the size of the whole tx.
+		{BinlogEntries{Entries: []*BinlogEntry{{OriginalSize: 1000}}, BigTx: true, TxNum: 1, TxLen: 3}, 0, 0},
+		{BinlogEntries{Entries: []*BinlogEntry{{OriginalSize: 1000}}, BigTx: true, TxNum: 2, TxLen: 3}, 0, 0},
+		{BinlogEntries{Entries: []*BinlogEntry{{OriginalSize: 1000}}, BigTx: true, TxNum: 3, TxLen: 3}, 1, 1000},
+	}
+	for i, tt := range tests {
+		entries, bytes := tt.entries.CreditCost()
+		if entries != tt.wantEntries || bytes != tt.wantBytes {
+			t.Fatalf("case %v: got %v/%v, want %v/%v", i, entries, bytes, tt.wantEntries, tt.wantBytes)
+		}
+	}
+}
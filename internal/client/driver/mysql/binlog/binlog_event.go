@@ -29,7 +29,8 @@ func ToEventDML(eventType replication.EventType) EventDML {
 	switch eventType {
 	case replication.WRITE_ROWS_EVENTv0, replication.WRITE_ROWS_EVENTv1, replication.WRITE_ROWS_EVENTv2:
 		return InsertDML
-	case replication.UPDATE_ROWS_EVENTv0, replication.UPDATE_ROWS_EVENTv1, replication.UPDATE_ROWS_EVENTv2:
+	case replication.UPDATE_ROWS_EVENTv0, replication.UPDATE_ROWS_EVENTv1, replication.UPDATE_ROWS_EVENTv2,
+		replication.PARTIAL_UPDATE_ROWS_EVENT:
 		return UpdateDML
 	case replication.DELETE_ROWS_EVENTv0, replication.DELETE_ROWS_EVENTv1, replication.DELETE_ROWS_EVENTv2:
 		return DeleteDML
@@ -71,6 +72,7 @@ type DataEvent struct {
 	NewColumnValues   *mysql.ColumnValues
 	Table             *config.Table // TODO tmp solution
 	LogPos            int64         // for kafka. The pos of WRITE_ROW_EVENT
+	RowsQueryNo       int           // 1-based index in BinlogEntry.RowsQueries. 0 if unknown.
 	TableItem         interface{}
 }
 
@@ -7,8 +7,12 @@
 package binlog
 
 import (
+	"bytes"
 	gosql "database/sql"
+	"database/sql/driver"
 	"github.com/cznic/mathutil"
+	"io"
+	"net"
 	"os"
 	"path"
 	"path/filepath"
@@ -32,6 +36,8 @@ import (
 
 	"github.com/pingcap/parser"
 	"github.com/pingcap/parser/ast"
+	"github.com/pingcap/parser/format"
+	"github.com/pingcap/parser/model"
 	_ "github.com/pingcap/tidb/types/parser_driver"
 
 	"github.com/satori/go.uuid"
@@ -89,6 +95,30 @@ type BinlogReader struct {
 	sqlFilter *SqlFilter
 
 	context *sqle.Context
+	// nil if not enabled
+	schemaHistory *SchemaHistory
+
+	// ALTERs on the ghost table of an online DDL tool. key: schema.origin_table
+	// Rebuilt from the schema history when the job is restarted during the migration.
+	onlineDDLAlters map[string][]*ast.AlterTableStmt
+	// The online DDL tool evidenced on a replicated table, by the changelog table of gh-ost
+	// or the triggers of pt-online-schema-change. key: schema.origin_table
+	onlineDDLTools map[string]string
+
+	// The last ROWS_QUERY_EVENT in the current tx. It is added to the entry if any row of it is replicated.
+	rowsQuery      string
+	rowsQueryAdded bool
+
+	// The start GTID set and the transactions read after it. Used to continue on another server (failover).
+	// nil if not streaming by GTID.
+	gtidSet *gomysql.MysqlGTIDSet
+
+	// The events of the current tx are spilled here if they exceed TxMemoryLimit.
+	spillDir string
+	// The events are spilled in chunks of about this size, each sent in a payload.
+	spillChunkSize int64
+	// OriginalSize of the current tx when it was last spilled.
+	spilledSize int
 }
 
 type SqlFilter struct {
@@ -107,6 +137,12 @@ type SqlFilter struct {
 	NoDDLAlterTableModifyColumn bool
 	NoDDLAlterTableChangeColumn bool
 	NoDDLAlterTableAlterColumn  bool
+
+	NoDDLRenameTable bool
+	NoDDLView        bool
+	NoDDLTrigger     bool
+	NoDDLFunction    bool
+	NoDDLProcedure   bool
 }
 
 func parseSqlFilter(strs []string) (*SqlFilter, error) {
@@ -142,6 +178,17 @@ func parseSqlFilter(strs []string) (*SqlFilter, error) {
 		case "noddlaltertablealtercolumn":
 			s.NoDDLAlterTableAlterColumn = true
 
+		case "noddlrenametable":
+			s.NoDDLRenameTable = true
+		case "noddlview":
+			s.NoDDLView = true
+		case "noddltrigger":
+			s.NoDDLTrigger = true
+		case "noddlfunction":
+			s.NoDDLFunction = true
+		case "noddlprocedure":
+			s.NoDDLProcedure = true
+
 		default:
 			return nil, fmt.Errorf("unknown sql filter item: %v", strs[i])
 		}
@@ -149,11 +196,34 @@ func parseSqlFilter(strs []string) (*SqlFilter, error) {
 	return s, nil
 }
 
-func NewMySQLReader(execCtx *common.ExecContext, cfg *config.MySQLDriverConfig, logger *logrus.Entry, replicateDoDb []*config.DataSource, sqleContext *sqle.Context) (binlogReader *BinlogReader, err error) {
+func validateOriginUuidRules(rules []*config.OriginUuidRule) error {
+	for i, rule := range rules {
+		if rule.ServerUuid != "*" {
+			if _, err := uuid.FromString(rule.ServerUuid); err != nil {
+				return fmt.Errorf("bad ServerUuid in OriginUuidRules[%v]: %v", i, err)
+			}
+		}
+		switch rule.Action {
+		case config.OriginUuidInclude, config.OriginUuidExclude:
+		case config.OriginUuidRoute:
+			if rule.RouteTo == "" {
+				return fmt.Errorf("RouteTo is required for OriginUuidRules[%v]", i)
+			}
+		default:
+			return fmt.Errorf("unknown Action in OriginUuidRules[%v]: %v", i, rule.Action)
+		}
+	}
+	return nil
+}
+
+func NewMySQLReader(execCtx *common.ExecContext, cfg *config.MySQLDriverConfig, logger *logrus.Entry, replicateDoDb []*config.DataSource, sqleContext *sqle.Context, schemaHistory *SchemaHistory) (binlogReader *BinlogReader, err error) {
 	sqlFilter, err := parseSqlFilter(cfg.SqlFilter)
 	if err != nil {
 		return nil, err
 	}
+	if err := validateOriginUuidRules(cfg.OriginUuidRules); err != nil {
+		return nil, err
+	}
 
 	binlogReader = &BinlogReader{
 		execCtx:                 execCtx,
@@ -166,6 +236,18 @@ func NewMySQLReader(execCtx *common.ExecContext, cfg *config.MySQLDriverConfig,
 		tables:                  make(map[string](map[string]*config.TableContext)),
 		sqlFilter:               sqlFilter,
 		context:                 sqleContext,
+		schemaHistory:           schemaHistory,
+		onlineDDLAlters:         make(map[string][]*ast.AlterTableStmt),
+		onlineDDLTools:          make(map[string]string),
+		spillDir:                GetSpillDir(execCtx.StateDir, execCtx.Subject, "src"),
+	}
+	if err := ResetSpillDir(binlogReader.spillDir); err != nil {
+		return nil, err
+	}
+	// The size of the binlog is only an estimate of the payload. Leave a margin.
+	binlogReader.spillChunkSize = cfg.TxMemoryLimit
+	if maxChunkSize := int64(execCtx.MaxPayload / 4); maxChunkSize > 0 && maxChunkSize < binlogReader.spillChunkSize {
+		binlogReader.spillChunkSize = maxChunkSize
 	}
 
 	for _, db := range replicateDoDb {
@@ -177,10 +259,19 @@ func NewMySQLReader(execCtx *common.ExecContext, cfg *config.MySQLDriverConfig,
 		}
 	}
 
+	if schemaHistory != nil {
+		if err := binlogReader.loadOnlineDDLAlters(); err != nil {
+			return nil, err
+		}
+	}
+
 	uri := cfg.ConnectionConfig.GetDBUri()
 	if binlogReader.db, err = sql.CreateDB(uri); err != nil {
 		return nil, err
 	}
+	if err := binlogReader.loadPtOscTriggers(); err != nil {
+		return nil, err
+	}
 
 	id, err := util.NewIdWorker(2, 3, util.SnsEpoch)
 	if err != nil {
@@ -202,21 +293,7 @@ func NewMySQLReader(execCtx *common.ExecContext, cfg *config.MySQLDriverConfig,
 	if binlogReader.mysqlContext.BinlogRelay {
 		// init when connecting
 	} else {
-		binlogSyncerConfig := replication.BinlogSyncerConfig{
-			ServerID:       uint32(binlogReader.serverId),
-			Flavor:         "mysql",
-			Host:           cfg.ConnectionConfig.Host,
-			Port:           uint16(cfg.ConnectionConfig.Port),
-			User:           cfg.ConnectionConfig.User,
-			Password:       cfg.ConnectionConfig.Password,
-			RawModeEnabled: false,
-			UseDecimal:     true,
-
-			MaxReconnectAttempts: 3,
-			HeartbeatPeriod:      3 * time.Second,
-			ReadTimeout:          6 * time.Second,
-		}
-		binlogReader.binlogSyncer = replication.NewBinlogSyncer(binlogSyncerConfig)
+		binlogReader.binlogSyncer = binlogReader.newBinlogSyncer(cfg.ConnectionConfig)
 	}
 
 	binlogReader.mysqlContext.Stage = models.StageRegisteringSlaveOnMaster
@@ -224,6 +301,24 @@ func NewMySQLReader(execCtx *common.ExecContext, cfg *config.MySQLDriverConfig,
 	return binlogReader, err
 }
 
+func (b *BinlogReader) newBinlogSyncer(connectionConfig *mysql.ConnectionConfig) *replication.BinlogSyncer {
+	binlogSyncerConfig := replication.BinlogSyncerConfig{
+		ServerID:       uint32(b.serverId),
+		Flavor:         "mysql",
+		Host:           connectionConfig.Host,
+		Port:           uint16(connectionConfig.Port),
+		User:           connectionConfig.User,
+		Password:       connectionConfig.Password,
+		RawModeEnabled: false,
+		UseDecimal:     true,
+
+		MaxReconnectAttempts: 3,
+		HeartbeatPeriod:      3 * time.Second,
+		ReadTimeout:          6 * time.Second,
+	}
+	return replication.NewBinlogSyncer(binlogSyncerConfig)
+}
+
 func (b *BinlogReader) getDbTableMap(schemaName string) map[string]*config.TableContext {
 	tableMap, ok := b.tables[schemaName]
 	if !ok {
@@ -243,7 +338,13 @@ func (b *BinlogReader) addTableToTableMap(tableMap map[string]*config.TableConte
 		return err
 	}
 
-	tableMap[table.TableName] = config.NewTableContext(table, whereCtx)
+	transformCtx, err := config.NewTransformCtx(table)
+	if err != nil {
+		b.logger.Errorf("mysql.reader: Error parse column transforms of %v.%v", table.TableSchema, table.TableName)
+		return err
+	}
+
+	tableMap[table.TableName] = config.NewTableContext(table, whereCtx, transformCtx)
 	return nil
 }
 
@@ -367,6 +468,9 @@ func (b *BinlogReader) ConnectBinlogStreamer(coordinates base.BinlogCoordinatesX
 				b.logger.Errorf("mysql.reader: err: %v", err)
 				return err
 			}
+			// parse again: the set passed to the syncer is not modified afterwards.
+			readGtidSet, _ := gomysql.ParseMysqlGTIDSet(coordinates.GtidSet)
+			b.gtidSet = readGtidSet.(*gomysql.MysqlGTIDSet)
 
 			b.binlogStreamer, err = b.binlogSyncer.StartSyncGTID(gtidSet)
 		}
@@ -380,6 +484,103 @@ func (b *BinlogReader) ConnectBinlogStreamer(coordinates base.BinlogCoordinatesX
 	return nil
 }
 
+// addReadGtid adds the current transaction to b.gtidSet, after the entry is sent.
+func (b *BinlogReader) addReadGtid() {
+	if b.gtidSet == nil {
+		return
+	}
+	sid := b.currentCoordinates.SID
+	common.UpdateGtidSet(b.gtidSet, sid.String(), sid, b.currentCoordinates.GNO)
+}
+
+// GetReadGtidSet returns the GTID set of transactions read, or "" if not streaming by GTID.
+// It must be called when DataStreamEvents is not running.
+func (b *BinlogReader) GetReadGtidSet() string {
+	if b.gtidSet == nil {
+		return ""
+	}
+	return b.gtidSet.String()
+}
+
+// StreamError is returned by DataStreamEvents when reading from the binlog streamer fails,
+// as opposed to failing to handle an event read.
+type StreamError struct {
+	Err error
+}
+
+func (e *StreamError) Error() string {
+	return e.Err.Error()
+}
+
+// IsConnectionError tells whether err returned by DataStreamEvents is caused by losing the source,
+// e.g. a broken connection or the server shutting down. Only such an error might be solved by source failover.
+func IsConnectionError(err error) bool {
+	streamErr, ok := err.(*StreamError)
+	if !ok {
+		return false
+	}
+	err = streamErr.Err
+	// Errors from go-mysql are wrapped by errors.Trace.
+	for {
+		causer, ok := err.(interface{ Cause() error })
+		if !ok || causer.Cause() == nil || causer.Cause() == err {
+			break
+		}
+		err = causer.Cause()
+	}
+
+	switch err {
+	case io.EOF, io.ErrUnexpectedEOF, gomysql.ErrBadConn, driver.ErrBadConn:
+		return true
+	}
+	switch err := err.(type) {
+	case net.Error:
+		return true
+	case *gomysql.MyError:
+		switch err.Code {
+		case gomysql.ER_SERVER_SHUTDOWN, gomysql.ER_NET_READ_ERROR, gomysql.ER_NET_READ_INTERRUPTED,
+			gomysql.ER_NET_ERROR_ON_WRITE, gomysql.ER_NET_WRITE_INTERRUPTED:
+			return true
+		}
+	}
+	return false
+}
+
+// FailoverTo connects the binlog streamer to another server, continuing after the transactions read.
+// It must be called when DataStreamEvents is not running. BinlogRelay is not supported.
+func (b *BinlogReader) FailoverTo(connectionConfig *mysql.ConnectionConfig) (err error) {
+	if b.mysqlContext.BinlogRelay {
+		return fmt.Errorf("source failover is not supported with BinlogRelay")
+	}
+	if b.gtidSet == nil {
+		return fmt.Errorf("source failover requires GTID")
+	}
+	gtidSet, err := gomysql.ParseMysqlGTIDSet(b.gtidSet.String())
+	if err != nil {
+		return err
+	}
+
+	db, err := sql.CreateDB(connectionConfig.GetDBUri())
+	if err != nil {
+		return err
+	}
+	if err := sql.CloseDB(b.db); err != nil {
+		b.logger.Warnf("mysql.reader: error closing db on failover. err: %v", err)
+	}
+	b.db = db
+
+	b.binlogSyncer.Close()
+	b.binlogSyncer = b.newBinlogSyncer(connectionConfig)
+	b.logger.Printf("mysql.reader: Connecting binlog streamer to %v:%v at gtid %v",
+		connectionConfig.Host, connectionConfig.Port, gtidSet)
+	b.binlogStreamer, err = b.binlogSyncer.StartSyncGTID(gtidSet)
+	if err != nil {
+		return err
+	}
+	b.mysqlContext.Stage = models.StageRequestingBinlogDump
+	return nil
+}
+
 func (b *BinlogReader) GetCurrentBinlogCoordinates() *base.BinlogCoordinateTx {
 	b.currentCoordinatesMutex.Lock()
 	defer b.currentCoordinatesMutex.Unlock()
@@ -387,6 +588,84 @@ func (b *BinlogReader) GetCurrentBinlogCoordinates() *base.BinlogCoordinateTx {
 	return &returnCoordinates
 }
 
+// columnsPresent returns which of the columns are in the row image, by the columns-present bitmap of a rows event.
+// It returns nil if all columns are present (binlog_row_image=FULL).
+// setRowsQuery sets the statement (from ROWS_QUERY_EVENT) of the following row events, or "" at a new tx.
+func (b *BinlogReader) setRowsQuery(query string) {
+	b.rowsQuery = query
+	b.rowsQueryAdded = false
+}
+
+// addRowsQuery links a replicated row event to the current statement,
+// which is added to the entry on the first of its rows.
+func (b *BinlogReader) addRowsQuery(dmlEvent *DataEvent) {
+	if b.rowsQuery == "" {
+		return
+	}
+	if !b.rowsQueryAdded {
+		b.currentBinlogEntry.RowsQueries = append(b.currentBinlogEntry.RowsQueries, b.rowsQuery)
+		b.rowsQueryAdded = true
+	}
+	dmlEvent.RowsQueryNo = len(b.currentBinlogEntry.RowsQueries)
+}
+
+// filterDataEvent tells whether the row event matches the 'where' of the table.
+// An update moving a row across the filter is converted to an insert or a delete.
+func (b *BinlogReader) filterDataEvent(table *config.TableContext, dmlEvent *DataEvent) (whereTrue bool, err error) {
+	if table == nil || table.WhereCtx.IsDefault {
+		return true, nil
+	}
+	switch dmlEvent.DML {
+	case InsertDML:
+		return table.WhereTrue(dmlEvent.NewColumnValues)
+	case UpdateDML:
+		before, err := table.WhereTrue(dmlEvent.WhereColumnValues)
+		if err != nil {
+			return false, err
+		}
+		after, err := table.WhereTrue(dmlEvent.NewColumnValues)
+		if err != nil {
+			return false, err
+		}
+		// A row moving into the filter is inserted, and a row moving out of it is deleted.
+		// Both need the whole row, which is not in a MINIMAL or NOBLOB row image.
+		if before != after &&
+			(dmlEvent.WhereColumnValues.Present != nil || dmlEvent.NewColumnValues.Present != nil) {
+			return false, fmt.Errorf("update on 'where columns' of %v.%v requires the full row image (binlog_row_image=FULL)",
+				dmlEvent.DatabaseName, dmlEvent.TableName)
+		}
+		switch {
+		case !before && after:
+			b.logger.Debugf("mysql.reader: update enters 'where'. as insert")
+			dmlEvent.DML = InsertDML
+			dmlEvent.WhereColumnValues = nil
+		case before && !after:
+			b.logger.Debugf("mysql.reader: update leaves 'where'. as delete")
+			dmlEvent.DML = DeleteDML
+			dmlEvent.NewColumnValues = nil
+		}
+		return before || after, nil
+	case DeleteDML:
+		return table.WhereTrue(dmlEvent.WhereColumnValues)
+	}
+	return true, nil
+}
+
+func columnsPresent(bitmap []byte, nColumns int) []bool {
+	all := true
+	present := make([]bool, nColumns)
+	for i := 0; i < nColumns; i++ {
+		present[i] = i>>3 < len(bitmap) && bitmap[i>>3]&(1<<uint(i&7)) != 0
+		if !present[i] {
+			all = false
+		}
+	}
+	if all {
+		return nil
+	}
+	return present
+}
+
 func ToColumnValuesV2(abstractValues []interface{}, table *config.TableContext) *mysql.ColumnValues {
 	result := &mysql.ColumnValues{
 		AbstractValues: make([]*interface{}, len(abstractValues)),
@@ -425,6 +704,9 @@ type parseDDLResult struct {
 	tables []SchemaTable
 	sqls   []string
 	ast    ast.StmtNode
+	// For DDLs not supported by the parser (ast is nil): "trigger", "function" or "procedure".
+	objectType string
+	objectName string
 }
 
 // StreamEvents
@@ -451,6 +733,8 @@ func (b *BinlogReader) handleEvent(ev *replication.BinlogEvent, entriesChannel c
 		b.currentCoordinates.LastCommitted = evt.LastCommitted
 		b.currentCoordinates.SeqenceNumber = evt.SequenceNumber
 		b.currentBinlogEntry = NewBinlogEntryAt(b.currentCoordinates)
+		b.spilledSize = 0
+		b.setRowsQuery("")
 	case replication.QUERY_EVENT:
 		evt := ev.Event.(*replication.QueryEvent)
 		query := string(evt.Query)
@@ -499,8 +783,10 @@ func (b *BinlogReader) handleEvent(ev *replication.BinlogEvent, entriesChannel c
 					b.currentBinlogEntry.Events = append(b.currentBinlogEntry.Events, event)
 					b.currentBinlogEntry.SpanContext = span.Context()
 					b.currentBinlogEntry.OriginalSize += len(ev.RawData)
+					b.applyOriginUuidRules()
 					entriesChannel <- b.currentBinlogEntry
 					b.LastAppliedRowsEventHint = b.currentCoordinates
+					b.addReadGtid()
 					return nil
 				} else {
 					// it is a ddl
@@ -513,14 +799,74 @@ func (b *BinlogReader) handleEvent(ev *replication.BinlogEvent, entriesChannel c
 					b.context.UseSchema(currentSchema)
 				}
 				b.context.UpdateContext(ddlInfo.ast, "mysql")
+				if err := b.recordSchemaHistory(query, currentSchema, ddlInfo); err != nil {
+					return err
+				}
 
 				if b.sqlFilter.NoDDL {
 					skipEvent = true
 				}
 
+				// The pairs of a RENAME TABLE are applied in one statement, as `a to tmp, b to a, tmp to b` is atomic.
+				var renamePairs []renameTablePair
+				flushRenamePairs := func() {
+					if len(renamePairs) > 0 {
+						b.currentBinlogEntry.Events = append(b.currentBinlogEntry.Events, newRenameTableEvent(renamePairs))
+						renamePairs = nil
+					}
+				}
 				for i, sql := range ddlInfo.sqls {
 					realSchema := utils.StringElse(ddlInfo.tables[i].Schema, currentSchema)
 					tableName := ddlInfo.tables[i].Table
+
+					if renameAst, ok := ddlInfo.ast.(*ast.RenameTableStmt); ok {
+						newTable := renameAst.TableToTables[i].NewTable
+						newSchemaName := utils.StringElse(newTable.Schema.O, currentSchema)
+						events, isOnlineDDL, err := b.handleOnlineDDLRename(realSchema, tableName, newSchemaName, newTable.Name.O)
+						if err != nil {
+							return err
+						}
+						if isOnlineDDL {
+							if skipEvent || b.sqlFilter.NoDDLAlterTable {
+								b.logger.Debugf("mysql.reader. skipped online ddl events. n: %v", len(events))
+							} else {
+								flushRenamePairs()
+								b.currentBinlogEntry.Events = append(b.currentBinlogEntry.Events, events...)
+							}
+							continue
+						}
+
+						pair, event, err := b.handleRenameTable(realSchema, tableName, newSchemaName, newTable.Name.O)
+						if err != nil {
+							return err
+						}
+						if pair == nil && event == nil {
+							// nothing to apply
+						} else if skipEvent || b.sqlFilter.NoDDLRenameTable {
+							b.logger.Debugf("mysql.reader. skipped a ddl event of rename table %v.%v", realSchema, tableName)
+						} else if pair != nil {
+							renamePairs = append(renamePairs, *pair)
+						} else {
+							flushRenamePairs()
+							b.currentBinlogEntry.Events = append(b.currentBinlogEntry.Events, *event)
+						}
+						continue
+					}
+
+					b.recordOnlineDDLTool(ddlInfo, realSchema, tableName)
+					if ddlInfo.objectType == "trigger" && strings.HasPrefix(ddlInfo.objectName, ptOscTriggerPrefix) {
+						b.logger.Infof("mysql.reader: skip trigger of pt-online-schema-change. query: %v", sql)
+						continue
+					}
+					if origin, _, isGhost, ok := b.onlineDDLTableName(realSchema, tableName); ok {
+						// The DDLs on a ghost table are kept even before the tool is evidenced,
+						// as pt-online-schema-change creates and alters the ghost table before its triggers.
+						b.handleOnlineDDLTable(ddlInfo.ast, realSchema, origin, isGhost)
+						if _, _, ok := b.matchOnlineDDLTable(realSchema, tableName); ok {
+							continue
+						}
+					}
+
 					err = b.checkObjectFitRegexp(b.mysqlContext.ReplicateDoDb, realSchema, tableName)
 					if err != nil {
 						b.logger.Warnf("mysql.reader: skip query %s", query)
@@ -532,16 +878,20 @@ func (b *BinlogReader) handleEvent(ev *replication.BinlogEvent, entriesChannel c
 						return nil
 					}
 
-					var table *config.Table
-					var schema *config.DataSource
-					for i := range b.mysqlContext.ReplicateDoDb {
-						if b.mysqlContext.ReplicateDoDb[i].TableSchema == realSchema {
-							schema = b.mysqlContext.ReplicateDoDb[i]
-							for j := range b.mysqlContext.ReplicateDoDb[i].Tables {
-								if b.mysqlContext.ReplicateDoDb[i].Tables[j].TableName == tableName {
-									table = b.mysqlContext.ReplicateDoDb[i].Tables[j]
-								}
-							}
+					schema, table := b.findTableConfig(realSchema, tableName)
+
+					switch ddlInfo.objectType {
+					case "trigger":
+						if b.sqlFilter.NoDDLTrigger {
+							skipEvent = true
+						}
+					case "function":
+						if b.sqlFilter.NoDDLFunction {
+							skipEvent = true
+						}
+					case "procedure":
+						if b.sqlFilter.NoDDLProcedure {
+							skipEvent = true
 						}
 					}
 
@@ -559,7 +909,15 @@ func (b *BinlogReader) handleEvent(ev *replication.BinlogEvent, entriesChannel c
 							skipEvent = true
 						}
 					case *ast.DropTableStmt:
-						if b.sqlFilter.NoDDLDropTable {
+						if realAst.IsView {
+							if b.sqlFilter.NoDDLView {
+								skipEvent = true
+							}
+						} else if b.sqlFilter.NoDDLDropTable {
+							skipEvent = true
+						}
+					case *ast.CreateViewStmt:
+						if b.sqlFilter.NoDDLView {
 							skipEvent = true
 						}
 					case *ast.AlterTableStmt:
@@ -613,15 +971,35 @@ func (b *BinlogReader) handleEvent(ev *replication.BinlogEvent, entriesChannel c
 							}
 						}
 					}
+					// Views, triggers and routines might refer to objects qualified by the schema anywhere.
+					_, isCreateView := ddlInfo.ast.(*ast.CreateViewStmt)
+					dropTableAst, isDropTable := ddlInfo.ast.(*ast.DropTableStmt)
+					isViewDDL := isCreateView || (isDropTable && dropTableAst.IsView)
+
 					if schema != nil && schema.TableSchemaRename != "" {
 						ddlInfo.tables[i].Schema = schema.TableSchemaRename
 						b.logger.Debugf("mysql.reader. ddl schema mapping :from  %s to %s", realSchema, schema.TableSchemaRename)
 						//sql = strings.Replace(sql, realSchema, schema.TableSchemaRename, 1)
-						sql = loadMapping(sql, realSchema, schema.TableSchemaRename, "schemaRename", " ")
+						if isViewDDL {
+							sql, err = renameViewSchema(sql, realSchema, schema.TableSchemaRename)
+						} else if ddlInfo.objectType != "" {
+							sql, err = renameObjectSchema(sql, realSchema, schema.TableSchemaRename)
+						} else {
+							sql = loadMapping(sql, realSchema, schema.TableSchemaRename, "schemaRename", " ")
+						}
+						if err != nil {
+							return err
+						}
 						currentSchema = schema.TableSchemaRename
 					}
 
-					if table != nil && table.TableRename != "" {
+					if ddlInfo.objectType == "trigger" {
+						if table != nil && table.TableRename != "" {
+							ddlInfo.tables[i].Table = table.TableRename
+							sql = renameTriggerTable(sql, utils.StringElse(ddlInfo.tables[i].Schema, currentSchema), table.TableRename)
+							b.logger.Debugf("mysql.reader. trigger table mapping: from %s to %s", tableName, table.TableRename)
+						}
+					} else if table != nil && table.TableRename != "" {
 						ddlInfo.tables[i].Table = table.TableRename
 						//sql = strings.Replace(sql, tableName, table.TableRename, 1)
 						sql = loadMapping(sql, tableName, table.TableRename, "", currentSchema)
@@ -640,10 +1018,28 @@ func (b *BinlogReader) handleEvent(ev *replication.BinlogEvent, entriesChannel c
 						b.currentBinlogEntry.Events = append(b.currentBinlogEntry.Events, event)
 					}
 				}
+				flushRenamePairs()
 				b.currentBinlogEntry.SpanContext = span.Context()
 				b.currentBinlogEntry.OriginalSize += len(ev.RawData)
+				b.applyOriginUuidRules()
 				entriesChannel <- b.currentBinlogEntry
 				b.LastAppliedRowsEventHint = b.currentCoordinates
+				b.addReadGtid()
+			}
+		}
+	case replication.ROWS_QUERY_EVENT:
+		evt := ev.Event.(*replication.RowsQueryEvent)
+		b.setRowsQuery(string(evt.Query))
+	case replication.TRANSACTION_PAYLOAD_EVENT:
+		// binlog_transaction_compression=ON. Events of the transaction (except GTID_EVENT) are in the payload.
+		evt := ev.Event.(*replication.TransactionPayloadEvent)
+		for _, innerEv := range evt.Events {
+			// Inner events have no position in the binlog file. Use the position of the payload.
+			innerEv.Header.LogPos = ev.Header.LogPos
+			innerEv.Header.EventSize = ev.Header.EventSize
+			innerEv.SpanContest = span.Context()
+			if err := b.handleEvent(innerEv, entriesChannel); err != nil {
+				return err
 			}
 		}
 	case replication.XID_EVENT:
@@ -652,8 +1048,10 @@ func (b *BinlogReader) handleEvent(ev *replication.BinlogEvent, entriesChannel c
 		// TODO is the pos the start or the end of a event?
 		// pos if which event should be use? Do we need +1?
 		b.currentBinlogEntry.Coordinates.LogPos = b.currentCoordinates.LogPos
+		b.applyOriginUuidRules()
 		entriesChannel <- b.currentBinlogEntry
 		b.LastAppliedRowsEventHint = b.currentCoordinates
+		b.addReadGtid()
 	default:
 		if rowsEvent, ok := ev.Event.(*replication.RowsEvent); ok {
 			dml := ToEventDML(ev.Header.EventType)
@@ -707,54 +1105,38 @@ func (b *BinlogReader) handleEvent(ev *replication.BinlogEvent, entriesChannel c
 					// We do both at the same time
 					continue
 				}
+				// It might be changed by the 'where' filter below.
+				dmlEvent.DML = dml
 				switch dml {
 				case InsertDML:
 					{
 						dmlEvent.NewColumnValues = ToColumnValuesV2(row, table)
+						dmlEvent.NewColumnValues.Present = columnsPresent(rowsEvent.ColumnBitmap1, len(row))
 					}
 				case UpdateDML:
 					{
 						dmlEvent.WhereColumnValues = ToColumnValuesV2(row, table)
+						dmlEvent.WhereColumnValues.Present = columnsPresent(rowsEvent.ColumnBitmap1, len(row))
 						dmlEvent.NewColumnValues = ToColumnValuesV2(rowsEvent.Rows[i+1], table)
+						dmlEvent.NewColumnValues.Present = columnsPresent(rowsEvent.ColumnBitmap2, len(rowsEvent.Rows[i+1]))
+						if ev.Header.EventType == replication.PARTIAL_UPDATE_ROWS_EVENT {
+							if err := resolveJsonDiffs(dmlEvent.WhereColumnValues, dmlEvent.NewColumnValues); err != nil {
+								return err
+							}
+						}
 					}
 				case DeleteDML:
 					{
 						dmlEvent.WhereColumnValues = ToColumnValuesV2(row, table)
+						dmlEvent.WhereColumnValues.Present = columnsPresent(rowsEvent.ColumnBitmap1, len(row))
 					}
 				}
 
 				//b.logger.Debugf("event before row: %v", dmlEvent.WhereColumnValues)
 				//b.logger.Debugf("event after row: %v", dmlEvent.NewColumnValues)
-				whereTrue := true
-				var err error
-				if table != nil && !table.WhereCtx.IsDefault {
-					switch dml {
-					case InsertDML:
-						whereTrue, err = table.WhereTrue(dmlEvent.NewColumnValues)
-						if err != nil {
-							return err
-						}
-					case UpdateDML:
-						before, err := table.WhereTrue(dmlEvent.WhereColumnValues)
-						if err != nil {
-							return err
-						}
-						after, err := table.WhereTrue(dmlEvent.NewColumnValues)
-						if err != nil {
-							return err
-						}
-						if before != after {
-							return fmt.Errorf("update on 'where columns' cause inconsistency")
-							// TODO split it to delete + insert to allow such update
-						} else {
-							whereTrue = before
-						}
-					case DeleteDML:
-						whereTrue, err = table.WhereTrue(dmlEvent.WhereColumnValues)
-						if err != nil {
-							return err
-						}
-					}
+				whereTrue, err := b.filterDataEvent(table, &dmlEvent)
+				if err != nil {
+					return err
 				}
 				if table != nil && table.Table.TableRename != "" {
 					if dmlEvent.Table != nil {
@@ -788,26 +1170,49 @@ func (b *BinlogReader) handleEvent(ev *replication.BinlogEvent, entriesChannel c
 					// decides whether action is taken sycnhronously (meaning we wait before
 					// next iteration) or asynchronously (we keep pushing more events)
 					// In reality, reads will be synchronous
-					if table != nil && len(table.Table.ColumnMap) > 0 {
-						if dmlEvent.NewColumnValues != nil {
-							newRow := make([]*interface{}, len(table.Table.ColumnMap))
-							for i := range table.Table.ColumnMap {
-								idx := table.Table.ColumnMap[i]
-								newRow[i] = dmlEvent.NewColumnValues.AbstractValues[idx]
+					if table != nil && table.TransformCtx != nil {
+						for _, values := range []*mysql.ColumnValues{dmlEvent.WhereColumnValues, dmlEvent.NewColumnValues} {
+							if values != nil {
+								if err := table.TransformCtx.TransformValues(values); err != nil {
+									return err
+								}
 							}
-							dmlEvent.NewColumnValues.AbstractValues = newRow
 						}
-
-						if dmlEvent.WhereColumnValues != nil {
+					}
+					if table != nil && len(table.Table.ColumnMap) > 0 {
+						for _, values := range []*mysql.ColumnValues{dmlEvent.WhereColumnValues, dmlEvent.NewColumnValues} {
+							if values == nil {
+								continue
+							}
 							newRow := make([]*interface{}, len(table.Table.ColumnMap))
+							var present []bool
+							if values.Present != nil {
+								present = make([]bool, len(table.Table.ColumnMap))
+							}
 							for i := range table.Table.ColumnMap {
 								idx := table.Table.ColumnMap[i]
-								newRow[i] = dmlEvent.WhereColumnValues.AbstractValues[idx]
+								newRow[i] = values.AbstractValues[idx]
+								if present != nil {
+									present[i] = values.Present[idx]
+								}
 							}
-							dmlEvent.WhereColumnValues.AbstractValues = newRow
+							values.AbstractValues = newRow
+							values.Present = present
 						}
 					}
+					b.addRowsQuery(&dmlEvent)
 					b.currentBinlogEntry.Events = append(b.currentBinlogEntry.Events, dmlEvent)
+					inMemorySize := int64(b.currentBinlogEntry.OriginalSize - b.spilledSize)
+					if inMemorySize >= b.mysqlContext.TxMemoryLimit ||
+						(b.currentBinlogEntry.Spill() != nil && inMemorySize >= b.spillChunkSize) {
+						b.logger.Debugf("mysql.reader: spill a big tx. gno: %v, size: %v",
+							b.currentCoordinates.GNO, b.currentBinlogEntry.OriginalSize)
+						nChunks := int((inMemorySize + b.spillChunkSize - 1) / b.spillChunkSize)
+						if err := b.currentBinlogEntry.SpillEvents(b.spillDir, nChunks); err != nil {
+							return err
+						}
+						b.spilledSize = b.currentBinlogEntry.OriginalSize
+					}
 				} else {
 					b.logger.Debugf("event has not passed 'where'")
 				}
@@ -877,7 +1282,7 @@ func (b *BinlogReader) DataStreamEvents(entriesChannel chan<- *BinlogEntry) erro
 		ev, err := b.binlogStreamer.GetEvent(context.Background())
 		if err != nil {
 			b.logger.Errorf("mysql.reader error GetEvent. err: %v", err)
-			return err
+			return &StreamError{Err: err}
 		}
 		spanContext := ev.SpanContest
 		span := trace.StartSpan("DataStreamEvents()  get binlogEvent  from mysql-go ", opentracing.FollowsFrom(spanContext))
@@ -926,6 +1331,9 @@ func (b *BinlogReader) DataStreamEvents(entriesChannel chan<- *BinlogEntry) erro
 func resolveDDLSQL(sql string) (result parseDDLResult, err error) {
 	stmt, err := parser.New().ParseOneStmt(sql, "", "")
 	if err != nil {
+		if objectResult, ok := resolveObjectDDLSQL(sql); ok {
+			return objectResult, nil
+		}
 		result.sqls = append(result.sqls, sql)
 		return result, err
 	}
@@ -962,14 +1370,25 @@ func resolveDDLSQL(sql string) (result parseDDLResult, err error) {
 		if v.IfExists {
 			ex = "if exists"
 		}
+		objectType := "table"
+		if v.IsView {
+			objectType = "view"
+		}
 		for _, t := range v.Tables {
 			var db string
 			if t.Schema.O != "" {
 				db = fmt.Sprintf("%s.", t.Schema.O)
 			}
-			s := fmt.Sprintf("drop table %s %s`%s`", ex, db, t.Name.O)
+			s := fmt.Sprintf("drop %s %s %s`%s`", objectType, ex, db, t.Name.O)
 			appendSql(s, t.Schema.O, t.Name.O)
 		}
+	case *ast.RenameTableStmt:
+		// One sql for each pair. The new table is taken from TableToTables when handling it.
+		for _, t2t := range v.TableToTables {
+			appendSql("", t2t.OldTable.Schema.O, t2t.OldTable.Name.O)
+		}
+	case *ast.CreateViewStmt:
+		appendSql(sql, v.ViewName.Schema.O, v.ViewName.Name.O)
 	case *ast.CreateUserStmt, *ast.GrantStmt:
 		appendSql(sql, "mysql", "user")
 	default:
@@ -979,6 +1398,573 @@ func resolveDDLSQL(sql string) (result parseDDLResult, err error) {
 	return result, nil
 }
 
+const qualifiedNameRegexStr = "(?:(`(?:[^`]|``)+`|[\\w$]+)\\s*\\.\\s*)?(`(?:[^`]|``)+`|[\\w$]+)"
+
+var (
+	// DDLs of triggers and routines, which are not supported by the parser.
+	// Groups: 1: CREATE or DROP, 2: object type, 3: schema, 4: name
+	objectDDLRegex = regexp.MustCompile("(?is)^\\s*(CREATE|DROP)\\s+(?:DEFINER\\s*=\\s*\\S+\\s+)?(TRIGGER|FUNCTION|PROCEDURE)\\s+" +
+		"(?:IF\\s+(?:NOT\\s+)?EXISTS\\s+)?" + qualifiedNameRegexStr)
+	// The table of CREATE TRIGGER. Groups: 1: schema, 2: table
+	triggerTableRegex = regexp.MustCompile("(?is)\\s(?:BEFORE|AFTER)\\s+(?:INSERT|UPDATE|DELETE)\\s+ON\\s+" + qualifiedNameRegexStr +
+		"\\s+FOR\\s+EACH\\s+ROW")
+)
+
+func unquoteName(name string) string {
+	if len(name) >= 2 && name[0] == '`' && name[len(name)-1] == '`' {
+		return strings.Replace(name[1:len(name)-1], "``", "`", -1)
+	}
+	return name
+}
+
+// resolveObjectDDLSQL recognizes DDLs of triggers, functions and procedures.
+// A trigger is considered as a DDL on its table, and a routine as a DDL on its schema.
+func resolveObjectDDLSQL(sql string) (result parseDDLResult, ok bool) {
+	m := objectDDLRegex.FindStringSubmatch(sql)
+	if m == nil {
+		return result, false
+	}
+	result.isDDL = true
+	result.objectType = strings.ToLower(m[2])
+	result.objectName = unquoteName(m[4])
+	schema := unquoteName(m[3])
+	table := ""
+	if result.objectType == "trigger" && strings.ToUpper(m[1]) == "CREATE" {
+		if mt := triggerTableRegex.FindStringSubmatch(sql); mt != nil {
+			schema = utils.StringElse(unquoteName(mt[1]), schema)
+			table = unquoteName(mt[2])
+		}
+	}
+	result.tables = append(result.tables, SchemaTable{Schema: schema, Table: table})
+	result.sqls = append(result.sqls, sql)
+	return result, true
+}
+
+// renameTriggerTable replaces the table in the ON clause of CREATE TRIGGER.
+func renameTriggerTable(sql string, schema string, table string) string {
+	loc := triggerTableRegex.FindStringSubmatchIndex(sql)
+	if loc == nil {
+		return sql
+	}
+	// replace from the schema (or the table if no schema) to the end of the table
+	start := loc[4]
+	if loc[2] >= 0 {
+		start = loc[2]
+	}
+	return sql[:start] + fmt.Sprintf("%s.%s", mysql.EscapeName(schema), mysql.EscapeName(table)) + sql[loc[5]:]
+}
+
+// schemaRenamer renames the schema qualifier of tables and columns in an AST.
+type schemaRenamer struct {
+	schema    string
+	newSchema string
+}
+
+func (v *schemaRenamer) Enter(in ast.Node) (ast.Node, bool) {
+	switch n := in.(type) {
+	case *ast.TableName:
+		if n.Schema.O == v.schema {
+			n.Schema = model.NewCIStr(v.newSchema)
+		}
+	case *ast.ColumnName:
+		if n.Schema.O == v.schema {
+			n.Schema = model.NewCIStr(v.newSchema)
+		}
+	}
+	return in, false
+}
+
+func (v *schemaRenamer) Leave(in ast.Node) (ast.Node, bool) {
+	return in, true
+}
+
+// renameViewSchema renames the schema qualifiers in CREATE VIEW (including those in the select) or DROP VIEW.
+func renameViewSchema(sql string, schema string, newSchema string) (string, error) {
+	stmt, err := parser.New().ParseOneStmt(sql, "", "")
+	if err != nil {
+		return "", err
+	}
+	stmt.Accept(&schemaRenamer{schema: schema, newSchema: newSchema})
+	buf := bytes.NewBuffer(nil)
+	if err := stmt.Restore(format.NewRestoreCtx(format.DefaultRestoreFlags, buf)); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// renameObjectSchema renames the schema qualifiers of the object and the trigger table of a trigger or routine DDL,
+// which is not supported by the parser. The body cannot be rewritten. It is an error if the body refers to the schema.
+func renameObjectSchema(sql string, schema string, newSchema string) (string, error) {
+	newQualifier := mysql.EscapeName(newSchema)
+	// [start, end) of the schema qualifiers, in order
+	var qualifiers [][2]int
+	headerEnd := 0
+	if loc := objectDDLRegex.FindStringSubmatchIndex(sql); loc != nil {
+		if loc[6] >= 0 && unquoteName(sql[loc[6]:loc[7]]) == schema {
+			qualifiers = append(qualifiers, [2]int{loc[6], loc[7]})
+		}
+		headerEnd = loc[1]
+	}
+	if loc := triggerTableRegex.FindStringSubmatchIndex(sql); loc != nil && loc[0] >= headerEnd {
+		if loc[2] >= 0 && unquoteName(sql[loc[2]:loc[3]]) == schema {
+			qualifiers = append(qualifiers, [2]int{loc[2], loc[3]})
+		}
+		headerEnd = loc[1]
+	}
+
+	bodyRegex := regexp.MustCompile("(?:^|[^\\w$`.])(?:`" + regexp.QuoteMeta(strings.Replace(schema, "`", "``", -1)) + "`|" +
+		regexp.QuoteMeta(schema) + ")\\s*\\.")
+	if bodyRegex.MatchString(sql[headerEnd:]) {
+		return "", fmt.Errorf("cannot rename schema %v to %v in the body of the ddl. skip it with SqlFilter NoDDLTrigger,"+
+			" NoDDLFunction or NoDDLProcedure, and create it on the dest. query: %v", schema, newSchema, sql)
+	}
+
+	for i := len(qualifiers) - 1; i >= 0; i-- {
+		sql = sql[:qualifiers[i][0]] + newQualifier + sql[qualifiers[i][1]:]
+	}
+	return sql, nil
+}
+
+// recordSchemaHistory appends the DDL and the resulting columns of the affected tables to the schema history.
+func (b *BinlogReader) recordSchemaHistory(query string, currentSchema string, ddlInfo parseDDLResult) error {
+	if b.schemaHistory == nil || ddlInfo.ast == nil {
+		return nil
+	}
+	if b.currentCoordinates.SID == uuid.Nil {
+		b.logger.Debugf("mysql.reader: no gtid for ddl. not recorded in schema history. query: %v", query)
+		return nil
+	}
+
+	record := &SchemaHistoryRecord{
+		Gtid:          b.currentCoordinates.GetGtidForThisTx(),
+		CurrentSchema: currentSchema,
+		Queries:       []string{query},
+	}
+	addTable := func(schema string, table string) {
+		historyTable := &SchemaHistoryTable{
+			TableSchema: schema,
+			TableName:   table,
+		}
+		if columns, err := base.GetTableColumnsSqle(b.context, schema, table); err == nil {
+			historyTable.Columns = columns
+		}
+		record.Tables = append(record.Tables, historyTable)
+	}
+	for i := range ddlInfo.tables {
+		if ddlInfo.tables[i].Table == "" {
+			continue
+		}
+		addTable(utils.StringElse(ddlInfo.tables[i].Schema, currentSchema), ddlInfo.tables[i].Table)
+	}
+	switch realAst := ddlInfo.ast.(type) {
+	case *ast.RenameTableStmt:
+		for _, t2t := range realAst.TableToTables {
+			addTable(utils.StringElse(t2t.NewTable.Schema.O, currentSchema), t2t.NewTable.Name.O)
+		}
+	case *ast.AlterTableStmt:
+		for _, spec := range realAst.Specs {
+			if spec.Tp == ast.AlterTableRenameTable {
+				addTable(utils.StringElse(spec.NewTable.Schema.O, currentSchema), spec.NewTable.Name.O)
+			}
+		}
+	}
+
+	if err := b.schemaHistory.Append(record); err != nil {
+		b.logger.Errorf("mysql.reader: failed to append schema history. err: %v", err)
+		return err
+	}
+	return nil
+}
+
+var (
+	// gh-ost: _tbl_gho (ghost), _tbl_ghc (changelog), _tbl_del (the original table after cut-over)
+	ghostTableRegex = regexp.MustCompile("^_(.+)_(gho|ghc|del)$")
+	// pt-online-schema-change: _tbl_new (ghost), _tbl_old (the original table after cut-over)
+	ptOscTableRegex = regexp.MustCompile("^_(.+)_(new|old)$")
+)
+
+const (
+	ptOscTriggerPrefix = "pt_osc_"
+
+	onlineDDLToolGhost = "gh-ost"
+	onlineDDLToolPtOsc = "pt-online-schema-change"
+)
+
+// onlineDDLTableName checks if a table is named as one created by gh-ost or pt-online-schema-change
+// for a replicated table. A table replicated itself is not considered.
+// isGhost is true for the table being altered, which replaces the origin table on cut-over.
+func (b *BinlogReader) onlineDDLTableName(schema string, table string) (origin string, tool string, isGhost bool, ok bool) {
+	if _, replicated := b.tables[schema][table]; replicated {
+		return "", "", false, false
+	}
+	if m := ghostTableRegex.FindStringSubmatch(table); m != nil {
+		if _, replicated := b.tables[schema][m[1]]; replicated {
+			return m[1], onlineDDLToolGhost, m[2] == "gho", true
+		}
+	}
+	if m := ptOscTableRegex.FindStringSubmatch(table); m != nil {
+		if _, replicated := b.tables[schema][m[1]]; replicated {
+			return m[1], onlineDDLToolPtOsc, m[2] == "new", true
+		}
+	}
+	return "", "", false, false
+}
+
+// matchOnlineDDLTable checks if a table is created by gh-ost or pt-online-schema-change for a replicated table.
+// Besides the name, the tool must be evidenced on the origin table (see recordOnlineDDLTool).
+func (b *BinlogReader) matchOnlineDDLTable(schema string, table string) (origin string, isGhost bool, ok bool) {
+	origin, tool, isGhost, ok := b.onlineDDLTableName(schema, table)
+	if !ok {
+		return "", false, false
+	}
+	// The changelog table of gh-ost is the evidence itself.
+	if table != fmt.Sprintf("_%s_ghc", origin) && b.onlineDDLTools[onlineDDLKey(schema, origin)] != tool {
+		return "", false, false
+	}
+	return origin, isGhost, true
+}
+
+// recordOnlineDDLTool records (or removes) the evidence of an online DDL tool on a replicated table, with a DDL on table.
+// The evidence of gh-ost is its changelog table, and that of pt-online-schema-change is its triggers on the origin table.
+func (b *BinlogReader) recordOnlineDDLTool(ddlInfo parseDDLResult, schema string, table string) {
+	if ddlInfo.objectType == "trigger" && strings.HasPrefix(ddlInfo.objectName, ptOscTriggerPrefix) {
+		if table != "" {
+			// CREATE TRIGGER pt_osc_db_tbl_ins ... ON tbl
+			if _, replicated := b.tables[schema][table]; replicated {
+				b.onlineDDLTools[onlineDDLKey(schema, table)] = onlineDDLToolPtOsc
+			}
+			return
+		}
+		// DROP TRIGGER pt_osc_db_tbl_ins
+		origin := strings.TrimPrefix(ddlInfo.objectName, fmt.Sprintf("%s%s_", ptOscTriggerPrefix, schema))
+		if i := strings.LastIndex(origin, "_"); i > 0 {
+			key := onlineDDLKey(schema, origin[:i])
+			if b.onlineDDLTools[key] == onlineDDLToolPtOsc {
+				delete(b.onlineDDLTools, key)
+			}
+		}
+		return
+	}
+
+	m := ghostTableRegex.FindStringSubmatch(table)
+	if m == nil || m[2] != "ghc" {
+		return
+	}
+	if _, replicated := b.tables[schema][m[1]]; !replicated {
+		return
+	}
+	switch ddlInfo.ast.(type) {
+	case *ast.CreateTableStmt:
+		b.onlineDDLTools[onlineDDLKey(schema, m[1])] = onlineDDLToolGhost
+	case *ast.DropTableStmt:
+		delete(b.onlineDDLTools, onlineDDLKey(schema, m[1]))
+	}
+}
+
+// loadPtOscTriggers records pt-online-schema-change in progress on the source,
+// as its triggers are not in the schema history.
+func (b *BinlogReader) loadPtOscTriggers() error {
+	query := `select event_object_schema, event_object_table from information_schema.triggers
+		where trigger_name like 'pt\\_osc\\_%'`
+	rows, err := b.db.Query(query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var schema, table string
+		if err := rows.Scan(&schema, &table); err != nil {
+			return err
+		}
+		if _, replicated := b.tables[schema][table]; replicated {
+			b.onlineDDLTools[onlineDDLKey(schema, table)] = onlineDDLToolPtOsc
+		}
+	}
+	return rows.Err()
+}
+
+func onlineDDLKey(schema string, table string) string {
+	return fmt.Sprintf("%s.%s", schema, table)
+}
+
+// handleOnlineDDLTable handles a DDL on a table of an online DDL tool. The DDL itself is not replicated.
+// ALTERs on the ghost table are kept, and will be applied to the origin table on cut-over.
+func (b *BinlogReader) handleOnlineDDLTable(stmt ast.StmtNode, schema string, origin string, isGhost bool) {
+	b.logger.Debugf("mysql.reader: skip ddl on online ddl table. origin table: %v.%v", schema, origin)
+	if !isGhost {
+		return
+	}
+	key := onlineDDLKey(schema, origin)
+	switch realAst := stmt.(type) {
+	case *ast.CreateTableStmt:
+		b.onlineDDLAlters[key] = nil
+	case *ast.AlterTableStmt:
+		b.onlineDDLAlters[key] = append(b.onlineDDLAlters[key], realAst)
+	case *ast.DropTableStmt:
+		// migration cancelled
+		delete(b.onlineDDLAlters, key)
+	}
+}
+
+// loadOnlineDDLAlters rebuilds onlineDDLAlters with the DDLs in the schema history,
+// which has been truncated to the binlog start position.
+func (b *BinlogReader) loadOnlineDDLAlters() error {
+	return b.schemaHistory.ForEachDDL(func(currentSchema string, query string) error {
+		ddlInfo, err := resolveDDLSQL(query)
+		if err != nil {
+			return fmt.Errorf("failed to parse query in schema history. query: %v, err: %v", query, err)
+		}
+		for i := range ddlInfo.tables {
+			schema := utils.StringElse(ddlInfo.tables[i].Schema, currentSchema)
+			b.recordOnlineDDLTool(ddlInfo, schema, ddlInfo.tables[i].Table)
+			origin, _, isGhost, ok := b.onlineDDLTableName(schema, ddlInfo.tables[i].Table)
+			if !ok {
+				continue
+			}
+			if renameAst, isRename := ddlInfo.ast.(*ast.RenameTableStmt); isRename {
+				if isGhost && renameAst.TableToTables[i].NewTable.Name.O == origin {
+					// cut-over done
+					delete(b.onlineDDLAlters, onlineDDLKey(schema, origin))
+				}
+				continue
+			}
+			b.handleOnlineDDLTable(ddlInfo.ast, schema, origin, isGhost)
+		}
+		return nil
+	})
+}
+
+// handleOnlineDDLRename handles the cut-over of an online DDL tool, i.e. `origin TO _origin_del, _origin_gho TO origin`.
+// The returned events are the ALTERs on the ghost table, applied to the origin table.
+// isOnlineDDL is false if the rename is not a cut-over.
+func (b *BinlogReader) handleOnlineDDLRename(oldSchema string, oldTable string, newSchema string, newTable string) (
+	events []DataEvent, isOnlineDDL bool, err error) {
+
+	if oldSchema != newSchema {
+		return nil, false, nil
+	}
+	if origin, isGhost, ok := b.matchOnlineDDLTable(newSchema, newTable); ok && !isGhost && origin == oldTable {
+		// The origin table is moved away. Keep it in b.tables for the ghost table.
+		return nil, true, nil
+	}
+	origin, isGhost, ok := b.matchOnlineDDLTable(oldSchema, oldTable)
+	if !ok || !isGhost || origin != newTable {
+		return nil, false, nil
+	}
+
+	b.logger.Infof("mysql.reader: online ddl cut-over. table: %v.%v", newSchema, newTable)
+	// The Where and column spec of the origin table are kept.
+	if err := b.updateTableMeta(b.tables[newSchema][newTable].Table, newSchema, newTable); err != nil {
+		return nil, true, err
+	}
+
+	key := onlineDDLKey(newSchema, newTable)
+	alters, found := b.onlineDDLAlters[key]
+	delete(b.onlineDDLAlters, key)
+	if !found {
+		// The migration started before the job or the schema history.
+		if !b.sqlFilter.NoDDL && !b.sqlFilter.NoDDLAlterTable {
+			return nil, true, fmt.Errorf("ALTERs of the online ddl on %v.%v are unknown. alter the dest table"+
+				" in the same way, and restart the job with SqlFilter NoDDLAlterTable until the cut-over is replicated",
+				newSchema, newTable)
+		}
+		b.logger.Warnf("mysql.reader: ALTERs of the online ddl on %v.%v are unknown. the dest table is not altered",
+			newSchema, newTable)
+	}
+	mappedSchema, mappedTable := b.mappedTableName(newSchema, newTable)
+	for _, alter := range alters {
+		stmt := *alter
+		stmt.Table = &ast.TableName{Schema: model.NewCIStr(mappedSchema), Name: model.NewCIStr(mappedTable)}
+		buf := bytes.NewBuffer(nil)
+		if err := stmt.Restore(format.NewRestoreCtx(format.DefaultRestoreFlags, buf)); err != nil {
+			return nil, true, err
+		}
+		b.logger.Debugf("mysql.reader: online ddl. alter: %v", buf.String())
+		events = append(events, NewQueryEventAffectTable("", buf.String(), NotDML,
+			SchemaTable{Schema: mappedSchema, Table: mappedTable}))
+	}
+	return events, true, nil
+}
+
+// originUuid is the server uuid where the current tx is originally executed.
+// It is the source of the dtle job if the tx is executed by a dtle applier.
+func (b *BinlogReader) originUuid() string {
+	if b.currentBinlogEntry.Coordinates.OSID != "" {
+		return b.currentBinlogEntry.Coordinates.OSID
+	}
+	return b.currentBinlogEntry.Coordinates.SID.String()
+}
+
+// applyOriginUuidRules applies OriginUuidRules to the current entry before sending it.
+// The events of a skipped tx are removed, but the entry is still sent, so that its GTID is recorded as executed.
+func (b *BinlogReader) applyOriginUuidRules() {
+	rules := b.mysqlContext.OriginUuidRules
+	if len(rules) == 0 {
+		return
+	}
+	origin := b.originUuid()
+	var matched *config.OriginUuidRule
+	hasInclude := false
+	for _, rule := range rules {
+		if rule.Action == config.OriginUuidInclude {
+			hasInclude = true
+		}
+		if rule.ServerUuid == "*" || strings.EqualFold(rule.ServerUuid, origin) {
+			matched = rule
+			break
+		}
+	}
+
+	skip := false
+	switch {
+	case matched == nil:
+		skip = hasInclude
+	case matched.Action == config.OriginUuidExclude:
+		skip = true
+	case matched.Action == config.OriginUuidRoute:
+		b.currentBinlogEntry.RouteTo = matched.RouteTo
+		b.logger.Debugf("mysql.reader: route a tx by OriginUuidRules. origin: %v, gtid: %v, to: %v",
+			origin, b.currentCoordinates.GetGtidForThisTx(), matched.RouteTo)
+	}
+	if skip {
+		b.logger.Debugf("mysql.reader: skip a tx by OriginUuidRules. origin: %v, gtid: %v",
+			origin, b.currentCoordinates.GetGtidForThisTx())
+		b.currentBinlogEntry.Events = nil
+		b.currentBinlogEntry.RowsQueries = nil
+		b.currentBinlogEntry.DiscardSpill()
+	}
+}
+
+// findTableConfig finds the schema and table in ReplicateDoDb. Either might be nil.
+func (b *BinlogReader) findTableConfig(schemaName string, tableName string) (schema *config.DataSource, table *config.Table) {
+	for i := range b.mysqlContext.ReplicateDoDb {
+		if b.mysqlContext.ReplicateDoDb[i].TableSchema == schemaName {
+			schema = b.mysqlContext.ReplicateDoDb[i]
+			for j := range b.mysqlContext.ReplicateDoDb[i].Tables {
+				if b.mysqlContext.ReplicateDoDb[i].Tables[j].TableName == tableName {
+					table = b.mysqlContext.ReplicateDoDb[i].Tables[j]
+				}
+			}
+		}
+	}
+	return schema, table
+}
+
+// mappedTableName applies the schema and table rename rules.
+func (b *BinlogReader) mappedTableName(schemaName string, tableName string) (string, string) {
+	schema, table := b.findTableConfig(schemaName, tableName)
+	if schema != nil && schema.TableSchemaRename != "" {
+		schemaName = schema.TableSchemaRename
+	}
+	if table != nil && table.TableRename != "" {
+		tableName = table.TableRename
+	}
+	return schemaName, tableName
+}
+
+// renameTablePair is a `old TO new` pair of RENAME TABLE, with the names on the dest.
+type renameTablePair struct {
+	oldSchema string
+	oldTable  string
+	newSchema string
+	newTable  string
+}
+
+// newRenameTableEvent renames the pairs in one statement.
+func newRenameTableEvent(pairs []renameTablePair) DataEvent {
+	toParts := make([]string, len(pairs))
+	for i, pair := range pairs {
+		toParts[i] = fmt.Sprintf("%s.%s to %s.%s",
+			mysql.EscapeName(pair.oldSchema), mysql.EscapeName(pair.oldTable),
+			mysql.EscapeName(pair.newSchema), mysql.EscapeName(pair.newTable))
+	}
+	query := fmt.Sprintf("rename table %s", strings.Join(toParts, ", "))
+	// The applier resets the cached affected table, all tables of the affected schema if the table is empty,
+	// or all tables if both are empty.
+	affected := SchemaTable{Schema: pairs[0].oldSchema, Table: pairs[0].oldTable}
+	for _, pair := range pairs[1:] {
+		if pair.oldSchema != affected.Schema || pair.newSchema != affected.Schema {
+			affected = SchemaTable{}
+			break
+		}
+		affected.Table = ""
+	}
+	return NewQueryEventAffectTable("", query, NotDML, affected)
+}
+
+// handleRenameTable handles a `old TO new` pair of RENAME TABLE, and updates b.tables.
+// The pair is returned if the table is renamed on the dest, or the event if the table is created on the dest.
+// Both are nil if there is nothing to apply on the dest.
+func (b *BinlogReader) handleRenameTable(oldSchema string, oldTable string, newSchema string, newTable string) (
+	*renameTablePair, *DataEvent, error) {
+
+	_ = b.checkObjectFitRegexp(b.mysqlContext.ReplicateDoDb, oldSchema, oldTable)
+	_ = b.checkObjectFitRegexp(b.mysqlContext.ReplicateDoDb, newSchema, newTable)
+	oldReplicated := !b.skipQueryDDL("", oldSchema, oldTable)
+	newReplicated := !b.skipQueryDDL("", newSchema, newTable)
+	b.logger.Debugf("mysql.reader: rename table %v.%v (replicated: %v) to %v.%v (replicated: %v)",
+		oldSchema, oldTable, oldReplicated, newSchema, newTable, newReplicated)
+
+	delete(b.getDbTableMap(oldSchema), oldTable)
+	if newReplicated {
+		_, table := b.findTableConfig(newSchema, newTable)
+		if err := b.updateTableMeta(table, newSchema, newTable); err != nil {
+			if oldReplicated {
+				return nil, nil, err
+			}
+			b.logger.Warnf("mysql.reader: cannot get table info of %v.%v after rename. err: %v", newSchema, newTable, err)
+		}
+	}
+
+	mappedOldSchema, mappedOldTable := b.mappedTableName(oldSchema, oldTable)
+	mappedNewSchema, mappedNewTable := b.mappedTableName(newSchema, newTable)
+	switch {
+	case oldReplicated && newReplicated:
+		return &renameTablePair{
+			oldSchema: mappedOldSchema,
+			oldTable:  mappedOldTable,
+			newSchema: mappedNewSchema,
+			newTable:  mappedNewTable,
+		}, nil, nil
+	case oldReplicated:
+		b.logger.Warnf("mysql.reader: table %v.%v is renamed to %v.%v, which is not replicated. the dest table is kept",
+			oldSchema, oldTable, newSchema, newTable)
+		return nil, nil, nil
+	case newReplicated:
+		b.logger.Warnf("mysql.reader: table %v.%v, which is not replicated, is renamed to %v.%v."+
+			" existing rows of the table are not copied", oldSchema, oldTable, newSchema, newTable)
+		query, err := b.showCreateTableInContext(newSchema, newTable, mappedNewSchema, mappedNewTable)
+		if err != nil {
+			b.logger.Warnf("mysql.reader: cannot create table %v.%v on dest. err: %v", newSchema, newTable, err)
+			return nil, nil, nil
+		}
+		event := NewQueryEventAffectTable("", query, NotDML, SchemaTable{Schema: mappedNewSchema, Table: mappedNewTable})
+		return nil, &event, nil
+	default:
+		return nil, nil, nil
+	}
+}
+
+// showCreateTableInContext builds a `CREATE TABLE IF NOT EXISTS` for the dest from the table def in the sqle context.
+func (b *BinlogReader) showCreateTableInContext(schemaName string, tableName string,
+	destSchema string, destTable string) (string, error) {
+
+	query, err := restoreCreateTableSqle(b.context, schemaName, tableName, destSchema, destTable, true)
+	if err != nil {
+		return "", err
+	}
+
+	tableCtx := b.getDbTableMap(schemaName)[tableName]
+	if tableCtx != nil && len(tableCtx.Table.ColumnMap) > 0 {
+		fromNames := make([]string, len(tableCtx.Table.ColumnMap))
+		for i, idx := range tableCtx.Table.ColumnMap {
+			fromNames[i] = tableCtx.Table.OriginalTableColumns.Columns[idx].RawName
+		}
+		return base.MapCreateTableColumns(query, fromNames, tableCtx.Table.MappedTableColumns.Names())
+	}
+	return query, nil
+}
+
 func (b *BinlogReader) skipQueryDDL(sql string, schema string, tableName string) bool {
 	switch strings.ToLower(schema) {
 	case "mysql":
@@ -1025,12 +2011,6 @@ func skipQueryEvent(sql string) bool {
 	if strings.HasPrefix(sql, "create user") {
 		return true
 	}
-	if strings.HasPrefix(sql, "create function") {
-		return true
-	}
-	if strings.HasPrefix(sql, "create procedure") {
-		return true
-	}
 	if strings.HasPrefix(sql, "drop user") {
 		return true
 	}
@@ -1091,6 +2071,15 @@ func (b *BinlogReader) skipRowEvent(rowsEvent *replication.RowsEvent, dml EventD
 				// - Plan B: skip sending at applier: unnecessary sending
 				// - Plan A: skip sending at extractor: currently extractor does not know target mysql SID
 			}
+		} else if tableLower == g.HeartbeatTable && dml != DeleteDML && len(rowsEvent.Rows) > 0 {
+			// The rows are not replicated. The tx carries the timestamp.
+			// See Extractor.writeHeartbeat. The last row is the after image of an update.
+			row := rowsEvent.Rows[len(rowsEvent.Rows)-1]
+			if len(row) >= 2 && fmt.Sprintf("%s", row[0]) == b.execCtx.Subject {
+				if ts, ok := row[1].(int64); ok {
+					b.currentBinlogEntry.HeartbeatTs = ts
+				}
+			}
 		}
 		return true, nil
 	case "mysql":
@@ -1102,6 +2091,9 @@ func (b *BinlogReader) skipRowEvent(rowsEvent *replication.RowsEvent, dml EventD
 	case "sys", "information_schema", "performance_schema":
 		return true, nil
 	default:
+		if _, _, ok := b.matchOnlineDDLTable(string(rowsEvent.Table.Schema), tableOrigin); ok {
+			return true, nil
+		}
 		if len(b.tables) > 0 {
 			//if table in tartget Table, do this event
 			for schemaName, tableMap := range b.tables {
@@ -1270,7 +2262,10 @@ func (b *BinlogReader) updateTableMeta(table *config.Table, realSchema string, t
 		table.Where = "true"
 	}
 	table.OriginalTableColumns = columns
-	table.ColumnMap = config.BuildColumnMapIndex(table.ColumnMapFrom, table.OriginalTableColumns.Ordinals)
+	if err := table.BuildColumnMap(); err != nil {
+		b.logger.Warnf("updateTableMeta: cannot apply column spec after ddl. err: %v, table %v.%v", err.Error(), realSchema, tableName)
+		return err
+	}
 	tableMap := b.getDbTableMap(realSchema)
 	err = b.addTableToTableMap(tableMap, table)
 	if err != nil {
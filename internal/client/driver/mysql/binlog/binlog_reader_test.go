@@ -0,0 +1,375 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package binlog
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/actiontech/dtle/internal/config"
+	"github.com/actiontech/dtle/internal/config/mysql"
+	gomysql "github.com/siddontang/go-mysql/mysql"
+	"github.com/siddontang/go-mysql/replication"
+	"github.com/sirupsen/logrus"
+)
+
+// tracedError is like an error wrapped by errors.Trace of go-mysql.
+type tracedError struct {
+	cause error
+}
+
+func (e *tracedError) Error() string {
+	return e.cause.Error()
+}
+
+func (e *tracedError) Cause() error {
+	return e.cause
+}
+
+func TestIsConnectionError(t *testing.T) {
+	_, dialErr := net.Dial("tcp", "127.0.0.1:0")
+	if dialErr == nil {
+		t.Fatalf("dial 127.0.0.1:0 succeeded")
+	}
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"EOF", &StreamError{Err: io.EOF}, true},
+		{"bad conn", &StreamError{Err: gomysql.ErrBadConn}, true},
+		{"traced bad conn", &StreamError{Err: &tracedError{gomysql.ErrBadConn}}, true},
+		{"driver bad conn", &StreamError{Err: driver.ErrBadConn}, true},
+		{"dial", &StreamError{Err: &tracedError{dialErr}}, true},
+		{"server shutdown", &StreamError{Err: gomysql.NewError(gomysql.ER_SERVER_SHUTDOWN, "shutdown")}, true},
+		{"purged binlog", &StreamError{Err: gomysql.NewError(gomysql.ER_MASTER_FATAL_ERROR_READING_BINLOG, "purged")}, false},
+		{"parse", &StreamError{Err: fmt.Errorf("invalid event")}, false},
+		{"handle event", io.EOF, false},
+		{"nil", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsConnectionError(tt.err); got != tt.want {
+				t.Fatalf("IsConnectionError(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFailoverTo(t *testing.T) {
+	gtidSet, err := gomysql.ParseMysqlGTIDSet("3e11fa47-71ca-11e1-9e33-c80aa9429562:1-10")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+	unavailable := &mysql.ConnectionConfig{Host: "127.0.0.1", Port: port, User: "u", Password: "p"}
+
+	tests := []struct {
+		name          string
+		binlogRelay   bool
+		gtidSet       *gomysql.MysqlGTIDSet
+		wantNewSyncer bool
+	}{
+		{"binlog relay", true, gtidSet.(*gomysql.MysqlGTIDSet), false},
+		{"no gtid", false, nil, false},
+		{"unavailable", false, gtidSet.(*gomysql.MysqlGTIDSet), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			oldSyncer := replication.NewBinlogSyncer(replication.BinlogSyncerConfig{ServerID: 100, Flavor: "mysql"})
+			b := &BinlogReader{
+				serverId:     100,
+				logger:       logrus.NewEntry(logrus.New()),
+				mysqlContext: &config.MySQLDriverConfig{BinlogRelay: tt.binlogRelay},
+				binlogSyncer: oldSyncer,
+				gtidSet:      tt.gtidSet,
+			}
+			if err := b.FailoverTo(unavailable); err == nil {
+				t.Fatalf("FailoverTo() succeeded")
+			}
+			// Without BinlogRelay and GTID, the streamer is kept.
+			if (b.binlogSyncer != oldSyncer) != tt.wantNewSyncer {
+				t.Fatalf("FailoverTo() replaced binlogSyncer: %v, want %v", b.binlogSyncer != oldSyncer, tt.wantNewSyncer)
+			}
+			if b.gtidSet != tt.gtidSet {
+				t.Fatalf("FailoverTo() modified gtidSet")
+			}
+		})
+	}
+}
+
+func TestMatchOnlineDDLTable(t *testing.T) {
+	b := &BinlogReader{
+		tables: map[string]map[string]*config.TableContext{
+			"db1": {"tb1": nil, "tb2": nil, "_tb2_old": nil},
+		},
+		onlineDDLTools: make(map[string]string),
+	}
+	type match struct {
+		table   string
+		origin  string
+		isGhost bool
+		ok      bool
+	}
+	steps := []struct {
+		ddl  string
+		want []match
+	}{
+		{"", []match{
+			// no tool evidenced
+			{"_tb1_gho", "", false, false},
+			{"_tb1_new", "", false, false},
+			// the changelog table of gh-ost is the evidence itself
+			{"_tb1_ghc", "tb1", false, true},
+			// not for a replicated table
+			{"_tb3_ghc", "", false, false},
+		}},
+		{"create table db1._tb1_ghc (id int)", []match{
+			{"_tb1_gho", "tb1", true, true},
+			{"_tb1_del", "tb1", false, true},
+			// the evidence is of another tool
+			{"_tb1_new", "", false, false},
+		}},
+		{"drop table db1._tb1_ghc", []match{
+			{"_tb1_gho", "", false, false},
+		}},
+		{"CREATE DEFINER=`root`@`%` TRIGGER `pt_osc_db1_tb2_ins` AFTER INSERT ON `db1`.`tb2` FOR EACH ROW " +
+			"REPLACE INTO `db1`.`_tb2_new` (`id`) VALUES (NEW.`id`)", []match{
+			{"_tb2_new", "tb2", true, true},
+			// replicated itself
+			{"_tb2_old", "", false, false},
+			{"_tb2_gho", "", false, false},
+		}},
+		{"DROP TRIGGER IF EXISTS `db1`.`pt_osc_db1_tb2_ins`", []match{
+			{"_tb2_new", "", false, false},
+		}},
+	}
+	for _, step := range steps {
+		if step.ddl != "" {
+			ddlInfo, err := resolveDDLSQL(step.ddl)
+			if err != nil {
+				t.Fatalf("resolveDDLSQL(%v) error: %v", step.ddl, err)
+			}
+			for i := range ddlInfo.tables {
+				b.recordOnlineDDLTool(ddlInfo, ddlInfo.tables[i].Schema, ddlInfo.tables[i].Table)
+			}
+		}
+		for _, want := range step.want {
+			origin, isGhost, ok := b.matchOnlineDDLTable("db1", want.table)
+			if origin != want.origin || isGhost != want.isGhost || ok != want.ok {
+				t.Fatalf("after %q, matchOnlineDDLTable(db1, %v) = %v, %v, %v, want %v, %v, %v", step.ddl, want.table,
+					origin, isGhost, ok, want.origin, want.isGhost, want.ok)
+			}
+		}
+	}
+
+	// Without the evidence, a rename like a cut-over is not taken as one.
+	if _, isOnlineDDL, err := b.handleOnlineDDLRename("db1", "_tb1_gho", "db1", "tb1"); isOnlineDDL || err != nil {
+		t.Fatalf("handleOnlineDDLRename() = %v, %v, want false", isOnlineDDL, err)
+	}
+}
+
+func TestFilterDataEvent(t *testing.T) {
+	b := &BinlogReader{logger: logrus.NewEntry(logrus.New())}
+	table := config.NewTable("db1", "tb1")
+	table.OriginalTableColumns = mysql.NewColumnList(mysql.NewColumns([]string{"id", "a"}))
+	whereCtx, err := config.NewWhereCtx("a > 10", table)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tbCtx := config.NewTableContext(table, whereCtx, nil)
+
+	// a row is nil if absent
+	row := func(a interface{}, present []bool) *mysql.ColumnValues {
+		if a == nil {
+			return nil
+		}
+		values := ToColumnValuesV2([]interface{}{1, a}, tbCtx)
+		values.Present = present
+		return values
+	}
+	minimal := []bool{true, false}
+	for i, c := range []struct {
+		dml       EventDML
+		before    interface{}
+		after     interface{}
+		present   []bool
+		whereTrue bool
+		wantDML   EventDML
+		wantErr   bool
+	}{
+		{InsertDML, nil, 11, nil, true, InsertDML, false},
+		{InsertDML, nil, 1, nil, false, InsertDML, false},
+		{DeleteDML, 11, nil, nil, true, DeleteDML, false},
+		{DeleteDML, 1, nil, nil, false, DeleteDML, false},
+		{UpdateDML, 11, 12, nil, true, UpdateDML, false},
+		{UpdateDML, 1, 2, nil, false, UpdateDML, false},
+		// enters the filter
+		{UpdateDML, 1, 11, nil, true, InsertDML, false},
+		// leaves the filter
+		{UpdateDML, 11, 1, nil, true, DeleteDML, false},
+		// not crossing the filter, the partial row image is fine
+		{UpdateDML, 11, 12, minimal, true, UpdateDML, false},
+		{UpdateDML, 1, 11, minimal, false, UpdateDML, true},
+		{UpdateDML, 11, 1, minimal, false, UpdateDML, true},
+	} {
+		dmlEvent := NewDataEvent("db1", "tb1", c.dml, 2)
+		dmlEvent.WhereColumnValues = row(c.before, c.present)
+		dmlEvent.NewColumnValues = row(c.after, c.present)
+		whereTrue, err := b.filterDataEvent(tbCtx, &dmlEvent)
+		if c.wantErr {
+			if err == nil {
+				t.Fatalf("case %v: expect an error for the partial row image", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("case %v: %v", i, err)
+		}
+		if whereTrue != c.whereTrue || dmlEvent.DML != c.wantDML {
+			t.Fatalf("case %v: got %v %v, want %v %v", i, whereTrue, dmlEvent.DML, c.whereTrue, c.wantDML)
+		}
+		// An insert has only the after image, and a delete only the before image.
+		switch dmlEvent.DML {
+		case InsertDML:
+			if dmlEvent.WhereColumnValues != nil || dmlEvent.NewColumnValues == nil {
+				t.Fatalf("case %v: an insert with the before image", i)
+			}
+		case DeleteDML:
+			if dmlEvent.NewColumnValues != nil || dmlEvent.WhereColumnValues == nil {
+				t.Fatalf("case %v: a delete with the after image", i)
+			}
+		}
+	}
+
+	// no filter
+	dmlEvent := NewDataEvent("db1", "tb1", UpdateDML, 2)
+	if whereTrue, err := b.filterDataEvent(nil, &dmlEvent); !whereTrue || err != nil {
+		t.Fatalf("got %v %v without a table config", whereTrue, err)
+	}
+}
+
+func TestRenameViewSchema(t *testing.T) {
+	const restoredView = "CREATE ALGORITHM = UNDEFINED DEFINER = CURRENT_USER SQL SECURITY DEFINER VIEW "
+	for _, c := range []struct {
+		sql  string
+		want string
+	}{
+		{"create view db1.v1 as select db1.tb1.a, b from db1.tb1 join db2.tb2 on db1.tb1.id = tb2.id",
+			restoredView + "`db1_new`.`v1` AS SELECT `db1_new`.`tb1`.`a`,`b` FROM `db1_new`.`tb1` " +
+				"JOIN `db2`.`tb2` ON `db1_new`.`tb1`.`id`=`tb2`.`id`"},
+		// subqueries
+		{"create view `db1`.`v1` as select * from (select a from db1.tb1) t where a in (select a from db1.tb3)",
+			restoredView + "`db1_new`.`v1` AS SELECT * FROM (SELECT `a` FROM (`db1_new`.`tb1`)) AS `t` " +
+				"WHERE `a` IN (SELECT `a` FROM `db1_new`.`tb3`)"},
+		// not qualified
+		{"create view v1 as select a from tb1", restoredView + "`v1` AS SELECT `a` FROM `tb1`"},
+		{"drop view db1.v1, db2.v2", "DROP VIEW `db1_new`.`v1`, `db2`.`v2`"},
+	} {
+		got, err := renameViewSchema(c.sql, "db1", "db1_new")
+		if err != nil {
+			t.Fatalf("%v: %v", c.sql, err)
+		}
+		if got != c.want {
+			t.Fatalf("%v:\ngot  %v\nwant %v", c.sql, got, c.want)
+		}
+	}
+	if _, err := renameViewSchema("create view v1 as bad", "db1", "db1_new"); err == nil {
+		t.Fatalf("expect an error for a bad sql")
+	}
+}
+
+func TestRenameObjectSchema(t *testing.T) {
+	for _, c := range []struct {
+		sql     string
+		want    string
+		wantErr bool
+	}{
+		{"CREATE DEFINER=`root`@`%` TRIGGER `db1`.`tr1` BEFORE INSERT ON `db1`.`tb1` FOR EACH ROW SET NEW.a = 1",
+			"CREATE DEFINER=`root`@`%` TRIGGER `db1_new`.`tr1` BEFORE INSERT ON `db1_new`.`tb1` FOR EACH ROW SET NEW.a = 1",
+			false},
+		{"create trigger tr1 before insert on tb1 for each row set new.a = 1",
+			"create trigger tr1 before insert on tb1 for each row set new.a = 1", false},
+		// a schema only prefixed by the old one
+		{"create function db1.f1() returns int return (select count(*) from tb1 where db1x.a = 1)",
+			"create function `db1_new`.f1() returns int return (select count(*) from tb1 where db1x.a = 1)", false},
+		{"drop procedure if exists db1.p1", "drop procedure if exists `db1_new`.p1", false},
+		{"drop trigger db2.tr1", "drop trigger db2.tr1", false},
+		// the body refers to the schema
+		{"create procedure db1.p1() begin select * from db1.tb1; end", "", true},
+		{"create procedure db1.p1() begin select * from `db1` . tb1; end", "", true},
+	} {
+		got, err := renameObjectSchema(c.sql, "db1", "db1_new")
+		if c.wantErr {
+			if err == nil {
+				t.Fatalf("%v: expect an error, got %v", c.sql, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%v: %v", c.sql, err)
+		}
+		if got != c.want {
+			t.Fatalf("%v:\ngot  %v\nwant %v", c.sql, got, c.want)
+		}
+	}
+}
+
+func TestAddRowsQuery(t *testing.T) {
+	b := &BinlogReader{currentBinlogEntry: &BinlogEntry{}}
+	var events []DataEvent
+	addEvent := func() {
+		dmlEvent := NewDataEvent("db1", "tb1", InsertDML, 1)
+		b.addRowsQuery(&dmlEvent)
+		events = append(events, dmlEvent)
+	}
+
+	// binlog_rows_query_log_events=OFF
+	addEvent()
+	b.setRowsQuery("insert into tb1 values (1), (2)")
+	addEvent()
+	addEvent()
+	// all rows of the statement are filtered out
+	b.setRowsQuery("insert into tb1 values (3)")
+	b.setRowsQuery("update tb1 set a = 1")
+	addEvent()
+	// the same statement as the last
+	b.setRowsQuery("update tb1 set a = 1")
+	addEvent()
+
+	wantQueries := []string{"insert into tb1 values (1), (2)", "update tb1 set a = 1", "update tb1 set a = 1"}
+	if !reflect.DeepEqual(b.currentBinlogEntry.RowsQueries, wantQueries) {
+		t.Fatalf("got %q, want %q", b.currentBinlogEntry.RowsQueries, wantQueries)
+	}
+	wantRowsQuery := []string{"", wantQueries[0], wantQueries[0], wantQueries[1], wantQueries[2]}
+	for i := range events {
+		if got := b.currentBinlogEntry.RowsQueryOf(&events[i]); got != wantRowsQuery[i] {
+			t.Fatalf("event %v: got %q, want %q", i, got, wantRowsQuery[i])
+		}
+	}
+	if events[4].RowsQueryNo != 3 {
+		t.Fatalf("got RowsQueryNo %v, want 3", events[4].RowsQueryNo)
+	}
+
+	// a new tx
+	b.currentBinlogEntry = &BinlogEntry{}
+	b.setRowsQuery("")
+	addEvent()
+	if len(b.currentBinlogEntry.RowsQueries) != 0 || events[5].RowsQueryNo != 0 {
+		t.Fatalf("the statement of the last tx is added")
+	}
+}
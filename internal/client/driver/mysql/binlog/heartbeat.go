@@ -0,0 +1,62 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package binlog
+
+import (
+	"sync"
+	"time"
+
+	"github.com/actiontech/dtle/internal/models"
+)
+
+// HeartbeatTracker computes the end-to-end replication lag from the heartbeats applied on the dest.
+// A heartbeat is a row written by the extractor into the heartbeat table on the source, see BinlogEntry.HeartbeatTs.
+type HeartbeatTracker struct {
+	lock sync.Mutex
+	// unix microseconds
+	lastSourceTs  int64
+	lastAppliedTs int64
+}
+
+// OnApplied is called after the tx carrying the heartbeat is committed on the dest (or sent to kafka).
+// A heartbeat older than the last one is ignored, e.g. applied late by a parallel worker.
+func (t *HeartbeatTracker) OnApplied(sourceTs int64) {
+	t.onApplied(sourceTs, time.Now().UnixNano()/1000)
+}
+
+func (t *HeartbeatTracker) onApplied(sourceTs int64, appliedTs int64) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if sourceTs < t.lastSourceTs {
+		return
+	}
+	t.lastSourceTs = sourceTs
+	t.lastAppliedTs = appliedTs
+}
+
+// Stat returns nil if no heartbeat has been applied.
+func (t *HeartbeatTracker) Stat() *models.HeartbeatLag {
+	return t.stat(time.Now().UnixNano() / 1000)
+}
+
+func (t *HeartbeatTracker) stat(now int64) *models.HeartbeatLag {
+	t.lock.Lock()
+	sourceTs, appliedTs := t.lastSourceTs, t.lastAppliedTs
+	t.lock.Unlock()
+	if appliedTs == 0 {
+		return nil
+	}
+	lag := (appliedTs - sourceTs) / 1000
+	if lag < 0 {
+		// clock skew between the source and dtle
+		lag = 0
+	}
+	return &models.HeartbeatLag{
+		Lag:       lag,
+		SinceLast: (now - appliedTs) / 1000,
+	}
+}
@@ -0,0 +1,55 @@
+package binlog
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/actiontech/dtle/internal/models"
+)
+
+func TestHeartbeatTracker(t *testing.T) {
+	tracker := &HeartbeatTracker{}
+	if lag := tracker.Stat(); lag != nil {
+		t.Fatalf("got %+v before any heartbeat", lag)
+	}
+
+	// unix microseconds
+	tracker.onApplied(1000000, 1250000)
+	if got, want := tracker.stat(3250000), (&models.HeartbeatLag{Lag: 250, SinceLast: 2000}); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+	// An older heartbeat is ignored.
+	tracker.onApplied(500000, 4000000)
+	if got, want := tracker.stat(4000000), (&models.HeartbeatLag{Lag: 250, SinceLast: 2750}); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+	tracker.onApplied(2000000, 2100000)
+	if got, want := tracker.stat(2100000), (&models.HeartbeatLag{Lag: 100, SinceLast: 0}); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+	// clock skew
+	tracker.onApplied(3000000, 2900000)
+	if got := tracker.stat(2900000); got.Lag != 0 {
+		t.Fatalf("got lag %v with the source clock ahead, want 0", got.Lag)
+	}
+
+	// The source ts and the applied ts are from the same heartbeat.
+	tracker = &HeartbeatTracker{}
+	wg := sync.WaitGroup{}
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := int64(1); j <= 1000; j++ {
+				ts := j*1000000 + int64(i)
+				tracker.onApplied(ts, ts+100000)
+				if lag := tracker.Stat(); lag == nil || lag.Lag != 100 {
+					t.Errorf("got %+v, want lag 100", lag)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+}
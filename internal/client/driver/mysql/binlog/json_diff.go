@@ -0,0 +1,220 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package binlog
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/siddontang/go-mysql/replication"
+
+	"github.com/actiontech/dtle/internal/config/mysql"
+)
+
+func init() {
+	// An unresolved partial JSON update is sent as the column value.
+	gob.Register(replication.JsonDiffs{})
+}
+
+// jsonPathLeg is a member name (of an object) or an index (of an array).
+type jsonPathLeg struct {
+	member  string
+	index   int
+	isIndex bool
+}
+
+// parseJsonDiffPath parses a path of a JSON diff, e.g. `$.a."b c"[1]`.
+// Paths in the diffs are always concrete, without wildcards or ranges.
+func parseJsonDiffPath(path string) ([]jsonPathLeg, error) {
+	if !strings.HasPrefix(path, "$") {
+		return nil, fmt.Errorf("bad JSON path %v", path)
+	}
+	var legs []jsonPathLeg
+	s := path[1:]
+	for len(s) > 0 {
+		switch s[0] {
+		case '.':
+			s = s[1:]
+			if strings.HasPrefix(s, `"`) {
+				end := 1
+				for ; end < len(s); end++ {
+					if s[end] == '\\' {
+						end++
+					} else if s[end] == '"' {
+						break
+					}
+				}
+				if end >= len(s) {
+					return nil, fmt.Errorf("bad JSON path %v", path)
+				}
+				member, err := strconv.Unquote(s[:end+1])
+				if err != nil {
+					return nil, fmt.Errorf("bad JSON path %v: %v", path, err)
+				}
+				legs = append(legs, jsonPathLeg{member: member})
+				s = s[end+1:]
+			} else {
+				end := strings.IndexAny(s, ".[")
+				if end < 0 {
+					end = len(s)
+				}
+				legs = append(legs, jsonPathLeg{member: s[:end]})
+				s = s[end:]
+			}
+		case '[':
+			end := strings.IndexByte(s, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("bad JSON path %v", path)
+			}
+			index, err := strconv.Atoi(strings.TrimSpace(s[1:end]))
+			if err != nil {
+				return nil, fmt.Errorf("bad JSON path %v: %v", path, err)
+			}
+			legs = append(legs, jsonPathLeg{index: index, isIndex: true})
+			s = s[end+1:]
+		default:
+			return nil, fmt.Errorf("bad JSON path %v", path)
+		}
+	}
+	return legs, nil
+}
+
+func decodeJsonText(text []byte) (interface{}, error) {
+	var v interface{}
+	decoder := json.NewDecoder(bytes.NewReader(text))
+	decoder.UseNumber()
+	if err := decoder.Decode(&v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// applyJsonDiff returns the document modified by the diff at legs.
+func applyJsonDiff(doc interface{}, legs []jsonPathLeg, op replication.JsonDiffOperation, value interface{}) (interface{}, error) {
+	if len(legs) == 0 {
+		if op == replication.JsonDiffOperationReplace {
+			return value, nil
+		}
+		return nil, fmt.Errorf("cannot %v the JSON document itself", op)
+	}
+	leg := legs[0]
+	last := len(legs) == 1
+	if leg.isIndex {
+		array, ok := doc.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("not a JSON array at [%v]", leg.index)
+		}
+		if last && op == replication.JsonDiffOperationInsert {
+			i := leg.index
+			if i > len(array) {
+				i = len(array)
+			}
+			array = append(array, nil)
+			copy(array[i+1:], array[i:])
+			array[i] = value
+			return array, nil
+		}
+		if leg.index < 0 || leg.index >= len(array) {
+			return nil, fmt.Errorf("JSON array index %v out of range", leg.index)
+		}
+		if last && op == replication.JsonDiffOperationRemove {
+			return append(array[:leg.index], array[leg.index+1:]...), nil
+		}
+		elem, err := applyJsonDiff(array[leg.index], legs[1:], op, value)
+		if err != nil {
+			return nil, err
+		}
+		array[leg.index] = elem
+		return array, nil
+	} else {
+		object, ok := doc.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("not a JSON object at .%v", leg.member)
+		}
+		if last {
+			switch op {
+			case replication.JsonDiffOperationRemove:
+				delete(object, leg.member)
+			default:
+				object[leg.member] = value
+			}
+			return object, nil
+		}
+		member, ok := object[leg.member]
+		if !ok {
+			return nil, fmt.Errorf("no JSON member %v", leg.member)
+		}
+		member, err := applyJsonDiff(member, legs[1:], op, value)
+		if err != nil {
+			return nil, err
+		}
+		object[leg.member] = member
+		return object, nil
+	}
+}
+
+// ApplyJsonDiffs applies the diffs of a partial JSON update to the document (in JSON text) in the before image,
+// and returns the document in the after image.
+func ApplyJsonDiffs(before []byte, diffs replication.JsonDiffs) ([]byte, error) {
+	doc, err := decodeJsonText(before)
+	if err != nil {
+		return nil, err
+	}
+	for _, diff := range diffs {
+		legs, err := parseJsonDiffPath(diff.Path)
+		if err != nil {
+			return nil, err
+		}
+		var value interface{}
+		if diff.Op != replication.JsonDiffOperationRemove {
+			value, err = decodeJsonText([]byte(diff.Value))
+			if err != nil {
+				return nil, err
+			}
+		}
+		doc, err = applyJsonDiff(doc, legs, diff.Op, value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to apply JSON diff %v: %v", diff, err)
+		}
+	}
+	return json.Marshal(doc)
+}
+
+// resolveJsonDiffs replaces the JSON diffs in the after image with the whole documents,
+// if the documents are in the before image (binlog_row_image=FULL).
+// Diffs which cannot be resolved are left to the applier.
+func resolveJsonDiffs(before *mysql.ColumnValues, after *mysql.ColumnValues) error {
+	for i, pValue := range after.AbstractValues {
+		diffs, ok := (*pValue).(replication.JsonDiffs)
+		if !ok {
+			continue
+		}
+		if i >= len(before.AbstractValues) || !before.IsPresent(i) {
+			continue
+		}
+		var doc []byte
+		switch v := (*before.AbstractValues[i]).(type) {
+		case []byte:
+			doc = v
+		case string:
+			doc = []byte(v)
+		default:
+			continue
+		}
+		newDoc, err := ApplyJsonDiffs(doc, diffs)
+		if err != nil {
+			return err
+		}
+		var newValue interface{} = newDoc
+		after.AbstractValues[i] = &newValue
+	}
+	return nil
+}
@@ -0,0 +1,295 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package binlog
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+
+	"github.com/pingcap/parser"
+	"github.com/pingcap/parser/ast"
+	"github.com/pingcap/parser/format"
+	"github.com/pingcap/parser/model"
+	gomysql "github.com/siddontang/go-mysql/mysql"
+
+	sqle "github.com/actiontech/dtle/internal/client/driver/mysql/sqle/inspector"
+	"github.com/actiontech/dtle/internal/config/mysql"
+)
+
+// SchemaHistoryTable is the definition of a table after a SchemaHistoryRecord.
+type SchemaHistoryTable struct {
+	TableSchema string
+	TableName   string
+	// nil if the table does not exist after the record
+	Columns *mysql.ColumnList
+}
+
+// SchemaHistoryRecord is a snapshot of the replicated tables, or a DDL in binlog.
+type SchemaHistoryRecord struct {
+	Snapshot bool
+	// The GTID set of a snapshot, or the GTID (sid:gno) of a DDL.
+	Gtid          string
+	CurrentSchema string
+	// CREATE TABLE statements for a snapshot, or the DDL.
+	Queries []string
+	Tables  []*SchemaHistoryTable
+}
+
+// SchemaHistory is the history of table definitions of a job. It is persisted in the state dir, one record per line.
+// Row events are decoded with the definitions at their position, so that a job restarted at (or replaying from)
+// an old GTID does not use the current definitions of the tables.
+type SchemaHistory struct {
+	Records []*SchemaHistoryRecord
+
+	path string
+	lock sync.Mutex
+}
+
+func GetSchemaHistoryPath(stateDir string, subject string) string {
+	return path.Join(stateDir, "schemahistory", subject, "history.jsonl")
+}
+
+func NewSchemaHistory(path string) *SchemaHistory {
+	return &SchemaHistory{
+		path: path,
+	}
+}
+
+// LoadSchemaHistory returns nil (without error) if there is no history.
+func LoadSchemaHistory(path string) (*SchemaHistory, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read schema history %s: %v", path, err)
+	}
+	h := NewSchemaHistory(path)
+	lines := bytes.Split(buf, []byte{'\n'})
+	for i, line := range lines {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		record := &SchemaHistoryRecord{}
+		if err := json.Unmarshal(line, record); err != nil {
+			if i == len(lines)-1 {
+				// the last record is partially written (on a crash). The DDL will be read from binlog again.
+				if err := h.persist(); err != nil {
+					return nil, err
+				}
+				break
+			}
+			return nil, fmt.Errorf("failed to decode schema history %s at line %v: %v", path, i+1, err)
+		}
+		h.Records = append(h.Records, record)
+	}
+	return h, nil
+}
+
+// Append adds a record and persists it.
+func (h *SchemaHistory) Append(record *SchemaHistoryRecord) error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(h.path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(h.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	h.Records = append(h.Records, record)
+	return nil
+}
+
+// ForEachDDL calls fn with the DDLs (not the snapshots) in order.
+func (h *SchemaHistory) ForEachDDL(fn func(currentSchema string, query string) error) error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	for _, record := range h.Records {
+		if record.Snapshot {
+			continue
+		}
+		for _, query := range record.Queries {
+			if err := fn(record.CurrentSchema, query); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// persist rewrites the whole history.
+func (h *SchemaHistory) persist() error {
+	buf := bytes.NewBuffer(nil)
+	for _, record := range h.Records {
+		line, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	if err := os.MkdirAll(filepath.Dir(h.path), 0755); err != nil {
+		return err
+	}
+	tmpPath := h.path + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, buf.Bytes(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, h.path)
+}
+
+// Reset drops all records and starts the history over with the snapshot.
+func (h *SchemaHistory) Reset(snapshot *SchemaHistoryRecord) error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.Records = []*SchemaHistoryRecord{snapshot}
+	return h.persist()
+}
+
+// Truncate keeps the records as of gtidSet (executed), i.e. the last snapshot contained in gtidSet
+// and the DDLs in gtidSet after it. DDLs not in gtidSet will be read from binlog (and recorded) again.
+// It returns false if there is no such snapshot, and the history is not changed.
+func (h *SchemaHistory) Truncate(gtidSet string) (bool, error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	executed, err := gomysql.ParseMysqlGTIDSet(gtidSet)
+	if err != nil {
+		return false, err
+	}
+
+	iSnapshot := -1
+	for i, record := range h.Records {
+		if !record.Snapshot {
+			continue
+		}
+		snapshotSet, err := gomysql.ParseMysqlGTIDSet(record.Gtid)
+		if err != nil {
+			return false, fmt.Errorf("bad gtid set in schema history. gtid: %v, err: %v", record.Gtid, err)
+		}
+		if executed.Contain(snapshotSet) {
+			iSnapshot = i
+		}
+	}
+	if iSnapshot < 0 {
+		return false, nil
+	}
+
+	records := []*SchemaHistoryRecord{h.Records[iSnapshot]}
+	for _, record := range h.Records[iSnapshot+1:] {
+		if record.Snapshot {
+			continue
+		}
+		gtid, err := gomysql.ParseMysqlGTIDSet(record.Gtid)
+		if err != nil {
+			return false, fmt.Errorf("bad gtid in schema history. gtid: %v, err: %v", record.Gtid, err)
+		}
+		if executed.Contain(gtid) {
+			records = append(records, record)
+		}
+	}
+
+	if len(records) != len(h.Records) {
+		h.Records = records
+		if err := h.persist(); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// Replay applies the records to sqleContext, and returns the latest columns of each table.
+// Key of the result: schema, table. Value is nil for a dropped table.
+func (h *SchemaHistory) Replay(sqleContext *sqle.Context) (map[string]map[string]*mysql.ColumnList, error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	sqleContext.LoadSchemas(nil)
+	result := make(map[string]map[string]*mysql.ColumnList)
+	p := parser.New()
+	for _, record := range h.Records {
+		if record.Snapshot {
+			for _, table := range record.Tables {
+				sqleContext.AddSchema(table.TableSchema)
+				sqleContext.LoadTables(table.TableSchema, nil)
+			}
+		}
+		if record.CurrentSchema != "" {
+			sqleContext.UseSchema(record.CurrentSchema)
+		}
+		for _, query := range record.Queries {
+			stmt, err := p.ParseOneStmt(query, "", "")
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse query in schema history. gtid: %v, query: %v, err: %v",
+					record.Gtid, query, err)
+			}
+			sqleContext.UpdateContext(stmt, "mysql")
+			if createDb, ok := stmt.(*ast.CreateDatabaseStmt); ok {
+				sqleContext.LoadTables(createDb.Name, nil)
+			}
+		}
+		for _, table := range record.Tables {
+			tableMap, ok := result[table.TableSchema]
+			if !ok {
+				tableMap = make(map[string]*mysql.ColumnList)
+				result[table.TableSchema] = tableMap
+			}
+			tableMap[table.TableName] = table.Columns
+		}
+	}
+	return result, nil
+}
+
+// ShowCreateTableSqle restores the CREATE TABLE statement (qualified by the schema) of a table in sqleContext.
+func ShowCreateTableSqle(sqleContext *sqle.Context, schema string, table string) (string, error) {
+	return restoreCreateTableSqle(sqleContext, schema, table, schema, table, false)
+}
+
+func restoreCreateTableSqle(sqleContext *sqle.Context, schema string, table string,
+	destSchema string, destTable string, ifNotExists bool) (string, error) {
+
+	tableInfo, ok := sqleContext.GetTable(schema, table)
+	if !ok {
+		return "", fmt.Errorf("table does not exists in sqle context. table: %v.%v", schema, table)
+	}
+	cStmt := tableInfo.MergedTable
+	if cStmt == nil {
+		cStmt = tableInfo.OriginalTable
+	}
+	if cStmt == nil {
+		return "", fmt.Errorf("no table def in sqle context. table: %v.%v", schema, table)
+	}
+	stmt := *cStmt
+	stmt.IfNotExists = ifNotExists
+	stmt.Table = &ast.TableName{Schema: model.NewCIStr(destSchema), Name: model.NewCIStr(destTable)}
+	buf := bytes.NewBuffer(nil)
+	if err := stmt.Restore(format.NewRestoreCtx(format.DefaultRestoreFlags, buf)); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
@@ -0,0 +1,158 @@
+package binlog
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const schemaHistoryTestUuid = "3e11fa47-71ca-11e1-9e33-c80aa9429562"
+
+// newSchemaHistoryTestRecords returns a snapshot at :1-10, DDLs :11 and :12, a snapshot at :1-20 and a DDL :21.
+func newSchemaHistoryTestRecords() []*SchemaHistoryRecord {
+	return []*SchemaHistoryRecord{
+		{Snapshot: true, Gtid: schemaHistoryTestUuid + ":1-10", Queries: []string{"create table db1.tb1 (a int)"}},
+		{Gtid: schemaHistoryTestUuid + ":11", CurrentSchema: "db1", Queries: []string{"alter table tb1 add b int"}},
+		{Gtid: schemaHistoryTestUuid + ":12", CurrentSchema: "db1", Queries: []string{"alter table tb1 add c int"}},
+		{Snapshot: true, Gtid: schemaHistoryTestUuid + ":1-20", Queries: []string{"create table db1.tb1 (a int, b int)"}},
+		{Gtid: schemaHistoryTestUuid + ":21", CurrentSchema: "db1", Queries: []string{"drop table tb1"}},
+	}
+}
+
+func newSchemaHistoryTestFile(t *testing.T, dir string) (string, []*SchemaHistoryRecord) {
+	path := GetSchemaHistoryPath(dir, "job1")
+	h := NewSchemaHistory(path)
+	records := newSchemaHistoryTestRecords()
+	for _, record := range records {
+		if err := h.Append(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return path, records
+}
+
+func TestLoadSchemaHistory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dtle-schema-history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	h, err := LoadSchemaHistory(GetSchemaHistoryPath(dir, "none"))
+	if err != nil || h != nil {
+		t.Fatalf("got %v %v, want no history", h, err)
+	}
+
+	path, records := newSchemaHistoryTestFile(t, dir)
+	if filepath.Dir(path) != filepath.Join(dir, "schemahistory", "job1") {
+		t.Fatalf("unexpected path %v", path)
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the last record partially written
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte(`{"Snapshot":false,"Gtid":"` + schemaHistoryTestUuid)); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	h, err = LoadSchemaHistory(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(h.Records, records) {
+		t.Fatalf("got %v records, want %v", len(h.Records), len(records))
+	}
+	// The partial record is removed from the file, so a record appended later is on its own line.
+	recovered, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(recovered) != string(content) {
+		t.Fatalf("the partial record is not removed. got:\n%s", recovered)
+	}
+	record := &SchemaHistoryRecord{Gtid: schemaHistoryTestUuid + ":22", Queries: []string{"create table db1.tb2 (a int)"}}
+	if err := h.Append(record); err != nil {
+		t.Fatal(err)
+	}
+	h, err = LoadSchemaHistory(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := append(records, record); !reflect.DeepEqual(h.Records, want) {
+		t.Fatalf("got %v records, want %v", len(h.Records), len(want))
+	}
+
+	// A bad record which is not the last is an error.
+	if err := ioutil.WriteFile(path, append([]byte("{bad\n"), content...), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadSchemaHistory(path); err == nil {
+		t.Fatalf("expect an error for a bad record")
+	}
+}
+
+func TestSchemaHistoryTruncate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dtle-schema-history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, c := range []struct {
+		gtidSet string
+		ok      bool
+		// indexes of newSchemaHistoryTestRecords kept
+		want []int
+	}{
+		{schemaHistoryTestUuid + ":1-5", false, []int{0, 1, 2, 3, 4}},
+		{"", false, []int{0, 1, 2, 3, 4}},
+		{schemaHistoryTestUuid + ":1-10", true, []int{0}},
+		{schemaHistoryTestUuid + ":1-11", true, []int{0, 1}},
+		{schemaHistoryTestUuid + ":1-10:12", true, []int{0, 2}},
+		{schemaHistoryTestUuid + ":1-20", true, []int{3}},
+		{schemaHistoryTestUuid + ":1-30", true, []int{3, 4}},
+		{schemaHistoryTestUuid + ":1-30,4e11fa47-71ca-11e1-9e33-c80aa9429562:1-3", true, []int{3, 4}},
+	} {
+		os.RemoveAll(dir)
+		path, records := newSchemaHistoryTestFile(t, dir)
+		h, err := LoadSchemaHistory(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ok, err := h.Truncate(c.gtidSet)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok != c.ok {
+			t.Fatalf("%v: got %v, want %v", c.gtidSet, ok, c.ok)
+		}
+		var want []*SchemaHistoryRecord
+		for _, i := range c.want {
+			want = append(want, records[i])
+		}
+		if !reflect.DeepEqual(h.Records, want) {
+			t.Fatalf("%v: got %v records, want %v", c.gtidSet, len(h.Records), c.want)
+		}
+		// persisted
+		h, err = LoadSchemaHistory(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(h.Records, want) {
+			t.Fatalf("%v: got %v records after reload, want %v", c.gtidSet, len(h.Records), c.want)
+		}
+	}
+
+	h := NewSchemaHistory(GetSchemaHistoryPath(dir, "job1"))
+	if _, err := h.Truncate("bad"); err == nil {
+		t.Fatalf("expect an error for a bad gtid set")
+	}
+}
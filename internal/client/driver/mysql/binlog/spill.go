@@ -0,0 +1,162 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package binlog
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+
+	"github.com/actiontech/dtle/internal/client/driver/common"
+)
+
+// GetSpillDir returns the dir of the spill files of a job. side is "src" or "dest",
+// as both might be on the same agent.
+func GetSpillDir(stateDir string, subject string, side string) string {
+	return path.Join(stateDir, "spill", subject, side)
+}
+
+// ResetSpillDir removes the spill files left by a previous run of the task.
+func ResetSpillDir(dir string) error {
+	return os.RemoveAll(dir)
+}
+
+// SpillFile keeps the events of a big tx on the disk, so they need not be in memory all at once.
+// The events are written and read in chunks. A chunk is: length (4 bytes), BinlogEntry in WireVersionProto
+// with only the events.
+type SpillFile struct {
+	file   *os.File
+	writer *bufio.Writer
+	chunks int
+}
+
+func NewSpillFile(dir string) (*SpillFile, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	file, err := ioutil.TempFile(dir, "tx-")
+	if err != nil {
+		return nil, err
+	}
+	return &SpillFile{
+		file:   file,
+		writer: bufio.NewWriter(file),
+	}, nil
+}
+
+// Append writes events as a chunk.
+func (s *SpillFile) Append(events []DataEvent) error {
+	w := &common.WireWriter{}
+	for i := range events {
+		if err := w.Message(2, events[i].marshalWire); err != nil {
+			return err
+		}
+	}
+	var header [4]byte
+	binary.BigEndian.PutUint32(header[:], uint32(len(w.Bytes())))
+	if _, err := s.writer.Write(header[:]); err != nil {
+		return err
+	}
+	if _, err := s.writer.Write(w.Bytes()); err != nil {
+		return err
+	}
+	s.chunks += 1
+	return nil
+}
+
+func (s *SpillFile) Chunks() int {
+	return s.chunks
+}
+
+// ForEachChunk reads the chunks in the order they are written. Only one chunk is in memory at a time.
+func (s *SpillFile) ForEachChunk(fn func(events []DataEvent) error) error {
+	if err := s.writer.Flush(); err != nil {
+		return err
+	}
+	if _, err := s.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	// Append after this continues at the end.
+	defer s.file.Seek(0, io.SeekEnd)
+
+	reader := bufio.NewReader(s.file)
+	var header [4]byte
+	for i := 0; i < s.chunks; i++ {
+		if _, err := io.ReadFull(reader, header[:]); err != nil {
+			return err
+		}
+		data := make([]byte, binary.BigEndian.Uint32(header[:]))
+		if _, err := io.ReadFull(reader, data); err != nil {
+			return err
+		}
+		entry := &BinlogEntry{}
+		if err := entry.unmarshalWire(common.NewWireReader(data)); err != nil {
+			return err
+		}
+		if err := fn(entry.Events); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *SpillFile) Remove() {
+	s.file.Close()
+	os.Remove(s.file.Name())
+}
+
+// SpillEvents moves the events in memory to the spill file in dir, which is created on the first call.
+// The events are split into nChunks chunks of about the same number of events.
+func (b *BinlogEntry) SpillEvents(dir string, nChunks int) error {
+	if b.spill == nil {
+		spill, err := NewSpillFile(dir)
+		if err != nil {
+			return err
+		}
+		b.spill = spill
+	}
+	if nChunks < 1 {
+		nChunks = 1
+	}
+	chunkLen := (len(b.Events) + nChunks - 1) / nChunks
+	for start := 0; start < len(b.Events); start += chunkLen {
+		end := start + chunkLen
+		if end > len(b.Events) {
+			end = len(b.Events)
+		}
+		if err := b.spill.Append(b.Events[start:end]); err != nil {
+			return err
+		}
+	}
+	b.Events = make([]DataEvent, 0)
+	return nil
+}
+
+// Spill returns nil if no event is spilled.
+func (b *BinlogEntry) Spill() *SpillFile {
+	return b.spill
+}
+
+// ForEachEvents calls fn with the spilled events chunk by chunk, then with the events in memory.
+func (b *BinlogEntry) ForEachEvents(fn func(events []DataEvent) error) error {
+	if b.spill != nil {
+		if err := b.spill.ForEachChunk(fn); err != nil {
+			return err
+		}
+	}
+	return fn(b.Events)
+}
+
+// DiscardSpill removes the spill file, if any.
+func (b *BinlogEntry) DiscardSpill() {
+	if b.spill != nil {
+		b.spill.Remove()
+		b.spill = nil
+	}
+}
@@ -0,0 +1,148 @@
+package binlog
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/actiontech/dtle/internal/config/mysql"
+)
+
+func newSpillTestEvents(start int, n int) []DataEvent {
+	events := make([]DataEvent, n)
+	for i := range events {
+		events[i] = DataEvent{
+			DatabaseName: "db1",
+			TableName:    "tb1",
+			DML:          InsertDML,
+			ColumnCount:  1,
+			NewColumnValues: &mysql.ColumnValues{
+				AbstractValues: []*interface{}{valuePtr(int64(start + i))},
+			},
+			LogPos: int64(start + i),
+		}
+	}
+	return events
+}
+
+func TestSpillFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dtle-spill")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	spill, err := NewSpillFile(GetSpillDir(dir, "job1", "src"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer spill.Remove()
+	chunks := [][]DataEvent{newSpillTestEvents(0, 3), newSpillTestEvents(3, 1), newSpillTestEvents(4, 2)}
+	for _, events := range chunks[:2] {
+		if err := spill.Append(events); err != nil {
+			t.Fatal(err)
+		}
+	}
+	readAll := func() [][]DataEvent {
+		var got [][]DataEvent
+		err := spill.ForEachChunk(func(events []DataEvent) error {
+			got = append(got, events)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return got
+	}
+	if got := readAll(); !reflect.DeepEqual(got, chunks[:2]) {
+		t.Fatalf("got %v, want %v", got, chunks[:2])
+	}
+
+	// Appended after read, at the end. The chunks are read again from the beginning.
+	if err := spill.Append(chunks[2]); err != nil {
+		t.Fatal(err)
+	}
+	if spill.Chunks() != 3 {
+		t.Fatalf("chunks: %v", spill.Chunks())
+	}
+	if got := readAll(); !reflect.DeepEqual(got, chunks) {
+		t.Fatalf("got %v, want %v", got, chunks)
+	}
+
+	// An error stops reading.
+	n := 0
+	err = spill.ForEachChunk(func(events []DataEvent) error {
+		n++
+		return fmt.Errorf("stop")
+	})
+	if err == nil || n != 1 {
+		t.Fatalf("err %v, n %v", err, n)
+	}
+}
+
+func TestSpillEvents(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dtle-spill")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	spillDir := GetSpillDir(dir, "job1", "dest")
+
+	entry := &BinlogEntry{Events: newSpillTestEvents(0, 5)}
+	if entry.Spill() != nil {
+		t.Fatalf("spilled before SpillEvents")
+	}
+	if err := entry.SpillEvents(spillDir, 2); err != nil {
+		t.Fatal(err)
+	}
+	if len(entry.Events) != 0 || entry.Spill().Chunks() != 2 {
+		t.Fatalf("events %v, chunks %v", len(entry.Events), entry.Spill().Chunks())
+	}
+	entry.Events = newSpillTestEvents(5, 3)
+	if err := entry.SpillEvents(spillDir, 0); err != nil {
+		t.Fatal(err)
+	}
+	entry.Events = newSpillTestEvents(8, 2)
+
+	// The spilled events in order, then the events in memory.
+	var got []DataEvent
+	var chunkLens []int
+	err = entry.ForEachEvents(func(events []DataEvent) error {
+		got = append(got, events...)
+		chunkLens = append(chunkLens, len(events))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := newSpillTestEvents(0, 10); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if want := []int{3, 2, 3, 2}; !reflect.DeepEqual(chunkLens, want) {
+		t.Fatalf("chunks: got %v, want %v", chunkLens, want)
+	}
+
+	name := entry.Spill().file.Name()
+	entry.DiscardSpill()
+	if entry.Spill() != nil {
+		t.Fatalf("spill is not discarded")
+	}
+	if _, err := os.Stat(name); !os.IsNotExist(err) {
+		t.Fatalf("spill file is not removed. err: %v", err)
+	}
+
+	// Files left by a previous run.
+	left, err := NewSpillFile(spillDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	left.file.Close()
+	if err := ResetSpillDir(spillDir); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(spillDir); !os.IsNotExist(err) {
+		t.Fatalf("spill dir is not removed. err: %v", err)
+	}
+}
@@ -0,0 +1,447 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package binlog
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"time"
+
+	"github.com/pingcap/tidb/types"
+	"github.com/satori/go.uuid"
+	"github.com/siddontang/go-mysql/replication"
+
+	"github.com/actiontech/dtle/internal/client/driver/common"
+	"github.com/actiontech/dtle/internal/client/driver/mysql/base"
+	"github.com/actiontech/dtle/internal/config"
+	"github.com/actiontech/dtle/internal/config/mysql"
+)
+
+// Encoding of BinlogEntries in common.WireVersionProto. See mysql/wire.proto for the schema.
+
+// Value.Kind in wire.proto
+const (
+	valueKindNilPointer = iota
+	valueKindNull
+	valueKindInt8
+	valueKindInt16
+	valueKindInt32
+	valueKindInt64
+	valueKindInt
+	valueKindUint8
+	valueKindUint16
+	valueKindUint32
+	valueKindUint64
+	valueKindUint
+	valueKindFloat32
+	valueKindFloat64
+	valueKindString
+	valueKindBytes
+	valueKindJsonDiffs
+	valueKindTime
+	valueKindBinaryLiteral
+)
+
+func (b *BinlogEntries) MarshalWire() ([]byte, error) {
+	w := &common.WireWriter{}
+	for _, entry := range b.Entries {
+		if err := w.Message(1, entry.marshalWire); err != nil {
+			return nil, err
+		}
+	}
+	w.Bool(2, b.BigTx)
+	w.Svarint(3, int64(b.TxNum))
+	w.Svarint(4, int64(b.TxLen))
+	return w.Bytes(), nil
+}
+
+func (b *BinlogEntries) UnmarshalWire(data []byte) error {
+	r := common.NewWireReader(data)
+	for r.Next() {
+		switch r.Field() {
+		case 1:
+			entry := &BinlogEntry{}
+			r.Message(entry.unmarshalWire)
+			b.Entries = append(b.Entries, entry)
+		case 2:
+			b.BigTx = r.Bool()
+		case 3:
+			b.TxNum = int(r.Svarint())
+		case 4:
+			b.TxLen = int(r.Svarint())
+		default:
+			r.Skip()
+		}
+	}
+	return r.Err()
+}
+
+func (b *BinlogEntry) marshalWire(w *common.WireWriter) error {
+	w.Message(1, func(w *common.WireWriter) error {
+		marshalCoordinates(w, &b.Coordinates)
+		return nil
+	})
+	for i := range b.Events {
+		if err := w.Message(2, b.Events[i].marshalWire); err != nil {
+			return err
+		}
+	}
+	w.Svarint(3, int64(b.OriginalSize))
+	w.Svarint(4, b.HeartbeatTs)
+	w.String(5, b.RouteTo)
+	for _, query := range b.RowsQueries {
+		w.RepeatedString(6, query)
+	}
+	w.Bool(7, b.Routed)
+	return nil
+}
+
+func (b *BinlogEntry) unmarshalWire(r *common.WireReader) error {
+	for r.Next() {
+		switch r.Field() {
+		case 1:
+			r.Message(func(r *common.WireReader) error {
+				return unmarshalCoordinates(r, &b.Coordinates)
+			})
+		case 2:
+			event := DataEvent{}
+			r.Message(event.unmarshalWire)
+			b.Events = append(b.Events, event)
+		case 3:
+			b.OriginalSize = int(r.Svarint())
+		case 4:
+			b.HeartbeatTs = r.Svarint()
+		case 5:
+			b.RouteTo = r.String()
+		case 6:
+			b.RowsQueries = append(b.RowsQueries, r.String())
+		case 7:
+			b.Routed = r.Bool()
+		default:
+			r.Skip()
+		}
+	}
+	if b.Events == nil {
+		b.Events = make([]DataEvent, 0)
+	}
+	return r.Err()
+}
+
+func marshalCoordinates(w *common.WireWriter, c *base.BinlogCoordinateTx) {
+	w.String(1, c.LogFile)
+	w.Svarint(2, c.LogPos)
+	w.String(3, c.OSID)
+	if !uuid.Equal(c.SID, uuid.Nil) {
+		w.RawBytes(4, c.SID.Bytes())
+	}
+	w.Svarint(5, c.GNO)
+	w.Svarint(6, c.LastCommitted)
+	w.Svarint(7, c.SeqenceNumber)
+}
+
+func unmarshalCoordinates(r *common.WireReader, c *base.BinlogCoordinateTx) error {
+	for r.Next() {
+		switch r.Field() {
+		case 1:
+			c.LogFile = r.String()
+		case 2:
+			c.LogPos = r.Svarint()
+		case 3:
+			c.OSID = r.String()
+		case 4:
+			sid, err := uuid.FromBytes(r.RawBytes())
+			if err != nil {
+				return err
+			}
+			c.SID = sid
+		case 5:
+			c.GNO = r.Svarint()
+		case 6:
+			c.LastCommitted = r.Svarint()
+		case 7:
+			c.SeqenceNumber = r.Svarint()
+		default:
+			r.Skip()
+		}
+	}
+	return r.Err()
+}
+
+func (b *DataEvent) marshalWire(w *common.WireWriter) error {
+	w.String(1, b.Query)
+	w.String(2, b.CurrentSchema)
+	w.String(3, b.DatabaseName)
+	w.String(4, b.TableName)
+	w.String(5, string(b.DML))
+	w.Svarint(6, int64(b.ColumnCount))
+	if b.WhereColumnValues != nil {
+		if err := w.Message(7, func(w *common.WireWriter) error {
+			return marshalColumnValues(w, b.WhereColumnValues)
+		}); err != nil {
+			return err
+		}
+	}
+	if b.NewColumnValues != nil {
+		if err := w.Message(8, func(w *common.WireWriter) error {
+			return marshalColumnValues(w, b.NewColumnValues)
+		}); err != nil {
+			return err
+		}
+	}
+	if b.Table != nil {
+		buf := new(bytes.Buffer)
+		if err := gob.NewEncoder(buf).Encode(b.Table); err != nil {
+			return err
+		}
+		w.RepeatedBytes(9, buf.Bytes())
+	}
+	w.Svarint(10, b.LogPos)
+	w.Svarint(11, int64(b.RowsQueryNo))
+	return nil
+}
+
+func (b *DataEvent) unmarshalWire(r *common.WireReader) error {
+	for r.Next() {
+		switch r.Field() {
+		case 1:
+			b.Query = r.String()
+		case 2:
+			b.CurrentSchema = r.String()
+		case 3:
+			b.DatabaseName = r.String()
+		case 4:
+			b.TableName = r.String()
+		case 5:
+			b.DML = EventDML(r.String())
+		case 6:
+			b.ColumnCount = int(r.Svarint())
+		case 7:
+			b.WhereColumnValues = &mysql.ColumnValues{}
+			r.Message(func(r *common.WireReader) error {
+				return unmarshalColumnValues(r, b.WhereColumnValues)
+			})
+		case 8:
+			b.NewColumnValues = &mysql.ColumnValues{}
+			r.Message(func(r *common.WireReader) error {
+				return unmarshalColumnValues(r, b.NewColumnValues)
+			})
+		case 9:
+			b.Table = &config.Table{}
+			if err := gob.NewDecoder(bytes.NewReader(r.RawBytes())).Decode(b.Table); err != nil {
+				return err
+			}
+		case 10:
+			b.LogPos = r.Svarint()
+		case 11:
+			b.RowsQueryNo = int(r.Svarint())
+		default:
+			r.Skip()
+		}
+	}
+	return r.Err()
+}
+
+func marshalColumnValues(w *common.WireWriter, values *mysql.ColumnValues) error {
+	for _, v := range values.AbstractValues {
+		if err := w.Message(1, func(w *common.WireWriter) error {
+			return marshalValue(w, v)
+		}); err != nil {
+			return err
+		}
+	}
+	w.PackedBools(2, values.Present)
+	return nil
+}
+
+func unmarshalColumnValues(r *common.WireReader, values *mysql.ColumnValues) error {
+	for r.Next() {
+		switch r.Field() {
+		case 1:
+			var v *interface{}
+			r.Message(func(r *common.WireReader) (err error) {
+				v, err = unmarshalValue(r)
+				return err
+			})
+			values.AbstractValues = append(values.AbstractValues, v)
+		case 2:
+			values.Present = append(values.Present, r.PackedBools()...)
+		default:
+			r.Skip()
+		}
+	}
+	return r.Err()
+}
+
+func marshalValue(w *common.WireWriter, pv *interface{}) error {
+	if pv == nil {
+		return nil
+	}
+	switch v := (*pv).(type) {
+	case nil:
+		w.Uvarint(1, valueKindNull)
+	case int8:
+		w.Uvarint(1, valueKindInt8)
+		w.Svarint(2, int64(v))
+	case int16:
+		w.Uvarint(1, valueKindInt16)
+		w.Svarint(2, int64(v))
+	case int32:
+		w.Uvarint(1, valueKindInt32)
+		w.Svarint(2, int64(v))
+	case int64:
+		w.Uvarint(1, valueKindInt64)
+		w.Svarint(2, v)
+	case int:
+		w.Uvarint(1, valueKindInt)
+		w.Svarint(2, int64(v))
+	case uint8:
+		w.Uvarint(1, valueKindUint8)
+		w.Uvarint(3, uint64(v))
+	case uint16:
+		w.Uvarint(1, valueKindUint16)
+		w.Uvarint(3, uint64(v))
+	case uint32:
+		w.Uvarint(1, valueKindUint32)
+		w.Uvarint(3, uint64(v))
+	case uint64:
+		w.Uvarint(1, valueKindUint64)
+		w.Uvarint(3, v)
+	case uint:
+		w.Uvarint(1, valueKindUint)
+		w.Uvarint(3, uint64(v))
+	case float32:
+		w.Uvarint(1, valueKindFloat32)
+		w.Double(4, float64(v))
+	case float64:
+		w.Uvarint(1, valueKindFloat64)
+		w.Double(4, v)
+	case string:
+		w.Uvarint(1, valueKindString)
+		w.String(5, v)
+	case []byte:
+		w.Uvarint(1, valueKindBytes)
+		w.RawBytes(5, v)
+	case replication.JsonDiffs:
+		w.Uvarint(1, valueKindJsonDiffs)
+		for _, diff := range v {
+			w.Message(6, func(w *common.WireWriter) error {
+				w.Uvarint(1, uint64(diff.Op))
+				w.String(2, diff.Path)
+				w.String(3, diff.Value)
+				return nil
+			})
+		}
+	case time.Time:
+		bs, err := v.MarshalBinary()
+		if err != nil {
+			return err
+		}
+		w.Uvarint(1, valueKindTime)
+		w.RawBytes(5, bs)
+	case types.BinaryLiteral:
+		w.Uvarint(1, valueKindBinaryLiteral)
+		w.RawBytes(5, v)
+	default:
+		return fmt.Errorf("wire: unsupported column value type %T", v)
+	}
+	return nil
+}
+
+func unmarshalValue(r *common.WireReader) (*interface{}, error) {
+	var kind uint64
+	var intValue int64
+	var uintValue uint64
+	var doubleValue float64
+	var bytesValue []byte
+	jsonDiffs := replication.JsonDiffs{}
+	for r.Next() {
+		switch r.Field() {
+		case 1:
+			kind = r.Uvarint()
+		case 2:
+			intValue = r.Svarint()
+		case 3:
+			uintValue = r.Uvarint()
+		case 4:
+			doubleValue = r.Double()
+		case 5:
+			bytesValue = r.RawBytes()
+		case 6:
+			diff := &replication.JsonDiff{}
+			r.Message(func(r *common.WireReader) error {
+				for r.Next() {
+					switch r.Field() {
+					case 1:
+						diff.Op = replication.JsonDiffOperation(r.Uvarint())
+					case 2:
+						diff.Path = r.String()
+					case 3:
+						diff.Value = r.String()
+					default:
+						r.Skip()
+					}
+				}
+				return r.Err()
+			})
+			jsonDiffs = append(jsonDiffs, diff)
+		default:
+			r.Skip()
+		}
+	}
+	if r.Err() != nil {
+		return nil, r.Err()
+	}
+
+	var v interface{}
+	switch kind {
+	case valueKindNilPointer:
+		return nil, nil
+	case valueKindNull:
+		v = nil
+	case valueKindInt8:
+		v = int8(intValue)
+	case valueKindInt16:
+		v = int16(intValue)
+	case valueKindInt32:
+		v = int32(intValue)
+	case valueKindInt64:
+		v = intValue
+	case valueKindInt:
+		v = int(intValue)
+	case valueKindUint8:
+		v = uint8(uintValue)
+	case valueKindUint16:
+		v = uint16(uintValue)
+	case valueKindUint32:
+		v = uint32(uintValue)
+	case valueKindUint64:
+		v = uintValue
+	case valueKindUint:
+		v = uint(uintValue)
+	case valueKindFloat32:
+		v = float32(doubleValue)
+	case valueKindFloat64:
+		v = doubleValue
+	case valueKindString:
+		v = string(bytesValue)
+	case valueKindBytes:
+		v = append([]byte{}, bytesValue...)
+	case valueKindJsonDiffs:
+		v = jsonDiffs
+	case valueKindTime:
+		t := time.Time{}
+		if err := t.UnmarshalBinary(bytesValue); err != nil {
+			return nil, err
+		}
+		v = t
+	case valueKindBinaryLiteral:
+		v = types.BinaryLiteral(append([]byte{}, bytesValue...))
+	default:
+		return nil, fmt.Errorf("wire: unknown column value kind %v. the src might be of a newer version", kind)
+	}
+	return &v, nil
+}
@@ -0,0 +1,182 @@
+package binlog
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/pingcap/tidb/types"
+	"github.com/satori/go.uuid"
+	"github.com/siddontang/go-mysql/replication"
+
+	"github.com/actiontech/dtle/internal/client/driver/common"
+	"github.com/actiontech/dtle/internal/client/driver/mysql/base"
+	"github.com/actiontech/dtle/internal/config"
+	"github.com/actiontech/dtle/internal/config/mysql"
+)
+
+func valuePtr(v interface{}) *interface{} {
+	return &v
+}
+
+func TestValueWire(t *testing.T) {
+	values := []*interface{}{
+		nil,
+		valuePtr(nil),
+		valuePtr(int8(-8)),
+		valuePtr(int16(-16)),
+		valuePtr(int32(-32)),
+		valuePtr(int64(-64)),
+		valuePtr(int(-1)),
+		valuePtr(uint8(8)),
+		valuePtr(uint16(16)),
+		valuePtr(uint32(32)),
+		valuePtr(uint64(1<<64 - 1)),
+		valuePtr(uint(1)),
+		valuePtr(float32(1.5)),
+		valuePtr(float64(-2.25)),
+		valuePtr(""),
+		valuePtr("abc"),
+		valuePtr([]byte{}),
+		valuePtr([]byte{0, 1, 2}),
+		valuePtr(replication.JsonDiffs{}),
+		valuePtr(replication.JsonDiffs{
+			{Op: replication.JsonDiffOperationReplace, Path: "$.a", Value: "1"},
+			{Op: replication.JsonDiffOperationRemove, Path: "$.b"},
+		}),
+		valuePtr(types.BinaryLiteral{0xff, 0}),
+	}
+	for i, v := range values {
+		w := &common.WireWriter{}
+		if err := marshalValue(w, v); err != nil {
+			t.Fatal(err)
+		}
+		got, err := unmarshalValue(common.NewWireReader(w.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, v) {
+			t.Fatalf("value %v: got %#v, want %#v", i, got, v)
+		}
+	}
+
+	ts := time.Date(2020, 1, 2, 3, 4, 5, 6000, time.FixedZone("", 8*3600))
+	w := &common.WireWriter{}
+	if err := marshalValue(w, valuePtr(ts)); err != nil {
+		t.Fatal(err)
+	}
+	got, err := unmarshalValue(common.NewWireReader(w.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotTs, ok := (*got).(time.Time); !ok || !gotTs.Equal(ts) {
+		t.Fatalf("got %#v, want %v", *got, ts)
+	}
+
+	if err := marshalValue(&common.WireWriter{}, valuePtr(struct{}{})); err == nil {
+		t.Fatalf("no error for an unsupported type")
+	}
+	w = &common.WireWriter{}
+	w.Uvarint(1, 1000)
+	if _, err := unmarshalValue(common.NewWireReader(w.Bytes())); err == nil {
+		t.Fatalf("no error for an unknown kind")
+	}
+}
+
+func TestBinlogEntriesWire(t *testing.T) {
+	sid := uuid.NewV4()
+	table := config.NewTable("db1", "tb1")
+	entries := &BinlogEntries{
+		Entries: []*BinlogEntry{
+			{
+				Coordinates: base.BinlogCoordinateTx{
+					LogFile:       "mysql-bin.000001",
+					LogPos:        1234,
+					OSID:          "origin",
+					SID:           sid,
+					GNO:           5,
+					LastCommitted: 3,
+					SeqenceNumber: 4,
+				},
+				Events: []DataEvent{
+					{
+						CurrentSchema: "db1",
+						DatabaseName:  "db1",
+						TableName:     "tb1",
+						Query:         "create table tb1 (id int primary key, a int)",
+						DML:           NotDML,
+					},
+					{
+						DatabaseName: "db1",
+						TableName:    "tb1",
+						DML:          InsertDML,
+						ColumnCount:  2,
+						NewColumnValues: &mysql.ColumnValues{
+							AbstractValues: []*interface{}{valuePtr(int32(1)), valuePtr(nil)},
+						},
+						Table:       table,
+						LogPos:      100,
+						RowsQueryNo: 1,
+					},
+					{
+						DatabaseName: "db1",
+						TableName:    "tb1",
+						DML:          UpdateDML,
+						ColumnCount:  2,
+						// binlog_row_image=MINIMAL
+						WhereColumnValues: &mysql.ColumnValues{
+							AbstractValues: []*interface{}{valuePtr(int32(1)), nil},
+							Present:        []bool{true, false},
+						},
+						NewColumnValues: &mysql.ColumnValues{
+							AbstractValues: []*interface{}{nil, valuePtr("x")},
+							Present:        []bool{false, true},
+						},
+					},
+					{
+						DatabaseName: "db1",
+						TableName:    "tb1",
+						DML:          DeleteDML,
+						ColumnCount:  2,
+						WhereColumnValues: &mysql.ColumnValues{
+							AbstractValues: []*interface{}{},
+						},
+					},
+				},
+				OriginalSize: 2048,
+				HeartbeatTs:  1600000000000000,
+				RowsQueries:  []string{"insert into tb1 values (1, null)", ""},
+			},
+			{
+				Events:  []DataEvent{},
+				RouteTo: "job2",
+				Routed:  true,
+			},
+		},
+		BigTx: true,
+		TxNum: 2,
+		TxLen: 3,
+	}
+
+	data, err := entries.MarshalWire()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := &BinlogEntries{}
+	if err := got.UnmarshalWire(data); err != nil {
+		t.Fatal(err)
+	}
+
+	// The Table is gob-encoded. Only the exported fields are kept.
+	gotTable := got.Entries[0].Events[1].Table
+	if gotTable == nil || gotTable.TableSchema != table.TableSchema || gotTable.TableName != table.TableName {
+		t.Fatalf("table: got %+v", gotTable)
+	}
+	got.Entries[0].Events[1].Table = table
+	// An empty list of values is not distinguished from a nil one.
+	got.Entries[0].Events[3].WhereColumnValues.AbstractValues = []*interface{}{}
+
+	if !reflect.DeepEqual(got, entries) {
+		t.Fatalf("got %+v, want %+v", got, entries)
+	}
+}
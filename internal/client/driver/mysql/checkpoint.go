@@ -0,0 +1,294 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package mysql
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+
+	"github.com/actiontech/dtle/internal/models"
+)
+
+// TableCheckpoint is the progress of dumping a table.
+// It describes the last chunk acknowledged by the applier.
+type TableCheckpoint struct {
+	Done        bool
+	Iteration   int64
+	LastMaxVals []string
+	RowsCopied  int64
+
+	// Not nil if the table is split into unique key ranges.
+	// Bounds[i] is the upper bound of Ranges[i] and the lower bound of Ranges[i+1].
+	Bounds [][]string
+	Ranges []*TableCheckpoint
+}
+
+// FullCopyCheckpoint is persisted in the state dir during full copy,
+// so that an interrupted full copy can be resumed.
+type FullCopyCheckpoint struct {
+	// The snapshot coordinates. Binlog must be replicated from here after full copy.
+	Gtid    string
+	LogFile string
+	LogPos  int64
+	// key: TableSchema.TableName
+	Tables map[string]*TableCheckpoint
+
+	path string
+	lock sync.Mutex
+	// Not persisted again after removed, e.g. by a late appliedChunks.
+	removed bool
+}
+
+func getFullCopyCheckpointPath(stateDir string, subject string) string {
+	return path.Join(stateDir, "fullcopy", subject, "checkpoint.json")
+}
+
+func checkpointTableKey(schema string, table string) string {
+	return fmt.Sprintf("%s.%s", schema, table)
+}
+
+func NewFullCopyCheckpoint(path string, gtid string, logFile string, logPos int64) *FullCopyCheckpoint {
+	return &FullCopyCheckpoint{
+		Gtid:    gtid,
+		LogFile: logFile,
+		LogPos:  logPos,
+		Tables:  make(map[string]*TableCheckpoint),
+		path:    path,
+	}
+}
+
+// LoadFullCopyCheckpoint returns nil (without error) if there is no checkpoint.
+func LoadFullCopyCheckpoint(path string) (*FullCopyCheckpoint, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read full copy checkpoint %s: %v", path, err)
+	}
+	c := &FullCopyCheckpoint{}
+	if err := json.Unmarshal(buf, c); err != nil {
+		return nil, fmt.Errorf("failed to decode full copy checkpoint %s: %v", path, err)
+	}
+	if c.Tables == nil {
+		c.Tables = make(map[string]*TableCheckpoint)
+	}
+	c.path = path
+	return c, nil
+}
+
+func (c *FullCopyCheckpoint) GetTable(schema string, table string) *TableCheckpoint {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.Tables[checkpointTableKey(schema, table)]
+}
+
+// UpdateTable records the progress of a table and persists the checkpoint.
+func (c *FullCopyCheckpoint) UpdateTable(schema string, table string, tc *TableCheckpoint) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.Tables[checkpointTableKey(schema, table)] = tc
+	return c.persist()
+}
+
+// InitTableRanges records the bounds of a split table, with no range started.
+func (c *FullCopyCheckpoint) InitTableRanges(schema string, table string, bounds [][]string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	tc := &TableCheckpoint{
+		Bounds: bounds,
+		Ranges: make([]*TableCheckpoint, len(bounds)+1),
+	}
+	for i := range tc.Ranges {
+		tc.Ranges[i] = &TableCheckpoint{}
+	}
+	c.Tables[checkpointTableKey(schema, table)] = tc
+	return c.persist()
+}
+
+// UpdateRange records the progress of a range of a split table and persists the checkpoint.
+func (c *FullCopyCheckpoint) UpdateRange(schema string, table string, index int, rc *TableCheckpoint) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	tc := c.Tables[checkpointTableKey(schema, table)]
+	if tc == nil || index >= len(tc.Ranges) {
+		return fmt.Errorf("DTLE_BUG: range %v of table %v.%v is not in checkpoint", index, schema, table)
+	}
+	tc.Ranges[index] = rc
+	tc.RowsCopied = 0
+	tc.Done = true
+	for _, r := range tc.Ranges {
+		tc.RowsCopied += r.RowsCopied
+		tc.Done = tc.Done && r.Done
+	}
+	return c.persist()
+}
+
+// RangeStats reports the progress of each range of split tables.
+func (c *FullCopyCheckpoint) RangeStats() []*models.RangeStat {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	var stats []*models.RangeStat
+	for key, tc := range c.Tables {
+		for i, r := range tc.Ranges {
+			stats = append(stats, &models.RangeStat{
+				Table:      key,
+				Index:      i,
+				RowsCopied: r.RowsCopied,
+				Done:       r.Done,
+			})
+		}
+	}
+	return stats
+}
+
+func (c *FullCopyCheckpoint) Persist() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.persist()
+}
+
+func (c *FullCopyCheckpoint) persist() error {
+	if c.removed {
+		return nil
+	}
+	buf, err := json.Marshal(c)
+	if err != nil {
+		return fmt.Errorf("failed to encode full copy checkpoint: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(c.path), 0700); err != nil {
+		return fmt.Errorf("failed to make dirs for %s: %v", c.path, err)
+	}
+	tmpPath := c.path + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, buf, 0600); err != nil {
+		return fmt.Errorf("failed to save full copy checkpoint to tmp: %v", err)
+	}
+	if err := os.Rename(tmpPath, c.path); err != nil {
+		return fmt.Errorf("failed to rename tmp to path: %v", err)
+	}
+	return nil
+}
+
+func (c *FullCopyCheckpoint) Remove() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.removed = true
+	err := os.Remove(c.path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// appliedChunks advances the checkpoint as the chunks are applied. The applier reports the Seq
+// of each applied DumpEntry on "<subject>_full_applied".
+// Chunks are applied concurrently, so the checkpoint of a table (or a range) moves past a chunk
+// only after all of its earlier chunks are applied.
+type appliedChunks struct {
+	lock    sync.Mutex
+	lastSeq int64
+	// key: Seq of a sent chunk
+	units map[int64]*unitChunks
+}
+
+// unitChunks are the chunks of a dump unit waiting to be applied.
+type unitChunks struct {
+	pending []*sentChunk
+	// All chunks are sent. The unit is done after they are applied.
+	sentAll    bool
+	rowsCopied int64
+	save       func(tc *TableCheckpoint) error
+}
+
+type sentChunk struct {
+	seq        int64
+	checkpoint *TableCheckpoint
+	rowsCount  int64
+	applied    bool
+}
+
+func newAppliedChunks() *appliedChunks {
+	return &appliedChunks{
+		units: make(map[int64]*unitChunks),
+	}
+}
+
+// newUnitChunks tracks a dump unit, which has copied rowsCopied rows before. save persists its checkpoint.
+func newUnitChunks(rowsCopied int64, save func(tc *TableCheckpoint) error) *unitChunks {
+	return &unitChunks{
+		rowsCopied: rowsCopied,
+		save:       save,
+	}
+}
+
+// add records a chunk of u before it is sent, and returns the Seq for its DumpEntry.
+// checkpoint is the progress of u right after the chunk.
+func (a *appliedChunks) add(u *unitChunks, checkpoint *TableCheckpoint, rowsCount int64) int64 {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.lastSeq++
+	u.pending = append(u.pending, &sentChunk{
+		seq:        a.lastSeq,
+		checkpoint: checkpoint,
+		rowsCount:  rowsCount,
+	})
+	a.units[a.lastSeq] = u
+	return a.lastSeq
+}
+
+// finish records that all chunks of u are sent.
+func (a *appliedChunks) finish(u *unitChunks) error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	u.sentAll = true
+	return u.advance()
+}
+
+// applied records that the chunk of seq is applied.
+func (a *appliedChunks) applied(seq int64) error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	u, ok := a.units[seq]
+	if !ok {
+		// e.g. sent before the extractor restarts
+		return nil
+	}
+	delete(a.units, seq)
+	for _, c := range u.pending {
+		if c.seq == seq {
+			c.applied = true
+			break
+		}
+	}
+	return u.advance()
+}
+
+// advance saves the checkpoint after the leading applied chunks.
+func (u *unitChunks) advance() error {
+	var last *TableCheckpoint
+	for len(u.pending) > 0 && u.pending[0].applied {
+		u.rowsCopied += u.pending[0].rowsCount
+		last = u.pending[0].checkpoint
+		u.pending = u.pending[1:]
+	}
+	if len(u.pending) == 0 && u.sentAll {
+		return u.save(&TableCheckpoint{
+			Done:       true,
+			RowsCopied: u.rowsCopied,
+		})
+	}
+	if last != nil {
+		last.RowsCopied = u.rowsCopied
+		return u.save(last)
+	}
+	return nil
+}
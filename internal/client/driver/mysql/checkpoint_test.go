@@ -0,0 +1,47 @@
+package mysql
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestAppliedChunks(t *testing.T) {
+	a := newAppliedChunks()
+	var saved []*TableCheckpoint
+	u := newUnitChunks(10, func(tc *TableCheckpoint) error {
+		saved = append(saved, tc)
+		return nil
+	})
+	var seqs []int64
+	for i := int64(1); i <= 3; i++ {
+		seqs = append(seqs, a.add(u, &TableCheckpoint{Iteration: i}, 5))
+	}
+	if err := a.finish(u); err != nil {
+		t.Fatal(err)
+	}
+	if len(saved) != 0 {
+		t.Fatalf("saved before applied: %+v", saved)
+	}
+
+	// Chunks are applied out of order.
+	for _, seq := range []int64{seqs[1], 100, seqs[0], seqs[2]} {
+		if err := a.applied(seq); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := []*TableCheckpoint{
+		{Iteration: 2, RowsCopied: 20},
+		{Done: true, RowsCopied: 25},
+	}
+	if !reflect.DeepEqual(saved, want) {
+		t.Fatalf("got %+v, want %+v", saved, want)
+	}
+
+	u = newUnitChunks(0, func(tc *TableCheckpoint) error {
+		return fmt.Errorf("disk full")
+	})
+	if err := a.applied(a.add(u, &TableCheckpoint{Iteration: 1}, 1)); err == nil {
+		t.Fatalf("no error for a failed save")
+	}
+}
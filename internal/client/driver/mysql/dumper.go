@@ -7,8 +7,11 @@
 package mysql
 
 import (
+	gosql "database/sql"
 	"fmt"
+	"math/big"
 	"os"
+	"reflect"
 	"strings"
 	"sync"
 
@@ -31,10 +34,17 @@ type dumper struct {
 	EscapedTableName   string
 	table              *config.Table
 	columns            string
-	resultsChannel     chan *DumpEntry
-	shutdown           bool
-	shutdownCh         chan struct{}
-	shutdownLock       sync.Mutex
+	// The replicated columns come first in the selected columns. They might be followed by
+	// unique key columns and columns referenced by transforms, which are not replicated.
+	// selected[i] is the index in OriginalTableColumns of the i-th selected column.
+	selected       []int
+	nColumns       int
+	uniqueKeyIdxes []int
+	transformCtx   *config.TransformContext
+	resultsChannel chan *dumpChunk
+	shutdown       bool
+	shutdownCh     chan struct{}
+	shutdownLock   sync.Mutex
 
 	// DB is safe for using in goroutines
 	// http://golang.org/src/database/sql/sql.go?s=5574:6362#L201
@@ -45,6 +55,41 @@ type dumper struct {
 	oldWayDump bool
 
 	sentTableDef bool
+
+	// The dumping progress. Initialized from table, but not written back, since
+	// there might be several dumpers dumping different ranges of the same table.
+	iteration   int64
+	lastMaxVals []string
+	// nil if dumping the whole table
+	dumpRange *dumpRange
+
+	// of the job and of the agent
+	rateLimiters []*g.RateLimiters
+	throttler    *throttler
+}
+
+// dumpRange is a unique key range of a table: (LowerBound, UpperBound].
+// A nil bound means unbounded.
+type dumpRange struct {
+	Index      int
+	LowerBound []string
+	UpperBound []string
+}
+
+// NewRangeDumper creates a dumper which dumps only range r of the table.
+// The table must have a unique key. iteration and lastMaxVals are the progress of the range.
+func NewRangeDumper(db usql.QueryAble, table *config.Table, r *dumpRange, iteration int64, lastMaxVals []string,
+	chunkSize int64, logger *logrus.Entry) *dumper {
+
+	d := NewDumper(db, table, chunkSize, logger)
+	d.dumpRange = r
+	d.iteration = iteration
+	if iteration == 0 {
+		copy(d.lastMaxVals, r.LowerBound)
+	} else {
+		copy(d.lastMaxVals, lastMaxVals)
+	}
+	return d
 }
 
 func NewDumper(db usql.QueryAble, table *config.Table, chunkSize int64,
@@ -58,10 +103,15 @@ func NewDumper(db usql.QueryAble, table *config.Table, chunkSize int64,
 		TableName:          table.TableName,
 		EscapedTableName:   umconf.EscapeName(table.TableName),
 		table:              table,
-		resultsChannel:     make(chan *DumpEntry, 24),
+		resultsChannel:     make(chan *dumpChunk, 24),
 		chunkSize:          chunkSize,
 		shutdownCh:         make(chan struct{}),
 		sentTableDef:       false,
+		iteration:          table.Iteration,
+	}
+	if table.UseUniqueKey != nil {
+		dumper.lastMaxVals = make([]string, len(table.UseUniqueKey.LastMaxVals))
+		copy(dumper.lastMaxVals, table.UseUniqueKey.LastMaxVals)
 	}
 
 	switch os.Getenv(g.ENV_DUMP_CHECKSUM) {
@@ -79,6 +129,12 @@ func NewDumper(db usql.QueryAble, table *config.Table, chunkSize int64,
 	return dumper
 }
 
+// dumpChunk is a chunk of rows, along with the table progress right after the chunk.
+type dumpChunk struct {
+	entry      *DumpEntry
+	checkpoint *TableCheckpoint
+}
+
 type DumpStatResult struct {
 	Gtid       string
 	TotalCount int64
@@ -107,10 +163,75 @@ func (e *DumpEntry) incrementCounter() {
 	e.RowsCount++
 }
 
-func (d *dumper) prepareForDumping() error {
+// hasStatements returns true if the entry has statements to be executed other than the rows.
+func (e *DumpEntry) hasStatements() bool {
+	return e.SystemVariablesStatement != "" || e.SqlMode != "" || e.DbSQL != "" || len(e.TbSQL) > 0 ||
+		e.StageBegin || e.StageEnd
+}
+
+// selectedPosition returns the position of a column (by index in OriginalTableColumns) in the selected columns.
+// It returns -1 if the column is not selected.
+func (d *dumper) selectedPosition(colIdx int) int {
+	for i := range d.selected {
+		if d.selected[i] == colIdx {
+			return i
+		}
+	}
+	return -1
+}
+
+// selectColumn selects the column if it is not selected, and returns its position.
+func (d *dumper) selectColumn(colIdx int) int {
+	pos := d.selectedPosition(colIdx)
+	if pos < 0 {
+		pos = len(d.selected)
+		d.selected = append(d.selected, colIdx)
+	}
+	return pos
+}
+
+func (d *dumper) prepareForDumping() (err error) {
+	d.selected = nil
+	if len(d.table.ColumnMap) > 0 {
+		d.selected = append(d.selected, d.table.ColumnMap...)
+	} else {
+		for i := range d.table.OriginalTableColumns.Columns {
+			d.selected = append(d.selected, i)
+		}
+	}
+	d.nColumns = len(d.selected)
+
+	if d.table.UseUniqueKey != nil {
+		d.uniqueKeyIdxes = make([]int, len(d.table.UseUniqueKey.Columns.Columns))
+		for i, ukCol := range d.table.UseUniqueKey.Columns.Columns {
+			colIdx := -1
+			for j := range d.table.OriginalTableColumns.Columns {
+				if strings.EqualFold(d.table.OriginalTableColumns.Columns[j].RawName, ukCol.RawName) {
+					colIdx = j
+					break
+				}
+			}
+			if colIdx < 0 {
+				return fmt.Errorf("unique key column %v is not found in table %v.%v", ukCol.RawName, d.TableSchema, d.TableName)
+			}
+			d.uniqueKeyIdxes[i] = d.selectColumn(colIdx)
+		}
+	}
+
+	d.transformCtx, err = config.NewTransformCtx(d.table)
+	if err != nil {
+		return err
+	}
+	if d.transformCtx != nil {
+		for _, colIdx := range d.transformCtx.FieldsMap {
+			d.selectColumn(colIdx)
+		}
+	}
+
 	needPm := false
 	columns := make([]string, 0)
-	for _, col := range d.table.OriginalTableColumns.Columns {
+	for _, idx := range d.selected {
+		col := d.table.OriginalTableColumns.Columns[idx]
 		switch col.Type {
 		case umconf.FloatColumnType, umconf.DoubleColumnType,
 			umconf.MediumIntColumnType, umconf.BigIntColumnType,
@@ -121,7 +242,7 @@ func (d *dumper) prepareForDumping() error {
 			columns = append(columns, col.EscapedName)
 		}
 	}
-	if needPm {
+	if needPm || len(d.table.ColumnMap) > 0 || len(d.selected) > d.nColumns {
 		d.columns = strings.Join(columns, ", ")
 	} else {
 		d.columns = "*"
@@ -137,47 +258,37 @@ func (d *dumper) buildQueryOldWay() string {
 		d.EscapedTableName,
 		d.table.Where,
 		d.chunkSize,
-		d.table.Iteration*d.chunkSize,
+		d.iteration*d.chunkSize,
 	)
 }
 
-func (d *dumper) buildQueryOnUniqueKey() string {
-	nCol := len(d.table.UseUniqueKey.Columns.Columns)
+// uniqueKeyOrderBy orders the rows by the unique key of the table, in order "asc" or "desc".
+func uniqueKeyOrderBy(table *config.Table, order string) string {
+	nCol := len(table.UseUniqueKey.Columns.Columns)
 	uniqueKeyColumnAscending := make([]string, nCol, nCol)
-	for i, col := range d.table.UseUniqueKey.Columns.Columns {
+	for i, col := range table.UseUniqueKey.Columns.Columns {
 		colName := col.EscapedName
 		switch col.Type {
 		case umconf.EnumColumnType:
 			// TODO try mysql enum type
-			uniqueKeyColumnAscending[i] = fmt.Sprintf("concat(%s) asc", colName)
+			uniqueKeyColumnAscending[i] = fmt.Sprintf("concat(%s) %s", colName, order)
 		default:
-			uniqueKeyColumnAscending[i] = fmt.Sprintf("%s asc", colName)
+			uniqueKeyColumnAscending[i] = fmt.Sprintf("%s %s", colName, order)
 		}
 	}
+	return strings.Join(uniqueKeyColumnAscending, ", ")
+}
 
+func (d *dumper) buildQueryOnUniqueKey() string {
 	var rangeStr string
 
-	if d.table.Iteration == 0 {
+	if d.iteration == 0 && (d.dumpRange == nil || d.dumpRange.LowerBound == nil) {
 		rangeStr = "true"
 	} else {
-		rangeItems := make([]string, nCol)
-
-		// The form like: (A > a) or (A = a and B > b) or (A = a and B = b and C > c) or ...
-		for x := 0; x < nCol; x++ {
-			innerItems := make([]string, x+1)
-
-			for y := 0; y < x; y++ {
-				colName := d.table.UseUniqueKey.Columns.Columns[y].EscapedName
-				innerItems[y] = fmt.Sprintf("(%s = %s)", colName, d.table.UseUniqueKey.LastMaxVals[y])
-			}
-
-			colName := d.table.UseUniqueKey.Columns.Columns[x].EscapedName
-			innerItems[x] = fmt.Sprintf("(%s > %s)", colName, d.table.UseUniqueKey.LastMaxVals[x])
-
-			rangeItems[x] = fmt.Sprintf("(%s)", strings.Join(innerItems, " and "))
-		}
-
-		rangeStr = strings.Join(rangeItems, " or ")
+		rangeStr = d.buildUniqueKeyCompare(d.lastMaxVals, ">", ">")
+	}
+	if d.dumpRange != nil && d.dumpRange.UpperBound != nil {
+		rangeStr = fmt.Sprintf("(%s) and (%s)", rangeStr, d.buildUniqueKeyCompare(d.dumpRange.UpperBound, "<", "<="))
 	}
 
 	return fmt.Sprintf(`SELECT %s FROM %s.%s where (%s) and (%s) order by %s LIMIT %d`,
@@ -187,12 +298,43 @@ func (d *dumper) buildQueryOnUniqueKey() string {
 		// where
 		rangeStr, d.table.Where,
 		// order by
-		strings.Join(uniqueKeyColumnAscending, ", "),
+		uniqueKeyOrderBy(d.table, "asc"),
 		// limit
 		d.chunkSize,
 	)
 }
 
+func (d *dumper) buildUniqueKeyCompare(vals []string, op string, lastOp string) string {
+	return uniqueKeyCompare(d.table, vals, op, lastOp)
+}
+
+// uniqueKeyCompare compares the unique key of the table with vals in lexicographical order.
+// The form like: (A op a) or (A = a and B op b) or (A = a and B = b and C lastOp c) or ...
+func uniqueKeyCompare(table *config.Table, vals []string, op string, lastOp string) string {
+	nCol := len(table.UseUniqueKey.Columns.Columns)
+	rangeItems := make([]string, nCol)
+
+	for x := 0; x < nCol; x++ {
+		innerItems := make([]string, x+1)
+
+		for y := 0; y < x; y++ {
+			colName := table.UseUniqueKey.Columns.Columns[y].EscapedName
+			innerItems[y] = fmt.Sprintf("(%s = %s)", colName, vals[y])
+		}
+
+		colName := table.UseUniqueKey.Columns.Columns[x].EscapedName
+		if x == nCol-1 {
+			innerItems[x] = fmt.Sprintf("(%s %s %s)", colName, lastOp, vals[x])
+		} else {
+			innerItems[x] = fmt.Sprintf("(%s %s %s)", colName, op, vals[x])
+		}
+
+		rangeItems[x] = fmt.Sprintf("(%s)", strings.Join(innerItems, " and "))
+	}
+
+	return strings.Join(rangeItems, " or ")
+}
+
 // dumps a specific chunk, reading chunk info from the channel
 func (d *dumper) getChunkData() (nRows int64, err error) {
 	entry := &DumpEntry{
@@ -207,27 +349,7 @@ func (d *dumper) getChunkData() (nRows int64, err error) {
 		if err == nil && entry.RowsCount == 0 {
 			return
 		}
-
-		keepGoing := true
-		timer := time.NewTimer(pingInterval)
-		for keepGoing {
-			select {
-			case d.resultsChannel <- entry:
-				if !timer.Stop() {
-					<-timer.C
-				}
-				keepGoing = false
-			case <-timer.C:
-				timer.Reset(pingInterval)
-				d.logger.Debugf("mysql.dumper: resultsChannel full. waiting and ping conn")
-				var dummy int
-				errPing := d.db.QueryRow("select 1").Scan(&dummy)
-				if errPing != nil {
-					d.logger.Debugf("mysql.dumper: ping query row got error. err: %v", errPing)
-				}
-			}
-		}
-		d.logger.Debugf("mysql.dumper: resultsChannel: %v", len(d.resultsChannel))
+		d.sendEntry(entry, true)
 	}()
 
 	query := ""
@@ -239,7 +361,7 @@ func (d *dumper) getChunkData() (nRows int64, err error) {
 	d.logger.Debugf("getChunkData. query: %s", query)
 
 	if d.doChecksum != 0 {
-		if d.doChecksum == 2 || (d.doChecksum == 1 && d.table.Iteration == 0) {
+		if d.doChecksum == 2 || (d.doChecksum == 1 && d.iteration == 0) {
 			row := d.db.QueryRow(fmt.Sprintf("checksum table %v.%v", d.TableSchema, d.TableName))
 			var table string
 			var cs int64
@@ -252,8 +374,10 @@ func (d *dumper) getChunkData() (nRows int64, err error) {
 		}
 	}
 
+	d.throttler.waitIfThrottled(d.shutdownCh)
+
 	// this must be increased after building query
-	d.table.Iteration += 1
+	d.iteration += 1
 	rows, err := d.db.Query(query)
 	if err != nil {
 		d.logger.Debugf("mysql.dumper. error at select chunk. query: ", query)
@@ -269,6 +393,7 @@ func (d *dumper) getChunkData() (nRows int64, err error) {
 
 	scanArgs := make([]interface{}, len(columns)) // tmp use, for casting `values` to `[]interface{}`
 
+	var nBytes int64
 	for rows.Next() {
 		rowValuesRaw := make([]*[]byte, len(columns))
 		for i := range rowValuesRaw {
@@ -281,55 +406,383 @@ func (d *dumper) getChunkData() (nRows int64, err error) {
 		}
 
 		entry.ValuesX = append(entry.ValuesX, rowValuesRaw)
+		for _, value := range rowValuesRaw {
+			if value != nil {
+				nBytes += int64(len(*value))
+			}
+		}
 
 		entry.incrementCounter()
 	}
 
 	d.logger.Debugf("getChunkData. n_row: %d", entry.RowsCount)
 
-	if entry.RowsCount > 0 {
-		var lastVals []string
+	for _, limiters := range d.rateLimiters {
+		limiters.SnapshotRows.Wait(entry.RowsCount, d.shutdownCh)
+		limiters.SnapshotBytes.Wait(nBytes, d.shutdownCh)
+	}
 
-		for _, col := range entry.ValuesX[len(entry.ValuesX)-1] {
-			lastVals = append(lastVals, usql.EscapeColRawToString(col))
-		}
+	if entry.RowsCount > 0 {
+		lastRow := entry.ValuesX[len(entry.ValuesX)-1]
 
 		if d.table.UseUniqueKey != nil {
-			// lastVals must not be nil if len(data) > 0
-			for i, col := range d.table.UseUniqueKey.Columns.Columns {
-				// TODO save the idx
-				idx := d.table.OriginalTableColumns.Ordinals[strings.ToLower(col.RawName)]
-				if idx > len(lastVals) {
-					return entry.RowsCount, fmt.Errorf("getChunkData. GetLastMaxVal: column index %v > n_column %v", idx, len(lastVals))
+			// lastRow must not be nil if len(data) > 0
+			for i, idx := range d.uniqueKeyIdxes {
+				if idx >= len(lastRow) {
+					return entry.RowsCount, fmt.Errorf("getChunkData. GetLastMaxVal: column index %v >= n_column %v", idx, len(lastRow))
 				} else {
-					d.table.UseUniqueKey.LastMaxVals[i] = lastVals[idx]
+					d.lastMaxVals[i] = usql.EscapeColRawToString(lastRow[idx])
 				}
 			}
-			d.logger.Debugf("GetLastMaxVal: got %v", d.table.UseUniqueKey.LastMaxVals)
+			d.logger.Debugf("GetLastMaxVal: got %v", d.lastMaxVals)
 		}
 	}
+	if err := d.adjustEntry(entry); err != nil {
+		return entry.RowsCount, err
+	}
+
+	return entry.RowsCount, nil
+}
+
+// adjustEntry applies table renaming and column transforms to the entry,
+// and removes the unreplicated columns from the rows.
+func (d *dumper) adjustEntry(entry *DumpEntry) error {
 	if d.table.TableRename != "" {
 		entry.TableName = d.table.TableRename
 	}
 	if d.table.TableSchemaRename != "" {
 		entry.TableSchema = d.table.TableSchemaRename
 	}
-	if len(d.table.ColumnMap) > 0 {
-		for i, oldRow := range entry.ValuesX {
-			row := make([]*[]byte, len(d.table.ColumnMap))
-			for i := range d.table.ColumnMap {
-				fromIdx := d.table.ColumnMap[i]
-				row[i] = oldRow[fromIdx]
+	if d.transformCtx != nil {
+		for _, row := range entry.ValuesX {
+			if err := d.transformRow(row); err != nil {
+				return err
 			}
-			entry.ValuesX[i] = row
+		}
+	}
+	// Columns are selected in the order of the column map. Only the trailing extra columns are cut.
+	for i, row := range entry.ValuesX {
+		if len(row) > d.nColumns {
+			entry.ValuesX[i] = row[:d.nColumns]
 		}
 	}
 	// ValuesX[i]: n-th row
 	// ValuesX[i][j]: j-th col of n-th row
 	// Values[i]: i-th chunk of rows
 	// Values[i][j]: j-th row (in paren-wrapped string)
+	return nil
+}
 
-	return entry.RowsCount, nil
+// transformRow rewrites the transformed columns of a selected row in place.
+func (d *dumper) transformRow(row []*[]byte) error {
+	result, err := d.transformCtx.Transform(func(idx int) interface{} {
+		pos := d.selectedPosition(idx)
+		if pos < 0 || row[pos] == nil {
+			return nil
+		}
+		return *row[pos]
+	})
+	if err != nil {
+		return err
+	}
+	for i, t := range d.transformCtx.Transforms {
+		pos := d.selectedPosition(t.ColumnIdx)
+		if pos < 0 {
+			continue // not replicated
+		}
+		if result[i] == nil {
+			row[pos] = nil
+		} else {
+			bs := []byte(config.FormatTransformValue(result[i]))
+			row[pos] = &bs
+		}
+	}
+	return nil
+}
+
+// sendEntry sends the entry to resultsChannel, pinging the connection while waiting if pingConn is true.
+// It returns false if the dumper is closed.
+func (d *dumper) sendEntry(entry *DumpEntry, pingConn bool) bool {
+	chunk := &dumpChunk{
+		entry:      entry,
+		checkpoint: d.currentCheckpoint(),
+	}
+	timer := time.NewTimer(pingInterval)
+	defer timer.Stop()
+	for {
+		select {
+		case d.resultsChannel <- chunk:
+			d.logger.Debugf("mysql.dumper: resultsChannel: %v", len(d.resultsChannel))
+			return true
+		case <-d.shutdownCh:
+			return false
+		case <-timer.C:
+			timer.Reset(pingInterval)
+			if !pingConn {
+				d.logger.Debugf("mysql.dumper: resultsChannel full. waiting")
+				continue
+			}
+			d.logger.Debugf("mysql.dumper: resultsChannel full. waiting and ping conn")
+			var dummy int
+			errPing := d.db.QueryRow("select 1").Scan(&dummy)
+			if errPing != nil {
+				d.logger.Debugf("mysql.dumper: ping query row got error. err: %v", errPing)
+			}
+		}
+	}
+}
+
+// streamTable dumps a table without a usable unique key. Instead of paging with LIMIT/OFFSET,
+// the whole table is read by one unbuffered query and cut into chunks on our side.
+// The rows are applied through a staging table (see Staged, StageBegin and StageEnd of DumpEntry),
+// so the table can be dumped again from the beginning, but cannot be resumed in the middle.
+func (d *dumper) streamTable() {
+	newEntry := func() *DumpEntry {
+		return &DumpEntry{
+			TableSchema: d.TableSchema,
+			TableName:   d.TableName,
+			RowsCount:   0,
+			Staged:      true,
+		}
+	}
+	sendErr := func(err error) {
+		d.logger.Errorf("mysql.dumper: error at stream table %v.%v. err: %v", d.TableSchema, d.TableName, err)
+		entry := newEntry()
+		entry.Err = err.Error()
+		d.sendEntry(entry, false)
+	}
+
+	// The connection could not be pinged while the query is running. The server should wait
+	// long enough when we are blocked by a full resultsChannel.
+	_, err := d.db.Exec(fmt.Sprintf("set session net_write_timeout = %d", streamNetWriteTimeout))
+	if err != nil {
+		sendErr(err)
+		return
+	}
+
+	query := fmt.Sprintf(`SELECT %s FROM %s.%s where (%s)`,
+		d.columns,
+		d.EscapedTableSchema,
+		d.EscapedTableName,
+		d.table.Where,
+	)
+	d.logger.Debugf("streamTable. query: %s", query)
+	rows, err := d.db.Query(query)
+	if err != nil {
+		sendErr(err)
+		return
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		sendErr(err)
+		return
+	}
+	scanArgs := make([]interface{}, len(columns)) // tmp use, for casting `values` to `[]interface{}`
+
+	entry := newEntry()
+	entry.StageBegin = true
+	for rows.Next() {
+		rowValuesRaw := make([]*[]byte, len(columns))
+		for i := range rowValuesRaw {
+			scanArgs[i] = &rowValuesRaw[i]
+		}
+		if err := rows.Scan(scanArgs...); err != nil {
+			sendErr(err)
+			return
+		}
+		entry.ValuesX = append(entry.ValuesX, rowValuesRaw)
+		entry.incrementCounter()
+
+		if entry.RowsCount >= d.chunkSize {
+			d.iteration += 1
+			if err := d.adjustEntry(entry); err != nil {
+				sendErr(err)
+				return
+			}
+			if !d.sendEntry(entry, false) {
+				return
+			}
+			entry = newEntry()
+		}
+	}
+	if err := rows.Err(); err != nil {
+		sendErr(err)
+		return
+	}
+
+	// The last entry might have no row. It is still sent to finish the staging table.
+	d.iteration += 1
+	entry.StageEnd = true
+	if err := d.adjustEntry(entry); err != nil {
+		sendErr(err)
+		return
+	}
+	d.logger.Infof("mysql.dumper: stream table %v.%v finished. n_chunk: %v", d.TableSchema, d.TableName, d.iteration)
+	d.sendEntry(entry, false)
+}
+
+const (
+	maxRangesPerTable = 1024
+
+	// in seconds
+	streamNetWriteTimeout = 3600
+)
+
+// sampleUniqueKeyBounds returns (at most) nRanges-1 increasing bounds, which split the table into nRanges ranges.
+// The range [min, max] of the first unique key column, which must be an integer, is split evenly,
+// and each bound is the greatest unique key not above a split point. Each query is a lookup on the index.
+// The table is not split otherwise (nil is returned).
+func sampleUniqueKeyBounds(db usql.QueryAble, table *config.Table, nRanges int,
+	logger *logrus.Entry) (bounds [][]string, err error) {
+
+	if nRanges <= 1 {
+		return nil, nil
+	}
+	uniqueKey := table.UseUniqueKey
+	// NULL could not be compared, and rows with NULL would be in no range. inspect does not use such a key either.
+	if uniqueKey.HasNullable {
+		logger.Warnf("mysql.dumper: table %v.%v is not split. unique key %v has nullable columns",
+			table.TableSchema, table.TableName, uniqueKey.Name)
+		return nil, nil
+	}
+	firstCol := uniqueKey.Columns.Columns[0]
+	switch firstCol.Type {
+	case umconf.TinyintColumnType, umconf.SmallintColumnType, umconf.MediumIntColumnType,
+		umconf.IntColumnType, umconf.BigIntColumnType:
+	default:
+		logger.Infof("mysql.dumper: table %v.%v is not split. the first column of unique key %v is not an integer",
+			table.TableSchema, table.TableName, uniqueKey.Name)
+		return nil, nil
+	}
+
+	// The whole table, regardless of Where, for the min and max to be read from the index.
+	var minVal, maxVal gosql.NullString
+	query := fmt.Sprintf("SELECT min(%s), max(%s) FROM %s.%s", firstCol.EscapedName, firstCol.EscapedName,
+		umconf.EscapeName(table.TableSchema), umconf.EscapeName(table.TableName))
+	if err := db.QueryRow(query).Scan(&minVal, &maxVal); err != nil {
+		return nil, err
+	}
+	if !minVal.Valid || !maxVal.Valid {
+		return nil, nil
+	}
+	splitPoints, err := splitIntegerRange(minVal.String, maxVal.String, nRanges)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, point := range splitPoints {
+		query := uniqueKeyBoundQuery(table, point)
+		logger.Debugf("mysql.dumper: sample unique key. query: %v", query)
+		bound, found, err := queryUniqueKeyBound(db, query, len(uniqueKey.Columns.Columns))
+		if err != nil {
+			return nil, err
+		}
+		// no row between the last two points
+		if !found || (len(bounds) > 0 && reflect.DeepEqual(bound, bounds[len(bounds)-1])) {
+			continue
+		}
+		bounds = append(bounds, bound)
+	}
+
+	logger.Infof("mysql.dumper: table %v.%v is split into %v ranges. %v: [%v, %v]",
+		table.TableSchema, table.TableName, len(bounds)+1, firstCol.RawName, minVal.String, maxVal.String)
+	return bounds, nil
+}
+
+// splitIntegerRange returns the (at most) n-1 distinct points which split [min, max] evenly into n parts.
+// Each part is (the last point, the point], with the first one starting from min.
+func splitIntegerRange(min string, max string, n int) (points []string, err error) {
+	minInt, ok := new(big.Int).SetString(min, 10)
+	if !ok {
+		return nil, fmt.Errorf("bad integer %v", min)
+	}
+	maxInt, ok := new(big.Int).SetString(max, 10)
+	if !ok {
+		return nil, fmt.Errorf("bad integer %v", max)
+	}
+	width := new(big.Int).Sub(maxInt, minInt)
+	var last *big.Int
+	for i := 1; i < n; i++ {
+		// min + width * i / n
+		point := new(big.Int).Mul(width, big.NewInt(int64(i)))
+		point.Div(point, big.NewInt(int64(n)))
+		point.Add(point, minInt)
+		if point.Cmp(maxInt) >= 0 {
+			break
+		}
+		if last != nil && point.Cmp(last) <= 0 {
+			continue
+		}
+		points = append(points, point.String())
+		last = point
+	}
+	return points, nil
+}
+
+// uniqueKeyBoundQuery selects the greatest unique key whose first column is not above point.
+func uniqueKeyBoundQuery(table *config.Table, point string) string {
+	columns := make([]string, 0)
+	for _, col := range table.UseUniqueKey.Columns.Columns {
+		// keep the same form as the dumped values, which LastMaxVals comes from
+		switch col.Type {
+		case umconf.FloatColumnType, umconf.DoubleColumnType,
+			umconf.MediumIntColumnType, umconf.BigIntColumnType,
+			umconf.DecimalColumnType:
+			columns = append(columns, fmt.Sprintf("%s+0", col.EscapedName))
+		default:
+			columns = append(columns, col.EscapedName)
+		}
+	}
+	return fmt.Sprintf(`SELECT %s FROM %s.%s where %s <= %s order by %s LIMIT 1`,
+		strings.Join(columns, ", "),
+		umconf.EscapeName(table.TableSchema),
+		umconf.EscapeName(table.TableName),
+		table.UseUniqueKey.Columns.Columns[0].EscapedName, point,
+		uniqueKeyOrderBy(table, "desc"),
+	)
+}
+
+// queryUniqueKeyBound reads the unique key of the only row returned by query.
+func queryUniqueKeyBound(db usql.QueryAble, query string, nCol int) (bound []string, found bool, err error) {
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, false, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return nil, false, rows.Err()
+	}
+	rowValuesRaw := make([]*[]byte, nCol)
+	scanArgs := make([]interface{}, nCol)
+	for i := range rowValuesRaw {
+		scanArgs[i] = &rowValuesRaw[i]
+	}
+	if err := rows.Scan(scanArgs...); err != nil {
+		return nil, false, err
+	}
+	bound = make([]string, nCol)
+	for i := range rowValuesRaw {
+		if rowValuesRaw[i] == nil {
+			return nil, false, fmt.Errorf("unexpected NULL in unique key. query: %v", query)
+		}
+		bound[i] = usql.EscapeColRawToString(rowValuesRaw[i])
+	}
+	return bound, true, nil
+}
+
+// currentCheckpoint must be called in the dumping goroutine.
+func (d *dumper) currentCheckpoint() *TableCheckpoint {
+	tc := &TableCheckpoint{
+		Iteration: d.iteration,
+	}
+	if d.lastMaxVals != nil {
+		tc.LastMaxVals = make([]string, len(d.lastMaxVals))
+		copy(tc.LastMaxVals, d.lastMaxVals)
+	}
+	return tc
 }
 
 func (d *dumper) Dump() error {
@@ -339,6 +792,12 @@ func (d *dumper) Dump() error {
 	}
 
 	go func() {
+		if d.table.UseUniqueKey == nil && !d.oldWayDump {
+			d.streamTable()
+			close(d.resultsChannel)
+			return
+		}
+
 		for {
 			select {
 			case <-d.shutdownCh:
@@ -10,16 +10,19 @@ import (
 	"database/sql"
 	"reflect"
 	"testing"
+
+	usql "github.com/actiontech/dtle/internal/client/driver/mysql/sql"
 	"github.com/actiontech/dtle/internal/config"
-	log "github.com/actiontech/dtle/internal/logger"
+	umconf "github.com/actiontech/dtle/internal/config/mysql"
+	"github.com/sirupsen/logrus"
 )
 
 func TestNewDumper(t *testing.T) {
 	type args struct {
 		db        *sql.Tx
-		dbName    string
-		tableName string
-		logger    *log.Entry
+		table     *config.Table
+		chunkSize int64
+		logger    *logrus.Entry
 	}
 	tests := []struct {
 		name string
@@ -30,7 +33,7 @@ func TestNewDumper(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := NewDumper(tt.args.db, tt.args.dbName, tt.args.tableName, tt.args.logger); !reflect.DeepEqual(got, tt.want) {
+			if got := NewDumper(tt.args.db, tt.args.table, tt.args.chunkSize, tt.args.logger); !reflect.DeepEqual(got, tt.want) {
 				t.Errorf("NewDumper() = %v, want %v", got, tt.want)
 			}
 		})
@@ -52,20 +55,16 @@ func Test_dumpEntry_incrementCounter(t *testing.T) {
 }
 
 func Test_dumper_Dump(t *testing.T) {
-	type args struct {
-		w int
-	}
 	tests := []struct {
 		name    string
 		d       *dumper
-		args    args
 		wantErr bool
 	}{
 		// TODO: Add test cases.
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if err := tt.d.Dump(tt.args.w); (err != nil) != tt.wantErr {
+			if err := tt.d.Dump(); (err != nil) != tt.wantErr {
 				t.Errorf("dumper.Dump() error = %v, wantErr %v", err, tt.wantErr)
 			}
 		})
@@ -86,7 +85,7 @@ func Test_showDatabases(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := showDatabases(tt.args.db)
+			got, err := usql.ShowDatabases(tt.args.db)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("showDatabases() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -113,7 +112,7 @@ func Test_showTables(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			gotTables, err := showTables(tt.args.db, tt.args.dbName)
+			gotTables, err := usql.ShowTables(tt.args.db, tt.args.dbName, false)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("showTables() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -141,3 +140,74 @@ func Test_dumper_Close(t *testing.T) {
 		})
 	}
 }
+
+func TestSplitIntegerRange(t *testing.T) {
+	tests := []struct {
+		min  string
+		max  string
+		n    int
+		want []string
+	}{
+		{"1", "100", 4, []string{"25", "50", "75"}},
+		{"-10", "10", 2, []string{"0"}},
+		// fewer points than asked for a narrow range
+		{"1", "3", 10, []string{"1", "2"}},
+		{"5", "5", 4, nil},
+		{"0", "18446744073709551615", 2, []string{"9223372036854775807"}},
+	}
+	for _, tt := range tests {
+		got, err := splitIntegerRange(tt.min, tt.max, tt.n)
+		if err != nil {
+			t.Fatalf("splitIntegerRange(%v, %v, %v) error: %v", tt.min, tt.max, tt.n, err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Fatalf("splitIntegerRange(%v, %v, %v) = %v, want %v", tt.min, tt.max, tt.n, got, tt.want)
+		}
+	}
+	if _, err := splitIntegerRange("a", "10", 2); err == nil {
+		t.Fatalf("splitIntegerRange() accepted a bad integer")
+	}
+}
+
+func newSampleTestTable(columns ...umconf.Column) *config.Table {
+	for i := range columns {
+		columns[i].EscapedName = umconf.EscapeName(columns[i].RawName)
+	}
+	return &config.Table{
+		TableSchema: "db1",
+		TableName:   "tb1",
+		Where:       "true",
+		UseUniqueKey: &umconf.UniqueKey{
+			Name:    "PRIMARY",
+			Columns: *umconf.NewColumnList(columns),
+		},
+	}
+}
+
+func TestUniqueKeyBoundQuery(t *testing.T) {
+	table := newSampleTestTable(
+		umconf.Column{RawName: "id", Type: umconf.BigIntColumnType},
+		umconf.Column{RawName: "name", Type: umconf.VarcharColumnType},
+	)
+	want := "SELECT `id`+0, `name` FROM `db1`.`tb1` where `id` <= 100 order by `id` desc, `name` desc LIMIT 1"
+	if got := uniqueKeyBoundQuery(table, "100"); got != want {
+		t.Fatalf("uniqueKeyBoundQuery() = %v, want %v", got, want)
+	}
+}
+
+func TestSampleUniqueKeyBoundsNotSplit(t *testing.T) {
+	logger := logrus.NewEntry(logrus.New())
+	nullable := newSampleTestTable(umconf.Column{RawName: "id", Type: umconf.IntColumnType, Nullable: true})
+	nullable.UseUniqueKey.HasNullable = true
+	for _, table := range []*config.Table{
+		nullable,
+		newSampleTestTable(umconf.Column{RawName: "name", Type: umconf.VarcharColumnType}),
+		newSampleTestTable(umconf.Column{RawName: "ts", Type: umconf.DateTimeColumnType}),
+	} {
+		// returns before querying the db
+		bounds, err := sampleUniqueKeyBounds(nil, table, 4, logger)
+		if err != nil || bounds != nil {
+			t.Fatalf("sampleUniqueKeyBounds(%v) = %v, %v, want not split", table.UseUniqueKey, bounds, err)
+		}
+	}
+}
@@ -30,8 +30,6 @@ import (
 	"sync/atomic"
 	"time"
 
-	"github.com/golang/snappy"
-	gonats "github.com/nats-io/go-nats"
 	"github.com/nats-io/not.go"
 	gomysql "github.com/siddontang/go-mysql/mysql"
 
@@ -59,6 +57,8 @@ const (
 	DefaultConnectWait            = DefaultConnectWaitSecond * time.Second
 	DefaultBigTX                  = 1024 * 1024 * 100
 	ReconnectStreamerSleepSeconds = 5
+	SourceFailoverAttempts        = 12
+	SourceFailoverMaxNoProgress   = 3 // consecutive failovers without reading a transaction
 	SCHEMAS                       = "schemas"
 	SCHEMA                        = "schema"
 	TABLES                        = "tables"
@@ -74,8 +74,15 @@ type Extractor struct {
 
 	mysqlVersionDigit int
 	db                *gosql.DB
-	singletonDB       *gosql.DB
-	dumpers           []*dumper
+	// guards db and mysqlContext.ConnectionConfig, which are swapped by failover while the heartbeat
+	// writer and ID() read them. The throttler has stopped using db before streaming.
+	dbLock       sync.RWMutex
+	singletonDB  *gosql.DB
+	dumpers      []*dumper
+	dumpersMutex sync.Mutex
+	// nil if not doing full copy
+	checkpoint    *FullCopyCheckpoint
+	appliedChunks *appliedChunks
 	// db.tb exists when creating the job, for full-copy.
 	// vs e.mysqlContext.ReplicateDoDb: all user assigned db.tb
 	replicateDoDb            []*config.DataSource
@@ -91,8 +98,17 @@ type Extractor struct {
 	sendByTimeoutCounter  int
 	sendBySizeFullCounter int
 
-	natsConn *gonats.Conn
-	waitCh   chan *models.WaitResult
+	transport common.Transport
+	waitCh    chan *models.WaitResult
+	// might be nil
+	payloadCipher *common.PayloadCipher
+	codec         *common.Codec
+	// agreed with the dest in the handshake
+	wireVersion int
+	// nil if the dest does not support the flow control
+	creditAccount *common.CreditAccount
+	// created when a tx is first routed by OriginUuidRules
+	routeClient *common.RouteClient
 
 	shutdown     bool
 	shutdownCh   chan struct{}
@@ -101,11 +117,17 @@ type Extractor struct {
 	testStub1Delay int64
 
 	context *sqle.Context
+	// nil if the job has no gtid
+	schemaHistory *binlog.SchemaHistory
 
 	// This must be `<-` after `getSchemaTablesAndMeta()`.
 	gotCoordinateCh chan struct{}
 	streamerReadyCh chan error
 	fullCopyDone    chan struct{}
+
+	rateLimiters *g.RateLimiters
+	// nil if MaxLoad and CriticalLoad are not set
+	throttler *throttler
 }
 
 func NewExtractor(execCtx *common.ExecContext, cfg *config.MySQLDriverConfig, logger *logrus.Logger) (*Extractor, error) {
@@ -129,6 +151,7 @@ func NewExtractor(execCtx *common.ExecContext, cfg *config.MySQLDriverConfig, lo
 		gotCoordinateCh: make(chan struct{}),
 		streamerReadyCh: make(chan error),
 		fullCopyDone:    make(chan struct{}),
+		rateLimiters:    g.NewRateLimiters(cfg.RateLimit),
 	}
 	e.context.LoadSchemas(nil)
 
@@ -234,10 +257,10 @@ func (e *Extractor) Run() {
 			e.initBinlogReader(e.initialBinlogCoordinates)
 
 			go func() {
-				_, err := e.natsConn.Subscribe(fmt.Sprintf("%s_progress", e.subject), func(m *gonats.Msg) {
+				err := e.transport.Subscribe(fmt.Sprintf("%s_progress", e.subject), func(m *common.Msg) {
 					binlogFile := string(m.Data)
 					e.logger.Debugf("*** progress: %v", binlogFile)
-					err := e.natsConn.Publish(m.Reply, nil)
+					err := m.Ack()
 					if err != nil {
 						e.logger.Debugf("*** progress reply error. err %v", err)
 					}
@@ -258,11 +281,25 @@ func (e *Extractor) Run() {
 		defer span.Finish()
 		ctx = opentracing.ContextWithSpan(ctx, span)
 		e.mysqlContext.MarkRowCopyStartTime()
-		if err := e.mysqlDump(); err != nil {
+		var err error
+		e.throttler, err = newThrottler(e.db, e.mysqlContext.MaxLoad, e.mysqlContext.CriticalLoad, e.logger)
+		if err != nil {
+			e.onError(TaskStateDead, err)
+			return
+		}
+		throttleStopCh := make(chan struct{})
+		go func() {
+			if err := e.throttler.run(throttleStopCh); err != nil {
+				e.onError(TaskStateDead, err)
+			}
+		}()
+		err = e.mysqlDump()
+		close(throttleStopCh)
+		if err != nil {
 			e.onError(TaskStateDead, err)
 			return
 		}
-		dumpMsg, err := Encode(&DumpStatResult{
+		dumpMsg, err := e.encode(&DumpStatResult{
 			Gtid:       e.initialBinlogCoordinates.GtidSet,
 			LogFile:    e.initialBinlogCoordinates.LogFile,
 			LogPos:     e.initialBinlogCoordinates.LogPos,
@@ -273,6 +310,8 @@ func (e *Extractor) Run() {
 		}
 		if err := e.publish(ctx, fmt.Sprintf("%s_full_complete", e.subject), "", dumpMsg); err != nil {
 			e.onError(TaskStateDead, err)
+		} else if err := e.checkpoint.Remove(); err != nil {
+			e.logger.Warnf("mysql.extractor: failed to remove full copy checkpoint. err: %v", err)
 		}
 	} else { // no full copy
 		// Will not get consistent table meta-info for an incremental only job.
@@ -285,6 +324,10 @@ func (e *Extractor) Run() {
 			e.onError(TaskStateDead, err)
 			return
 		}
+		if err := e.initSchemaHistory(e.initialBinlogCoordinates.GtidSet, false); err != nil {
+			e.onError(TaskStateDead, err)
+			return
+		}
 		e.gotCoordinateCh <- struct{}{}
 	}
 	if !e.mysqlContext.BinlogRelay {
@@ -305,9 +348,66 @@ func (e *Extractor) Run() {
 			e.onError(TaskStateDead, err)
 			return
 		}
+		if e.mysqlContext.HeartbeatInterval > 0 {
+			if err := e.createTableHeartbeat(); err != nil {
+				e.onError(TaskStateDead, err)
+				return
+			}
+			go e.writeHeartbeat()
+		}
+	}
+}
+
+func (e *Extractor) createTableHeartbeat() error {
+	query := fmt.Sprintf(`
+			CREATE DATABASE IF NOT EXISTS %v;
+		`, g.DtleSchemaName)
+	if _, err := e.db.Exec(query); err != nil {
+		return err
+	}
+
+	query = fmt.Sprintf(`
+			CREATE TABLE IF NOT EXISTS %v.%v (
+				job_name varchar(255) NOT NULL COMMENT 'name of job',
+				ts bigint NOT NULL COMMENT 'unix microseconds on the source when the heartbeat was written.',
+				PRIMARY KEY (job_name)
+			);
+		`, g.DtleSchemaName, g.HeartbeatTable)
+	if _, err := e.db.Exec(query); err != nil {
+		return err
+	}
+	e.logger.Debugf("mysql.extractor. after create heartbeat table")
+
+	return nil
+}
+
+// writeHeartbeat periodically writes the source time into the heartbeat table.
+// The binlog reader recognizes the row and the dest computes the lag when the tx is applied.
+// A failed write is not fatal. The lag stat just grows.
+func (e *Extractor) writeHeartbeat() {
+	query := fmt.Sprintf("insert into %v.%v (job_name, ts) values (?, floor(unix_timestamp(now(6)) * 1000000))"+
+		" on duplicate key update ts = values(ts)", g.DtleSchemaName, g.HeartbeatTable)
+	ticker := time.NewTicker(time.Duration(e.mysqlContext.HeartbeatInterval) * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-e.shutdownCh:
+			return
+		case <-ticker.C:
+			if _, err := e.currentDB().Exec(query, e.subject); err != nil {
+				e.logger.Warnf("mysql.extractor. failed to write heartbeat. err: %v", err)
+			}
+		}
 	}
 }
 
+// currentDB returns e.db, which might be swapped by failover.
+func (e *Extractor) currentDB() *gosql.DB {
+	e.dbLock.RLock()
+	defer e.dbLock.RUnlock()
+	return e.db
+}
+
 // initiateInspector connects, validates and inspects the "inspector" server.
 // The "inspector" server is typically a replica; it is where we issue some
 // queries such as:
@@ -541,22 +641,48 @@ func (e *Extractor) readTableColumns() (err error) {
 			if err != nil {
 				return err
 			}
-			doTb.ColumnMap = config.BuildColumnMapIndex(doTb.ColumnMapFrom, doTb.OriginalTableColumns.Ordinals)
-
+			if err := doTb.BuildColumnMap(); err != nil {
+				return err
+			}
 		}
 	}
 	return nil
 }
 
 func (e *Extractor) initNatsPubClient() (err error) {
-	natsAddr := fmt.Sprintf("nats://%s", e.mysqlContext.NatsAddr)
-	sc, err := gonats.Connect(natsAddr)
+	e.transport, err = common.NewTransport(e.mysqlContext.Transport, e.mysqlContext.NatsAddr,
+		e.mysqlContext.TransportPort, false, e.execCtx.NatsSecurity, e.logger)
+	if err != nil {
+		e.logger.Errorf("mysql.extractor: Can't connect %v transport. nats server %v. make sure a nats streaming server is running.%v",
+			e.mysqlContext.Transport, e.mysqlContext.NatsAddr, err)
+		return err
+	}
+	e.logger.Debugf("mysql.extractor: Connect %v transport. nats server %v", e.mysqlContext.Transport, e.mysqlContext.NatsAddr)
+	e.payloadCipher, err = common.NewPayloadCipher(e.mysqlContext.EncryptionKeyFile, e.logger)
 	if err != nil {
-		e.logger.Errorf("mysql.extractor: Can't connect nats server %v. make sure a nats streaming server is running.%v", natsAddr, err)
 		return err
 	}
-	e.logger.Debugf("mysql.extractor: Connect nats server %v", natsAddr)
-	e.natsConn = sc
+	e.codec, err = common.NewCodec(e.mysqlContext.Compression, e.mysqlContext.CompressionLevel, e.execCtx.MaxPayload)
+	if err != nil {
+		return err
+	}
+	// A dest of an older version is over nats, and knows neither encryption nor the other codecs.
+	// Subscribe before the handshake, so the first credits are not missed.
+	creditAccount, err := common.NewCreditAccount(e.transport, e.subject, e.logger)
+	if err != nil {
+		return err
+	}
+	_, isNats := e.transport.(*common.NatsTransport)
+	allowLegacy := isNats && e.payloadCipher == nil &&
+		(e.mysqlContext.Compression == "" || e.mysqlContext.Compression == common.CodecSnappy)
+	e.wireVersion, err = common.RequestHello(e.transport, e.subject, e.mysqlContext.SnapshotParallelism, allowLegacy,
+		e.shutdownCh, e.logger)
+	if err != nil {
+		return err
+	}
+	if e.wireVersion >= common.WireVersionFlowControl {
+		e.creditAccount = creditAccount
+	}
 
 	return nil
 }
@@ -572,7 +698,7 @@ func (e *Extractor) initiateStreaming() error {
 	}()
 
 	go func() {
-		_, err := e.natsConn.Subscribe(fmt.Sprintf("%s_restart", e.subject), func(m *gonats.Msg) {
+		err := e.transport.Subscribe(fmt.Sprintf("%s_restart", e.subject), func(m *common.Msg) {
 			e.mysqlContext.Gtid = string(m.Data)
 			e.onError(TaskStateRestart, fmt.Errorf("restart"))
 		})
@@ -580,7 +706,7 @@ func (e *Extractor) initiateStreaming() error {
 			e.onError(TaskStateRestart, err)
 		}
 
-		_, err = e.natsConn.Subscribe(fmt.Sprintf("%s_error", e.subject), func(m *gonats.Msg) {
+		err = e.transport.Subscribe(fmt.Sprintf("%s_error", e.subject), func(m *common.Msg) {
 			e.mysqlContext.Gtid = string(m.Data)
 			e.onError(TaskStateDead, fmt.Errorf("applier"))
 		})
@@ -671,10 +797,96 @@ func (e *Extractor) getSchemaTablesAndMeta() error {
 	return nil
 }
 
+// initSchemaHistory loads the schema history, and rebuilds the table meta as of gtidSet (the binlog start position).
+// The current table meta (read by getSchemaTablesAndMeta) is used as a snapshot if the history cannot
+// cover gtidSet or if fresh is true.
+func (e *Extractor) initSchemaHistory(gtidSet string, fresh bool) (err error) {
+	if gtidSet == "" {
+		e.logger.Warnf("mysql.extractor: no gtid for the job. schema history is not enabled")
+		return nil
+	}
+	historyPath := binlog.GetSchemaHistoryPath(e.execCtx.StateDir, e.subject)
+	e.schemaHistory, err = binlog.LoadSchemaHistory(historyPath)
+	if err != nil {
+		return err
+	}
+
+	if e.schemaHistory != nil && !fresh {
+		covered, err := e.schemaHistory.Truncate(gtidSet)
+		if err != nil {
+			return err
+		}
+		if covered {
+			e.logger.Infof("mysql.extractor: rebuilding table meta from schema history. gtid: %v, records: %v",
+				gtidSet, len(e.schemaHistory.Records))
+			return e.replaySchemaHistory()
+		}
+		e.logger.Warnf("mysql.extractor: schema history %v cannot cover gtid %v. using the current table meta",
+			historyPath, gtidSet)
+	}
+
+	if e.schemaHistory == nil {
+		e.schemaHistory = binlog.NewSchemaHistory(historyPath)
+	}
+	snapshot := &binlog.SchemaHistoryRecord{
+		Snapshot: true,
+		Gtid:     gtidSet,
+	}
+	for _, db := range e.replicateDoDb {
+		for _, tb := range db.Tables {
+			if !e.context.HasTable(tb.TableSchema, tb.TableName) {
+				continue
+			}
+			query, err := binlog.ShowCreateTableSqle(e.context, tb.TableSchema, tb.TableName)
+			if err != nil {
+				return err
+			}
+			snapshot.Queries = append(snapshot.Queries, query)
+			snapshot.Tables = append(snapshot.Tables, &binlog.SchemaHistoryTable{
+				TableSchema: tb.TableSchema,
+				TableName:   tb.TableName,
+				Columns:     tb.OriginalTableColumns,
+			})
+		}
+	}
+	return e.schemaHistory.Reset(snapshot)
+}
+
+// replaySchemaHistory replaces the sqle context and the table columns with those in the schema history.
+func (e *Extractor) replaySchemaHistory() error {
+	context := sqle.NewContext(nil)
+	columnsMap, err := e.schemaHistory.Replay(context)
+	if err != nil {
+		return err
+	}
+	for _, db := range e.replicateDoDb {
+		for _, tb := range db.Tables {
+			if !context.HasTable(tb.TableSchema, tb.TableName) {
+				// The table will be created in binlog.
+				e.logger.Debugf("mysql.extractor: table %v.%v is not in schema history", tb.TableSchema, tb.TableName)
+				continue
+			}
+			columns := columnsMap[tb.TableSchema][tb.TableName]
+			if columns == nil {
+				columns, err = base.GetTableColumnsSqle(context, tb.TableSchema, tb.TableName)
+				if err != nil {
+					return err
+				}
+			}
+			tb.OriginalTableColumns = columns
+			if err := tb.BuildColumnMap(); err != nil {
+				return err
+			}
+		}
+	}
+	e.context = context
+	return nil
+}
+
 // initBinlogReader creates and connects the reader: we hook up to a MySQL server as a replica
 // Cooperate with `initiateStreaming()` using `e.streamerReadyCh`. Any err will be sent thru the chan.
 func (e *Extractor) initBinlogReader(binlogCoordinates *base.BinlogCoordinatesX) {
-	binlogReader, err := binlog.NewMySQLReader(e.execCtx, e.mysqlContext, e.logger, e.replicateDoDb, e.context)
+	binlogReader, err := binlog.NewMySQLReader(e.execCtx, e.mysqlContext, e.logger, e.replicateDoDb, e.context, e.schemaHistory)
 	if err != nil {
 		e.logger.Debugf("mysql.extractor: err at initBinlogReader: NewMySQLReader: %v", err.Error())
 		e.streamerReadyCh <- err
@@ -846,16 +1058,10 @@ func GobEncode(v interface{}) ([]byte, error) {
 	}
 	return b.Bytes(), nil
 }
-func Encode(v interface{}) ([]byte, error) {
 
-	//	gob.Register(types.BinaryLiteral{})   can not use.when the data more than 500M ,it Cause memory out
-	b := new(bytes.Buffer)
-	enc := gob.NewEncoder(b)
-	if err := enc.Encode(v); err != nil {
-		return nil, err
-	}
-	return snappy.Encode(nil, b.Bytes()), nil
-	//return b.Bytes(), nil
+// encode encodes v in the wire version agreed with the dest, and compresses it with the codec of the job.
+func (e *Extractor) encode(v interface{}) ([]byte, error) {
+	return encodeWire(e.wireVersion, e.codec, v)
 }
 
 // StreamEvents will begin streaming events. It will be blocking, so should be
@@ -874,7 +1080,18 @@ func (e *Extractor) StreamEvents() error {
 				if len(entries.Entries) > 0 {
 					gno = entries.Entries[0].Coordinates.GNO
 				}
-				txMsg, err := Encode(entries)
+				if e.creditAccount != nil {
+					// Blocks the binlog reading (by dataChannel) until the dest catches up.
+					nEntries, nBytes := entries.CreditCost()
+					err := e.creditAccount.Acquire(nEntries, nBytes, e.shutdownCh, func() {
+						e.logger.Debugf("mysql.extractor: waiting for credits. gno: %v", gno)
+						e.mysqlContext.Stage = models.StageWaitingForCredits
+					})
+					if err != nil {
+						return err
+					}
+				}
+				txMsg, err := e.encode(&entries)
 				if err != nil {
 					return err
 				}
@@ -892,6 +1109,32 @@ func (e *Extractor) StreamEvents() error {
 				entriesSize = 0
 				return nil
 			}
+			// A spilled tx is sent as a big tx, a part for each chunk on the disk and the last for the events in memory.
+			sendSpilledEntry := func(binlogEntry *binlog.BinlogEntry) error {
+				defer binlogEntry.DiscardSpill()
+				txLen := binlogEntry.Spill().Chunks() + 1
+				txNum := 0
+				return binlogEntry.ForEachEvents(func(events []binlog.DataEvent) error {
+					txNum += 1
+					part := &binlog.BinlogEntry{
+						Coordinates:  binlogEntry.Coordinates,
+						OriginalSize: binlogEntry.OriginalSize,
+						Events:       events,
+					}
+					if txNum == 1 {
+						// The dest appends the events of the other parts to the first.
+						part.HeartbeatTs = binlogEntry.HeartbeatTs
+						part.RowsQueries = binlogEntry.RowsQueries
+					}
+					entries.Entries = []*binlog.BinlogEntry{part}
+					entries.BigTx = true
+					entries.TxNum = txNum
+					entries.TxLen = txLen
+					e.logger.Debugf("extractor. incr. send spilled tx part %v/%v. gno: %v",
+						txNum, txLen, binlogEntry.Coordinates.GNO)
+					return sendEntries()
+				})
+			}
 
 			keepGoing := true
 
@@ -909,6 +1152,27 @@ func (e *Extractor) StreamEvents() error {
 					ctx = opentracing.ContextWithSpan(ctx, span)
 					//span.SetTag("timetag", time.Now().Unix())
 					binlogEntry.SpanContext = nil
+					if binlogEntry.RouteTo != "" {
+						// The tx must not go to the applier of this job instead.
+						if err = e.publishRoutedEntry(ctx, binlogEntry); err != nil {
+							span.Finish()
+							break
+						}
+					}
+					if err == nil && binlogEntry.Spill() != nil {
+						if len(entries.Entries) > 0 {
+							err = sendEntries()
+						}
+						if err == nil {
+							err = sendSpilledEntry(binlogEntry)
+						}
+						if !timer.Stop() {
+							<-timer.C
+						}
+						timer.Reset(groupTimeoutDuration)
+						span.Finish()
+						break
+					}
 					entries.Entries = append(entries.Entries, binlogEntry)
 					entriesSize += binlogEntry.OriginalSize
 
@@ -971,7 +1235,7 @@ func (e *Extractor) StreamEvents() error {
 							continue
 						}
 						entryArray = append(entryArray, binlogEntry)
-						txMsg, err := Encode(&entryArray)
+						txMsg, err := e.encode(&entryArray)
 						if err != nil {
 							e.onError(TaskStateDead, err)
 							break L
@@ -992,7 +1256,7 @@ func (e *Extractor) StreamEvents() error {
 				case <-time.After(100 * time.Millisecond):
 					{
 						if len(entryArray) != 0 {
-							txMsg, err := Encode(&entryArray)
+							txMsg, err := e.encode(&entryArray)
 							if err != nil {
 								e.onError(TaskStateDead, err)
 								break L
@@ -1020,16 +1284,149 @@ func (e *Extractor) StreamEvents() error {
 		}()*/
 		// endregion
 		// The next should block and execute forever, unless there's a serious error
-		if err := e.binlogReader.DataStreamEvents(e.dataChannel); err != nil {
-			if e.shutdown {
-				return nil
+		// failovers counts the consecutive source failovers without any transaction read.
+		failovers := 0
+		for {
+			gtidSetBefore := e.binlogReader.GetReadGtidSet()
+			err := e.binlogReader.DataStreamEvents(e.dataChannel)
+			if err == nil || e.shutdown {
+				break
+			}
+			if len(e.mysqlContext.SourceCandidates) == 0 || !binlog.IsConnectionError(err) {
+				return fmt.Errorf("mysql.extractor: StreamEvents encountered unexpected error: %+v", err)
+			}
+			if e.binlogReader.GetReadGtidSet() != gtidSetBefore {
+				failovers = 0
+			}
+			if failovers >= SourceFailoverMaxNoProgress {
+				return fmt.Errorf("mysql.extractor: StreamEvents encountered unexpected error: %+v. "+
+					"source failover: no progress after %v failovers", err, failovers)
+			}
+			if failovers > 0 {
+				time.Sleep(time.Duration(failovers) * ReconnectStreamerSleepSeconds * time.Second)
+			}
+			failovers++
+			e.logger.Warnf("mysql.extractor: StreamEvents encountered error. trying source failover. err: %v", err)
+			if failoverErr := e.failover(); failoverErr != nil {
+				return fmt.Errorf("mysql.extractor: StreamEvents encountered unexpected error: %+v. source failover: %v",
+					err, failoverErr)
 			}
-			return fmt.Errorf("mysql.extractor: StreamEvents encountered unexpected error: %+v", err)
 		}
 	}
 	return nil
 }
 
+// candidateConnectionConfig fills the empty User/Password/Charset of a source candidate.
+func (e *Extractor) candidateConnectionConfig(candidate *umconf.ConnectionConfig) *umconf.ConnectionConfig {
+	conn := *candidate
+	if conn.User == "" {
+		conn.User = e.mysqlContext.ConnectionConfig.User
+		if conn.Password == "" {
+			conn.Password = e.mysqlContext.ConnectionConfig.Password
+		}
+	}
+	if conn.Charset == "" {
+		conn.Charset = e.mysqlContext.ConnectionConfig.Charset
+	}
+	return &conn
+}
+
+// candidateHasGtidSet tells whether gtid_executed of the server contains gtidSet.
+func candidateHasGtidSet(conn *umconf.ConnectionConfig, gtidSet gomysql.GTIDSet) (bool, error) {
+	db, err := sql.CreateDB(conn.GetDBUri())
+	if err != nil {
+		return false, err
+	}
+	defer sql.CloseDB(db)
+
+	var executedStr string
+	if err := db.QueryRow("select @@global.gtid_executed").Scan(&executedStr); err != nil {
+		return false, err
+	}
+	return gtidExecutedContains(executedStr, gtidSet)
+}
+
+// gtidExecutedContains tells whether executedStr, the value of gtid_executed, contains gtidSet.
+func gtidExecutedContains(executedStr string, gtidSet gomysql.GTIDSet) (bool, error) {
+	executed, err := gomysql.ParseMysqlGTIDSet(executedStr)
+	if err != nil {
+		return false, err
+	}
+	return executed.Contain(gtidSet), nil
+}
+
+// failoverCandidates returns ConnectionConfig and SourceCandidates, in the order to be tried on failover.
+func (e *Extractor) failoverCandidates() []*umconf.ConnectionConfig {
+	candidates := []*umconf.ConnectionConfig{e.mysqlContext.ConnectionConfig}
+	for _, candidate := range e.mysqlContext.SourceCandidates {
+		candidates = append(candidates, e.candidateConnectionConfig(candidate))
+	}
+	return candidates
+}
+
+// failover continues binlog streaming on a server whose gtid_executed contains the transactions read.
+// The candidates are ConnectionConfig (a DNS name is resolved again) and SourceCandidates, in order.
+// A newly promoted master might not be ready yet, so the candidates are tried for some rounds.
+func (e *Extractor) failover() error {
+	if e.mysqlContext.BinlogRelay {
+		return fmt.Errorf("source failover is not supported with BinlogRelay")
+	}
+	gtidSetStr := e.binlogReader.GetReadGtidSet()
+	if gtidSetStr == "" {
+		return fmt.Errorf("source failover requires GTID")
+	}
+	gtidSet, err := gomysql.ParseMysqlGTIDSet(gtidSetStr)
+	if err != nil {
+		return err
+	}
+
+	oldConn := e.mysqlContext.ConnectionConfig
+	candidates := e.failoverCandidates()
+	for i := 0; i < SourceFailoverAttempts && !e.shutdown; i++ {
+		if i > 0 {
+			time.Sleep(ReconnectStreamerSleepSeconds * time.Second)
+		}
+		for _, conn := range candidates {
+			ok, err := candidateHasGtidSet(conn, gtidSet)
+			if err != nil {
+				e.logger.Warnf("mysql.extractor: source candidate %v:%v is not available. err: %v", conn.Host, conn.Port, err)
+				continue
+			}
+			if !ok {
+				e.logger.Infof("mysql.extractor: source candidate %v:%v does not have gtid %v", conn.Host, conn.Port, gtidSet)
+				continue
+			}
+			if err := e.binlogReader.FailoverTo(conn); err != nil {
+				e.logger.Warnf("mysql.extractor: failed to fail over to %v:%v. err: %v", conn.Host, conn.Port, err)
+				continue
+			}
+
+			db, err := sql.CreateDB(conn.GetDBUri())
+			if err != nil {
+				return err
+			}
+			e.dbLock.Lock()
+			oldDb := e.db
+			e.db = db
+			e.mysqlContext.ConnectionConfig = conn
+			e.dbLock.Unlock()
+			// A heartbeat being written on oldDb is waited for, and the next one goes to db.
+			if err := sql.CloseDB(oldDb); err != nil {
+				e.logger.Warnf("mysql.extractor: error closing db on failover. err: %v", err)
+			}
+
+			msg := fmt.Sprintf("source failover from %v:%v to %v:%v at gtid %v",
+				oldConn.Host, oldConn.Port, conn.Host, conn.Port, gtidSet)
+			e.logger.Warnf("mysql.extractor: %v", msg)
+			if e.execCtx.EmitEvent != nil {
+				e.execCtx.EmitEvent(models.NewTaskEvent(models.TaskSourceFailover).SetDriverMessage(msg))
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("no source candidate has gtid %v", gtidSet)
+}
+
 func splitEntries(entries binlog.BinlogEntries, entriseSize int) (entris []binlog.BinlogEntries) {
 	clientLen := math.Ceil(float64(entriseSize) / DefaultBigTX)
 	clientNum := math.Ceil(float64(len(entries.Entries[0].Events)) / clientLen)
@@ -1060,9 +1457,51 @@ func splitEntries(entries binlog.BinlogEntries, entriseSize int) (entris []binlo
 	return entris
 }
 
+// publishRoutedEntry sends an entry routed by OriginUuidRules to the job it is routed to.
+// The events are then removed from the entry, which is still sent to the applier of this job to record the GTID.
+func (e *Extractor) publishRoutedEntry(ctx context.Context, binlogEntry *binlog.BinlogEntry) error {
+	if _, ok := e.transport.(*common.NatsTransport); !ok {
+		// The other job is not at the end of this point-to-point connection.
+		return fmt.Errorf("routing a tx to job %v requires the %v transport", binlogEntry.RouteTo, common.TransportNats)
+	}
+	if binlogEntry.Spill() != nil {
+		return fmt.Errorf("cannot route tx %v to job %v: it exceeds TxMemoryLimit and is spilled",
+			binlogEntry.Coordinates.GNO, binlogEntry.RouteTo)
+	}
+	if e.routeClient == nil {
+		var err error
+		if e.routeClient, err = common.NewRouteClient(e.transport, e.subject, e.logger); err != nil {
+			return err
+		}
+	}
+	// The payload is encoded and encrypted as for this job. Check that the dest of that job can read it.
+	err := e.routeClient.Check(binlogEntry.RouteTo, e.wireVersion, e.payloadCipher.CurrentKeyId(), e.shutdownCh)
+	if err != nil {
+		return fmt.Errorf("cannot route tx %v: %v", binlogEntry.Coordinates.GNO, err)
+	}
+	routed := *binlogEntry
+	routed.RouteTo = ""
+	routed.Routed = true
+	txMsg, err := e.encode(&binlog.BinlogEntries{Entries: []*binlog.BinlogEntry{&routed}})
+	if err != nil {
+		return err
+	}
+	e.logger.Debugf("mysql.extractor: sending a routed tx. gno: %v, to: %v", binlogEntry.Coordinates.GNO, binlogEntry.RouteTo)
+	if err := e.publish(ctx, fmt.Sprintf("%s_incr_hete", binlogEntry.RouteTo), "", txMsg); err != nil {
+		return err
+	}
+	binlogEntry.Events = nil
+	binlogEntry.RowsQueries = nil
+	binlogEntry.RouteTo = ""
+	return nil
+}
+
 // retryOperation attempts up to `count` attempts at running given function,
 // exiting as soon as it returns with non-error.
 func (e *Extractor) publish(ctx context.Context, subject, gtid string, txMsg []byte) (err error) {
+	if txMsg, err = e.payloadCipher.Encrypt(subject, txMsg); err != nil {
+		return err
+	}
 	tracer := opentracing.GlobalTracer()
 	var t not.TraceMsg
 	var spanctx opentracing.SpanContext
@@ -1085,16 +1524,21 @@ func (e *Extractor) publish(ctx context.Context, subject, gtid string, txMsg []b
 	// Add the payload.
 	t.Write(txMsg)
 	defer span.Finish()
+	if strings.HasSuffix(subject, "_incr_hete") {
+		for _, limiters := range []*g.RateLimiters{e.rateLimiters, g.AgentRateLimiters} {
+			limiters.IncrBytes.Wait(int64(len(txMsg)), e.shutdownCh)
+		}
+	}
 	for {
 		e.logger.Debugf("mysql.extractor: publish. gtid: %v, msg_len: %v, subject: %v ", gtid, len(txMsg), subject)
-		_, err = e.natsConn.Request(subject, t.Bytes(), DefaultConnectWait)
+		err = e.transport.Request(subject, t.Bytes(), DefaultConnectWait)
 		if err == nil {
 			if gtid != "" {
 				e.mysqlContext.Gtid = gtid
 			}
 			txMsg = nil
 			break
-		} else if err == gonats.ErrTimeout {
+		} else if err == common.ErrTransportTimeout {
 			e.logger.Debugf("mysql.extractor: publish timeout, got %v", err)
 			continue
 		} else {
@@ -1142,9 +1586,27 @@ func (e *Extractor) sendSysVarAndSqlMode() error {
 //Perform the snapshot using the same logic as the "mysqldump" utility.
 func (e *Extractor) mysqlDump() error {
 	defer e.singletonDB.Close()
-	var tx sql.QueryAble
+	// one transaction for each snapshot worker
+	var txs []sql.QueryAble
 	var err error
 	step := 0
+	snapshotParallelism := e.mysqlContext.SnapshotParallelism
+	if snapshotParallelism < 1 {
+		snapshotParallelism = 1
+	}
+
+	checkpointPath := getFullCopyCheckpointPath(e.execCtx.StateDir, e.subject)
+	e.checkpoint, err = LoadFullCopyCheckpoint(checkpointPath)
+	if err != nil {
+		return err
+	}
+	resuming := e.checkpoint != nil
+	if resuming {
+		e.logger.Infof("mysql.extractor: resuming full copy from checkpoint. snapshot gtid: %v", e.checkpoint.Gtid)
+		if err := e.validateResumingCheckpoint(); err != nil {
+			return err
+		}
+	}
 	// ------
 	// STEP 0
 	// ------
@@ -1185,7 +1647,8 @@ func (e *Extractor) mysqlDump() error {
 
 	var needConsistentSnapshot = true // TODO determine by table characteristic (has-PK or not)
 	if needConsistentSnapshot {
-		e.logger.Printf("mysql.extractor: Step %d: start transaction with consistent snapshot", step)
+		e.logger.Printf("mysql.extractor: Step %d: start transaction with consistent snapshot. parallelism: %v",
+			step, snapshotParallelism)
 		gtidMatch := false
 		gtidMatchRound := 0
 		delayBetweenRetries := 200 * time.Millisecond
@@ -1198,52 +1661,89 @@ func (e *Extractor) mysqlDump() error {
 				e.logger.Errorf("mysql.extractor: get gtid, round: %v, phase 1, err: %v", gtidMatchRound, err)
 				return err
 			}
+			binlogCoordinates1, err := base.ParseBinlogCoordinatesFromRows(rows1)
+			if err != nil {
+				return err
+			}
+			e.logger.Debugf("mysql.extractor: binlog coordinates 1: %+v", binlogCoordinates1)
 
 			e.testStub1()
 
 			// 2
-			// TODO it seems that two 'start transaction' will be sent.
-			// https://github.com/golang/go/issues/19981
-			realTx, err := e.singletonDB.Begin()
-			tx = realTx
-			if err != nil {
-				return err
+			// Every snapshot connection starts its own consistent snapshot. They share the same
+			// consistent point iff no transaction is committed between phase 1 and phase 3.
+			var realTxs []*gosql.Tx
+			rollbackAll := func() error {
+				for i := range realTxs {
+					if err := realTxs[i].Rollback(); err != nil {
+						return err
+					}
+				}
+				return nil
 			}
-			query := "START TRANSACTION WITH CONSISTENT SNAPSHOT"
-			_, err = realTx.Exec(query)
-			if err != nil {
-				e.logger.Printf("[ERR] mysql.extractor: exec %+v, error: %v", query, err)
-				return err
+			for i := 0; i < snapshotParallelism; i++ {
+				// TODO it seems that two 'start transaction' will be sent.
+				// https://github.com/golang/go/issues/19981
+				realTx, err := e.singletonDB.Begin()
+				if err != nil {
+					rollbackAll()
+					return err
+				}
+				realTxs = append(realTxs, realTx)
+				query := "START TRANSACTION WITH CONSISTENT SNAPSHOT"
+				_, err = realTx.Exec(query)
+				if err != nil {
+					e.logger.Printf("[ERR] mysql.extractor: exec %+v, error: %v", query, err)
+					rollbackAll()
+					return err
+				}
 			}
 
 			e.testStub1()
 
 			// 3
-			rows2, err := realTx.Query("show master status")
-
-			// 4
-			binlogCoordinates1, err := base.ParseBinlogCoordinatesFromRows(rows1)
-			if err != nil {
-				return err
-			}
-			binlogCoordinates2, err := base.ParseBinlogCoordinatesFromRows(rows2)
-			if err != nil {
-				return err
-			}
-			e.logger.Debugf("mysql.extractor: binlog coordinates 1: %+v", binlogCoordinates1)
-			e.logger.Debugf("mysql.extractor: binlog coordinates 2: %+v", binlogCoordinates2)
+			gtidMatch = true
+			for i, realTx := range realTxs {
+				rows2, err := realTx.Query("show master status")
+				if err != nil {
+					rollbackAll()
+					return err
+				}
 
-			if binlogCoordinates1.GtidSet == binlogCoordinates2.GtidSet {
-				gtidMatch = true
-				e.logger.Infof("Got gtid after %v rounds", gtidMatchRound)
+				// 4
+				binlogCoordinates2, err := base.ParseBinlogCoordinatesFromRows(rows2)
+				if err != nil {
+					rollbackAll()
+					return err
+				}
+				e.logger.Debugf("mysql.extractor: binlog coordinates 2 (tx %v): %+v", i, binlogCoordinates2)
 
+				if binlogCoordinates1.GtidSet != binlogCoordinates2.GtidSet {
+					gtidMatch = false
+					break
+				}
 				// Obtain the binlog position and update the SourceInfo in the context. This means that all source records generated
 				// as part of the snapshot will contain the binlog position of the snapshot.
 				//binlogCoordinates, err := base.GetSelfBinlogCoordinatesWithTx(tx)
-
 				e.initialBinlogCoordinates = binlogCoordinates2
+			}
+
+			if gtidMatch {
+				e.logger.Infof("Got gtid after %v rounds", gtidMatchRound)
+				if resuming {
+					// Rows already copied were read from the previous snapshot. The remaining rows are read from
+					// the new snapshot, and binlog will be replayed from the previous snapshot, which is idempotent.
+					e.initialBinlogCoordinates = &base.BinlogCoordinatesX{
+						LogFile: e.checkpoint.LogFile,
+						LogPos:  e.checkpoint.LogPos,
+						GtidSet: e.checkpoint.Gtid,
+					}
+				}
 				e.logger.Printf("mysql.extractor: Step %d: read binlog coordinates of MySQL master: %+v", step, *e.initialBinlogCoordinates)
 
+				for i := range realTxs {
+					txs = append(txs, realTxs[i])
+				}
 				defer func() {
 					/*e.logger.Printf("mysql.extractor: Step %d: releasing global read lock to enable MySQL writes", step)
 					query := "UNLOCK TABLES"
@@ -1253,13 +1753,15 @@ func (e *Extractor) mysqlDump() error {
 					}
 					step++*/
 					e.logger.Printf("mysql.extractor: Step %d: committing transaction", step)
-					if err := realTx.Commit(); err != nil {
-						e.onError(TaskStateDead, err)
+					for i := range realTxs {
+						if err := realTxs[i].Commit(); err != nil {
+							e.onError(TaskStateDead, err)
+						}
 					}
 				}()
 			} else {
 				e.logger.Warningf("Failed got a consistenct TX with GTID in %v rounds. Will retry.", gtidMatchRound)
-				err = realTx.Rollback()
+				err = rollbackAll()
 				if err != nil {
 					return err
 				}
@@ -1268,8 +1770,8 @@ func (e *Extractor) mysqlDump() error {
 		}
 	} else {
 		e.logger.Debugf("mysql.extractor: no need to get consistent snapshot")
-		tx = e.singletonDB
-		rows1, err := tx.Query("show master status")
+		txs = append(txs, e.singletonDB)
+		rows1, err := e.singletonDB.Query("show master status")
 		if err != nil {
 			return err
 		}
@@ -1278,9 +1780,38 @@ func (e *Extractor) mysqlDump() error {
 			return err
 		}
 		e.logger.Debugf("mysql.extractor: got gtid")
+		if resuming {
+			e.initialBinlogCoordinates = &base.BinlogCoordinatesX{
+				LogFile: e.checkpoint.LogFile,
+				LogPos:  e.checkpoint.LogPos,
+				GtidSet: e.checkpoint.Gtid,
+			}
+		}
 	}
 	step++
 
+	if !resuming {
+		e.checkpoint = NewFullCopyCheckpoint(checkpointPath, e.initialBinlogCoordinates.GtidSet,
+			e.initialBinlogCoordinates.LogFile, e.initialBinlogCoordinates.LogPos)
+		if err := e.checkpoint.Persist(); err != nil {
+			return err
+		}
+	}
+	e.appliedChunks = newAppliedChunks()
+	err = e.transport.Subscribe(fmt.Sprintf("%s_full_applied", e.subject), func(m *common.Msg) {
+		seq, err := strconv.ParseInt(string(m.Data), 10, 64)
+		if err != nil {
+			e.logger.Warnf("mysql.extractor: bad full_applied. err: %v", err)
+			return
+		}
+		if err := e.appliedChunks.applied(seq); err != nil {
+			e.onError(TaskStateDead, err)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
 	// ------
 	// STEP 4
 	// ------
@@ -1293,6 +1824,9 @@ func (e *Extractor) mysqlDump() error {
 	if err != nil {
 		return err
 	}
+	if err := e.initSchemaHistory(e.initialBinlogCoordinates.GtidSet, true); err != nil {
+		return err
+	}
 
 	e.gotCoordinateCh <- struct{}{}
 
@@ -1307,14 +1841,37 @@ func (e *Extractor) mysqlDump() error {
 				if tb.TableSchema != db.TableSchema {
 					continue
 				}
-				total, err := e.CountTableRows(tb)
-				if err != nil {
-					return err
+				tc := e.checkpoint.GetTable(tb.TableSchema, tb.TableName)
+				// A partially copied table without unique key is copied again from the beginning.
+				restartTable := false
+				if tc != nil && !tc.Done {
+					if os.Getenv(g.ENV_DUMP_OLDWAY) != "" {
+						return fmt.Errorf("cannot resume full copy: table %v.%v was partially copied by LIMIT/OFFSET."+
+							" remove %v to start the full copy over", tb.TableSchema, tb.TableName, checkpointPath)
+					}
+					if tb.UseUniqueKey == nil {
+						e.logger.Warnf("mysql.extractor: table %v.%v has no usable unique key. copy it again from the beginning",
+							tb.TableSchema, tb.TableName)
+						restartTable = true
+					}
+				}
+				var total int64
+				if tc == nil || !tc.Done {
+					total, err = e.CountTableRows(tb)
+					if err != nil {
+						return err
+					}
+				}
+				if tc != nil && !tc.Done && !restartTable {
+					// rows before the checkpoint will not be sent again
+					total -= tc.RowsCopied
+					atomic.AddInt64(&e.mysqlContext.RowsEstimate, -tc.RowsCopied)
 				}
 				tb.Counter = total
 				var dbSQL string
 				var tbSQL []string
-				if !e.mysqlContext.SkipCreateDbTable {
+				// Do not recreate (or drop) a table which has been (partially) copied.
+				if !e.mysqlContext.SkipCreateDbTable && tc == nil {
 					var err error
 					if strings.ToLower(tb.TableSchema) != "mysql" {
 						if db.TableSchemaRename != "" {
@@ -1330,6 +1887,15 @@ func (e *Extractor) mysqlDump() error {
 						}*/
 					} else if strings.ToLower(tb.TableSchema) != "mysql" {
 						tbSQL, err = base.ShowCreateTable(e.singletonDB, tb.TableSchema, tb.TableName, e.mysqlContext.DropTableIfExists, true)
+						if err == nil && len(tb.ColumnMap) > 0 {
+							// The dest table has only the replicated columns.
+							fromNames := make([]string, len(tb.ColumnMap))
+							for i, idx := range tb.ColumnMap {
+								fromNames[i] = tb.OriginalTableColumns.Columns[idx].RawName
+							}
+							last := len(tbSQL) - 1
+							tbSQL[last], err = base.MapCreateTableColumns(tbSQL[last], fromNames, tb.MappedTableColumns.Names())
+						}
 						for num, sql := range tbSQL {
 							if db.TableSchemaRename != "" && strings.Contains(sql, fmt.Sprintf("USE %s", umconf.EscapeName(tb.TableSchema))) {
 								tbSQL[num] = strings.Replace(sql, tb.TableSchema, db.TableSchemaRename, 1)
@@ -1386,52 +1952,31 @@ func (e *Extractor) mysqlDump() error {
 	// STEP 5
 	// ------
 	// Dump all of the tables and generate source records ...
+	// Each snapshot transaction is used by one worker. Rows of a table (or a range of a table) are always sent
+	// in order by a single worker, while rows of different tables (or ranges) might be interleaved.
 	e.logger.Printf("mysql.extractor: Step %d: scanning contents of %d tables", step, e.tableCount)
 	startScan := utils.CurrentTimeMillis()
-	counter := 0
-	//pool := models.NewPool(10)
-	for _, db := range e.replicateDoDb {
-		for _, t := range db.Tables {
-			//pool.Add(1)
-			//go func(t *config.Table) {
-			counter++
-			// Obtain a record maker for this table, which knows about the schema ...
-			// Choose how we create statements based on the # of rows ...
-			e.logger.Printf("mysql.extractor: Step %d: - scanning table '%s.%s' (%d of %d tables)", step, t.TableSchema, t.TableName, counter, e.tableCount)
-
-			d := NewDumper(tx, t, e.mysqlContext.ChunkSize, e.logger)
-			if err := d.Dump(); err != nil {
-				e.onError(TaskStateDead, err)
-			}
-			e.dumpers = append(e.dumpers, d)
-			// Scan the rows in the table ...
-			for entry := range d.resultsChannel {
-				if entry.Err != "" {
-					e.onError(TaskStateDead, fmt.Errorf(entry.Err))
-				} else {
-					if !d.sentTableDef {
-						tableBs, err := GobEncode(d.table)
-						if err != nil {
-							realErr := fmt.Errorf(entry.Err)
-							e.onError(TaskStateDead, realErr)
-							return realErr
-						} else {
-							entry.Table = tableBs
-							d.sentTableDef = true
-						}
-					}
-					if err = e.encodeDumpEntry(entry); err != nil {
-						e.onError(TaskStateRestart, err)
-					}
-					atomic.AddInt64(&e.mysqlContext.TotalRowsCopied, entry.RowsCount)
-				}
-			}
-
-			//pool.Done()
-			//}(tb)
+	var counter int64
+	units, err := e.buildDumpUnits()
+	if err != nil {
+		return err
+	}
+	err = runDumpUnits(txs, units, func(ctx context.Context, tx sql.QueryAble, u *dumpUnit) error {
+		t := u.table
+		// Obtain a record maker for this table, which knows about the schema ...
+		// Choose how we create statements based on the # of rows ...
+		if u.dumpRange == nil {
+			e.logger.Printf("mysql.extractor: Step %d: - scanning table '%s.%s' (%d of %d units)",
+				step, t.TableSchema, t.TableName, atomic.AddInt64(&counter, 1), len(units))
+		} else {
+			e.logger.Printf("mysql.extractor: Step %d: - scanning table '%s.%s' range %d (%d of %d units)",
+				step, t.TableSchema, t.TableName, u.dumpRange.Index, atomic.AddInt64(&counter, 1), len(units))
 		}
+		return e.dumpTable(ctx, tx, u)
+	})
+	if err != nil {
+		return err
 	}
-	//pool.Wait()
 	step++
 
 	// We've copied all of the tables, but our buffer holds onto the very last record.
@@ -1443,17 +1988,230 @@ func (e *Extractor) mysqlDump() error {
 
 	return nil
 }
+
+// validateResumingCheckpoint checks that binlog after the snapshot of the checkpoint is still available.
+func (e *Extractor) validateResumingCheckpoint() error {
+	var gtidPurged string
+	if err := e.db.QueryRow("select @@global.gtid_purged").Scan(&gtidPurged); err != nil {
+		return err
+	}
+	purgedSet, err := gomysql.ParseMysqlGTIDSet(gtidPurged)
+	if err != nil {
+		return err
+	}
+	snapshotSet, err := gomysql.ParseMysqlGTIDSet(e.checkpoint.Gtid)
+	if err != nil {
+		return err
+	}
+	if !snapshotSet.Contain(purgedSet) {
+		return fmt.Errorf("cannot resume full copy: binlog after snapshot gtid '%v' has been purged (gtid_purged: '%v')."+
+			" remove %v to start the full copy over", e.checkpoint.Gtid, gtidPurged, e.checkpoint.path)
+	}
+	return nil
+}
+
+// dumpUnit is a table, or a unique key range of a table, dumped by a snapshot worker.
+type dumpUnit struct {
+	table *config.Table
+	// nil if dumping the whole table
+	dumpRange *dumpRange
+	// the progress of the range. nil if not started.
+	checkpoint *TableCheckpoint
+}
+
+// buildDumpUnits skips copied tables (or ranges) in the checkpoint,
+// and splits large tables into unique key ranges if SnapshotRangeRows is set.
+func (e *Extractor) buildDumpUnits() (units []*dumpUnit, err error) {
+	oldWayDump := os.Getenv(g.ENV_DUMP_OLDWAY) != ""
+	for _, db := range e.replicateDoDb {
+		for _, t := range db.Tables {
+			tc := e.checkpoint.GetTable(t.TableSchema, t.TableName)
+			if tc != nil && tc.Done {
+				e.logger.Infof("mysql.extractor: table %v.%v has been copied. skip", t.TableSchema, t.TableName)
+				continue
+			}
+
+			if tc == nil && e.mysqlContext.SnapshotRangeRows > 0 && t.Counter > e.mysqlContext.SnapshotRangeRows &&
+				t.UseUniqueKey != nil && !oldWayDump {
+
+				nRanges := (t.Counter + e.mysqlContext.SnapshotRangeRows - 1) / e.mysqlContext.SnapshotRangeRows
+				if nRanges > maxRangesPerTable {
+					nRanges = maxRangesPerTable
+				}
+				bounds, err := sampleUniqueKeyBounds(e.db, t, int(nRanges), e.logger)
+				if err != nil {
+					return nil, err
+				}
+				if len(bounds) > 0 {
+					if err := e.checkpoint.InitTableRanges(t.TableSchema, t.TableName, bounds); err != nil {
+						return nil, err
+					}
+					tc = e.checkpoint.GetTable(t.TableSchema, t.TableName)
+				}
+			}
+
+			if tc != nil && tc.Ranges != nil {
+				for i, rc := range tc.Ranges {
+					if rc.Done {
+						continue
+					}
+					r := &dumpRange{Index: i}
+					if i > 0 {
+						r.LowerBound = tc.Bounds[i-1]
+					}
+					if i < len(tc.Bounds) {
+						r.UpperBound = tc.Bounds[i]
+					}
+					units = append(units, &dumpUnit{table: t, dumpRange: r, checkpoint: rc})
+				}
+			} else if tc != nil && t.UseUniqueKey != nil {
+				e.logger.Infof("mysql.extractor: resume table %v.%v from iteration %v",
+					t.TableSchema, t.TableName, tc.Iteration)
+				t.Iteration = tc.Iteration
+				copy(t.UseUniqueKey.LastMaxVals, tc.LastMaxVals)
+				units = append(units, &dumpUnit{table: t, checkpoint: tc})
+			} else {
+				// A table without unique key is dumped from the beginning, see dumper.streamTable.
+				units = append(units, &dumpUnit{table: t})
+			}
+		}
+	}
+	return units, nil
+}
+
+// runDumpUnits dumps the units by a worker for each tx. On the first error, ctx of dump is canceled,
+// the units not started are skipped, and the error is returned.
+func runDumpUnits(txs []sql.QueryAble, units []*dumpUnit,
+	dump func(ctx context.Context, tx sql.QueryAble, u *dumpUnit) error) error {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	unitsCh := make(chan *dumpUnit, len(units))
+	for _, u := range units {
+		unitsCh <- u
+	}
+	close(unitsCh)
+
+	errCh := make(chan error, len(txs))
+	wg := sync.WaitGroup{}
+	for i := range txs {
+		wg.Add(1)
+		go func(tx sql.QueryAble) {
+			defer wg.Done()
+			for u := range unitsCh {
+				if ctx.Err() != nil {
+					return
+				}
+				if err := dump(ctx, tx, u); err != nil {
+					errCh <- err
+					cancel()
+					return
+				}
+			}
+		}(txs[i])
+	}
+	wg.Wait()
+	close(errCh)
+	// The first error. The others might be caused by the cancellation.
+	return <-errCh
+}
+
+// dumpTable scans the rows of a table (or a range of it) with tx and sends them to the applier.
+// It stops when ctx is canceled.
+func (e *Extractor) dumpTable(ctx context.Context, tx sql.QueryAble, u *dumpUnit) error {
+	t := u.table
+	var rowsCopied int64
+	if u.checkpoint != nil {
+		rowsCopied = u.checkpoint.RowsCopied
+	}
+	// The checkpoint advances after the chunks are applied.
+	chunks := newUnitChunks(rowsCopied, func(tc *TableCheckpoint) error {
+		var err error
+		if u.dumpRange == nil {
+			err = e.checkpoint.UpdateTable(t.TableSchema, t.TableName, tc)
+		} else {
+			err = e.checkpoint.UpdateRange(t.TableSchema, t.TableName, u.dumpRange.Index, tc)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to save full copy checkpoint: %v", err)
+		}
+		if tc.Done && u.dumpRange != nil {
+			e.logger.Infof("mysql.extractor: table %v.%v range %v finished. rows: %v",
+				t.TableSchema, t.TableName, u.dumpRange.Index, tc.RowsCopied)
+		}
+		return nil
+	})
+
+	var d *dumper
+	if u.dumpRange == nil {
+		d = NewDumper(tx, t, e.mysqlContext.ChunkSize, e.logger)
+	} else {
+		var iteration int64
+		var lastMaxVals []string
+		if u.checkpoint != nil {
+			iteration = u.checkpoint.Iteration
+			lastMaxVals = u.checkpoint.LastMaxVals
+		}
+		d = NewRangeDumper(tx, t, u.dumpRange, iteration, lastMaxVals, e.mysqlContext.ChunkSize, e.logger)
+	}
+	d.rateLimiters = []*g.RateLimiters{e.rateLimiters, g.AgentRateLimiters}
+	d.throttler = e.throttler
+	if err := d.Dump(); err != nil {
+		return err
+	}
+	e.dumpersMutex.Lock()
+	e.dumpers = append(e.dumpers, d)
+	e.dumpersMutex.Unlock()
+	// Scan the rows in the table ...
+	for {
+		var chunk *dumpChunk
+		var ok bool
+		select {
+		case chunk, ok = <-d.resultsChannel:
+		case <-ctx.Done():
+			d.Close()
+			return ctx.Err()
+		}
+		if !ok {
+			break
+		}
+		entry := chunk.entry
+		if entry.Err != "" {
+			d.Close()
+			return fmt.Errorf("dump table %v.%v: %v", t.TableSchema, t.TableName, entry.Err)
+		}
+		// Each range sends the table def, since ranges might arrive in any order.
+		if !d.sentTableDef {
+			tableBs, err := GobEncode(d.table)
+			if err != nil {
+				d.Close()
+				return err
+			}
+			entry.Table = tableBs
+			d.sentTableDef = true
+		}
+		entry.Seq = e.appliedChunks.add(chunks, chunk.checkpoint, entry.RowsCount)
+		if err := e.encodeDumpEntry(entry); err != nil {
+			d.Close()
+			// The chunk can be sent again after a restart, from the checkpoint.
+			e.onError(TaskStateRestart, err)
+			return err
+		}
+		atomic.AddInt64(&e.mysqlContext.TotalRowsCopied, entry.RowsCount)
+	}
+	return e.appliedChunks.finish(chunks)
+}
+
 func (e *Extractor) encodeDumpEntry(entry *DumpEntry) error {
 	var ctx context.Context
 	//tracer := opentracing.GlobalTracer()
 	span := opentracing.GlobalTracer().StartSpan("span_full")
 	defer span.Finish()
 	ctx = opentracing.ContextWithSpan(ctx, span)
-	bs, err := entry.Marshal(nil)
+	txMsg, err := e.encode(entry)
 	if err != nil {
 		return err
 	}
-	txMsg := snappy.Encode(nil, bs)
 	if err := e.publish(ctx, fmt.Sprintf("%s_full", e.subject), "", txMsg); err != nil {
 		return err
 	}
@@ -1503,16 +2261,22 @@ func (e *Extractor) Stats() (*models.TaskStatistics, error) {
 		ProgressPct:        strconv.FormatFloat(progressPct, 'f', 1, 64),
 		ETA:                eta,
 		Backlog:            fmt.Sprintf("%d/%d", len(e.dataChannel), cap(e.dataChannel)),
-		Stage:              e.mysqlContext.Stage,
+		Stage:              throttledStage(e.mysqlContext.Stage, e.throttler),
 		BufferStat: models.BufferStat{
-			ExtractorTxQueueSize: 0,
+			ExtractorTxQueueSize: len(e.dataChannel),
 			SendByTimeout:        e.sendByTimeoutCounter,
 			SendBySizeFull:       e.sendBySizeFullCounter,
 		},
-		Timestamp: time.Now().UTC().UnixNano(),
+		CompressionStat: e.codec.Stat(),
+		Timestamp:       time.Now().UTC().UnixNano(),
 	}
-	if e.natsConn != nil {
-		taskResUsage.MsgStat = e.natsConn.Statistics
+	taskResUsage.BufferStat.ExtractorCreditEntries, taskResUsage.BufferStat.ExtractorCreditBytes,
+		taskResUsage.BufferStat.ExtractorThrottledMs = e.creditAccount.Stat()
+	if e.checkpoint != nil {
+		taskResUsage.RangeStats = e.checkpoint.RangeStats()
+	}
+	if e.transport != nil {
+		taskResUsage.MsgStat = e.transport.Statistics()
 		e.mysqlContext.TotalTransferredBytes = int(taskResUsage.MsgStat.OutBytes)
 		if e.mysqlContext.TrafficAgainstLimits > 0 && int(taskResUsage.MsgStat.OutBytes)/1024/1024/1024 >= e.mysqlContext.TrafficAgainstLimits {
 			e.onError(TaskStateDead, fmt.Errorf("traffic limit exceeded : %d/%d", e.mysqlContext.TrafficAgainstLimits, int(taskResUsage.MsgStat.OutBytes)/1024/1024/1024))
@@ -1538,7 +2302,23 @@ func (e *Extractor) Stats() (*models.TaskStatistics, error) {
 	return &taskResUsage, nil
 }
 
+// RateLimit returns the rate limit of the job.
+func (e *Extractor) RateLimit() *models.RateLimit {
+	return e.rateLimiters.RateLimit()
+}
+
+// SetRateLimit changes the rate limit of the running job. It is not saved into the job spec,
+// and lasts only while the task runs. A restarted task uses RateLimit of the job config.
+func (e *Extractor) SetRateLimit(limit *models.RateLimit) {
+	e.logger.Infof("mysql.extractor: set rate limit %+v", *limit)
+	e.rateLimiters.SetRateLimit(limit)
+	e.mysqlContext.RateLimit = limit
+}
+
 func (e *Extractor) ID() string {
+	e.dbLock.RLock()
+	connectionConfig := e.mysqlContext.ConnectionConfig
+	e.dbLock.RUnlock()
 	id := config.DriverCtx{
 		DriverConfig: &config.MySQLDriverConfig{
 			TotalTransferredBytes: e.mysqlContext.TotalTransferredBytes,
@@ -1546,7 +2326,7 @@ func (e *Extractor) ID() string {
 			ReplicateIgnoreDb:     e.mysqlContext.ReplicateIgnoreDb,
 			Gtid:                  e.mysqlContext.Gtid,
 			NatsAddr:              e.mysqlContext.NatsAddr,
-			ConnectionConfig:      e.mysqlContext.ConnectionConfig,
+			ConnectionConfig:      connectionConfig,
 		},
 	}
 
@@ -1582,13 +2362,15 @@ func (e *Extractor) Shutdown() error {
 	e.shutdown = true
 	close(e.shutdownCh)
 
-	if e.natsConn != nil {
-		e.natsConn.Close()
+	if e.transport != nil {
+		e.transport.Close()
 	}
 
+	e.dumpersMutex.Lock()
 	for _, d := range e.dumpers {
 		d.Close()
 	}
+	e.dumpersMutex.Unlock()
 
 	if err := sql.CloseDB(e.singletonDB); err != nil {
 		e.logger.Errorf("Extractor.Shutdown error close singletonDB. err %v", err)
@@ -1604,7 +2386,7 @@ func (e *Extractor) Shutdown() error {
 		}
 	}
 
-	if err := sql.CloseDB(e.db); err != nil {
+	if err := sql.CloseDB(e.currentDB()); err != nil {
 		e.logger.Errorf("Extractor.Shutdown error close e.db. err %v", err)
 	}
 
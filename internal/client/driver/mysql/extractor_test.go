@@ -0,0 +1,156 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package mysql
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/actiontech/dtle/internal/client/driver/mysql/sql"
+	"github.com/actiontech/dtle/internal/config"
+	umconf "github.com/actiontech/dtle/internal/config/mysql"
+	gomysql "github.com/siddontang/go-mysql/mysql"
+)
+
+func TestFailoverCandidates(t *testing.T) {
+	e := &Extractor{
+		mysqlContext: &config.MySQLDriverConfig{
+			ConnectionConfig: &umconf.ConnectionConfig{
+				Host: "master", Port: 3306, User: "u", Password: "p", Charset: "utf8mb4",
+			},
+			SourceCandidates: []*umconf.ConnectionConfig{
+				{Host: "slave1", Port: 3306},
+				{Host: "slave2", Port: 3307, User: "u2", Password: "p2", Charset: "utf8"},
+				{Host: "slave3", Port: 3308, User: "u3"},
+			},
+		},
+	}
+	want := []*umconf.ConnectionConfig{
+		{Host: "master", Port: 3306, User: "u", Password: "p", Charset: "utf8mb4"},
+		{Host: "slave1", Port: 3306, User: "u", Password: "p", Charset: "utf8mb4"},
+		{Host: "slave2", Port: 3307, User: "u2", Password: "p2", Charset: "utf8"},
+		// A candidate with its own user does not take the password of ConnectionConfig.
+		{Host: "slave3", Port: 3308, User: "u3", Password: "", Charset: "utf8mb4"},
+	}
+	got := e.failoverCandidates()
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("failoverCandidates() = %+v, want %+v", got, want)
+	}
+	if got[0] != e.mysqlContext.ConnectionConfig {
+		t.Fatalf("failoverCandidates()[0] is not ConnectionConfig")
+	}
+	if e.mysqlContext.SourceCandidates[0].User != "" {
+		t.Fatalf("failoverCandidates() modified SourceCandidates")
+	}
+}
+
+func TestGtidExecutedContains(t *testing.T) {
+	const sid1 = "3e11fa47-71ca-11e1-9e33-c80aa9429562"
+	const sid2 = "4e11fa47-71ca-11e1-9e33-c80aa9429562"
+	tests := []struct {
+		name     string
+		executed string
+		read     string
+		want     bool
+		wantErr  bool
+	}{
+		{"same", sid1 + ":1-10", sid1 + ":1-10", true, false},
+		{"more", sid1 + ":1-20," + sid2 + ":1-5", sid1 + ":1-10", true, false},
+		{"less", sid1 + ":1-5", sid1 + ":1-10", false, false},
+		{"other sid", sid2 + ":1-10", sid1 + ":1-10", false, false},
+		{"gap", sid1 + ":1-4:6-10", sid1 + ":1-10", false, false},
+		{"empty read", sid1 + ":1-10", "", true, false},
+		{"bad executed", "bad", sid1 + ":1-10", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			read, err := gomysql.ParseMysqlGTIDSet(tt.read)
+			if err != nil {
+				t.Fatalf("ParseMysqlGTIDSet(%v) error: %v", tt.read, err)
+			}
+			got, err := gtidExecutedContains(tt.executed, read)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("gtidExecutedContains() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Fatalf("gtidExecutedContains() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCandidateHasGtidSetUnavailable(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+
+	gtidSet, err := gomysql.ParseMysqlGTIDSet("3e11fa47-71ca-11e1-9e33-c80aa9429562:1-10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := &umconf.ConnectionConfig{Host: "127.0.0.1", Port: port, User: "u", Password: "p"}
+	ok, err := candidateHasGtidSet(conn, gtidSet)
+	if err == nil || ok {
+		t.Fatalf("candidateHasGtidSet() = %v, %v, want an error", ok, err)
+	}
+}
+
+func TestRunDumpUnits(t *testing.T) {
+	var units []*dumpUnit
+	for i := 0; i < 10; i++ {
+		units = append(units, &dumpUnit{table: &config.Table{TableSchema: "db1", TableName: fmt.Sprint(i)}})
+	}
+	txs := make([]sql.QueryAble, 2)
+
+	var lock sync.Mutex
+	var dumped []string
+	err := runDumpUnits(txs, units, func(ctx context.Context, tx sql.QueryAble, u *dumpUnit) error {
+		lock.Lock()
+		defer lock.Unlock()
+		dumped = append(dumped, u.table.TableName)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dumped) != len(units) {
+		t.Fatalf("dumped %v, want all of the %v units", dumped, len(units))
+	}
+
+	// The first unit waits until canceled by the failure of the second.
+	errDump := fmt.Errorf("dump failed")
+	var started []string
+	err = runDumpUnits(txs, units, func(ctx context.Context, tx sql.QueryAble, u *dumpUnit) error {
+		lock.Lock()
+		started = append(started, u.table.TableName)
+		lock.Unlock()
+		if u.table.TableName == "1" {
+			return errDump
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(5 * time.Second):
+			t.Errorf("unit %v is not canceled", u.table.TableName)
+			return nil
+		}
+	})
+	if err != errDump {
+		t.Fatalf("got %v, want %v", err, errDump)
+	}
+	if len(started) != 2 {
+		t.Fatalf("started %v, want only units 0 and 1", started)
+	}
+}
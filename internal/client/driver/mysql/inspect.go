@@ -87,7 +87,9 @@ func (i *Inspector) ValidateOriginalTable(databaseName, tableName string, table
 		return err
 	}
 	// TODO why assign OriginalTableColumns twice (later getSchemaTablesAndMeta->readTableColumns)?
-	table.ColumnMap = uconf.BuildColumnMapIndex(table.ColumnMapFrom, table.OriginalTableColumns.Ordinals)
+	if err := table.BuildColumnMap(); err != nil {
+		return err
+	}
 
 
 	i.logger.Debugf("table: %s.%s. n_unique_keys: %d", table.TableSchema, table.TableName, len(uniqueKeys))
@@ -135,7 +137,7 @@ func (i *Inspector) ValidateOriginalTable(databaseName, tableName string, table
 		}
 	}
 	if table.UseUniqueKey == nil {
-		i.logger.Warnf("No valid unique key found for table %s.%s. It will be slow on large table.", table.TableSchema, table.TableName)
+		i.logger.Warnf("No valid unique key found for table %s.%s. It will be dumped by one query and cannot be resumed in the middle of full copy.", table.TableSchema, table.TableName)
 	} else {
 		i.logger.Infof("Chosen unique key for %s.%s is %s",
 			table.TableSchema, table.TableName, table.UseUniqueKey.String())
@@ -156,6 +158,11 @@ func (i *Inspector) ValidateOriginalTable(databaseName, tableName string, table
 	// TODO name escaping
 	// endregion
 
+	if _, err := uconf.NewTransformCtx(table); err != nil {
+		i.logger.Errorf("mysql.inspector: Error parse column transforms. err: %v", err)
+		return err
+	}
+
 	return nil
 }
 
@@ -274,6 +281,16 @@ func (i *Inspector) validateBinlogs() error {
 		i.mysqlContext.BinlogRowImage = "FULL"
 	}
 	i.mysqlContext.BinlogRowImage = strings.ToUpper(i.mysqlContext.BinlogRowImage)
+	switch i.mysqlContext.BinlogRowImage {
+	case "FULL":
+	case "MINIMAL", "NOBLOB":
+		// Rows are identified by the primary key in the before image, and absent columns are not applied.
+		i.logger.Infof("mysql.inspector: binlog_row_image is %v on %s:%d. Tables without a primary key will be matched by all columns in the row image.",
+			i.mysqlContext.BinlogRowImage, i.mysqlContext.ConnectionConfig.Host, i.mysqlContext.ConnectionConfig.Port)
+	default:
+		return fmt.Errorf("unsupported binlog_row_image %v on %s:%d", i.mysqlContext.BinlogRowImage,
+			i.mysqlContext.ConnectionConfig.Host, i.mysqlContext.ConnectionConfig.Port)
+	}
 
 	i.logger.Printf("mysql.inspector: Binary logs validated on %s:%d", i.mysqlContext.ConnectionConfig.Host, i.mysqlContext.ConnectionConfig.Port)
 	return nil
@@ -12,6 +12,7 @@ import (
 	"strings"
 
 	umconf "github.com/actiontech/dtle/internal/config/mysql"
+	"github.com/siddontang/go-mysql/replication"
 )
 
 type ValueComparisonSign string
@@ -102,17 +103,43 @@ func BuildSetPreparedClause(columns *umconf.ColumnList) (result string, err erro
 	}
 	setTokens := []string{}
 	for _, column := range columns.ColumnList() {
-		var setToken string
-		if column.TimezoneConversion != nil {
-			setToken = fmt.Sprintf("%s=convert_tz(?, '%s', '%s')", column.EscapedName, column.TimezoneConversion.ToTimezone, "+00:00")
-		} else {
-			setToken = fmt.Sprintf("%s=?", column.EscapedName)
-		}
-		setTokens = append(setTokens, setToken)
+		setTokens = append(setTokens, buildSetPreparedToken(column))
 	}
 	return strings.Join(setTokens, ", "), nil
 }
 
+func buildSetPreparedToken(column umconf.Column) string {
+	if column.TimezoneConversion != nil {
+		return fmt.Sprintf("%s=convert_tz(?, '%s', '%s')", column.EscapedName, column.TimezoneConversion.ToTimezone, "+00:00")
+	} else {
+		return fmt.Sprintf("%s=?", column.EscapedName)
+	}
+}
+
+// buildJsonDiffsSetToken makes a partial JSON update (binlog_row_value_options=PARTIAL_JSON) into
+// nested JSON_REPLACE/JSON_INSERT/JSON_ARRAY_INSERT/JSON_REMOVE calls, like mysqlbinlog does.
+func buildJsonDiffsSetToken(column umconf.Column, diffs replication.JsonDiffs) (setToken string, args []interface{}) {
+	expr := column.EscapedName
+	for _, diff := range diffs {
+		switch diff.Op {
+		case replication.JsonDiffOperationReplace:
+			expr = fmt.Sprintf("JSON_REPLACE(%s, ?, CAST(? AS JSON))", expr)
+			args = append(args, diff.Path, diff.Value)
+		case replication.JsonDiffOperationInsert:
+			if strings.HasSuffix(diff.Path, "]") {
+				expr = fmt.Sprintf("JSON_ARRAY_INSERT(%s, ?, CAST(? AS JSON))", expr)
+			} else {
+				expr = fmt.Sprintf("JSON_INSERT(%s, ?, CAST(? AS JSON))", expr)
+			}
+			args = append(args, diff.Path, diff.Value)
+		case replication.JsonDiffOperationRemove:
+			expr = fmt.Sprintf("JSON_REMOVE(%s, ?)", expr)
+			args = append(args, diff.Path)
+		}
+	}
+	return fmt.Sprintf("%s=%s", column.EscapedName, expr), args
+}
+
 func BuildDMLDeleteQuery(databaseName, tableName string, tableColumns *umconf.ColumnList, args []*interface{}) (result string, columnArgs []interface{}, hasUK bool, err error) {
 	if len(args) < tableColumns.Len() {
 		return result, columnArgs, hasUK, fmt.Errorf("args count differs from table column count in BuildDMLDeleteQuery %v, %v",
@@ -195,7 +222,7 @@ func BuildDMLInsertQuery(databaseName, tableName string, tableColumns, sharedCol
 	databaseName = umconf.EscapeName(databaseName)
 	tableName = umconf.EscapeName(tableName)
 
-	for _, column := range tableColumns.ColumnList() {
+	for _, column := range sharedColumns.ColumnList() {
 		tableOrdinal := tableColumns.Ordinals[column.RawName]
 		if *args[tableOrdinal] == nil {
 			sharedArgs = append(sharedArgs, *args[tableOrdinal])
@@ -205,8 +232,8 @@ func BuildDMLInsertQuery(databaseName, tableName string, tableColumns, sharedCol
 		}
 	}
 
-	mappedSharedColumnNames := duplicateNames(tableColumns.EscapedNames())
-	preparedValues := buildColumnsPreparedValues(tableColumns)
+	mappedSharedColumnNames := duplicateNames(mappedSharedColumns.EscapedNames())
+	preparedValues := buildColumnsPreparedValues(sharedColumns)
 
 	result = fmt.Sprintf(`
 			replace into
@@ -240,8 +267,16 @@ func BuildDMLUpdateQuery(databaseName, tableName string, tableColumns, sharedCol
 	databaseName = umconf.EscapeName(databaseName)
 	tableName = umconf.EscapeName(tableName)
 
-	for _, column := range tableColumns.ColumnList() {
+	setTokens := []string{}
+	mappedColumns := mappedSharedColumns.ColumnList()
+	for i, column := range sharedColumns.ColumnList() {
 		tableOrdinal := tableColumns.Ordinals[column.RawName]
+		if diffs, ok := (*valueArgs[tableOrdinal]).(replication.JsonDiffs); ok {
+			setToken, diffArgs := buildJsonDiffsSetToken(mappedColumns[i], diffs)
+			setTokens = append(setTokens, setToken)
+			sharedArgs = append(sharedArgs, diffArgs...)
+			continue
+		}
 		if *valueArgs[tableOrdinal] == nil || *valueArgs[tableOrdinal] == "NULL" ||
 			fmt.Sprintf("%v", *valueArgs[tableOrdinal]) == "" {
 			sharedArgs = append(sharedArgs, *valueArgs[tableOrdinal])
@@ -249,12 +284,13 @@ func BuildDMLUpdateQuery(databaseName, tableName string, tableColumns, sharedCol
 			arg := column.ConvertArg(*valueArgs[tableOrdinal])
 			sharedArgs = append(sharedArgs, arg)
 		}
+		setTokens = append(setTokens, buildSetPreparedToken(mappedColumns[i]))
 	}
 
 	comparisons := []string{}
 	uniqueKeyComparisons := []string{}
 	uniqueKeyArgs := make([]interface{}, 0)
-	for _, column := range tableColumns.ColumnList() {
+	for _, column := range uniqueKeyColumns.ColumnList() {
 		tableOrdinal := tableColumns.Ordinals[column.RawName]
 		if *whereArgs[tableOrdinal] == nil {
 			comparison, err := BuildValueComparison(column.EscapedName, "NULL", IsEqualsComparisonSign)
@@ -296,7 +332,7 @@ func BuildDMLUpdateQuery(databaseName, tableName string, tableColumns, sharedCol
 		columnArgs = uniqueKeyArgs
 	}
 
-	setClause, err := BuildSetPreparedClause(mappedSharedColumns)
+	setClause := strings.Join(setTokens, ", ")
 
 	result = fmt.Sprintf(`
  			update
@@ -0,0 +1,138 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package sql
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	umconf "github.com/actiontech/dtle/internal/config/mysql"
+)
+
+// newBuilderTestColumns returns columns `id` (the primary key if withPK), `a` and `b`.
+func newBuilderTestColumns(withPK bool) []umconf.Column {
+	columns := umconf.NewColumns([]string{"id", "a", "b"})
+	if withPK {
+		columns[0].Key = "PRI"
+	}
+	return columns
+}
+
+func newBuilderTestArgs(vals ...interface{}) []*interface{} {
+	args := make([]*interface{}, len(vals))
+	for i := range vals {
+		args[i] = &vals[i]
+	}
+	return args
+}
+
+// normalizeQuery removes the indents of a built query.
+func normalizeQuery(query string) string {
+	return strings.Join(strings.Fields(query), " ")
+}
+
+func TestBuildDMLInsertQuery(t *testing.T) {
+	columns := newBuilderTestColumns(true)
+	tableColumns := umconf.NewColumnList(columns)
+	for _, c := range []struct {
+		name      string
+		shared    *umconf.ColumnList
+		args      []*interface{}
+		wantQuery string
+		wantArgs  []interface{}
+	}{
+		{"full", tableColumns, newBuilderTestArgs(1, "x", nil),
+			"replace into `db1`.`tb1` (`id`, `a`, `b`) values (?, ?, ?)", []interface{}{1, "x", nil}},
+		// binlog_row_image=MINIMAL: `b` is absent and takes its default value.
+		{"minimal", umconf.NewColumnList(columns[:2]), newBuilderTestArgs(1, "x", nil),
+			"replace into `db1`.`tb1` (`id`, `a`) values (?, ?)", []interface{}{1, "x"}},
+	} {
+		query, args, err := BuildDMLInsertQuery("db1", "tb1", tableColumns, c.shared, c.shared, c.args)
+		if err != nil {
+			t.Fatalf("%v: %v", c.name, err)
+		}
+		if normalizeQuery(query) != c.wantQuery {
+			t.Fatalf("%v: got %v, want %v", c.name, normalizeQuery(query), c.wantQuery)
+		}
+		if !reflect.DeepEqual(args, c.wantArgs) {
+			t.Fatalf("%v: got args %v, want %v", c.name, args, c.wantArgs)
+		}
+	}
+
+	if _, _, err := BuildDMLInsertQuery("db1", "tb1", tableColumns, tableColumns, tableColumns,
+		newBuilderTestArgs(1, "x")); err == nil {
+		t.Fatalf("expect an error for fewer args than columns")
+	}
+	other := umconf.NewColumnList(umconf.NewColumns([]string{"c"}))
+	if _, _, err := BuildDMLInsertQuery("db1", "tb1", tableColumns, other, other,
+		newBuilderTestArgs(1, "x", nil)); err == nil {
+		t.Fatalf("expect an error for shared columns not in the table")
+	}
+}
+
+func TestBuildDMLUpdateQuery(t *testing.T) {
+	for _, c := range []struct {
+		name string
+		// the columns in the after and the before image
+		withPK    bool
+		shared    []int
+		where     []int
+		wantQuery string
+		wantArgs  []interface{}
+		wantWhere []interface{}
+		wantUK    bool
+	}{
+		{"full", true, []int{0, 1, 2}, []int{0, 1, 2},
+			"update `db1`.`tb1` set `id`=?, `a`=?, `b`=? where ((`id` = ?)) limit 1",
+			[]interface{}{1, "y", nil}, []interface{}{1}, true},
+		// binlog_row_image=MINIMAL: the changed columns in the after image, and the primary key in the before image.
+		{"minimal", true, []int{1}, []int{0},
+			"update `db1`.`tb1` set `a`=? where ((`id` = ?)) limit 1",
+			[]interface{}{"y"}, []interface{}{1}, true},
+		// Without a primary key, the row is identified by all columns in the before image.
+		{"full without pk", false, []int{0, 1, 2}, []int{0, 1, 2},
+			"update `db1`.`tb1` set `id`=?, `a`=?, `b`=? where ((`id` = ?) and (`a` = ?) and (`b` is NULL)) limit 1",
+			[]interface{}{1, "y", nil}, []interface{}{1, "x"}, false},
+		{"minimal without pk", false, []int{1}, []int{0, 1, 2},
+			"update `db1`.`tb1` set `a`=? where ((`id` = ?) and (`a` = ?) and (`b` is NULL)) limit 1",
+			[]interface{}{"y"}, []interface{}{1, "x"}, false},
+	} {
+		columns := newBuilderTestColumns(c.withPK)
+		tableColumns := umconf.NewColumnList(columns)
+		pick := func(indexes []int) *umconf.ColumnList {
+			var picked []umconf.Column
+			for _, i := range indexes {
+				picked = append(picked, columns[i])
+			}
+			return umconf.NewColumnList(picked)
+		}
+		shared := pick(c.shared)
+		query, args, whereArgs, hasUK, err := BuildDMLUpdateQuery("db1", "tb1", tableColumns, shared, shared,
+			pick(c.where), newBuilderTestArgs(1, "y", nil), newBuilderTestArgs(1, "x", nil))
+		if err != nil {
+			t.Fatalf("%v: %v", c.name, err)
+		}
+		if normalizeQuery(query) != c.wantQuery {
+			t.Fatalf("%v: got %v, want %v", c.name, normalizeQuery(query), c.wantQuery)
+		}
+		if !reflect.DeepEqual(args, c.wantArgs) || !reflect.DeepEqual(whereArgs, c.wantWhere) || hasUK != c.wantUK {
+			t.Fatalf("%v: got %v %v %v, want %v %v %v", c.name, args, whereArgs, hasUK, c.wantArgs, c.wantWhere, c.wantUK)
+		}
+	}
+
+	tableColumns := umconf.NewColumnList(newBuilderTestColumns(true))
+	if _, _, _, _, err := BuildDMLUpdateQuery("db1", "tb1", tableColumns, tableColumns, tableColumns, tableColumns,
+		newBuilderTestArgs(1, "y", nil), newBuilderTestArgs(1)); err == nil {
+		t.Fatalf("expect an error for fewer where args than columns")
+	}
+	empty := umconf.NewColumnList(nil)
+	if _, _, _, _, err := BuildDMLUpdateQuery("db1", "tb1", tableColumns, empty, empty, tableColumns,
+		newBuilderTestArgs(1, "y", nil), newBuilderTestArgs(1, "x", nil)); err == nil {
+		t.Fatalf("expect an error for no columns to set")
+	}
+}
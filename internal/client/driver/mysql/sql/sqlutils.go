@@ -151,6 +151,7 @@ type Conn struct {
 
 	PsDeleteExecutedGtid *gosql.Stmt
 	PsInsertExecutedGtid *gosql.Stmt
+	PsInsertRowsQuery    *gosql.Stmt
 }
 
 type DB struct {
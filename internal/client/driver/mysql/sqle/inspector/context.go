@@ -1,7 +1,10 @@
 package inspector
 
 import (
+	"bytes"
+
 	"github.com/pingcap/parser/ast"
+	"github.com/pingcap/parser/format"
 )
 
 type TableInfo struct {
@@ -189,6 +192,18 @@ func (ctx *Context) UpdateContext(node ast.Node, dbtype string) {
 		if ctx.HasTable(schemaName, tableName) {
 			return
 		}
+		if s.ReferTable != nil {
+			// create table ... like
+			referInfo, exist := ctx.getTableInfo(s.ReferTable)
+			if !exist {
+				return
+			}
+			var err error
+			s, err = copyCreateTableStmt(referInfo, dbtype, s.Table)
+			if err != nil {
+				return
+			}
+		}
 		ctx.AddTable(schemaName, tableName,
 			&TableInfo{
 				Size:          0, // table is empty after create
@@ -211,6 +226,16 @@ func (ctx *Context) UpdateContext(node ast.Node, dbtype string) {
 			}
 		}
 
+	case *ast.RenameTableStmt:
+		for _, t2t := range s.TableToTables {
+			info, exist := ctx.getTableInfo(t2t.OldTable)
+			if !exist {
+				continue
+			}
+			ctx.DelTable(ctx.getSchemaName(t2t.OldTable), t2t.OldTable.Name.O)
+			ctx.AddTable(ctx.getSchemaName(t2t.NewTable), t2t.NewTable.Name.O, info)
+		}
+
 	case *ast.AlterTableStmt:
 		info, exist := ctx.getTableInfo(s.Table)
 		if exist {
@@ -237,6 +262,21 @@ func (ctx *Context) UpdateContext(node ast.Node, dbtype string) {
 	}
 }
 
+// copyCreateTableStmt makes a CREATE TABLE statement of the table with a new name.
+func copyCreateTableStmt(info *TableInfo, dbtype string, table *ast.TableName) (*ast.CreateTableStmt, error) {
+	cStmt := info.MergedTable
+	if cStmt == nil {
+		cStmt = info.OriginalTable
+	}
+	stmt := *cStmt
+	stmt.Table = table
+	buf := bytes.NewBuffer(nil)
+	if err := stmt.Restore(format.NewRestoreCtx(format.DefaultRestoreFlags, buf)); err != nil {
+		return nil, err
+	}
+	return ParseCreateTableStmt(dbtype, buf.String())
+}
+
 func (c *Context) getSchemaName(stmt *ast.TableName) string {
 	if stmt.Schema.String() == "" {
 		return c.currentSchema
@@ -0,0 +1,182 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package mysql
+
+import (
+	gosql "database/sql"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/actiontech/dtle/internal/client/driver/mysql/sql"
+	umconf "github.com/actiontech/dtle/internal/config/mysql"
+	"github.com/actiontech/dtle/internal/models"
+)
+
+const (
+	throttleCheckInterval = 1 * time.Second
+	// The task is aborted if the status variables cannot be read in this many checks in a row.
+	throttleMaxReadFailures = 10
+)
+
+// throttler polls the status variables of MaxLoad and CriticalLoad on a server during the full copy.
+// The chunk reads (src) or writes (dest) pause while any MaxLoad threshold is exceeded,
+// and the task is aborted if any CriticalLoad threshold is exceeded.
+// A nil *throttler never throttles.
+type throttler struct {
+	db           *gosql.DB
+	maxLoad      umconf.LoadMap
+	criticalLoad umconf.LoadMap
+	logger       *logrus.Entry
+
+	lock sync.Mutex
+	// not empty while throttled
+	reason string
+	// checks in a row with a read failure
+	readFailures int
+}
+
+// newThrottler returns nil if neither maxLoad nor criticalLoad is set.
+func newThrottler(db *gosql.DB, maxLoad string, criticalLoad string, logger *logrus.Entry) (*throttler, error) {
+	maxLoadMap, err := umconf.ParseLoadMap(maxLoad)
+	if err != nil {
+		return nil, fmt.Errorf("bad MaxLoad %v: %v", maxLoad, err)
+	}
+	criticalLoadMap, err := umconf.ParseLoadMap(criticalLoad)
+	if err != nil {
+		return nil, fmt.Errorf("bad CriticalLoad %v: %v", criticalLoad, err)
+	}
+	if len(maxLoadMap) == 0 && len(criticalLoadMap) == 0 {
+		return nil, nil
+	}
+	return &throttler{
+		db:           db,
+		maxLoad:      maxLoadMap,
+		criticalLoad: criticalLoadMap,
+		logger:       logger,
+	}, nil
+}
+
+func (t *throttler) readStatusVariable(name string) (int64, error) {
+	var variableName, value string
+	// SHOW cannot be prepared with a placeholder. As gh-ost, the name is quoted in the statement.
+	query := fmt.Sprintf("show global status like '%s'", sql.EscapeValue(name))
+	err := t.db.QueryRow(query).Scan(&variableName, &value)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(value, 10, 64)
+}
+
+// check returns a not-nil error if any CriticalLoad is exceeded,
+// or if the status variables cannot be read for throttleMaxReadFailures checks.
+func (t *throttler) check() error {
+	var readErr error
+	for name, threshold := range t.criticalLoad {
+		value, err := t.readStatusVariable(name)
+		if err != nil {
+			readErr = fmt.Errorf("failed to read status variable %v: %v", name, err)
+			continue
+		}
+		if value >= threshold {
+			return fmt.Errorf("critical load reached: %v=%v >= %v", name, value, threshold)
+		}
+	}
+
+	reason := ""
+	for name, threshold := range t.maxLoad {
+		value, err := t.readStatusVariable(name)
+		if err != nil {
+			readErr = fmt.Errorf("failed to read status variable %v: %v", name, err)
+			// Throttle rather than copy at an unknown load.
+			reason = fmt.Sprintf("max load unknown: %v", readErr)
+			continue
+		}
+		if value >= threshold {
+			reason = fmt.Sprintf("max load reached: %v=%v >= %v", name, value, threshold)
+			break
+		}
+	}
+
+	if readErr != nil {
+		t.readFailures += 1
+		t.logger.Warnf("mysql.throttler: %v. failures in a row: %v", readErr, t.readFailures)
+		if t.readFailures >= throttleMaxReadFailures {
+			return fmt.Errorf("throttler: %v, for %v checks in a row", readErr, t.readFailures)
+		}
+	} else {
+		t.readFailures = 0
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if reason != t.reason {
+		if reason != "" {
+			t.logger.Infof("mysql.throttler: throttled. %v", reason)
+		} else {
+			t.logger.Infof("mysql.throttler: not throttled")
+		}
+	}
+	t.reason = reason
+	return nil
+}
+
+// run polls the server until stopCh is closed. It returns the error of critical load.
+func (t *throttler) run(stopCh <-chan struct{}) error {
+	if t == nil {
+		return nil
+	}
+	ticker := time.NewTicker(throttleCheckInterval)
+	defer ticker.Stop()
+	defer func() {
+		t.lock.Lock()
+		t.reason = ""
+		t.lock.Unlock()
+	}()
+	for {
+		if err := t.check(); err != nil {
+			return err
+		}
+		select {
+		case <-stopCh:
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// throttleReason returns "" if not throttled.
+func (t *throttler) throttleReason() string {
+	if t == nil {
+		return ""
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.reason
+}
+
+// throttledStage returns the stage to report in TaskStatistics.
+func throttledStage(stage string, t *throttler) string {
+	if reason := t.throttleReason(); reason != "" {
+		return fmt.Sprintf("%v: %v", models.StageThrottled, reason)
+	}
+	return stage
+}
+
+// waitIfThrottled blocks while throttled or until stopCh is closed.
+func (t *throttler) waitIfThrottled(stopCh <-chan struct{}) {
+	for t.throttleReason() != "" {
+		select {
+		case <-stopCh:
+			return
+		case <-time.After(throttleCheckInterval):
+		}
+	}
+}
@@ -0,0 +1,239 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package mysql
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/actiontech/dtle/internal/models"
+)
+
+// testStatusServer answers `show global status like` with its variables.
+// A variable not set cannot be read.
+type testStatusServer struct {
+	lock      sync.Mutex
+	variables map[string]string
+}
+
+var showStatusRegex = regexp.MustCompile(`^show global status like '(\w+)'$`)
+
+func (s *testStatusServer) set(name string, value string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if value == "" {
+		delete(s.variables, name)
+	} else {
+		s.variables[name] = value
+	}
+}
+
+func (s *testStatusServer) queryRows(query string) ([]string, [][]driver.Value, error) {
+	m := showStatusRegex.FindStringSubmatch(query)
+	if m == nil {
+		return nil, nil, fmt.Errorf("unexpected query: %v", query)
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	value, ok := s.variables[m[1]]
+	if !ok {
+		return nil, nil, fmt.Errorf("no status variable %v", m[1])
+	}
+	return []string{"Variable_name", "Value"}, [][]driver.Value{{m[1], value}}, nil
+}
+
+func newTestThrottler(t *testing.T, maxLoad string, criticalLoad string) (*throttler, *testStatusServer) {
+	server := &testStatusServer{variables: map[string]string{"Threads_running": "1", "Threads_connected": "1"}}
+	db := newRecorderDB(&recorderDriver{queryRows: server.queryRows})
+	th, err := newThrottler(db, maxLoad, criticalLoad, logrus.NewEntry(logrus.New()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return th, server
+}
+
+func TestNewThrottler(t *testing.T) {
+	th, err := newThrottler(nil, "", "", logrus.NewEntry(logrus.New()))
+	if th != nil || err != nil {
+		t.Fatalf("got %v %v, want no throttler", th, err)
+	}
+	// A nil throttler never throttles.
+	if err := th.run(nil); err != nil {
+		t.Fatal(err)
+	}
+	th.waitIfThrottled(nil)
+	if stage := throttledStage(models.StageSlaveWaitingForWorkersToProcessQueue, th); stage != models.StageSlaveWaitingForWorkersToProcessQueue {
+		t.Fatalf("got stage %v", stage)
+	}
+
+	for _, c := range []struct {
+		maxLoad      string
+		criticalLoad string
+	}{
+		{"Threads_running", ""},
+		{"Threads_running=a", ""},
+		{"", "=10"},
+	} {
+		if _, err := newThrottler(nil, c.maxLoad, c.criticalLoad, logrus.NewEntry(logrus.New())); err == nil {
+			t.Fatalf("expect an error for %+v", c)
+		}
+	}
+}
+
+func TestThrottlerCheck(t *testing.T) {
+	th, server := newTestThrottler(t, "Threads_running=20", "Threads_connected=100")
+	for _, c := range []struct {
+		running    string
+		connected  string
+		wantReason string
+		wantErr    bool
+	}{
+		{"19", "99", "", false},
+		{"20", "99", "max load reached: Threads_running=20 >= 20", false},
+		{"5", "99", "", false},
+		{"5", "100", "", true},
+	} {
+		server.set("Threads_running", c.running)
+		server.set("Threads_connected", c.connected)
+		err := th.check()
+		if c.wantErr {
+			if err == nil || !strings.Contains(err.Error(), "critical load reached") {
+				t.Fatalf("%+v: got %v, want a critical load error", c, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%+v: %v", c, err)
+		}
+		if reason := th.throttleReason(); reason != c.wantReason {
+			t.Fatalf("%+v: got reason %q, want %q", c, reason, c.wantReason)
+		}
+	}
+
+	server.set("Threads_running", "20")
+	server.set("Threads_connected", "1")
+	if err := th.check(); err != nil {
+		t.Fatal(err)
+	}
+	if stage := throttledStage(models.StageSlaveWaitingForWorkersToProcessQueue, th); !strings.HasPrefix(stage, models.StageThrottled+": ") {
+		t.Fatalf("got stage %v while throttled", stage)
+	}
+}
+
+func TestThrottlerReadFailures(t *testing.T) {
+	th, server := newTestThrottler(t, "Threads_running=20", "")
+
+	// Throttle rather than copy at an unknown load.
+	server.set("Threads_running", "")
+	for i := 0; i < throttleMaxReadFailures-1; i++ {
+		if err := th.check(); err != nil {
+			t.Fatalf("check %v: %v", i, err)
+		}
+	}
+	if reason := th.throttleReason(); !strings.HasPrefix(reason, "max load unknown") {
+		t.Fatalf("got reason %q while the load is unknown", reason)
+	}
+	// a successful read resets the failures
+	server.set("Threads_running", "1")
+	if err := th.check(); err != nil || th.throttleReason() != "" {
+		t.Fatalf("got %v %q after a successful read", err, th.throttleReason())
+	}
+
+	server.set("Threads_running", "")
+	for i := 0; i < throttleMaxReadFailures; i++ {
+		err := th.check()
+		if i < throttleMaxReadFailures-1 && err != nil {
+			t.Fatalf("check %v: %v", i, err)
+		}
+		if i == throttleMaxReadFailures-1 && err == nil {
+			t.Fatalf("expect an error after %v failures in a row", throttleMaxReadFailures)
+		}
+	}
+
+	// The value is not a number.
+	th, server = newTestThrottler(t, "", "Threads_connected=100")
+	server.set("Threads_connected", "abc")
+	for i := 0; i < throttleMaxReadFailures-1; i++ {
+		if err := th.check(); err != nil {
+			t.Fatalf("check %v: %v", i, err)
+		}
+	}
+	if err := th.check(); err == nil {
+		t.Fatalf("expect an error after %v failures in a row", throttleMaxReadFailures)
+	}
+}
+
+// waitThrottled waits until the throttler running in background is throttled.
+func waitThrottled(t *testing.T, th *throttler) {
+	deadline := time.Now().Add(5 * time.Second)
+	for th.throttleReason() == "" {
+		if time.Now().After(deadline) {
+			t.Fatalf("not throttled")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestThrottlerRun(t *testing.T) {
+	th, server := newTestThrottler(t, "Threads_running=20", "Threads_connected=100")
+	server.set("Threads_running", "30")
+
+	stopCh := make(chan struct{})
+	runErrCh := make(chan error, 1)
+	go func() {
+		runErrCh <- th.run(stopCh)
+	}()
+
+	// waits while throttled, until the load drops
+	time.AfterFunc(500*time.Millisecond, func() {
+		server.set("Threads_running", "1")
+	})
+	waitThrottled(t, th)
+	th.waitIfThrottled(nil)
+	if th.throttleReason() != "" {
+		t.Fatalf("still throttled after waitIfThrottled")
+	}
+
+	// waitIfThrottled returns when stopped
+	server.set("Threads_running", "30")
+	waitThrottled(t, th)
+	waitStopCh := make(chan struct{})
+	time.AfterFunc(200*time.Millisecond, func() {
+		close(waitStopCh)
+	})
+	th.waitIfThrottled(waitStopCh)
+	if th.throttleReason() == "" {
+		t.Fatalf("waitIfThrottled returned as not throttled, want by stopCh")
+	}
+
+	// The reason is cleared when run returns.
+	close(stopCh)
+	select {
+	case err := <-runErrCh:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("run not returned after stopped")
+	}
+	if reason := th.throttleReason(); reason != "" {
+		t.Fatalf("got reason %q after run returned", reason)
+	}
+
+	// run returns the critical load error
+	server.set("Threads_connected", "100")
+	if err := th.run(make(chan struct{})); err == nil {
+		t.Fatalf("expect a critical load error")
+	}
+}
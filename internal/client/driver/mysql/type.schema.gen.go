@@ -24,6 +24,10 @@ type DumpEntry struct {
 	RowsCount                int64
 	Err                      string
 	Table                    []byte
+	Staged                   bool
+	StageBegin               bool
+	StageEnd                 bool
+	Seq                      int64
 }
 
 func (d *DumpEntry) Size() (s uint64) {
@@ -231,7 +235,7 @@ func (d *DumpEntry) Size() (s uint64) {
 		}
 		s += l
 	}
-	s += 16
+	s += 27
 	return
 }
 func (d *DumpEntry) Marshal(buf []byte) ([]byte, error) {
@@ -528,7 +532,47 @@ func (d *DumpEntry) Marshal(buf []byte) ([]byte, error) {
 		copy(buf[i+16:], d.Table)
 		i += l
 	}
-	return buf[:i+16], nil
+	{
+		if d.Staged {
+			buf[i+16] = 1
+		} else {
+			buf[i+16] = 0
+		}
+	}
+	{
+		if d.StageBegin {
+			buf[i+17] = 1
+		} else {
+			buf[i+17] = 0
+		}
+	}
+	{
+		if d.StageEnd {
+			buf[i+18] = 1
+		} else {
+			buf[i+18] = 0
+		}
+	}
+	{
+
+		buf[i+0+19] = byte(d.Seq >> 0)
+
+		buf[i+1+19] = byte(d.Seq >> 8)
+
+		buf[i+2+19] = byte(d.Seq >> 16)
+
+		buf[i+3+19] = byte(d.Seq >> 24)
+
+		buf[i+4+19] = byte(d.Seq >> 32)
+
+		buf[i+5+19] = byte(d.Seq >> 40)
+
+		buf[i+6+19] = byte(d.Seq >> 48)
+
+		buf[i+7+19] = byte(d.Seq >> 56)
+
+	}
+	return buf[:i+27], nil
 }
 
 func (d *DumpEntry) Unmarshal(buf []byte) (uint64, error) {
@@ -828,5 +872,19 @@ func (d *DumpEntry) Unmarshal(buf []byte) (uint64, error) {
 		copy(d.Table, buf[i+16:])
 		i += l
 	}
-	return i + 16, nil
+	{
+		d.Staged = buf[i+16] == 1
+	}
+	{
+		d.StageBegin = buf[i+17] == 1
+	}
+	{
+		d.StageEnd = buf[i+18] == 1
+	}
+	{
+
+		d.Seq = 0 | (int64(buf[i+0+19]) << 0) | (int64(buf[i+1+19]) << 8) | (int64(buf[i+2+19]) << 16) | (int64(buf[i+3+19]) << 24) | (int64(buf[i+4+19]) << 32) | (int64(buf[i+5+19]) << 40) | (int64(buf[i+6+19]) << 48) | (int64(buf[i+7+19]) << 56)
+
+	}
+	return i + 27, nil
 }
@@ -0,0 +1,289 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package mysql
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+
+	"github.com/golang/snappy"
+
+	"github.com/actiontech/dtle/internal/client/driver/common"
+	"github.com/actiontech/dtle/internal/client/driver/mysql/binlog"
+)
+
+// The fields appended to DumpEntry in type.schema, which are fixed-size.
+// A DumpEntry in WireVersionLegacy from an older agent ends without Seq, or without Staged/StageBegin/StageEnd and Seq.
+const (
+	dumpEntryStageSize = 3
+	dumpEntrySeqSize   = 8
+)
+
+// unmarshalLegacyDumpEntry is DumpEntry.Unmarshal, which also decodes a DumpEntry from an older agent.
+// The fields it does not have are zero. It returns the size of msg consumed.
+func unmarshalLegacyDumpEntry(entry *DumpEntry, msg []byte) (uint64, error) {
+	// The generated code does not check the size, so the fields possibly missing are padded with zeros.
+	buf := make([]byte, len(msg)+dumpEntryStageSize+dumpEntrySeqSize)
+	copy(buf, msg)
+	n, err := entry.Unmarshal(buf)
+	if err != nil {
+		return 0, err
+	}
+	for _, missing := range []uint64{0, dumpEntrySeqSize, dumpEntryStageSize + dumpEntrySeqSize} {
+		if n == uint64(len(msg))+missing {
+			return uint64(len(msg)), nil
+		}
+	}
+	return n, nil
+}
+
+// Encoding of DumpEntry and DumpStatResult in common.WireVersionProto. See wire.proto for the schema.
+
+func (d *DumpEntry) MarshalWire() ([]byte, error) {
+	w := &common.WireWriter{}
+	w.String(1, d.SystemVariablesStatement)
+	w.String(2, d.SqlMode)
+	w.String(3, d.DbSQL)
+	w.String(4, d.TableName)
+	w.String(5, d.TableSchema)
+	for _, sql := range d.TbSQL {
+		w.RepeatedString(6, sql)
+	}
+	for _, row := range d.ValuesX {
+		w.Message(7, func(w *common.WireWriter) error {
+			for _, cell := range row {
+				w.Message(1, func(w *common.WireWriter) error {
+					if cell == nil {
+						w.Bool(1, true)
+					} else {
+						w.RawBytes(2, *cell)
+					}
+					return nil
+				})
+			}
+			return nil
+		})
+	}
+	w.Svarint(8, d.TotalCount)
+	w.Svarint(9, d.RowsCount)
+	w.String(10, d.Err)
+	w.RawBytes(11, d.Table)
+	w.Bool(12, d.Staged)
+	w.Bool(13, d.StageBegin)
+	w.Bool(14, d.StageEnd)
+	w.Svarint(15, d.Seq)
+	return w.Bytes(), nil
+}
+
+func (d *DumpEntry) UnmarshalWire(data []byte) error {
+	r := common.NewWireReader(data)
+	for r.Next() {
+		switch r.Field() {
+		case 1:
+			d.SystemVariablesStatement = r.String()
+		case 2:
+			d.SqlMode = r.String()
+		case 3:
+			d.DbSQL = r.String()
+		case 4:
+			d.TableName = r.String()
+		case 5:
+			d.TableSchema = r.String()
+		case 6:
+			d.TbSQL = append(d.TbSQL, r.String())
+		case 7:
+			var row []*[]byte
+			r.Message(func(r *common.WireReader) error {
+				for r.Next() {
+					if r.Field() != 1 {
+						r.Skip()
+						continue
+					}
+					r.Message(func(r *common.WireReader) error {
+						null := false
+						value := []byte{}
+						for r.Next() {
+							switch r.Field() {
+							case 1:
+								null = r.Bool()
+							case 2:
+								value = append(value, r.RawBytes()...)
+							default:
+								r.Skip()
+							}
+						}
+						if null {
+							row = append(row, nil)
+						} else {
+							row = append(row, &value)
+						}
+						return r.Err()
+					})
+				}
+				return r.Err()
+			})
+			d.ValuesX = append(d.ValuesX, row)
+		case 8:
+			d.TotalCount = r.Svarint()
+		case 9:
+			d.RowsCount = r.Svarint()
+		case 10:
+			d.Err = r.String()
+		case 11:
+			d.Table = append([]byte{}, r.RawBytes()...)
+		case 12:
+			d.Staged = r.Bool()
+		case 13:
+			d.StageBegin = r.Bool()
+		case 14:
+			d.StageEnd = r.Bool()
+		case 15:
+			d.Seq = r.Svarint()
+		default:
+			r.Skip()
+		}
+	}
+	return r.Err()
+}
+
+func (d *DumpStatResult) MarshalWire() ([]byte, error) {
+	w := &common.WireWriter{}
+	w.String(1, d.Gtid)
+	w.Svarint(2, d.TotalCount)
+	w.String(3, d.LogFile)
+	w.Svarint(4, d.LogPos)
+	return w.Bytes(), nil
+}
+
+func (d *DumpStatResult) UnmarshalWire(data []byte) error {
+	r := common.NewWireReader(data)
+	for r.Next() {
+		switch r.Field() {
+		case 1:
+			d.Gtid = r.String()
+		case 2:
+			d.TotalCount = r.Svarint()
+		case 3:
+			d.LogFile = r.String()
+		case 4:
+			d.LogPos = r.Svarint()
+		default:
+			r.Skip()
+		}
+	}
+	return r.Err()
+}
+
+// WireDecoder decodes the payloads from the extractor, by the wire version agreed in the handshake.
+// It is shared by the applier and the kafka runner.
+type WireDecoder struct {
+	Handshake     *common.HelloServer
+	PayloadCipher *common.PayloadCipher
+	Codec         *common.Codec
+}
+
+// payload decrypts and decompresses a payload.
+func (d *WireDecoder) payload(subject string, data []byte) ([]byte, error) {
+	if d.Handshake.Version() == common.WireVersionLegacy {
+		return snappy.Decode(nil, data)
+	}
+	data, err := d.PayloadCipher.Decrypt(subject, data)
+	if err != nil {
+		return nil, err
+	}
+	return d.Codec.Decompress(data)
+}
+
+// CheckRoute tells if the payloads routed by another job (RouteHello) can be decoded.
+func (d *WireDecoder) CheckRoute(h *common.RouteHello) error {
+	return common.CheckRoute(h, d.Handshake.Version(), d.PayloadCipher)
+}
+
+func (d *WireDecoder) DumpEntry(subject string, data []byte) (*DumpEntry, error) {
+	data, err := d.payload(subject, data)
+	if err != nil {
+		return nil, err
+	}
+	if d.Handshake.Version() == common.WireVersionLegacy {
+		return DecodeDumpEntry(data)
+	}
+	entry := &DumpEntry{}
+	if err := entry.UnmarshalWire(data); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+func (d *WireDecoder) DumpStatResult(subject string, data []byte) (*DumpStatResult, error) {
+	data, err := d.payload(subject, data)
+	if err != nil {
+		return nil, err
+	}
+	result := &DumpStatResult{}
+	if d.Handshake.Version() == common.WireVersionLegacy {
+		err = Decode(data, result)
+	} else {
+		err = result.UnmarshalWire(data)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (d *WireDecoder) BinlogEntries(subject string, data []byte) (*binlog.BinlogEntries, error) {
+	data, err := d.payload(subject, data)
+	if err != nil {
+		return nil, err
+	}
+	entries := &binlog.BinlogEntries{}
+	if d.Handshake.Version() == common.WireVersionLegacy {
+		err = Decode(data, entries)
+	} else {
+		err = entries.UnmarshalWire(data)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// encodeWire encodes v (*DumpEntry, *DumpStatResult or *binlog.BinlogEntries) in version.
+// The payload is compressed by codec, except in WireVersionLegacy, which is always snappy.
+func encodeWire(version int, codec *common.Codec, v interface{}) ([]byte, error) {
+	if version == common.WireVersionLegacy {
+		if entry, ok := v.(*DumpEntry); ok {
+			bs, err := entry.Marshal(nil)
+			if err != nil {
+				return nil, err
+			}
+			return snappy.Encode(nil, bs), nil
+		}
+		b := new(bytes.Buffer)
+		if err := gob.NewEncoder(b).Encode(v); err != nil {
+			return nil, err
+		}
+		return snappy.Encode(nil, b.Bytes()), nil
+	}
+
+	var bs []byte
+	var err error
+	switch v := v.(type) {
+	case *DumpEntry:
+		bs, err = v.MarshalWire()
+	case *DumpStatResult:
+		bs, err = v.MarshalWire()
+	case *binlog.BinlogEntries:
+		bs, err = v.MarshalWire()
+	default:
+		return nil, fmt.Errorf("wire: cannot encode %T", v)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return codec.Compress(bs)
+}
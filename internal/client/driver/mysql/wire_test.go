@@ -0,0 +1,134 @@
+package mysql
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDumpEntryWire(t *testing.T) {
+	cell := func(s string) *[]byte {
+		bs := []byte(s)
+		return &bs
+	}
+	for _, entry := range []*DumpEntry{
+		{},
+		{
+			SystemVariablesStatement: "SET @@session.foreign_key_checks = 0",
+			SqlMode:                  "STRICT_TRANS_TABLES",
+			DbSQL:                    "CREATE DATABASE IF NOT EXISTS `db1`",
+			TableName:                "tb1",
+			TableSchema:              "db1",
+			TbSQL:                    []string{"USE `db1`", "", "CREATE TABLE `tb1` (id int)"},
+			TotalCount:               -1,
+			RowsCount:                2,
+			Table:                    []byte{1, 2, 3},
+			Staged:                   true,
+			StageBegin:               true,
+		},
+		{
+			TableName:   "tb1",
+			TableSchema: "db1",
+			// a NULL, an empty value and a value in each row
+			ValuesX: [][]*[]byte{
+				{cell("1"), nil, cell("")},
+				{cell("2"), cell("a"), nil},
+			},
+			RowsCount: 2,
+			Err:       "some error",
+			Staged:    true,
+			StageEnd:  true,
+			Seq:       42,
+		},
+	} {
+		data, err := entry.MarshalWire()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := &DumpEntry{}
+		if err := got.UnmarshalWire(data); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, entry) {
+			t.Fatalf("got %+v, want %+v", got, entry)
+		}
+	}
+}
+
+func TestDumpStatResultWire(t *testing.T) {
+	for _, stat := range []*DumpStatResult{
+		{},
+		{
+			Gtid:       "00000000-0000-0000-0000-000000000001:1-10",
+			TotalCount: 100,
+			LogFile:    "mysql-bin.000002",
+			LogPos:     4,
+		},
+	} {
+		data, err := stat.MarshalWire()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := &DumpStatResult{}
+		if err := got.UnmarshalWire(data); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, stat) {
+			t.Fatalf("got %+v, want %+v", got, stat)
+		}
+	}
+}
+
+func TestDumpEntryLegacy(t *testing.T) {
+	entry := &DumpEntry{
+		SqlMode:     "STRICT_TRANS_TABLES",
+		TableName:   "tb1",
+		TableSchema: "db1",
+		TbSQL:       []string{"USE `db1`"},
+		ValuesX:     [][]*[]byte{{nil}},
+		RowsCount:   1,
+		Table:       []byte{1, 2, 3},
+		Staged:      true,
+		StageBegin:  true,
+		StageEnd:    true,
+		Seq:         42,
+	}
+	data, err := entry.Marshal(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		size    int
+		want    func(e DumpEntry) DumpEntry
+		wantErr bool
+	}{
+		{"current", len(data), func(e DumpEntry) DumpEntry { return e }, false},
+		{"without Seq", len(data) - dumpEntrySeqSize, func(e DumpEntry) DumpEntry {
+			e.Seq = 0
+			return e
+		}, false},
+		{"without Stage and Seq", len(data) - dumpEntrySeqSize - dumpEntryStageSize, func(e DumpEntry) DumpEntry {
+			e.Staged, e.StageBegin, e.StageEnd, e.Seq = false, false, false, 0
+			return e
+		}, false},
+		{"truncated", len(data) - 1, nil, true},
+		{"trailing garbage", len(data) + 1, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := make([]byte, tt.size)
+			copy(msg, data)
+			got, err := DecodeDumpEntry(msg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("DecodeDumpEntry() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if want := tt.want(*entry); !reflect.DeepEqual(*got, want) {
+				t.Fatalf("DecodeDumpEntry() = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
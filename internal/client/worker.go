@@ -569,7 +569,10 @@ func (r *Worker) startTask() error {
 	}
 
 	// Run prestart
-	ctx := &common.ExecContext{r.alloc.Job.ID, r.alloc.Job.Type, r.config.MaxPayload, r.config.StateDir}
+	ctx := &common.ExecContext{r.alloc.Job.ID, r.alloc.Job.Type, r.config.MaxPayload, r.config.StateDir,
+		func(event *models.TaskEvent) {
+			r.setState("", event)
+		}, r.config.NatsSecurity}
 
 	// Start the job
 	handle, err := drv.Start(ctx, r.task)
@@ -639,6 +642,17 @@ func (r *Worker) collectResourceUsageStats(stopCollection <-chan struct{}) {
 	}
 }
 
+// rateLimitHandle returns the handle if the task is running and supports rate limiting.
+func (r *Worker) rateLimitHandle() (driver.RateLimitHandle, bool) {
+	r.handleLock.Lock()
+	defer r.handleLock.Unlock()
+	if r.handle == nil {
+		return nil, false
+	}
+	h, ok := r.handle.(driver.RateLimitHandle)
+	return h, ok
+}
+
 // LatestResourceUsage returns the last resource utilization datapoint collected
 func (r *Worker) LatestTaskStats() *models.TaskStatistics {
 	r.taskStatsLock.RLock()
@@ -764,6 +778,10 @@ func (r *Worker) emitStats(ru *models.TaskStatistics) {
 		metrics.SetGaugeWithLabels([]string{"buffer", "dest_queue_size"}, float32(ru.BufferStat.ApplierTxQueueSize), labels)
 		metrics.SetGaugeWithLabels([]string{"buffer", "send_by_timeout"}, float32(ru.BufferStat.SendByTimeout), labels)
 		metrics.SetGaugeWithLabels([]string{"buffer", "send_by_size_full"}, float32(ru.BufferStat.SendBySizeFull), labels)
+		metrics.SetGaugeWithLabels([]string{"buffer", "src_credit_entries"}, float32(ru.BufferStat.ExtractorCreditEntries), labels)
+		metrics.SetGaugeWithLabels([]string{"buffer", "src_credit_bytes"}, float32(ru.BufferStat.ExtractorCreditBytes), labels)
+		metrics.SetGaugeWithLabels([]string{"buffer", "src_throttled_ms"}, float32(ru.BufferStat.ExtractorThrottledMs), labels)
+		metrics.SetGaugeWithLabels([]string{"buffer", "dest_queue_bytes"}, float32(ru.BufferStat.ApplierTxQueueBytes), labels)
 	}
 	if ru.TableStats != nil && r.config.PublishAllocationMetrics {
 		metrics.SetGaugeWithLabels([]string{"table", "insert"}, float32(ru.TableStats.InsertCount), labels)
@@ -780,4 +798,9 @@ func (r *Worker) emitStats(ru *models.TaskStatistics) {
 		metrics.SetGaugeWithLabels([]string{"throughput", "num"}, float32(ru.ThroughputStat.Num), labels)
 		metrics.SetGaugeWithLabels([]string{"throughput", "time"}, float32(ru.ThroughputStat.Time), labels)
 	}
+
+	if ru.HeartbeatLag != nil && r.config.PublishAllocationMetrics {
+		metrics.SetGaugeWithLabels([]string{"heartbeat", "lag"}, float32(ru.HeartbeatLag.Lag), labels)
+		metrics.SetGaugeWithLabels([]string{"heartbeat", "since_last"}, float32(ru.HeartbeatLag.SinceLast), labels)
+	}
 }
@@ -29,11 +29,13 @@ const (
 	DefaultBindPort  int = 8191
 	DefaultClusterID     = "udup-cluster"
 
-	channelBufferSize = 600
-	defaultNumRetries = 5
-	defaultChunkSize  = 2000
-	defaultNumWorkers = 1
-	defaultMsgBytes   = 20 * 1024
+	channelBufferSize          = 600
+	defaultNumRetries          = 5
+	defaultChunkSize           = 2000
+	defaultSnapshotParallelism = 1
+	defaultNumWorkers          = 1
+	defaultMsgBytes            = 20 * 1024
+	defaultTxMemoryLimit       = 100 * 1024 * 1024
 )
 
 // RPCHandler can be provided to the Client if there is a local server
@@ -83,6 +85,10 @@ type ClientConfig struct {
 
 	MaxPayload int
 
+	// NatsSecurity is the TLS and authentication of the nats server,
+	// and of the connections the tasks make to nats servers.
+	NatsSecurity *NatsSecurity
+
 	// StatsCollectionInterval is the interval at which the Udup client
 	// collects resource usage stats
 	StatsCollectionInterval time.Duration
@@ -112,6 +118,28 @@ func (c *ClientConfig) Copy() *ClientConfig {
 	return nc
 }
 
+// NatsSecurity is the TLS and authentication setting of the data channel between agents.
+// All agents of a cluster should share the same setting.
+type NatsSecurity struct {
+	// The certificate of the agent. It is used as the server certificate, and as the client
+	// certificate when connecting to other agents. Empty to disable TLS.
+	TLSCertFile string
+	TLSKeyFile  string
+	// CA to verify the server certificate, and the client certificate if TLSVerify.
+	TLSCAFile string
+	// Require and verify client certificates.
+	TLSVerify bool
+
+	// Either Token or User/Password is required if set.
+	Token    string
+	User     string
+	Password string
+}
+
+func (s *NatsSecurity) TLSEnabled() bool {
+	return s != nil && s.TLSCertFile != ""
+}
+
 type DriverCtx struct {
 	DriverConfig *MySQLDriverConfig
 }
@@ -120,6 +148,24 @@ func (d *DataSource) String() string {
 	return fmt.Sprintf(d.TableSchema)
 }
 
+const (
+	OriginUuidInclude = "include"
+	OriginUuidExclude = "exclude"
+	OriginUuidRoute   = "route"
+)
+
+// OriginUuidRule filters or routes a transaction by the server uuid where it is originally executed.
+// The first matched rule is applied. If no rule is matched, the transaction is skipped
+// if there is any "include" rule, or replicated otherwise.
+type OriginUuidRule struct {
+	// "*" for any server
+	ServerUuid string
+	// OriginUuidInclude, OriginUuidExclude or OriginUuidRoute
+	Action string
+	// For OriginUuidRoute: the transaction is sent to the job (by the subject) instead.
+	RouteTo string
+}
+
 type MySQLDriverConfig struct {
 	//Ref:http://dev.mysql.com/doc/refman/5.7/en/replication-options-slave.html#option_mysqld_replicate-do-table
 	ReplicateDoDb                       []*DataSource
@@ -132,7 +178,10 @@ type MySQLDriverConfig struct {
 	TotalTransferredBytes               int
 	MaxRetries                          int64
 	ChunkSize                           int64
+	SnapshotParallelism                 int   // number of concurrent dumping workers in full copy. the dest applies with that of the src
+	SnapshotRangeRows                   int64 // split a table into unique key ranges of about this many rows in full copy. 0 to disable.
 	SqlFilter                           []string
+	OriginUuidRules                     []*OriginUuidRule
 	RowsEstimate                        int64
 	DeltaEstimate                       int64
 	TimeZone                            string
@@ -166,6 +215,37 @@ type MySQLDriverConfig struct {
 	Stage                string
 	ApproveHeterogeneous bool
 	SkipCreateDbTable    bool
+	// dest: record statements of applied rows (from ROWS_QUERY events) into table RowsQueryAuditTable
+	RowsQueryAudit bool
+	// src: write a heartbeat row into table HeartbeatTable every HeartbeatInterval seconds,
+	// to measure the end-to-end replication lag. 0 to disable.
+	HeartbeatInterval int
+	// src: other servers (e.g. replicas which might be promoted by MHA/orchestrator) to fail over to,
+	// when the binlog connection to ConnectionConfig is lost. Empty User/Password/Charset are taken from ConnectionConfig.
+	SourceCandidates []*umconf.ConnectionConfig
+	// src: soft traffic limit of the job. Can be changed at runtime with the HTTP API.
+	RateLimit *models.RateLimit
+	// src/dest: status variable thresholds (LoadMap), e.g. "Threads_running=25,Threads_connected=500".
+	// The full copy pauses while any is exceeded on the source (chunk reads) or the dest (chunk writes).
+	MaxLoad string
+	// src/dest: the task is aborted if any is exceeded during the full copy. Same format as MaxLoad.
+	CriticalLoad string
+	// src/dest: "nats" (default) or "tcp". With "tcp", messages are streamed over a direct connection:
+	// the dest listens on TransportPort, and the src connects to it at the host of NatsAddr.
+	Transport     string
+	TransportPort int
+	// src/dest: a file on the agent with the keys to encrypt (src) and decrypt (dest) the payloads
+	// of the job with AES-GCM. See common.PayloadCipher for the format. Empty to disable.
+	EncryptionKeyFile string
+	// src: payload compression. "none", "snappy" (default), "zstd" or "lz4". The dest follows the src.
+	Compression string
+	// src: 1-22 for zstd, or the search depth of the high compression mode for lz4. 0 for the default.
+	CompressionLevel int
+	// dest: bytes (of the binlog entries) the src may send ahead of the dest queue. 0 for 64MB.
+	// The entries are limited by ReplChanBufferSize of the dest.
+	FlowControlBytes int64
+	// src/dest: bytes (of the binlog entries) of a tx kept in memory. The events beyond are spilled to the disk.
+	TxMemoryLimit int64
 
 	CountingRowsFlag            int64
 
@@ -182,6 +262,9 @@ func (a *MySQLDriverConfig) SetDefault() *MySQLDriverConfig {
 	if result.ChunkSize <= 0 {
 		result.ChunkSize = defaultChunkSize
 	}
+	if result.SnapshotParallelism <= 0 {
+		result.SnapshotParallelism = defaultSnapshotParallelism
+	}
 	if result.ReplChanBufferSize <= 0 {
 		result.ReplChanBufferSize = channelBufferSize
 	}
@@ -200,6 +283,9 @@ func (a *MySQLDriverConfig) SetDefault() *MySQLDriverConfig {
 	if result.GroupTimeout == 0 {
 		result.GroupTimeout = 100
 	}
+	if result.TxMemoryLimit <= 0 {
+		result.TxMemoryLimit = defaultTxMemoryLimit
+	}
 
 	// TODO temporarily (or permanently) disable homogeneous replication, hetero only.
 	result.ApproveHeterogeneous = true
@@ -274,14 +360,24 @@ type Table struct {
 	TableSchema       string
 	TableSchemaRename string
 	Counter           int64
+	// Column spec. If ColumnMapFrom is not empty, only these columns are replicated, in the given order.
+	// ColumnMapTo (if not empty) has the same length as ColumnMapFrom, and renames the columns.
+	// An empty item in ColumnMapTo keeps the column name.
+	// ColumnExclude columns are not replicated.
 	ColumnMapFrom     []string
-	//ColumnMapTo       []string
+	ColumnMapTo       []string
+	ColumnExclude     []string
 	//ColumnMapUseRe    bool
+	// column name -> expression, e.g. {"email": "hash(email, 'salt')"}. See TransformContext.
+	ColumnTransforms  map[string]string
 
 	OriginalTableColumns *umconf.ColumnList
 	UseUniqueKey         *umconf.UniqueKey
 	Iteration            int64
+	// Built by BuildColumnMap. ColumnMap[i] is the index in OriginalTableColumns of the i-th replicated column.
+	// Both are empty if all columns are replicated as is.
 	ColumnMap            []int
+	MappedTableColumns   *umconf.ColumnList
 
 	TableType    string
 	TableEngine  string
@@ -290,25 +386,84 @@ type Table struct {
 	Where string // TODO load from job description
 }
 
-func BuildColumnMapIndex(from []string, ordinals umconf.ColumnsMap) (mapIndex []int) {
-	mapIndex = make([]int, len(from))
-	for i, colName := range from {
-		idxFrom := ordinals[colName]
-		mapIndex[i] = idxFrom
+// BuildColumnMap builds ColumnMap and MappedTableColumns from the column spec and OriginalTableColumns.
+func (t *Table) BuildColumnMap() error {
+	t.ColumnMap = nil
+	t.MappedTableColumns = nil
+	if len(t.ColumnMapFrom) == 0 && len(t.ColumnMapTo) == 0 && len(t.ColumnExclude) == 0 {
+		return nil
 	}
-	return mapIndex
+	if len(t.ColumnMapTo) > 0 && len(t.ColumnMapTo) != len(t.ColumnMapFrom) {
+		return fmt.Errorf("table %v.%v: ColumnMapTo (%v columns) does not match ColumnMapFrom (%v columns)",
+			t.TableSchema, t.TableName, len(t.ColumnMapTo), len(t.ColumnMapFrom))
+	}
+
+	// column names are case insensitive
+	findColumn := func(name string) int {
+		for i := range t.OriginalTableColumns.Columns {
+			if strings.EqualFold(t.OriginalTableColumns.Columns[i].RawName, name) {
+				return i
+			}
+		}
+		return -1
+	}
+	excluded := make(map[int]bool)
+	for _, name := range t.ColumnExclude {
+		idx := findColumn(name)
+		if idx < 0 {
+			return fmt.Errorf("table %v.%v: unknown column %v in ColumnExclude", t.TableSchema, t.TableName, name)
+		}
+		excluded[idx] = true
+	}
+	from := t.ColumnMapFrom
+	if len(from) == 0 {
+		from = t.OriginalTableColumns.Names()
+	}
+
+	var columns []umconf.Column
+	for i, name := range from {
+		idx := findColumn(name)
+		if idx < 0 {
+			return fmt.Errorf("table %v.%v: unknown column %v in ColumnMapFrom", t.TableSchema, t.TableName, name)
+		}
+		if excluded[idx] {
+			continue
+		}
+		column := t.OriginalTableColumns.Columns[idx]
+		if len(t.ColumnMapTo) > 0 && t.ColumnMapTo[i] != "" {
+			column.RawName = t.ColumnMapTo[i]
+			column.EscapedName = umconf.EscapeName(t.ColumnMapTo[i])
+		}
+		t.ColumnMap = append(t.ColumnMap, idx)
+		columns = append(columns, column)
+	}
+	if len(columns) == 0 {
+		return fmt.Errorf("table %v.%v: no column left after applying the column spec", t.TableSchema, t.TableName)
+	}
+	t.MappedTableColumns = umconf.NewColumnList(columns)
+	return nil
+}
+
+// GetMappedTableColumns returns the replicated columns (after the column spec is applied).
+func (t *Table) GetMappedTableColumns() *umconf.ColumnList {
+	if t.MappedTableColumns != nil {
+		return t.MappedTableColumns
+	}
+	return t.OriginalTableColumns
 }
 
 type TableContext struct {
 	Table          *Table
 	WhereCtx       *WhereContext
+	TransformCtx   *TransformContext // nil if no transform
 	DefChangedSent bool
 }
 
-func NewTableContext(table *Table, whereCtx *WhereContext) *TableContext {
+func NewTableContext(table *Table, whereCtx *WhereContext, transformCtx *TransformContext) *TableContext {
 	return &TableContext{
 		Table:          table,
 		WhereCtx:       whereCtx,
+		TransformCtx:   transformCtx,
 		DefChangedSent: false,
 	}
 }
@@ -344,6 +499,13 @@ func (t *TableContext) WhereTrue(values *umconf.ColumnValues) (bool, error) {
 						fmt.Errorf("where_predicate. expect []byte for TextColumnType, but got %T", rawValue)
 				}
 				value = string(bs)
+			case umconf.UnknownColumnType:
+				// the type is not set, e.g. columns built by names only. take bytes as a string.
+				if bs, ok := rawValue.([]byte); ok {
+					value = string(bs)
+				} else {
+					value = rawValue
+				}
 			default:
 				value = rawValue
 			}
@@ -321,12 +321,20 @@ func (c *UniqueKey) String() string {
 
 type ColumnValues struct {
 	AbstractValues []*interface{}
+	// Present is nil if all columns are in the row image.
+	// Otherwise (binlog_row_image=MINIMAL/NOBLOB) a column is absent if false.
+	Present []bool
 }
 
 func (this *ColumnValues) GetAbstractValues() []*interface{} {
 	return this.AbstractValues
 }
 
+// IsPresent tells whether the column is in the row image. An absent column is not NULL.
+func (this *ColumnValues) IsPresent(index int) bool {
+	return this.Present == nil || this.Present[index]
+}
+
 func (c *ColumnValues) StringColumn(index int) string {
 	val := *c.GetAbstractValues()[index]
 	if ints, ok := val.([]uint8); ok {
@@ -0,0 +1,353 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package config
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	umconf "github.com/actiontech/dtle/internal/config/mysql"
+
+	qldatasource "github.com/araddon/qlbridge/datasource"
+	qlexpr "github.com/araddon/qlbridge/expr"
+	"github.com/araddon/qlbridge/value"
+	qlvm "github.com/araddon/qlbridge/vm"
+)
+
+type columnTransform struct {
+	// index in OriginalTableColumns
+	ColumnIdx int
+	Expr      string
+	Ast       qlexpr.Node
+	// fields referenced by the expression
+	Fields []string
+}
+
+// TransformContext rewrites column values with Table.ColumnTransforms.
+// Expressions are evaluated on the source row, before the column spec is applied.
+type TransformContext struct {
+	Transforms []*columnTransform
+	// fields referenced by any expression. field name -> index in OriginalTableColumns
+	FieldsMap map[string]int
+}
+
+// NewTransformCtx returns nil (without error) if the table has no transforms.
+func NewTransformCtx(table *Table) (*TransformContext, error) {
+	if len(table.ColumnTransforms) == 0 {
+		return nil, nil
+	}
+	ctx := &TransformContext{
+		FieldsMap: make(map[string]int),
+	}
+	findColumn := func(name string) (int, error) {
+		for i := range table.OriginalTableColumns.Columns {
+			if strings.EqualFold(table.OriginalTableColumns.Columns[i].RawName, name) {
+				return i, nil
+			}
+		}
+		return -1, fmt.Errorf("bad transform for table %v.%v: column %v does not exist",
+			table.TableSchema, table.TableName, name)
+	}
+
+	// sort to get a stable order
+	columns := make([]string, 0, len(table.ColumnTransforms))
+	for column := range table.ColumnTransforms {
+		columns = append(columns, column)
+	}
+	sort.Strings(columns)
+
+	for _, column := range columns {
+		expr := table.ColumnTransforms[column]
+		columnIdx, err := findColumn(column)
+		if err != nil {
+			return nil, err
+		}
+		ast, err := qlexpr.ParseExpression(expr)
+		if err != nil {
+			return nil, fmt.Errorf("bad transform '%v' for column %v.%v.%v: %v",
+				expr, table.TableSchema, table.TableName, column, err)
+		}
+		fields := qlexpr.FindAllIdentityField(ast)
+		for _, field := range fields {
+			if _, ok := ctx.FieldsMap[field]; ok {
+				continue
+			}
+			idx, err := findColumn(field)
+			if err != nil {
+				return nil, err
+			}
+			ctx.FieldsMap[field] = idx
+		}
+		ctx.Transforms = append(ctx.Transforms, &columnTransform{
+			ColumnIdx: columnIdx,
+			Expr:      expr,
+			Ast:       ast,
+			Fields:    fields,
+		})
+	}
+	return ctx, nil
+}
+
+// Transform evaluates the expressions. getValue returns the value of a column (by index in OriginalTableColumns),
+// nil for NULL. result[i] is the new value of Transforms[i].ColumnIdx, nil for NULL.
+func (c *TransformContext) Transform(getValue func(idx int) interface{}) (result []interface{}, err error) {
+	m := make(map[string]interface{})
+	for field, idx := range c.FieldsMap {
+		v := getValue(idx)
+		if bs, ok := v.([]byte); ok {
+			v = string(bs)
+		}
+		if v != nil {
+			m[field] = v
+		}
+	}
+	ctx := qldatasource.NewContextSimpleNative(m)
+	result = make([]interface{}, len(c.Transforms))
+	for i, t := range c.Transforms {
+		val, ok := qlvm.Eval(ctx, t.Ast)
+		if !ok {
+			if t.hasNullField(m) {
+				// like SQL, an expression on NULL is NULL
+				result[i] = nil
+				continue
+			}
+			return nil, fmt.Errorf("cannot eval transform '%v' with the row value", t.Expr)
+		}
+		if val == nil || val.Type() == value.NilType {
+			result[i] = nil
+		} else {
+			result[i] = val.Value()
+		}
+	}
+	return result, nil
+}
+
+func (t *columnTransform) hasNullField(m map[string]interface{}) bool {
+	for _, field := range t.Fields {
+		if _, ok := m[field]; !ok {
+			return true
+		}
+	}
+	return false
+}
+
+// FormatTransformValue formats a result of Transform for a column of a string type, or for the full copy,
+// where values are sent as strings. A number is not formatted in the exponent form, and a bool is 1 or 0.
+func FormatTransformValue(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case float32:
+		return strconv.FormatFloat(float64(v), 'f', -1, 32)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case int:
+		return strconv.Itoa(v)
+	case bool:
+		if v {
+			return "1"
+		}
+		return "0"
+	case time.Time:
+		return v.Format(convertTzLayout)
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+// TransformValues rewrites the values (of OriginalTableColumns) of a binlog row image in place.
+func (c *TransformContext) TransformValues(values *umconf.ColumnValues) error {
+	nCols := len(values.AbstractValues)
+	for _, t := range c.Transforms {
+		if t.ColumnIdx >= nCols {
+			return fmt.Errorf("cannot transform column %v: no enough columns (%v)", t.ColumnIdx, nCols)
+		}
+		if !values.IsPresent(t.ColumnIdx) {
+			continue
+		}
+		// binlog_row_image=MINIMAL or NOBLOB
+		for _, field := range t.Fields {
+			if idx := c.FieldsMap[field]; idx >= nCols || !values.IsPresent(idx) {
+				return fmt.Errorf("cannot eval transform '%v': column %v is not in the row image", t.Expr, field)
+			}
+		}
+	}
+	result, err := c.Transform(func(idx int) interface{} {
+		if idx >= nCols || values.AbstractValues[idx] == nil {
+			return nil
+		}
+		return *values.AbstractValues[idx]
+	})
+	if err != nil {
+		return err
+	}
+	for i, t := range c.Transforms {
+		if !values.IsPresent(t.ColumnIdx) {
+			continue
+		}
+		v := result[i]
+		if v != nil {
+			// keep the type of a string column
+			var orig interface{}
+			if values.AbstractValues[t.ColumnIdx] != nil {
+				orig = *values.AbstractValues[t.ColumnIdx]
+			}
+			switch orig.(type) {
+			case []byte:
+				v = []byte(FormatTransformValue(v))
+			case string:
+				v = FormatTransformValue(v)
+			}
+		}
+		values.AbstractValues[t.ColumnIdx] = &v
+	}
+	return nil
+}
+
+func init() {
+	qlexpr.FuncAdd("hash", &maskFunc{name: "hash", minArgs: 2, maxArgs: 2, f: maskHash})
+	qlexpr.FuncAdd("mask", &maskFunc{name: "mask", minArgs: 1, maxArgs: 3, f: maskMiddle})
+	qlexpr.FuncAdd("mask_email", &maskFunc{name: "mask_email", minArgs: 1, maxArgs: 1, f: maskEmail})
+	qlexpr.FuncAdd("redact", &maskFunc{name: "redact", minArgs: 1, maxArgs: 2, f: maskRedact})
+	qlexpr.FuncAdd("convert_tz", &maskFunc{name: "convert_tz", minArgs: 3, maxArgs: 3, f: convertTz})
+}
+
+// maskFunc is a builtin function for ColumnTransforms. The first argument is the column value.
+// NULL is returned for a NULL value.
+type maskFunc struct {
+	name    string
+	minArgs int
+	maxArgs int
+	f       func(s string, args []value.Value) (string, error)
+}
+
+func (m *maskFunc) Type() value.ValueType { return value.StringType }
+
+func (m *maskFunc) Validate(n *qlexpr.FuncNode) (qlexpr.EvaluatorFunc, error) {
+	if len(n.Args) < m.minArgs || len(n.Args) > m.maxArgs {
+		return nil, fmt.Errorf("%v() expects %v to %v args, but got %v", m.name, m.minArgs, m.maxArgs, len(n.Args))
+	}
+	return func(ctx qlexpr.EvalContext, args []value.Value) (value.Value, bool) {
+		if args[0] == nil || args[0].Type() == value.NilType {
+			return value.NewNilValue(), true
+		}
+		s, ok := value.ValueToString(args[0])
+		if !ok {
+			return value.NewNilValue(), false
+		}
+		r, err := m.f(s, args[1:])
+		if err != nil {
+			return value.NewErrorValue(err), false
+		}
+		return value.NewStringValue(r), true
+	}, nil
+}
+
+func intArg(args []value.Value, i int, dft int) (int, error) {
+	if i >= len(args) {
+		return dft, nil
+	}
+	n, ok := value.ValueToInt64(args[i])
+	if !ok || n < 0 {
+		return 0, fmt.Errorf("expect a non-negative int, but got %v", args[i].Value())
+	}
+	return int(n), nil
+}
+
+// hash(col, salt): hex HMAC-SHA256 of the value, keyed by salt.
+// The salt is required. Without it, a value of a small set (e.g. a phone number) is found by hashing all of them.
+func maskHash(s string, args []value.Value) (string, error) {
+	salt, _ := value.ValueToString(args[0])
+	if salt == "" {
+		return "", fmt.Errorf("hash() requires a non-empty salt")
+	}
+	mac := hmac.New(sha256.New, []byte(salt))
+	mac.Write([]byte(s))
+	return fmt.Sprintf("%x", mac.Sum(nil)), nil
+}
+
+// mask(col [, keepHead [, keepTail]]): replace all but the first keepHead and the last keepTail characters with '*'.
+func maskMiddle(s string, args []value.Value) (string, error) {
+	head, err := intArg(args, 0, 0)
+	if err != nil {
+		return "", err
+	}
+	tail, err := intArg(args, 1, 0)
+	if err != nil {
+		return "", err
+	}
+	rs := []rune(s)
+	for i := range rs {
+		if i >= head && i < len(rs)-tail {
+			rs[i] = '*'
+		}
+	}
+	return string(rs), nil
+}
+
+// mask_email(col): keep the first character of the local part and the domain, e.g. j***@example.com.
+func maskEmail(s string, args []value.Value) (string, error) {
+	at := strings.LastIndex(s, "@")
+	if at < 0 {
+		return maskMiddle(s, nil)
+	}
+	local := []rune(s[:at])
+	if len(local) == 0 {
+		return s, nil
+	}
+	return string(local[0]) + strings.Repeat("*", len(local)-1) + s[at:], nil
+}
+
+// redact(col [, replacement]): replace the value with a constant, '***' by default.
+func maskRedact(s string, args []value.Value) (string, error) {
+	if len(args) > 0 {
+		r, _ := value.ValueToString(args[0])
+		return r, nil
+	}
+	return "***", nil
+}
+
+const convertTzLayout = "2006-01-02 15:04:05.999999"
+
+// convert_tz(col, from_tz, to_tz): like mysql CONVERT_TZ(). A time zone is like '+08:00' or 'Asia/Shanghai'.
+func convertTz(s string, args []value.Value) (string, error) {
+	from, err := tzArg(args[0])
+	if err != nil {
+		return "", err
+	}
+	to, err := tzArg(args[1])
+	if err != nil {
+		return "", err
+	}
+	t, err := time.ParseInLocation(convertTzLayout, s, from)
+	if err != nil {
+		return "", err
+	}
+	return t.In(to).Format(convertTzLayout), nil
+}
+
+func tzArg(arg value.Value) (*time.Location, error) {
+	name, _ := value.ValueToString(arg)
+	if len(name) == 6 && (name[0] == '+' || name[0] == '-') && name[3] == ':' {
+		t, err := time.Parse("-07:00", name)
+		if err != nil {
+			return nil, err
+		}
+		_, offset := t.Zone()
+		return time.FixedZone(name, offset), nil
+	}
+	return time.LoadLocation(name)
+}
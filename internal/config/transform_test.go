@@ -0,0 +1,173 @@
+package config
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"fmt"
+	"testing"
+
+	"github.com/actiontech/dtle/internal/config/mysql"
+)
+
+func newTransformCtx(t *testing.T, transforms map[string]string, columnNames ...string) *TransformContext {
+	table := NewTable("db1", "tb1")
+	table.OriginalTableColumns = mysql.NewColumnList(mysql.NewColumns(columnNames))
+	table.ColumnTransforms = transforms
+	ctx, err := NewTransformCtx(table)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ctx
+}
+
+// transformOne evaluates the transform on column "b", with column "a" of value a.
+func transformOne(t *testing.T, expr string, a interface{}) (interface{}, error) {
+	ctx := newTransformCtx(t, map[string]string{"b": expr}, "id", "a", "b")
+	result, err := ctx.Transform(func(idx int) interface{} {
+		if idx == 1 {
+			return a
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result[0], nil
+}
+
+func hmacSha256(key string, s string) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(s))
+	return fmt.Sprintf("%x", mac.Sum(nil))
+}
+
+func TestMaskFunctions(t *testing.T) {
+	tests := []struct {
+		expr string
+		a    interface{}
+		want interface{}
+	}{
+		{"hash(a, 'salt')", "abc", hmacSha256("salt", "abc")},
+		{"hash(a, 'salt')", []byte("abc"), hmacSha256("salt", "abc")},
+		{"hash(a, 'salt2')", "abc", hmacSha256("salt2", "abc")},
+		{"mask(a)", "abcdef", "******"},
+		{"mask(a, 1)", "abcdef", "a*****"},
+		{"mask(a, 1, 2)", "abcdef", "a***ef"},
+		{"mask(a, 2, 2)", "abc", "abc"},
+		{"mask(a, 1, 1)", "中文名字", "中**字"},
+		{"mask_email(a)", "john@example.com", "j***@example.com"},
+		{"mask_email(a)", "a.b@c@example.com", "a****@example.com"},
+		{"mask_email(a)", "@example.com", "@example.com"},
+		{"mask_email(a)", "noemail", "*******"},
+		{"redact(a)", "secret", "***"},
+		{"redact(a, 'x')", "secret", "x"},
+		{"convert_tz(a, '+00:00', '+08:00')", "2020-01-01 20:00:00", "2020-01-02 04:00:00"},
+		{"convert_tz(a, '+08:00', '-01:30')", "2020-01-01 00:00:00.5", "2019-12-31 14:30:00.5"},
+		{"convert_tz(a, 'UTC', 'Asia/Shanghai')", "2020-06-01 00:00:00", "2020-06-01 08:00:00"},
+		// NULL
+		{"hash(a, 'salt')", nil, nil},
+		{"mask(a, 1)", nil, nil},
+		{"mask_email(a)", nil, nil},
+		{"redact(a)", nil, nil},
+		{"convert_tz(a, '+00:00', '+08:00')", nil, nil},
+		{"a", nil, nil},
+		{"a + 1", nil, nil},
+		// identity and arithmetic
+		{"a", []byte("x"), "x"},
+		{"a * 1000000", int64(3), int64(3000000)},
+	}
+	for _, tt := range tests {
+		got, err := transformOne(t, tt.expr, tt.a)
+		if err != nil {
+			t.Fatalf("%v on %#v: %v", tt.expr, tt.a, err)
+		}
+		if got != tt.want {
+			t.Fatalf("%v on %#v: got %#v, want %#v", tt.expr, tt.a, got, tt.want)
+		}
+	}
+
+	for _, expr := range []string{"mask(a, -1)", "convert_tz(a, 'bad/zone', '+08:00')", "hash(a, '')"} {
+		if _, err := transformOne(t, expr, "2020-01-01 00:00:00"); err == nil {
+			t.Fatalf("%v: no error", expr)
+		}
+	}
+	table := NewTable("db1", "tb1")
+	table.OriginalTableColumns = mysql.NewColumnList(mysql.NewColumns([]string{"a"}))
+	for _, transforms := range []map[string]string{
+		{"a": "mask(a, 1, 2, 3)"},
+		{"a": "hash(c, 'salt')"},
+		{"c": "hash(a, 'salt')"},
+		// without a salt
+		{"a": "hash(a)"},
+	} {
+		table.ColumnTransforms = transforms
+		if _, err := NewTransformCtx(table); err == nil {
+			t.Fatalf("%v: no error", transforms)
+		}
+	}
+}
+
+func TestFormatTransformValue(t *testing.T) {
+	tests := []struct {
+		v    interface{}
+		want string
+	}{
+		{"a", "a"},
+		{[]byte("b"), "b"},
+		{float64(1000000), "1000000"},
+		{float64(0.1), "0.1"},
+		{float32(1.5), "1.5"},
+		{int64(-3), "-3"},
+		{true, "1"},
+		{false, "0"},
+	}
+	for _, tt := range tests {
+		if got := FormatTransformValue(tt.v); got != tt.want {
+			t.Fatalf("%#v: got %v, want %v", tt.v, got, tt.want)
+		}
+	}
+}
+
+func TestTransformValues(t *testing.T) {
+	ctx := newTransformCtx(t, map[string]string{"a": "a * 1000000", "b": "mask(b, 1)"}, "id", "a", "b")
+
+	values := buildColumnValues(int64(1), []byte("1.5"), "abc")
+	if err := ctx.TransformValues(values); err != nil {
+		t.Fatal(err)
+	}
+	// The type of a string column is kept.
+	if got := *values.AbstractValues[1]; string(got.([]byte)) != "1500000" {
+		t.Fatalf("a: got %#v", got)
+	}
+	if got := *values.AbstractValues[2]; got != "a**" {
+		t.Fatalf("b: got %#v", got)
+	}
+
+	values = buildColumnValues(int64(1), nil, nil)
+	if err := ctx.TransformValues(values); err != nil {
+		t.Fatal(err)
+	}
+	if *values.AbstractValues[1] != nil || *values.AbstractValues[2] != nil {
+		t.Fatalf("NULL is not kept")
+	}
+
+	// binlog_row_image=MINIMAL. An absent column is not transformed.
+	values = buildColumnValues(int64(1), nil, "abc")
+	values.AbstractValues[1] = nil
+	values.Present = []bool{true, false, true}
+	if err := ctx.TransformValues(values); err != nil {
+		t.Fatal(err)
+	}
+	if values.AbstractValues[1] != nil || *values.AbstractValues[2] != "a**" {
+		t.Fatalf("got %v %v", values.AbstractValues[1], *values.AbstractValues[2])
+	}
+
+	// The referenced column is absent.
+	ctx = newTransformCtx(t, map[string]string{"b": "hash(a, 'salt')"}, "id", "a", "b")
+	values = buildColumnValues(int64(1), nil, "abc")
+	values.AbstractValues[1] = nil
+	values.Present = []bool{true, false, true}
+	if err := ctx.TransformValues(values); err == nil {
+		t.Fatalf("no error for an absent column")
+	}
+}
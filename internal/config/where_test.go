@@ -1,7 +1,6 @@
 package config
 
 import (
-	"github.com/actiontech/dtle/internal/client/driver/mysql/binlog"
 	"github.com/actiontech/dtle/internal/config/mysql"
 	"testing"
 )
@@ -15,11 +14,18 @@ func newTableContextWithWhere(t *testing.T,
 	if err != nil {
 		t.Fatal(err)
 	}
-	tbCtx := NewTableContext(table, whereCtx)
+	tbCtx := NewTableContext(table, whereCtx, nil)
 	return tbCtx
 }
 func buildColumnValues(vals ...interface{}) *mysql.ColumnValues {
-	return binlog.ToColumnValuesV2(vals, nil)
+	// like binlog.ToColumnValuesV2, which cannot be imported here
+	result := &mysql.ColumnValues{
+		AbstractValues: make([]*interface{}, len(vals)),
+	}
+	for i := range vals {
+		result.AbstractValues[i] = &vals[i]
+	}
+	return result
 }
 
 func TestWhereTrue(t *testing.T) {
@@ -97,3 +103,19 @@ func TestWhereTrueText(t *testing.T) {
 		t.Fatalf("it is not hello")
 	}
 }
+
+func TestWhereTrueTextColumnType(t *testing.T) {
+	tbCtx := newTableContextWithWhere(t, "db1", "tb1", "a = 'hello'", "id", "a")
+	// a text column is read as []byte from binlog
+	tbCtx.Table.OriginalTableColumns.Columns[1].Type = mysql.TextColumnType
+	r, err := tbCtx.WhereTrue(buildColumnValues(1, []byte("hello")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r != true {
+		t.Fatalf("it is hello")
+	}
+	if _, err := tbCtx.WhereTrue(buildColumnValues(2, "hello")); err == nil {
+		t.Fatalf("expect an error for a text column not read as []byte")
+	}
+}
@@ -0,0 +1,125 @@
+package g
+
+import (
+	"sync"
+	"time"
+
+	"github.com/actiontech/dtle/internal/models"
+)
+
+// RateLimiter is a token bucket, with a burst of one second. The rate can be changed at runtime.
+type RateLimiter struct {
+	lock   sync.Mutex
+	rate   int64 // tokens per second. <= 0 for unlimited
+	tokens float64
+	last   time.Time
+}
+
+func NewRateLimiter(rate int64) *RateLimiter {
+	return &RateLimiter{
+		rate:   rate,
+		tokens: float64(rate),
+		last:   time.Now(),
+	}
+}
+
+func (l *RateLimiter) Rate() int64 {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.rate
+}
+
+func (l *RateLimiter) SetRate(rate int64) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.refill()
+	l.rate = rate
+	if l.tokens > float64(rate) {
+		l.tokens = float64(rate)
+	}
+}
+
+// refill must be called with the lock held.
+func (l *RateLimiter) refill() {
+	now := time.Now()
+	if l.rate > 0 {
+		l.tokens += now.Sub(l.last).Seconds() * float64(l.rate)
+		if l.tokens > float64(l.rate) {
+			l.tokens = float64(l.rate)
+		}
+	}
+	l.last = now
+}
+
+// Wait takes n tokens, and blocks until the bucket is no longer in debt or stopCh is closed.
+// n might be larger than the burst.
+func (l *RateLimiter) Wait(n int64, stopCh <-chan struct{}) {
+	l.lock.Lock()
+	if l.rate <= 0 {
+		l.lock.Unlock()
+		return
+	}
+	l.refill()
+	l.tokens -= float64(n)
+	l.lock.Unlock()
+
+	for {
+		l.lock.Lock()
+		if l.rate <= 0 {
+			l.tokens = 0
+			l.lock.Unlock()
+			return
+		}
+		l.refill()
+		if l.tokens >= 0 {
+			l.lock.Unlock()
+			return
+		}
+		// check again at most every 100ms, in case the rate is changed.
+		wait := time.Duration(-l.tokens / float64(l.rate) * float64(time.Second))
+		l.lock.Unlock()
+		if wait > 100*time.Millisecond {
+			wait = 100 * time.Millisecond
+		}
+		select {
+		case <-stopCh:
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// RateLimiters enforces a models.RateLimit.
+type RateLimiters struct {
+	SnapshotRows  *RateLimiter
+	SnapshotBytes *RateLimiter
+	IncrBytes     *RateLimiter
+}
+
+func NewRateLimiters(limit *models.RateLimit) *RateLimiters {
+	if limit == nil {
+		limit = &models.RateLimit{}
+	}
+	return &RateLimiters{
+		SnapshotRows:  NewRateLimiter(limit.SnapshotRowsPerSecond),
+		SnapshotBytes: NewRateLimiter(limit.SnapshotBytesPerSecond),
+		IncrBytes:     NewRateLimiter(limit.IncrBytesPerSecond),
+	}
+}
+
+func (r *RateLimiters) RateLimit() *models.RateLimit {
+	return &models.RateLimit{
+		SnapshotRowsPerSecond:  r.SnapshotRows.Rate(),
+		SnapshotBytesPerSecond: r.SnapshotBytes.Rate(),
+		IncrBytesPerSecond:     r.IncrBytes.Rate(),
+	}
+}
+
+func (r *RateLimiters) SetRateLimit(limit *models.RateLimit) {
+	r.SnapshotRows.SetRate(limit.SnapshotRowsPerSecond)
+	r.SnapshotBytes.SetRate(limit.SnapshotBytesPerSecond)
+	r.IncrBytes.SetRate(limit.IncrBytesPerSecond)
+}
+
+// AgentRateLimiters are shared by all jobs on this agent.
+var AgentRateLimiters = NewRateLimiters(nil)
@@ -0,0 +1,84 @@
+package g
+
+import (
+	"testing"
+	"time"
+
+	"github.com/actiontech/dtle/internal/models"
+)
+
+// timeWait returns how long Wait(n) blocks.
+func timeWait(l *RateLimiter, n int64, stopCh <-chan struct{}) time.Duration {
+	start := time.Now()
+	l.Wait(n, stopCh)
+	return time.Since(start)
+}
+
+// checkDuration allows some scheduling delay.
+func checkDuration(t *testing.T, name string, got time.Duration, want time.Duration) {
+	if got < want-50*time.Millisecond || got > want+300*time.Millisecond {
+		t.Fatalf("%v: waited %v, want about %v", name, got, want)
+	}
+}
+
+func TestRateLimiterRefill(t *testing.T) {
+	l := NewRateLimiter(100)
+	// the burst of one second
+	checkDuration(t, "burst", timeWait(l, 100, nil), 0)
+	checkDuration(t, "empty", timeWait(l, 50, nil), 500*time.Millisecond)
+
+	// refilled up to the burst
+	time.Sleep(1500 * time.Millisecond)
+	checkDuration(t, "refilled", timeWait(l, 100, nil), 0)
+	checkDuration(t, "not more than the burst", timeWait(l, 20, nil), 200*time.Millisecond)
+
+	unlimited := NewRateLimiter(0)
+	checkDuration(t, "unlimited", timeWait(unlimited, 1<<40, nil), 0)
+}
+
+func TestRateLimiterDebt(t *testing.T) {
+	l := NewRateLimiter(100)
+	// n larger than the burst is taken at once, and the debt is paid by waiting.
+	checkDuration(t, "debt", timeWait(l, 150, nil), 500*time.Millisecond)
+	checkDuration(t, "after debt", timeWait(l, 10, nil), 100*time.Millisecond)
+
+	l = NewRateLimiter(10)
+	stopCh := make(chan struct{})
+	time.AfterFunc(200*time.Millisecond, func() {
+		close(stopCh)
+	})
+	checkDuration(t, "stopped", timeWait(l, 100, stopCh), 200*time.Millisecond)
+}
+
+func TestRateLimiterSetRate(t *testing.T) {
+	for _, c := range []struct {
+		name string
+		rate int64
+	}{
+		{"faster", 100000},
+		{"unlimited", 0},
+	} {
+		// in debt for 10s
+		l := NewRateLimiter(1)
+		rate := c.rate
+		time.AfterFunc(200*time.Millisecond, func() {
+			l.SetRate(rate)
+		})
+		checkDuration(t, c.name, timeWait(l, 11, nil), 200*time.Millisecond)
+		if l.Rate() != c.rate {
+			t.Fatalf("%v: got rate %v", c.name, l.Rate())
+		}
+	}
+
+	// slower: the tokens are capped to the new burst.
+	l := NewRateLimiter(1000)
+	l.SetRate(10)
+	checkDuration(t, "slower", timeWait(l, 15, nil), 500*time.Millisecond)
+
+	limiters := NewRateLimiters(nil)
+	limit := &models.RateLimit{SnapshotRowsPerSecond: 1, SnapshotBytesPerSecond: 2, IncrBytesPerSecond: 3}
+	limiters.SetRateLimit(limit)
+	if got := limiters.RateLimit(); *got != *limit {
+		t.Fatalf("got %+v, want %+v", got, limit)
+	}
+}
@@ -355,6 +355,16 @@ type TaskValidateResponse struct {
 	ServerID ServerIDValidate
 
 	Binlog BinlogValidate
+
+	KeylessTables KeylessTablesValidate
+}
+
+// KeylessTablesValidate is a warning rather than an error.
+type KeylessTablesValidate struct {
+	// Tables (schema.table) without primary key or unique key.
+	Tables []string
+	// Warning is not empty if there is any such table
+	Warning string
 }
 
 type BinlogValidate struct {
@@ -0,0 +1,18 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package models
+
+// RateLimit is the soft traffic limit of a job or of an agent. 0 for unlimited.
+// Unlike TrafficAgainstLimits, the task is slowed down instead of being killed.
+type RateLimit struct {
+	// rows read by the full copy
+	SnapshotRowsPerSecond int64
+	// bytes read by the full copy
+	SnapshotBytesPerSecond int64
+	// bytes sent by the incremental copy
+	IncrBytesPerSecond int64
+}
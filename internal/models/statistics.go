@@ -22,6 +22,8 @@ const (
 	StageSlaveWaitingForWorkersToProcessQueue          = "Waiting for slave workers to process their queues"
 	StageWaitingForGtidToBeCommitted                   = "Waiting for GTID to be committed"
 	StageWaitingForMasterToSendEvent                   = "Waiting for master to send event"
+	StageThrottled                                     = "Throttled"
+	StageWaitingForCredits                             = "Waiting for the slave to grant credits"
 )
 
 type TableStats struct {
@@ -35,6 +37,14 @@ type DelayCount struct {
 	Time uint64
 }
 
+// HeartbeatLag is the end-to-end replication lag, measured with the heartbeat table on the source.
+type HeartbeatLag struct {
+	// Milliseconds from the source commit of the last heartbeat to its destination commit.
+	Lag int64
+	// Milliseconds since the last heartbeat was committed on the destination.
+	SinceLast int64
+}
+
 type ThroughputStat struct {
 	Num  uint64
 	Time uint64
@@ -47,12 +57,39 @@ type MsgStat struct {
 	OutBytes uint64
 }
 
+// CompressionStat is the payload compression of a task. The src compresses, and the dest decompresses.
+type CompressionStat struct {
+	// The codec of the src, or of the last payload on the dest.
+	Codec           string
+	RawBytes        uint64
+	CompressedBytes uint64
+	// CompressedBytes / RawBytes
+	Ratio float64
+	// CPU time spent to compress or decompress, in milliseconds.
+	TimeMs uint64
+}
+
 type BufferStat struct {
 	ExtractorTxQueueSize    int
 	ApplierTxQueueSize      int
 	ApplierGroupTxQueueSize int
 	SendByTimeout           int
 	SendBySizeFull          int
+
+	// Credit-based flow control. The src shows the credits available, and the time spent waiting for them.
+	ExtractorCreditEntries int64
+	ExtractorCreditBytes   int64
+	ExtractorThrottledMs   int64
+	// Bytes (of the binlog entries) in the queue of the dest.
+	ApplierTxQueueBytes int64
+}
+
+// RangeStat is the full copy progress of a unique key range of a split table.
+type RangeStat struct {
+	Table      string
+	Index      int
+	RowsCopied int64
+	Done       bool
 }
 
 type CurrentCoordinates struct {
@@ -70,6 +107,7 @@ type TaskStatistics struct {
 	CurrentCoordinates *CurrentCoordinates
 	TableStats         *TableStats
 	DelayCount         *DelayCount
+	HeartbeatLag       *HeartbeatLag
 	ProgressPct        string
 	ExecMasterRowCount int64
 	ExecMasterTxCount  int64
@@ -79,7 +117,9 @@ type TaskStatistics struct {
 	Backlog            string
 	ThroughputStat     *ThroughputStat
 	MsgStat            gonats.Statistics
+	CompressionStat    *CompressionStat
 	BufferStat         BufferStat
+	RangeStats         []*RangeStat
 	Stage              string
 	Timestamp          int64
 }
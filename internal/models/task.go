@@ -219,6 +219,10 @@ const (
 
 	// TaskLeaderDead indicates that the leader task within the has finished.
 	TaskLeaderDead = "Leader Task Dead"
+
+	// TaskSourceFailover indicates that the task has lost the source and
+	// switched to another server.
+	TaskSourceFailover = "Source Failover"
 )
 
 // TaskEvent is an event that effects the state of a task and contains meta-data
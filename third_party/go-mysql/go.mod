@@ -0,0 +1,17 @@
+module github.com/siddontang/go-mysql
+
+require (
+	github.com/BurntSushi/toml v0.3.1
+	github.com/golang/protobuf v1.3.1 // indirect
+	github.com/klauspost/compress v1.9.8
+	github.com/pingcap/check v0.0.0-20190102082844-67f458068fc8
+	github.com/pingcap/errors v0.11.0
+	github.com/pingcap/parser v0.0.0-20190506092653-e336082eb825
+	github.com/pingcap/tipb v0.0.0-20190428032612-535e1abaa330 // indirect
+	github.com/satori/go.uuid v1.2.0
+	github.com/shopspring/decimal v0.0.0-20180709203117-cd690d0c9e24
+	github.com/siddontang/go v0.0.0-20180604090527-bdc77568d726
+	github.com/siddontang/go-log v0.0.0-20180807004314-8d05993dda07
+	github.com/sirupsen/logrus v1.4.1 // indirect
+	golang.org/x/text v0.3.2 // indirect
+)
@@ -0,0 +1,90 @@
+package replication
+
+import (
+	"fmt"
+
+	"github.com/pingcap/errors"
+	. "github.com/siddontang/go-mysql/mysql"
+)
+
+// binlog_row_value_options
+const PARTIAL_JSON_UPDATES = 1
+
+type JsonDiffOperation byte
+
+// See enum_json_diff_operation in mysql-server sql/json_diff.h
+const (
+	// The JSON value in the given path is replaced with a new value.
+	JsonDiffOperationReplace JsonDiffOperation = iota
+	// Add a new element at the given path.
+	JsonDiffOperationInsert
+	// The JSON value at the given path is removed from an array or object.
+	JsonDiffOperationRemove
+)
+
+func (op JsonDiffOperation) String() string {
+	switch op {
+	case JsonDiffOperationReplace:
+		return "Replace"
+	case JsonDiffOperationInsert:
+		return "Insert"
+	case JsonDiffOperationRemove:
+		return "Remove"
+	default:
+		return fmt.Sprintf("Unknown(%d)", op)
+	}
+}
+
+// JsonDiff is a modification of a JSON document, logged with binlog_row_value_options=PARTIAL_JSON.
+type JsonDiff struct {
+	Op   JsonDiffOperation
+	Path string
+	// The new value in JSON text. Empty for JsonDiffOperationRemove.
+	Value string
+}
+
+func (d *JsonDiff) String() string {
+	return fmt.Sprintf("%v %v %v", d.Op, d.Path, d.Value)
+}
+
+// JsonDiffs is the value of a JSON column in the after image of a PARTIAL_UPDATE_ROWS_EVENT,
+// in place of the whole document. The diffs apply in order to the document in the before image.
+type JsonDiffs []*JsonDiff
+
+// decodeJsonDiffs decodes a Json_diff_vector. See Json_diff_vector::read_binary() in mysql-server sql/json_diff.cc
+func (e *RowsEvent) decodeJsonDiffs(data []byte, meta uint16) (v JsonDiffs, n int, err error) {
+	length := int(FixedLengthInt(data[0:meta]))
+	n = length + int(meta)
+	data = data[meta:n]
+
+	v = JsonDiffs{}
+	pos := 0
+	for pos < len(data) {
+		op := JsonDiffOperation(data[pos])
+		pos++
+		if op > JsonDiffOperationRemove {
+			return nil, 0, errors.Errorf("corrupted JSON diff. unknown operation %d", op)
+		}
+
+		pathLength, _, m := LengthEncodedInt(data[pos:])
+		pos += m
+		diff := &JsonDiff{
+			Op:   op,
+			Path: string(data[pos : pos+int(pathLength)]),
+		}
+		pos += int(pathLength)
+
+		if op != JsonDiffOperationRemove {
+			valueLength, _, m := LengthEncodedInt(data[pos:])
+			pos += m
+			value, err := e.decodeJsonBinary(data[pos : pos+int(valueLength)])
+			if err != nil {
+				return nil, 0, errors.Annotatef(err, "JSON diff of path %s", diff.Path)
+			}
+			diff.Value = string(value)
+			pos += int(valueLength)
+		}
+		v = append(v, diff)
+	}
+	return v, n, nil
+}
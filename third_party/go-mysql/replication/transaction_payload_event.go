@@ -0,0 +1,111 @@
+package replication
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pingcap/errors"
+	. "github.com/siddontang/go-mysql/mysql"
+)
+
+// TransactionPayloadEvent is a transaction compressed with binlog_transaction_compression=ON (MySQL 8.0.20+).
+// See Transaction_payload_event in mysql-server libbinlogevents/include/control_events.h
+type TransactionPayloadEvent struct {
+	Size             uint64
+	UncompressedSize uint64
+	CompressionType  uint64
+	Payload          []byte
+	// The events (of the transaction) in the payload.
+	Events []*BinlogEvent
+
+	parser *BinlogParser
+}
+
+func (e *TransactionPayloadEvent) Dump(w io.Writer) {
+	fmt.Fprintf(w, "Payload Size: %d\n", e.Size)
+	fmt.Fprintf(w, "Payload Uncompressed Size: %d\n", e.UncompressedSize)
+	fmt.Fprintf(w, "Payload CompressionType: %d\n", e.CompressionType)
+	fmt.Fprintf(w, "Payload Events: %d\n", len(e.Events))
+	for _, ev := range e.Events {
+		ev.Dump(w)
+	}
+	fmt.Fprintln(w)
+}
+
+func (e *TransactionPayloadEvent) Decode(data []byte) error {
+	if err := e.decodeFields(data); err != nil {
+		return err
+	}
+	return e.decodePayload()
+}
+
+func (e *TransactionPayloadEvent) decodeFields(data []byte) error {
+	pos := 0
+	for {
+		if pos >= len(data) {
+			return errors.Errorf("no payload in transaction payload event")
+		}
+		fieldType, _, n := LengthEncodedInt(data[pos:])
+		pos += n
+		if fieldType == OTW_PAYLOAD_HEADER_END_MARK {
+			e.Payload = data[pos:]
+			return nil
+		}
+
+		fieldLength, _, n := LengthEncodedInt(data[pos:])
+		pos += n
+		if pos+int(fieldLength) > len(data) {
+			return errors.Errorf("bad field length %d in transaction payload event", fieldLength)
+		}
+		value, _, _ := LengthEncodedInt(data[pos : pos+int(fieldLength)])
+		switch fieldType {
+		case OTW_PAYLOAD_SIZE_FIELD:
+			e.Size = value
+		case OTW_PAYLOAD_COMPRESSION_TYPE_FIELD:
+			e.CompressionType = value
+		case OTW_PAYLOAD_UNCOMPRESSED_SIZE_FIELD:
+			e.UncompressedSize = value
+		}
+		pos += int(fieldLength)
+	}
+}
+
+func (e *TransactionPayloadEvent) decodePayload() error {
+	var payload []byte
+	switch byte(e.CompressionType) {
+	case ZSTD_COMPRESSION:
+		decoder, err := zstd.NewReader(nil, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return errors.Trace(err)
+		}
+		defer decoder.Close()
+		payload, err = decoder.DecodeAll(e.Payload, make([]byte, 0, e.UncompressedSize))
+		if err != nil {
+			return errors.Annotatef(err, "decompress transaction payload")
+		}
+	case NO_COMPRESSION:
+		payload = e.Payload
+	default:
+		return errors.Errorf("unknown compression type %d in transaction payload event", e.CompressionType)
+	}
+
+	pos := 0
+	for pos < len(payload) {
+		if pos+EventHeaderSize > len(payload) {
+			return errors.Errorf("incomplete event header in transaction payload at %d", pos)
+		}
+		eventSize := int(binary.LittleEndian.Uint32(payload[pos+9:]))
+		if eventSize < EventHeaderSize || pos+eventSize > len(payload) {
+			return errors.Errorf("bad event size %d in transaction payload at %d", eventSize, pos)
+		}
+		ev, err := e.parser.Parse(payload[pos : pos+eventSize])
+		if err != nil {
+			return errors.Trace(err)
+		}
+		e.Events = append(e.Events, ev)
+		pos += eventSize
+	}
+	return nil
+}